@@ -0,0 +1,165 @@
+package analytics
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+)
+
+// Bunch is a vehicle running closer behind the one ahead of it than a
+// fraction of the scheduled headway.
+type Bunch struct {
+	Line             string    `json:"line"`
+	DirectionID      int       `json:"direction_id"`
+	VehicleKey       string    `json:"vehicle_key"`
+	AheadKey         string    `json:"ahead_key"`
+	DistanceM        float64   `json:"distance_m"`
+	Seconds          int       `json:"seconds"`
+	ScheduledSeconds int       `json:"scheduled_seconds"`
+	Ratio            float64   `json:"ratio"`
+	Since            time.Time `json:"since"` // first pass the pair was found bunched in
+}
+
+func (b *Bunch) pair() string {
+	return b.VehicleKey + "|" + b.AheadKey
+}
+
+// BunchingAnalyzer periodically computes the headways of every line with
+// live vehicles and keeps the gaps whose ratio to the scheduled headway is
+// below its threshold.
+type BunchingAnalyzer struct {
+	vehicles  *store.Store
+	gtfs      *store.GTFSStore
+	threshold float64
+	logger    *slog.Logger
+	onChange  func(bunches []*Bunch, at time.Time)
+
+	mu        sync.RWMutex
+	bunches   []*Bunch
+	updatedAt time.Time
+}
+
+func NewBunchingAnalyzer(vehicleStore *store.Store, gtfsStore *store.GTFSStore, threshold float64, logger *slog.Logger) *BunchingAnalyzer {
+	return &BunchingAnalyzer{
+		vehicles:  vehicleStore,
+		gtfs:      gtfsStore,
+		threshold: threshold,
+		logger:    logger.With("component", "bunching"),
+	}
+}
+
+// SetOnChange sets a callback run after a pass finding a different set of
+// bunched vehicle pairs than the one before.
+func (a *BunchingAnalyzer) SetOnChange(fn func(bunches []*Bunch, at time.Time)) {
+	a.onChange = fn
+}
+
+// Threshold is the ratio of gap to scheduled headway below which vehicles
+// count as bunched.
+func (a *BunchingAnalyzer) Threshold() float64 {
+	return a.threshold
+}
+
+// Run analyzes every interval until ctx is cancelled.
+func (a *BunchingAnalyzer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.Analyze(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Analyze replaces the bunches with those found as of now.
+func (a *BunchingAnalyzer) Analyze(now time.Time) {
+	if !a.gtfs.GetStats().IsLoaded {
+		return
+	}
+
+	byLine := make(map[string][]*domain.Vehicle)
+	for _, v := range a.vehicles.List(store.ListOptions{}) {
+		byLine[v.Line] = append(byLine[v.Line], v)
+	}
+
+	a.mu.RLock()
+	since := make(map[string]time.Time, len(a.bunches))
+	for _, b := range a.bunches {
+		since[b.pair()] = b.Since
+	}
+	a.mu.RUnlock()
+
+	var bunches []*Bunch
+	for line, vehicles := range byLine {
+		route, ok := a.gtfs.GetRouteByLine(line)
+		if !ok {
+			continue
+		}
+		for _, d := range Headways(vehicles, a.gtfs.ScheduledHeadways(route.ID, now)) {
+			for _, g := range d.Gaps {
+				if g.Ratio == nil || *g.Ratio >= a.threshold {
+					continue
+				}
+				b := &Bunch{
+					Line:             line,
+					DirectionID:      d.DirectionID,
+					VehicleKey:       g.VehicleKey,
+					AheadKey:         g.AheadKey,
+					DistanceM:        g.DistanceM,
+					Seconds:          *g.Seconds,
+					ScheduledSeconds: *d.ScheduledSeconds,
+					Ratio:            *g.Ratio,
+					Since:            now,
+				}
+				if t, ok := since[b.pair()]; ok {
+					b.Since = t
+				}
+				bunches = append(bunches, b)
+			}
+		}
+	}
+	sort.Slice(bunches, func(i, j int) bool {
+		if bunches[i].Line != bunches[j].Line {
+			return bunches[i].Line < bunches[j].Line
+		}
+		if bunches[i].DirectionID != bunches[j].DirectionID {
+			return bunches[i].DirectionID < bunches[j].DirectionID
+		}
+		return bunches[i].pair() < bunches[j].pair()
+	})
+
+	changed := len(bunches) != len(since)
+	for _, b := range bunches {
+		if _, ok := since[b.pair()]; !ok {
+			changed = true
+		}
+	}
+
+	a.mu.Lock()
+	a.bunches = bunches
+	a.updatedAt = now
+	a.mu.Unlock()
+
+	if changed {
+		a.logger.Debug("bunching changed", "bunches", len(bunches))
+		if a.onChange != nil {
+			a.onChange(bunches, now)
+		}
+	}
+}
+
+// Bunches returns the bunches found by the last pass and when it ran.
+func (a *BunchingAnalyzer) Bunches() ([]*Bunch, time.Time) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.bunches, a.updatedAt
+}
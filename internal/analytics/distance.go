@@ -0,0 +1,176 @@
+package analytics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/pkg/geo"
+	"wabus/pkg/gtfs"
+)
+
+const (
+	// Steps shorter than this are GPS jitter of a standing vehicle.
+	minStepMeters = 10.0
+	// Steps faster than this are position glitches, not driving.
+	maxStepSpeedKmh = 90.0
+	// Gaps longer than this are not bridged with a straight line.
+	maxStepGap = 5 * time.Minute
+)
+
+// lastPosition is the previous accepted position of a vehicle.
+type lastPosition struct {
+	line      string
+	lat, lon  float64
+	timestamp time.Time
+}
+
+type lineKey struct {
+	line       string
+	vtype      domain.VehicleType
+	propulsion Propulsion
+}
+
+// LineDistance is the distance a line's vehicles of one propulsion traveled
+// on a service day.
+type LineDistance struct {
+	Line       string
+	Type       domain.VehicleType
+	Propulsion Propulsion
+	Meters     float64
+	KgCO2      float64
+}
+
+// DistanceTracker accumulates the distance traveled per line and service day
+// from consecutive vehicle positions. It keeps the last keepDays days in
+// memory; figures start over when the server restarts.
+type DistanceTracker struct {
+	mu       sync.Mutex
+	fleet    *Fleet
+	last     map[string]lastPosition
+	days     map[string]map[lineKey]float64 // service day -> meters
+	keepDays int
+	since    time.Time
+	pruned   time.Time
+}
+
+func NewDistanceTracker(fleet *Fleet, keepDays int) *DistanceTracker {
+	if fleet == nil {
+		fleet = &Fleet{}
+	}
+	if keepDays < 1 {
+		keepDays = 1
+	}
+	now := time.Now()
+	return &DistanceTracker{
+		fleet:    fleet,
+		last:     make(map[string]lastPosition),
+		days:     make(map[string]map[lineKey]float64),
+		keepDays: keepDays,
+		since:    now,
+		pruned:   now,
+	}
+}
+
+// Since returns when tracking started.
+func (t *DistanceTracker) Since() time.Time {
+	return t.since
+}
+
+// Record adds the distance between each updated vehicle's previous and
+// current position to its line.
+func (t *DistanceTracker) Record(deltas []domain.VehicleDelta) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range deltas {
+		if d.Type == domain.DeltaRemove {
+			delete(t.last, d.Key)
+			continue
+		}
+		v := d.Vehicle
+		if v == nil {
+			continue
+		}
+
+		prev, ok := t.last[v.Key]
+		if ok && !v.Timestamp.After(prev.timestamp) {
+			continue // same report seen again
+		}
+
+		cur := lastPosition{line: v.Line, lat: v.Lat, lon: v.Lon, timestamp: v.Timestamp}
+		if !ok || prev.line != v.Line {
+			t.last[v.Key] = cur
+			continue
+		}
+
+		dt := v.Timestamp.Sub(prev.timestamp)
+		meters := geo.Distance(prev.lat, prev.lon, v.Lat, v.Lon)
+		if meters < minStepMeters {
+			// Keep the earlier point so slow movement still adds up.
+			prev.timestamp = v.Timestamp
+			t.last[v.Key] = prev
+			continue
+		}
+		t.last[v.Key] = cur
+		if dt > maxStepGap || meters/dt.Seconds()*3.6 > maxStepSpeedKmh {
+			continue
+		}
+
+		day := gtfs.ServiceDay(v.Timestamp).Format("2006-01-02")
+		lines, ok := t.days[day]
+		if !ok {
+			lines = make(map[lineKey]float64)
+			t.days[day] = lines
+		}
+		key := lineKey{line: v.Line, vtype: v.Type, propulsion: t.fleet.Propulsion(v.Type, v.VehicleNumber)}
+		lines[key] += meters
+	}
+
+	if now := time.Now(); now.Sub(t.pruned) > maxStepGap {
+		t.pruneLocked(now)
+		t.pruned = now
+	}
+}
+
+// pruneLocked forgets vehicles that stopped reporting and days past the
+// retention window.
+func (t *DistanceTracker) pruneLocked(now time.Time) {
+	for key, p := range t.last {
+		if now.Sub(p.timestamp) > maxStepGap {
+			delete(t.last, key)
+		}
+	}
+	oldest := gtfs.ServiceDay(now).AddDate(0, 0, -(t.keepDays - 1)).Format("2006-01-02")
+	for day := range t.days {
+		if day < oldest {
+			delete(t.days, day)
+		}
+	}
+}
+
+// Day returns the per-line distances of a service day, sorted by line.
+func (t *DistanceTracker) Day(day time.Time) []LineDistance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := t.days[gtfs.ServiceDay(day).Format("2006-01-02")]
+	result := make([]LineDistance, 0, len(lines))
+	for key, meters := range lines {
+		result = append(result, LineDistance{
+			Line:       key.line,
+			Type:       key.vtype,
+			Propulsion: key.propulsion,
+			Meters:     meters,
+			KgCO2:      meters / 1000 * emissionFactor(key.vtype, key.propulsion),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Line != result[j].Line {
+			return result[i].Line < result[j].Line
+		}
+		return result[i].Propulsion < result[j].Propulsion
+	})
+	return result
+}
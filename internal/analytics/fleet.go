@@ -0,0 +1,149 @@
+// Package analytics derives aggregate figures, such as distance traveled and
+// estimated emissions, from the live vehicle feed.
+package analytics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"wabus/internal/domain"
+)
+
+// Propulsion is the drive type of a vehicle.
+type Propulsion string
+
+const (
+	PropulsionDiesel   Propulsion = "diesel"
+	PropulsionHybrid   Propulsion = "hybrid"
+	PropulsionCNG      Propulsion = "cng"
+	PropulsionElectric Propulsion = "electric"
+)
+
+func (p Propulsion) Valid() bool {
+	switch p {
+	case PropulsionDiesel, PropulsionHybrid, PropulsionCNG, PropulsionElectric:
+		return true
+	}
+	return false
+}
+
+// Fleet maps vehicles to their propulsion. Vehicles it doesn't list fall
+// back to the typical propulsion of their type.
+type Fleet struct {
+	propulsion map[string]Propulsion // keyed by fleetKey
+}
+
+// LoadFleet reads fleet metadata from a CSV file with the header
+// type,vehicle_number,propulsion, e.g. "bus,1234,electric". An empty path
+// returns an empty fleet.
+func LoadFleet(path string) (*Fleet, error) {
+	f := &Fleet{propulsion: make(map[string]Propulsion)}
+	if path == "" {
+		return f, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open fleet file: %w", err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read fleet header: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	typeCol, ok1 := cols["type"]
+	numberCol, ok2 := cols["vehicle_number"]
+	propulsionCol, ok3 := cols["propulsion"]
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("fleet file needs type, vehicle_number and propulsion columns")
+	}
+
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read fleet file: %w", err)
+		}
+
+		var vt domain.VehicleType
+		switch strings.ToLower(record[typeCol]) {
+		case "bus":
+			vt = domain.VehicleTypeBus
+		case "tram":
+			vt = domain.VehicleTypeTram
+		default:
+			return nil, fmt.Errorf("fleet file line %d: unknown type %q", line, record[typeCol])
+		}
+		p := Propulsion(strings.ToLower(record[propulsionCol]))
+		if !p.Valid() {
+			return nil, fmt.Errorf("fleet file line %d: unknown propulsion %q", line, record[propulsionCol])
+		}
+		f.propulsion[fleetKey(vt, record[numberCol])] = p
+	}
+	return f, nil
+}
+
+// Len returns the number of vehicles with explicit metadata.
+func (f *Fleet) Len() int {
+	return len(f.propulsion)
+}
+
+// Propulsion returns the propulsion of a vehicle: trams are electric, buses
+// diesel unless the fleet file says otherwise.
+func (f *Fleet) Propulsion(vt domain.VehicleType, vehicleNumber string) Propulsion {
+	if p, ok := f.propulsion[fleetKey(vt, vehicleNumber)]; ok {
+		return p
+	}
+	if vt == domain.VehicleTypeTram {
+		return PropulsionElectric
+	}
+	return PropulsionDiesel
+}
+
+func fleetKey(vt domain.VehicleType, vehicleNumber string) string {
+	return vt.String() + ":" + strings.TrimSpace(vehicleNumber)
+}
+
+// EmissionFactor is the estimated well-to-wheel CO2 per vehicle-kilometer.
+type EmissionFactor struct {
+	Type       string     `json:"type"`
+	Propulsion Propulsion `json:"propulsion"`
+	KgCO2PerKm float64    `json:"kg_co2_per_km"`
+}
+
+// EmissionFactors are rough averages: diesel and CNG from typical 12 m bus
+// fuel use, electric vehicles from traction energy per km times the carbon
+// intensity of the Polish grid (~0.66 kg/kWh).
+var EmissionFactors = []EmissionFactor{
+	{Type: "bus", Propulsion: PropulsionDiesel, KgCO2PerKm: 1.30},
+	{Type: "bus", Propulsion: PropulsionHybrid, KgCO2PerKm: 1.00},
+	{Type: "bus", Propulsion: PropulsionCNG, KgCO2PerKm: 1.20},
+	{Type: "bus", Propulsion: PropulsionElectric, KgCO2PerKm: 0.80},
+	{Type: "tram", Propulsion: PropulsionElectric, KgCO2PerKm: 2.00},
+}
+
+// emissionFactor returns the kg CO2/km for a vehicle type and propulsion,
+// falling back to the type's default propulsion.
+func emissionFactor(vt domain.VehicleType, p Propulsion) float64 {
+	for _, f := range EmissionFactors {
+		if f.Type == vt.String() && f.Propulsion == p {
+			return f.KgCO2PerKm
+		}
+	}
+	if vt == domain.VehicleTypeTram {
+		return emissionFactor(vt, PropulsionElectric)
+	}
+	return emissionFactor(domain.VehicleTypeBus, PropulsionDiesel)
+}
@@ -0,0 +1,155 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+// Gap statuses, by the ratio of a gap to the scheduled headway.
+const (
+	GapOK      = "ok"
+	GapBunched = "bunched" // below bunchedRatio
+	GapWide    = "wide"    // above wideRatio
+)
+
+const (
+	bunchedRatio = 0.5
+	wideRatio    = 1.5
+	// Vehicles slower than this are standing and don't count towards the
+	// speed gaps are timed at.
+	minMovingKmh = 5.0
+)
+
+// Gap is the spacing between a vehicle and the next one ahead of it on the
+// same shape.
+type Gap struct {
+	VehicleKey string  `json:"vehicle_key"`
+	AheadKey   string  `json:"ahead_key"`
+	ShapeID    string  `json:"shape_id"`
+	DistanceM  float64 `json:"distance_m"`
+	// Seconds is the time to cover the gap at the direction's average
+	// speed; unset while none of its vehicles is moving.
+	Seconds *int `json:"seconds,omitempty"`
+	// Ratio is Seconds over the scheduled headway, when both are known.
+	Ratio  *float64 `json:"ratio,omitempty"`
+	Status string   `json:"status,omitempty"`
+}
+
+// DirectionHeadways are the gaps between a line's vehicles running in one
+// direction, ordered from the start of the shape.
+type DirectionHeadways struct {
+	DirectionID      int   `json:"direction_id"`
+	Vehicles         int   `json:"vehicles"`
+	ScheduledSeconds *int  `json:"scheduled_seconds,omitempty"`
+	AvgSeconds       *int  `json:"avg_seconds,omitempty"`
+	Gaps             []Gap `json:"gaps"`
+}
+
+// Headways computes the gaps between consecutive vehicles of one line in the
+// same direction from their distance along the shape, and compares them with
+// the scheduled headways by direction ID. Only vehicles snapped to a shape
+// count; coupled units behind a lead one are skipped, and vehicles are only
+// compared with others on the same shape.
+func Headways(vehicles []*domain.Vehicle, scheduled map[int]time.Duration) []DirectionHeadways {
+	byDirection := make(map[int][]*domain.Vehicle)
+	for _, v := range vehicles {
+		if v.DirectionID == nil || v.Snapped == nil || v.LeadKey != "" {
+			continue
+		}
+		byDirection[*v.DirectionID] = append(byDirection[*v.DirectionID], v)
+	}
+	for dir := range scheduled {
+		if _, ok := byDirection[dir]; !ok {
+			byDirection[dir] = nil
+		}
+	}
+
+	result := make([]DirectionHeadways, 0, len(byDirection))
+	for dir, dirVehicles := range byDirection {
+		d := DirectionHeadways{DirectionID: dir, Vehicles: len(dirVehicles), Gaps: []Gap{}}
+		headway, hasSchedule := scheduled[dir]
+		if hasSchedule {
+			d.ScheduledSeconds = intPtr(int(headway.Seconds()))
+		}
+
+		speed := movingSpeed(dirVehicles)
+		byShape := make(map[string][]*domain.Vehicle)
+		for _, v := range dirVehicles {
+			byShape[v.Snapped.ShapeID] = append(byShape[v.Snapped.ShapeID], v)
+		}
+		for shapeID, shapeVehicles := range byShape {
+			sort.Slice(shapeVehicles, func(i, j int) bool {
+				return shapeVehicles[i].Snapped.DistanceAlong < shapeVehicles[j].Snapped.DistanceAlong
+			})
+			for i := 0; i+1 < len(shapeVehicles); i++ {
+				behind, ahead := shapeVehicles[i], shapeVehicles[i+1]
+				gap := Gap{
+					VehicleKey: behind.Key,
+					AheadKey:   ahead.Key,
+					ShapeID:    shapeID,
+					DistanceM:  math.Round(ahead.Snapped.DistanceAlong - behind.Snapped.DistanceAlong),
+				}
+				if speed > 0 {
+					gap.Seconds = intPtr(int(math.Round(gap.DistanceM / (speed / 3.6))))
+					if hasSchedule && headway > 0 {
+						ratio := math.Round(float64(*gap.Seconds)/headway.Seconds()*100) / 100
+						gap.Ratio = &ratio
+						gap.Status = gapStatus(ratio)
+					}
+				}
+				d.Gaps = append(d.Gaps, gap)
+			}
+		}
+		sort.SliceStable(d.Gaps, func(i, j int) bool { return d.Gaps[i].ShapeID < d.Gaps[j].ShapeID })
+
+		var total, timed int
+		for _, g := range d.Gaps {
+			if g.Seconds != nil {
+				total += *g.Seconds
+				timed++
+			}
+		}
+		if timed > 0 {
+			d.AvgSeconds = intPtr(total / timed)
+		}
+		result = append(result, d)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].DirectionID < result[j].DirectionID })
+	return result
+}
+
+// movingSpeed is the mean smoothed speed in km/h of the moving vehicles, 0
+// when none is moving.
+func movingSpeed(vehicles []*domain.Vehicle) float64 {
+	var sum float64
+	var n int
+	for _, v := range vehicles {
+		if v.SpeedAvg != nil && *v.SpeedAvg >= minMovingKmh {
+			sum += *v.SpeedAvg
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+func gapStatus(ratio float64) string {
+	switch {
+	case ratio < bunchedRatio:
+		return GapBunched
+	case ratio > wideRatio:
+		return GapWide
+	default:
+		return GapOK
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
@@ -0,0 +1,299 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/pkg/geo"
+	"wabus/pkg/gtfs"
+)
+
+const (
+	// Segments observed fewer times than this aren't judged slow.
+	minSlowSamples = 10
+	// Segments slower than this fraction of their shape's median speed are
+	// flagged as slow.
+	slowRatio = 0.6
+)
+
+// lastSnap is the previous snapped position of a vehicle.
+type lastSnap struct {
+	shapeID   string
+	along     float64
+	timestamp time.Time
+}
+
+type segmentKey struct {
+	line    string
+	shapeID string
+	index   int // distance along the shape / segment length
+	hour    int // local hour of the step
+}
+
+// segmentTotals sum the steps observed on a segment.
+type segmentTotals struct {
+	meters  float64
+	seconds float64
+	samples int
+}
+
+// SegmentTracker aggregates the speeds of vehicles moving along their shapes
+// into fixed-length segments by local hour and service day. Steps between
+// consecutive positions snapped to the same shape count towards the segment
+// their midpoint falls in. It keeps the last keepDays days in memory.
+type SegmentTracker struct {
+	mu       sync.Mutex
+	length   float64
+	last     map[string]lastSnap
+	days     map[string]map[segmentKey]*segmentTotals // service day -> totals
+	keepDays int
+	pruned   time.Time
+}
+
+func NewSegmentTracker(lengthMeters float64, keepDays int) *SegmentTracker {
+	if keepDays < 1 {
+		keepDays = 1
+	}
+	return &SegmentTracker{
+		length:   lengthMeters,
+		last:     make(map[string]lastSnap),
+		days:     make(map[string]map[segmentKey]*segmentTotals),
+		keepDays: keepDays,
+		pruned:   time.Now(),
+	}
+}
+
+// Length returns the segment length in meters.
+func (t *SegmentTracker) Length() float64 {
+	return t.length
+}
+
+// Record adds the step of each updated vehicle since its previous position
+// on the same shape.
+func (t *SegmentTracker) Record(deltas []domain.VehicleDelta) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range deltas {
+		if d.Type == domain.DeltaRemove {
+			delete(t.last, d.Key)
+			continue
+		}
+		v := d.Vehicle
+		if v == nil || v.Snapped == nil {
+			continue
+		}
+
+		prev, ok := t.last[v.Key]
+		if ok && !v.Timestamp.After(prev.timestamp) {
+			continue // same report seen again
+		}
+		t.last[v.Key] = lastSnap{shapeID: v.Snapped.ShapeID, along: v.Snapped.DistanceAlong, timestamp: v.Timestamp}
+		if !ok || prev.shapeID != v.Snapped.ShapeID {
+			continue
+		}
+
+		dt := v.Timestamp.Sub(prev.timestamp)
+		meters := v.Snapped.DistanceAlong - prev.along
+		if meters < 0 || dt > maxStepGap || meters/dt.Seconds()*3.6 > maxStepSpeedKmh {
+			continue
+		}
+
+		mid := prev.timestamp.Add(dt / 2)
+		day := gtfs.ServiceDay(mid).Format("2006-01-02")
+		segments, ok := t.days[day]
+		if !ok {
+			segments = make(map[segmentKey]*segmentTotals)
+			t.days[day] = segments
+		}
+		key := segmentKey{
+			line:    v.Line,
+			shapeID: v.Snapped.ShapeID,
+			index:   int((prev.along + meters/2) / t.length),
+			hour:    mid.In(gtfs.Location()).Hour(),
+		}
+		totals, ok := segments[key]
+		if !ok {
+			totals = &segmentTotals{}
+			segments[key] = totals
+		}
+		totals.meters += meters
+		totals.seconds += dt.Seconds()
+		totals.samples++
+	}
+
+	if now := time.Now(); now.Sub(t.pruned) > maxStepGap {
+		t.pruneLocked(now)
+		t.pruned = now
+	}
+}
+
+// pruneLocked drops the snapped positions no step could start from any more,
+// being older than the longest gap a step may span, and the segment totals
+// of service days before the last keepDays.
+func (t *SegmentTracker) pruneLocked(now time.Time) {
+	for key, p := range t.last {
+		if now.Sub(p.timestamp) > maxStepGap {
+			delete(t.last, key)
+		}
+	}
+	oldest := gtfs.ServiceDay(now).AddDate(0, 0, -(t.keepDays - 1)).Format("2006-01-02")
+	for day := range t.days {
+		if day < oldest {
+			delete(t.days, day)
+		}
+	}
+}
+
+// SegmentProfile is the observed speed on one stretch of a shape.
+type SegmentProfile struct {
+	Index int        `json:"index"`
+	FromM float64    `json:"from_m"`
+	ToM   float64    `json:"to_m"`
+	Start [2]float64 `json:"start"` // [lat, lon]
+	End   [2]float64 `json:"end"`
+	// SpeedKmh is the distance covered over the time spent on the segment.
+	SpeedKmh float64 `json:"speed_kmh"`
+	Samples  int     `json:"samples"`
+	// ByHour is SpeedKmh per local hour ("0"-"23") with observations.
+	ByHour map[string]float64 `json:"by_hour"`
+	Slow   bool               `json:"slow"`
+}
+
+// ShapeProfile is the speed profile along one shape of a line.
+type ShapeProfile struct {
+	ShapeID        string           `json:"shape_id"`
+	DirectionID    *int             `json:"direction_id,omitempty"`
+	MedianSpeedKmh float64          `json:"median_speed_kmh"`
+	SlowSegments   int              `json:"slow_segments"`
+	Segments       []SegmentProfile `json:"segments"`
+}
+
+// Profiles returns the speed profiles of a line's shapes over the service
+// days from since on, optionally narrowed to the local hours in hours.
+// Segments are flagged slow when observed often enough and slower than a
+// fraction of the median segment speed of their shape. Shapes without
+// observations are left out.
+func (t *SegmentTracker) Profiles(line string, shapes []*domain.Shape, since time.Time, hours map[int]bool) []ShapeProfile {
+	sinceDay := gtfs.ServiceDay(since).Format("2006-01-02")
+	bySegment := make(map[string]map[int]map[int]segmentTotals) // shape -> index -> hour
+
+	t.mu.Lock()
+	for day, segments := range t.days {
+		if day < sinceDay {
+			continue
+		}
+		for key, totals := range segments {
+			if key.line != line || (hours != nil && !hours[key.hour]) {
+				continue
+			}
+			if bySegment[key.shapeID] == nil {
+				bySegment[key.shapeID] = make(map[int]map[int]segmentTotals)
+			}
+			if bySegment[key.shapeID][key.index] == nil {
+				bySegment[key.shapeID][key.index] = make(map[int]segmentTotals)
+			}
+			sum := bySegment[key.shapeID][key.index][key.hour]
+			sum.meters += totals.meters
+			sum.seconds += totals.seconds
+			sum.samples += totals.samples
+			bySegment[key.shapeID][key.index][key.hour] = sum
+		}
+	}
+	t.mu.Unlock()
+
+	result := make([]ShapeProfile, 0, len(bySegment))
+	for _, shape := range shapes {
+		observed, ok := bySegment[shape.ID]
+		if !ok || len(shape.Points) < 2 {
+			continue
+		}
+		profile := ShapeProfile{ShapeID: shape.ID, DirectionID: shape.DirectionID, Segments: []SegmentProfile{}}
+		cum := cumulativeDistances(shape.Points)
+		for index, byHour := range observed {
+			seg := SegmentProfile{
+				Index:  index,
+				FromM:  float64(index) * t.length,
+				ToM:    math.Min(float64(index+1)*t.length, cum[len(cum)-1]),
+				ByHour: make(map[string]float64, len(byHour)),
+			}
+			seg.Start = pointAt(shape.Points, cum, seg.FromM)
+			seg.End = pointAt(shape.Points, cum, seg.ToM)
+			var total segmentTotals
+			for hour, sum := range byHour {
+				if sum.seconds > 0 {
+					seg.ByHour[strconv.Itoa(hour)] = roundSpeed(sum.meters / sum.seconds * 3.6)
+				}
+				total.meters += sum.meters
+				total.seconds += sum.seconds
+				total.samples += sum.samples
+			}
+			if total.seconds > 0 {
+				seg.SpeedKmh = roundSpeed(total.meters / total.seconds * 3.6)
+			}
+			seg.Samples = total.samples
+			profile.Segments = append(profile.Segments, seg)
+		}
+		sort.Slice(profile.Segments, func(i, j int) bool { return profile.Segments[i].Index < profile.Segments[j].Index })
+
+		speeds := make([]float64, 0, len(profile.Segments))
+		for _, seg := range profile.Segments {
+			if seg.Samples >= minSlowSamples {
+				speeds = append(speeds, seg.SpeedKmh)
+			}
+		}
+		if len(speeds) > 0 {
+			sort.Float64s(speeds)
+			profile.MedianSpeedKmh = speeds[len(speeds)/2]
+			for i := range profile.Segments {
+				seg := &profile.Segments[i]
+				if seg.Samples >= minSlowSamples && seg.SpeedKmh < profile.MedianSpeedKmh*slowRatio {
+					seg.Slow = true
+					profile.SlowSegments++
+				}
+			}
+		}
+		result = append(result, profile)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ShapeID < result[j].ShapeID })
+	return result
+}
+
+func cumulativeDistances(points []domain.ShapePoint) []float64 {
+	cum := make([]float64, len(points))
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		cum[i] = cum[i-1] + geo.Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+	return cum
+}
+
+// pointAt returns the [lat, lon] of the point meters along the shape.
+func pointAt(points []domain.ShapePoint, cum []float64, meters float64) [2]float64 {
+	if len(points) == 0 {
+		return [2]float64{}
+	}
+	i := sort.SearchFloat64s(cum, meters)
+	if i == 0 {
+		return [2]float64{points[0].Lat, points[0].Lon}
+	}
+	if i >= len(points) {
+		last := points[len(points)-1]
+		return [2]float64{last.Lat, last.Lon}
+	}
+	a, b := points[i-1], points[i]
+	var frac float64
+	if span := cum[i] - cum[i-1]; span > 0 {
+		frac = (meters - cum[i-1]) / span
+	}
+	lat, lon := geo.Interpolate(a.Lat, a.Lon, b.Lat, b.Lon, frac)
+	return [2]float64{lat, lon}
+}
+
+func roundSpeed(kmh float64) float64 {
+	return math.Round(kmh*10) / 10
+}
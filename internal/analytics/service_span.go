@@ -0,0 +1,219 @@
+package analytics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+	"wabus/pkg/gtfs"
+)
+
+// Observations up to this long after a line's last scheduled arrival of the
+// previous service day still count towards that day, so night service
+// running past midnight isn't split between two days.
+const spanCarryOver = 30 * time.Minute
+
+// Service span issues.
+const (
+	SpanLateStart   = "late_start"   // first vehicle seen after the first departure
+	SpanEarlyEnd    = "early_end"    // last vehicle seen before the last departure
+	SpanNotObserved = "not_observed" // scheduled, but no vehicle seen all day
+	SpanUnscheduled = "unscheduled"  // vehicles seen without any scheduled trip
+)
+
+// spanObservation is the first and last report of a line on a service day.
+type spanObservation struct {
+	firstAt  time.Time
+	firstKey string
+	lastAt   time.Time
+	lastKey  string
+}
+
+// ServiceSpanTracker records when each line's first and last vehicle of a
+// service day reported, to compare with the first and last scheduled
+// departures. It keeps the last keepDays days in memory.
+type ServiceSpanTracker struct {
+	mu       sync.Mutex
+	gtfs     *store.GTFSStore
+	days     map[string]map[string]*spanObservation // service day -> line
+	cutoffs  map[string]time.Time                   // line|calendar day -> end of the previous day's service
+	keepDays int
+	since    time.Time
+	pruned   time.Time
+}
+
+func NewServiceSpanTracker(gtfsStore *store.GTFSStore, keepDays int) *ServiceSpanTracker {
+	if keepDays < 1 {
+		keepDays = 1
+	}
+	now := time.Now()
+	return &ServiceSpanTracker{
+		gtfs:     gtfsStore,
+		days:     make(map[string]map[string]*spanObservation),
+		cutoffs:  make(map[string]time.Time),
+		keepDays: keepDays,
+		since:    now,
+		pruned:   now,
+	}
+}
+
+// Since returns when tracking started.
+func (t *ServiceSpanTracker) Since() time.Time {
+	return t.since
+}
+
+// Record notes the report of each updated vehicle against its line.
+func (t *ServiceSpanTracker) Record(deltas []domain.VehicleDelta) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range deltas {
+		v := d.Vehicle
+		if d.Type != domain.DeltaUpdate || v == nil || v.Line == "" {
+			continue
+		}
+		day := t.serviceDayLocked(v.Line, v.Timestamp).Format("2006-01-02")
+		lines, ok := t.days[day]
+		if !ok {
+			lines = make(map[string]*spanObservation)
+			t.days[day] = lines
+		}
+		obs, ok := lines[v.Line]
+		if !ok {
+			lines[v.Line] = &spanObservation{firstAt: v.Timestamp, firstKey: v.Key, lastAt: v.Timestamp, lastKey: v.Key}
+			continue
+		}
+		if v.Timestamp.Before(obs.firstAt) {
+			obs.firstAt, obs.firstKey = v.Timestamp, v.Key
+		}
+		if v.Timestamp.After(obs.lastAt) {
+			obs.lastAt, obs.lastKey = v.Timestamp, v.Key
+		}
+	}
+
+	if now := time.Now(); now.Sub(t.pruned) > maxStepGap {
+		t.pruneLocked(now)
+		t.pruned = now
+	}
+}
+
+// serviceDayLocked returns the service day a report of line at ts belongs
+// to: the previous one while that day's service of the line is still
+// running, otherwise the calendar day.
+func (t *ServiceSpanTracker) serviceDayLocked(line string, ts time.Time) time.Time {
+	today := gtfs.ServiceDay(ts)
+	if t.gtfs == nil || !t.gtfs.GetStats().IsLoaded {
+		return today
+	}
+	key := line + "|" + today.Format("2006-01-02")
+	cutoff, ok := t.cutoffs[key]
+	if !ok {
+		if span, scheduled := t.gtfs.ScheduledSpan(line, today.AddDate(0, 0, -1)); scheduled {
+			cutoff = span.LastArrival.Add(spanCarryOver)
+		}
+		t.cutoffs[key] = cutoff
+	}
+	if ts.Before(cutoff) {
+		return today.AddDate(0, 0, -1)
+	}
+	return today
+}
+
+// pruneLocked forgets days past the retention window, keeping one more day
+// as night service may still be running on it. Cached cutoffs are dropped
+// too, so a new GTFS dataset is picked up.
+func (t *ServiceSpanTracker) pruneLocked(now time.Time) {
+	oldest := gtfs.ServiceDay(now).AddDate(0, 0, -t.keepDays).Format("2006-01-02")
+	for day := range t.days {
+		if day < oldest {
+			delete(t.days, day)
+		}
+	}
+	t.cutoffs = make(map[string]time.Time)
+}
+
+// LineServiceSpan compares the first and last observed vehicle of a line on
+// a service day with its first and last scheduled departure. Diffs are
+// observed minus scheduled, so a positive FirstDiffSeconds is a late start
+// and a negative LastDiffSeconds an early end.
+type LineServiceSpan struct {
+	Line             string     `json:"line"`
+	ScheduledTrips   int        `json:"scheduled_trips"`
+	ScheduledFirst   *time.Time `json:"scheduled_first,omitempty"`
+	ScheduledLast    *time.Time `json:"scheduled_last,omitempty"`
+	ObservedFirst    *time.Time `json:"observed_first,omitempty"`
+	FirstVehicle     string     `json:"first_vehicle,omitempty"`
+	ObservedLast     *time.Time `json:"observed_last,omitempty"`
+	LastVehicle      string     `json:"last_vehicle,omitempty"`
+	FirstDiffSeconds *int       `json:"first_diff_seconds,omitempty"`
+	LastDiffSeconds  *int       `json:"last_diff_seconds,omitempty"`
+	Issues           []string   `json:"issues"`
+}
+
+// Compare matches the observations of a service day with its scheduled
+// spans by line. Departures are only judged once they are more than
+// tolerance in the past and were within the tracking period, so a restart
+// doesn't read as a late start.
+func (t *ServiceSpanTracker) Compare(day time.Time, scheduled map[string]domain.ServiceSpan, tolerance time.Duration, now time.Time) []LineServiceSpan {
+	t.mu.Lock()
+	observed := make(map[string]spanObservation)
+	for line, obs := range t.days[gtfs.ServiceDay(day).Format("2006-01-02")] {
+		observed[line] = *obs
+	}
+	t.mu.Unlock()
+
+	lines := make(map[string]struct{}, len(scheduled)+len(observed))
+	for line := range scheduled {
+		lines[line] = struct{}{}
+	}
+	for line := range observed {
+		lines[line] = struct{}{}
+	}
+
+	result := make([]LineServiceSpan, 0, len(lines))
+	for line := range lines {
+		s := LineServiceSpan{Line: line, Issues: []string{}}
+		obs, seen := observed[line]
+		if seen {
+			s.ObservedFirst, s.FirstVehicle = timePtr(obs.firstAt), obs.firstKey
+			s.ObservedLast, s.LastVehicle = timePtr(obs.lastAt), obs.lastKey
+		}
+		span, ok := scheduled[line]
+		if !ok {
+			s.Issues = append(s.Issues, SpanUnscheduled)
+			result = append(result, s)
+			continue
+		}
+		s.ScheduledTrips = span.Trips
+		s.ScheduledFirst = timePtr(span.FirstDeparture)
+		s.ScheduledLast = timePtr(span.LastDeparture)
+
+		firstDue := now.After(span.FirstDeparture.Add(tolerance)) && !t.since.After(span.FirstDeparture)
+		lastDue := now.After(span.LastDeparture.Add(tolerance)) && !t.since.After(span.LastDeparture)
+		switch {
+		case !seen && lastDue:
+			s.Issues = append(s.Issues, SpanNotObserved)
+		case !seen && firstDue:
+			s.Issues = append(s.Issues, SpanLateStart)
+		case seen:
+			s.FirstDiffSeconds = intPtr(int(obs.firstAt.Sub(span.FirstDeparture).Seconds()))
+			s.LastDiffSeconds = intPtr(int(obs.lastAt.Sub(span.LastDeparture).Seconds()))
+			if firstDue && obs.firstAt.After(span.FirstDeparture.Add(tolerance)) {
+				s.Issues = append(s.Issues, SpanLateStart)
+			}
+			if lastDue && obs.lastAt.Before(span.LastDeparture.Add(-tolerance)) {
+				s.Issues = append(s.Issues, SpanEarlyEnd)
+			}
+		}
+		result = append(result, s)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Line < result[j].Line })
+	return result
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
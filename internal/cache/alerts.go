@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+)
+
+// ManualAlerts persists the manual alerts of an alert store in Redis so they
+// survive restarts. Nothing is written before the persisted alerts have been
+// loaded, or the first admin change after starting without Redis would
+// overwrite them with the few alerts made since. Writes happen on one
+// goroutine, each with the alerts as they are by then, so an older list is
+// never written over a newer one.
+type ManualAlerts struct {
+	cache  *RedisCache
+	alerts *store.AlertStore
+	logger *slog.Logger
+	loaded atomic.Bool
+	dirty  chan struct{}
+}
+
+func NewManualAlerts(cache *RedisCache, alerts *store.AlertStore, logger *slog.Logger) *ManualAlerts {
+	return &ManualAlerts{
+		cache:  cache,
+		alerts: alerts,
+		logger: logger.With("component", "manual_alerts"),
+		dirty:  make(chan struct{}, 1),
+	}
+}
+
+// Load merges the persisted manual alerts into the store, keeping those
+// created in the meantime, and schedules a write of the merged list. Once it
+// has succeeded, later calls do nothing.
+func (m *ManualAlerts) Load(ctx context.Context) error {
+	if m.loaded.Load() {
+		return nil
+	}
+	var manual []*domain.Alert
+	if _, err := m.cache.GetJSON(ctx, KeyManualAlerts, &manual); err != nil {
+		return err
+	}
+	m.alerts.LoadManual(manual)
+	m.loaded.Store(true)
+	m.logger.Info("loaded manual alerts", "alerts", len(manual))
+	m.Changed()
+	return nil
+}
+
+// Changed schedules a write of the manual alerts. Changes made while a write
+// is pending are covered by it.
+func (m *ManualAlerts) Changed() {
+	select {
+	case m.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// OnStateChange loads the persisted alerts when Redis becomes available, or
+// rewrites them in case a write failed while it was down. It is meant for
+// RedisCache.AddOnStateChange.
+func (m *ManualAlerts) OnStateChange(available bool) {
+	if !available {
+		return
+	}
+	if m.loaded.Load() {
+		m.Changed()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Load(ctx); err != nil {
+		m.logger.Warn("failed to load manual alerts", "error", err)
+	}
+}
+
+// Run writes the manual alerts after every change until ctx is cancelled.
+// Changes before the persisted alerts were loaded are written with them.
+func (m *ManualAlerts) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.dirty:
+			if !m.loaded.Load() {
+				continue
+			}
+			manual := m.alerts.ListSource(domain.AlertSourceManual)
+			writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err := m.cache.SetJSON(writeCtx, KeyManualAlerts, manual, 0)
+			cancel()
+			if err != nil {
+				m.logger.Warn("failed to persist manual alerts", "error", err)
+			}
+		}
+	}
+}
@@ -1,24 +1,45 @@
 package cache
 
-import "fmt"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
 
 const (
-	KeySyncFull         = "sync:full"
-	KeyRoutes           = "routes"
-	KeyStops            = "stops"
-	KeyCalendars        = "calendars"
-	KeyCalendarDates    = "calendar_dates"
-	KeyGTFSVersion      = "gtfs:version"
+	KeySyncFull       = "sync:full"
+	KeyRoutes         = "routes"
+	KeyStops          = "stops"
+	KeyCalendars      = "calendars"
+	KeyCalendarDates  = "calendar_dates"
+	KeyGTFSVersion    = "gtfs:version"
+	KeyStopPopularity = "stats:stop_popularity"
+	KeyManualAlerts   = "alerts:manual"
+	KeyGeofences      = "geofences:admin"
+	KeyLeaderLock     = "leader:ingestor"
 )
 
-func KeyScheduleToday(stopID string) string {
-	return fmt.Sprintf("schedule:today:%s", stopID)
+// ChannelHubDeltas is the pub/sub channel instances share delta batches on.
+const ChannelHubDeltas = "hub:deltas"
+
+// KeySchedule keys a stop's schedule by service date rather than "today" or
+// "tomorrow", so entries stay correct across midnight until the next refresh.
+func KeySchedule(stopID string, day time.Time) string {
+	return fmt.Sprintf("schedule:%s:%s", day.Format("20060102"), stopID)
 }
 
-func KeyScheduleTomorrow(stopID string) string {
-	return fmt.Sprintf("schedule:tomorrow:%s", stopID)
+// KeyRateLimitBucket keys one persisted rate limit bucket. Each bucket has
+// its own key and expiry, so instances flushing their offenders don't
+// overwrite each other's.
+func KeyRateLimitBucket(bucket string) string {
+	return "ratelimit:bucket:" + bucket
 }
 
+// KeyRateLimitBuckets matches the keys of all persisted rate limit buckets.
+const KeyRateLimitBuckets = "ratelimit:bucket:*"
+
 func KeyStopLines(stopID string) string {
 	return fmt.Sprintf("lines:%s", stopID)
 }
@@ -26,3 +47,21 @@ func KeyStopLines(stopID string) string {
 func KeyRouteShape(routeID string) string {
 	return fmt.Sprintf("shape:%s", routeID)
 }
+
+// KeyDevicePrefs keys a device's preferences by a hash of its token, so the
+// tokens themselves aren't readable from Redis.
+func KeyDevicePrefs(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("device:%s:prefs", hex.EncodeToString(sum[:]))
+}
+
+// KeyClass groups a cache key into its class for statistics, dropping the
+// per-stop, per-date or per-route suffix (e.g. "schedule:20250301:123" -> "schedule").
+func KeyClass(key string) string {
+	parts := strings.Split(key, ":")
+	switch parts[0] {
+	case "schedule", "lines", "shape", "device":
+		return parts[0]
+	}
+	return key
+}
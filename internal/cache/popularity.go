@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// popularityLoadLimit bounds how many persisted stop counters are loaded on start.
+const popularityLoadLimit = 10000
+
+// StopPopularity counts per-stop requests so the warmer can warm the stops
+// clients actually ask for before the long tail. When a Redis cache is set,
+// counters are periodically flushed to a sorted set so they survive restarts.
+type StopPopularity struct {
+	mu      sync.Mutex
+	counts  map[string]int64
+	pending map[string]int64
+	cache   *RedisCache
+	logger  *slog.Logger
+}
+
+func NewStopPopularity(cache *RedisCache, logger *slog.Logger) *StopPopularity {
+	return &StopPopularity{
+		counts:  make(map[string]int64),
+		pending: make(map[string]int64),
+		cache:   cache,
+		logger:  logger.With("component", "stop_popularity"),
+	}
+}
+
+func (p *StopPopularity) Record(stopID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counts[stopID]++
+	if p.cache != nil {
+		p.pending[stopID]++
+	}
+}
+
+// Ranked returns up to n stop IDs with at least one recorded request, most
+// requested first. A non-positive n returns all of them.
+func (p *StopPopularity) Ranked(n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]string, 0, len(p.counts))
+	for id := range p.counts {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ci, cj := p.counts[result[i]], p.counts[result[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return result[i] < result[j]
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// Load merges persisted counters from Redis into memory.
+func (p *StopPopularity) Load(ctx context.Context) error {
+	if p.cache == nil {
+		return nil
+	}
+	persisted, err := p.cache.TopScores(ctx, KeyStopPopularity, popularityLoadLimit)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, count := range persisted {
+		p.counts[id] += count
+	}
+	p.logger.Info("loaded stop popularity", "stops", len(persisted))
+	return nil
+}
+
+// Flush writes counters recorded since the last flush to Redis.
+func (p *StopPopularity) Flush(ctx context.Context) error {
+	if p.cache == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = make(map[string]int64)
+	p.mu.Unlock()
+
+	if err := p.cache.IncrScores(ctx, KeyStopPopularity, pending); err != nil {
+		// Put the increments back so they are retried on the next flush.
+		p.mu.Lock()
+		for id, count := range pending {
+			p.pending[id] += count
+		}
+		p.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes counters to Redis every interval until ctx is cancelled.
+func (p *StopPopularity) Run(ctx context.Context, interval time.Duration) {
+	if p.cache == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := p.Flush(flushCtx); err != nil {
+				p.logger.Warn("final stop popularity flush failed", "error", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := p.Flush(ctx); err != nil {
+				p.logger.Warn("stop popularity flush failed", "error", err)
+			}
+		}
+	}
+}
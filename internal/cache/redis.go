@@ -5,50 +5,176 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// HitRecorder receives cache lookup outcomes, keyed by KeyClass.
+type HitRecorder interface {
+	RecordCacheHit(class string)
+	RecordCacheMiss(class string)
+}
+
+// ErrUnavailable is returned by cache operations while Redis is unreachable.
+var ErrUnavailable = errors.New("redis cache unavailable")
+
 type RedisCache struct {
-	client *redis.Client
-	prefix string
-	logger *slog.Logger
+	client   *redis.Client
+	prefix   string
+	logger   *slog.Logger
+	recorder HitRecorder
+
+	available   atomic.Bool
+	transitions atomic.Int64
+
+	healthMu      sync.RWMutex
+	lastCheck     time.Time
+	lastError     string
+	lastLatency   time.Duration
+	onStateChange []func(available bool)
+}
+
+// RedisHealth describes the outcome of the most recent Redis health check.
+type RedisHealth struct {
+	Available   bool      `json:"available"`
+	LastCheck   time.Time `json:"last_check"`
+	LastError   string    `json:"last_error,omitempty"`
+	LatencyMs   float64   `json:"latency_ms"`
+	Transitions int64     `json:"transitions"`
 }
 
+// NewRedisCache creates a cache and pings Redis once. The cache is returned
+// even when the ping fails; it stays disabled until Monitor sees Redis come up.
 func NewRedisCache(addr, password string, db int, logger *slog.Logger) (*RedisCache, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       db,
 	})
+	client.AddHook(tracingHook{})
+
+	c := &RedisCache{
+		client: client,
+		prefix: "wabus:",
+		logger: logger.With("component", "redis_cache"),
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("redis connection failed: %w", err)
+	if err := c.check(ctx); err != nil {
+		return c, fmt.Errorf("redis connection failed: %w", err)
 	}
+	return c, nil
+}
 
-	return &RedisCache{
-		client: client,
-		prefix: "wabus:",
-		logger: logger.With("component", "redis_cache"),
-	}, nil
+// SetHitRecorder registers where hit/miss outcomes of Get are reported.
+func (c *RedisCache) SetHitRecorder(r HitRecorder) {
+	c.recorder = r
 }
 
 func (c *RedisCache) Close() error {
 	return c.client.Close()
 }
 
+// Available reports whether the last health check succeeded.
+func (c *RedisCache) Available() bool {
+	return c.available.Load()
+}
+
+// AddOnStateChange registers a callback invoked when Redis becomes available
+// or unavailable. Callbacks run in the order they were added.
+func (c *RedisCache) AddOnStateChange(fn func(available bool)) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	c.onStateChange = append(c.onStateChange, fn)
+}
+
+func (c *RedisCache) Health() RedisHealth {
+	c.healthMu.RLock()
+	defer c.healthMu.RUnlock()
+	return RedisHealth{
+		Available:   c.available.Load(),
+		LastCheck:   c.lastCheck,
+		LastError:   c.lastError,
+		LatencyMs:   float64(c.lastLatency.Microseconds()) / 1000,
+		Transitions: c.transitions.Load(),
+	}
+}
+
+// Monitor pings Redis every interval, enabling the cache when it becomes
+// reachable and disabling it when it drops. The client reconnects on its own;
+// Monitor only tracks the resulting state.
+func (c *RedisCache) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			c.check(checkCtx)
+			cancel()
+		}
+	}
+}
+
+// Ping checks now whether Redis answers and how quickly, without waiting
+// for Monitor.
+func (c *RedisCache) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := c.client.Ping(ctx).Err()
+	return time.Since(start), err
+}
+
+func (c *RedisCache) check(ctx context.Context) error {
+	start := time.Now()
+	err := c.client.Ping(ctx).Err()
+	latency := time.Since(start)
+
+	c.healthMu.Lock()
+	c.lastCheck = time.Now()
+	c.lastLatency = latency
+	if err != nil {
+		c.lastError = err.Error()
+	} else {
+		c.lastError = ""
+	}
+	onStateChange := c.onStateChange
+	c.healthMu.Unlock()
+
+	available := err == nil
+	if c.available.Swap(available) != available {
+		c.transitions.Add(1)
+		if available {
+			c.logger.Info("redis cache available", "latency_ms", latency.Milliseconds())
+		} else {
+			c.logger.Warn("redis cache unavailable", "error", err)
+		}
+		for _, fn := range onStateChange {
+			fn(available)
+		}
+	}
+	return err
+}
+
 func (c *RedisCache) key(k string) string {
 	return c.prefix + k
 }
 
 func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !c.Available() {
+		return ErrUnavailable
+	}
 	start := time.Now()
 	err := c.client.Set(ctx, c.key(key), value, ttl).Err()
 	if err != nil {
@@ -60,21 +186,39 @@ func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time
 }
 
 func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if !c.Available() {
+		c.recordMiss(key)
+		return nil, ErrUnavailable
+	}
 	start := time.Now()
 	val, err := c.client.Get(ctx, c.key(key)).Bytes()
 	if err == redis.Nil {
 		c.logger.Debug("cache miss", "key", key)
+		c.recordMiss(key)
 		return nil, nil
 	}
 	if err != nil {
 		c.logger.Error("cache get failed", "key", key, "error", err)
+		c.recordMiss(key)
 		return nil, err
 	}
 	c.logger.Debug("cache hit", "key", key, "size_bytes", len(val), "duration_ms", time.Since(start).Milliseconds())
+	if c.recorder != nil {
+		c.recorder.RecordCacheHit(KeyClass(key))
+	}
 	return val, nil
 }
 
+func (c *RedisCache) recordMiss(key string) {
+	if c.recorder != nil {
+		c.recorder.RecordCacheMiss(KeyClass(key))
+	}
+}
+
 func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	if !c.Available() {
+		return ErrUnavailable
+	}
 	return c.client.Del(ctx, c.key(key)).Err()
 }
 
@@ -100,6 +244,46 @@ func (c *RedisCache) GetJSON(ctx context.Context, key string, dest interface{})
 	return true, nil
 }
 
+// swrEntry wraps a cached JSON value with the time it stops being fresh.
+// The Redis key itself lives longer so stale values can still be served.
+type swrEntry struct {
+	Data       json.RawMessage `json:"data"`
+	FreshUntil time.Time       `json:"fresh_until"`
+}
+
+// SetJSONSWR stores value as fresh for ttl and servable as stale for a further staleFor.
+func (c *RedisCache) SetJSONSWR(ctx context.Context, key string, value interface{}, ttl, staleFor time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+	entry, err := json.Marshal(swrEntry{Data: data, FreshUntil: time.Now().Add(ttl)})
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+	return c.Set(ctx, key, entry, ttl+staleFor)
+}
+
+// GetJSONSWR loads a value stored with SetJSONSWR. stale reports whether the
+// value is past its freshness deadline and should be revalidated.
+func (c *RedisCache) GetJSONSWR(ctx context.Context, key string, dest interface{}) (found bool, stale bool, err error) {
+	data, err := c.Get(ctx, key)
+	if err != nil {
+		return false, false, err
+	}
+	if data == nil {
+		return false, false, nil
+	}
+	var entry swrEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return false, false, fmt.Errorf("json unmarshal: %w", err)
+	}
+	if err := json.Unmarshal(entry.Data, dest); err != nil {
+		return false, false, fmt.Errorf("json unmarshal: %w", err)
+	}
+	return true, time.Now().After(entry.FreshUntil), nil
+}
+
 func (c *RedisCache) SetCompressed(ctx context.Context, key string, value []byte, ttl time.Duration) error {
 	compressed, err := gzipCompress(value)
 	if err != nil {
@@ -140,6 +324,9 @@ func (c *RedisCache) GetJSONCompressed(ctx context.Context, key string, dest int
 }
 
 func (c *RedisCache) DeletePattern(ctx context.Context, pattern string) error {
+	if !c.Available() {
+		return ErrUnavailable
+	}
 	iter := c.client.Scan(ctx, 0, c.key(pattern), 0).Iterator()
 	for iter.Next(ctx) {
 		if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
@@ -149,6 +336,98 @@ func (c *RedisCache) DeletePattern(ctx context.Context, pattern string) error {
 	return iter.Err()
 }
 
+// JSONEntry is a value SetJSONBatch stores under Key for TTL.
+type JSONEntry struct {
+	Key   string
+	Value any
+	TTL   time.Duration
+}
+
+// SetJSONBatch stores each entry under its own key and TTL in one round trip.
+func (c *RedisCache) SetJSONBatch(ctx context.Context, entries []JSONEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if !c.Available() {
+		return ErrUnavailable
+	}
+	pipe := c.client.Pipeline()
+	for _, e := range entries {
+		data, err := json.Marshal(e.Value)
+		if err != nil {
+			return fmt.Errorf("json marshal: %w", err)
+		}
+		pipe.Set(ctx, c.key(e.Key), data, e.TTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetPattern returns the values of all keys matching pattern.
+func (c *RedisCache) GetPattern(ctx context.Context, pattern string) ([][]byte, error) {
+	if !c.Available() {
+		return nil, ErrUnavailable
+	}
+	var keys []string
+	iter := c.client.Scan(ctx, 0, c.key(pattern), 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), 500)]
+		keys = keys[len(batch):]
+		results, err := c.client.MGet(ctx, batch...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range results {
+			if v, ok := r.(string); ok { // nil when expired since the scan
+				values = append(values, []byte(v))
+			}
+		}
+	}
+	return values, nil
+}
+
+// IncrScores adds the given increments to members of a sorted set in one round trip.
+func (c *RedisCache) IncrScores(ctx context.Context, key string, increments map[string]int64) error {
+	if len(increments) == 0 {
+		return nil
+	}
+	if !c.Available() {
+		return ErrUnavailable
+	}
+	pipe := c.client.Pipeline()
+	for member, incr := range increments {
+		pipe.ZIncrBy(ctx, c.key(key), float64(incr), member)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// TopScores returns up to n members of a sorted set with the highest scores.
+func (c *RedisCache) TopScores(ctx context.Context, key string, n int) (map[string]int64, error) {
+	if !c.Available() {
+		return nil, ErrUnavailable
+	}
+	zs, err := c.client.ZRevRangeWithScores(ctx, c.key(key), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(zs))
+	for _, z := range zs {
+		if member, ok := z.Member.(string); ok {
+			result[member] = int64(z.Score)
+		}
+	}
+	return result, nil
+}
+
 func gzipCompress(data []byte) ([]byte, error) {
 	var buf bytes.Buffer
 	gz := gzip.NewWriter(&buf)
@@ -169,3 +448,79 @@ func gzipDecompress(data []byte) ([]byte, error) {
 	defer gz.Close()
 	return io.ReadAll(gz)
 }
+
+// acquireLockScript takes a lock for ARGV[1] if it is free, or extends it if
+// ARGV[1] already holds it, and returns the current holder.
+var acquireLockScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if not cur then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return ARGV[1]
+end
+if cur == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return cur
+`)
+
+// releaseLockScript deletes the lock only if ARGV[1] holds it.
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// AcquireLock takes or renews a lock held for ttl and returns its holder,
+// which equals owner when the lock is ours.
+func (c *RedisCache) AcquireLock(ctx context.Context, key, owner string, ttl time.Duration) (string, error) {
+	if !c.Available() {
+		return "", ErrUnavailable
+	}
+	return acquireLockScript.Run(ctx, c.client, []string{c.key(key)}, owner, ttl.Milliseconds()).Text()
+}
+
+// ReleaseLock frees a lock if owner holds it.
+func (c *RedisCache) ReleaseLock(ctx context.Context, key, owner string) error {
+	if !c.Available() {
+		return ErrUnavailable
+	}
+	return releaseLockScript.Run(ctx, c.client, []string{c.key(key)}, owner).Err()
+}
+
+// Publish sends payload to the subscribers of channel on every instance.
+func (c *RedisCache) Publish(ctx context.Context, channel string, payload []byte) error {
+	if !c.Available() {
+		return ErrUnavailable
+	}
+	return c.client.Publish(ctx, c.key(channel), payload).Err()
+}
+
+// Subscribe delivers the messages published to channel until ctx is done.
+// The subscription survives Redis outages by reconnecting; messages
+// published in the meantime are lost.
+func (c *RedisCache) Subscribe(ctx context.Context, channel string) <-chan []byte {
+	ps := c.client.Subscribe(ctx, c.key(channel))
+	out := make(chan []byte, 64)
+	go func() {
+		defer close(out)
+		defer ps.Close()
+		messages := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case out <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
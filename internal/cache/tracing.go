@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"wabus/internal/tracing"
+)
+
+// tracingHook records a client span for every Redis command and pipeline
+// run within a trace, leaving out health checks and background work.
+// Misses aren't errors.
+type tracingHook struct{}
+
+func (tracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (tracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := tracing.StartChild(ctx, "redis "+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.String("db.operation", cmd.Name()),
+			),
+		)
+		err := next(ctx, cmd)
+		if !errors.Is(err, redis.Nil) {
+			tracing.SetError(span, err)
+		}
+		span.End()
+		return err
+	}
+}
+
+func (tracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := tracing.StartChild(ctx, "redis pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.Int("db.redis.commands", len(cmds)),
+			),
+		)
+		err := next(ctx, cmds)
+		if !errors.Is(err, redis.Nil) {
+			tracing.SetError(span, err)
+		}
+		span.End()
+		return err
+	}
+}
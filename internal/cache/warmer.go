@@ -7,24 +7,36 @@ import (
 
 	"wabus/internal/domain"
 	"wabus/internal/store"
+	"wabus/pkg/gtfs"
 )
 
 type CacheWarmer struct {
-	cache  *RedisCache
-	store  *store.GTFSStore
-	ttl    time.Duration
-	logger *slog.Logger
+	cache        *RedisCache
+	store        *store.GTFSStore
+	popularity   *StopPopularity
+	ttl          time.Duration
+	staleTTL     time.Duration
+	topN         int  // max popular stops warmed; <= 0 means no limit
+	warmLongTail bool // also warm stops that were never requested
+	logger       *slog.Logger
 }
 
-func NewCacheWarmer(cache *RedisCache, store *store.GTFSStore, ttl time.Duration, logger *slog.Logger) *CacheWarmer {
+func NewCacheWarmer(cache *RedisCache, store *store.GTFSStore, popularity *StopPopularity, ttl, staleTTL time.Duration, topN int, warmLongTail bool, logger *slog.Logger) *CacheWarmer {
 	return &CacheWarmer{
-		cache:  cache,
-		store:  store,
-		ttl:    ttl,
-		logger: logger.With("component", "cache_warmer"),
+		cache:        cache,
+		store:        store,
+		popularity:   popularity,
+		ttl:          ttl,
+		staleTTL:     staleTTL,
+		topN:         topN,
+		warmLongTail: warmLongTail,
+		logger:       logger.With("component", "cache_warmer"),
 	}
 }
 
+// WarmAll warms the cache in stages so the most used keys are hot first:
+// the sync payload, then the top-N stops ranked by request frequency, then
+// (optionally) the long tail. Without any popularity data all stops are warmed.
 func (w *CacheWarmer) WarmAll(ctx context.Context) error {
 	start := time.Now()
 	w.logger.Info("starting cache warming")
@@ -33,12 +45,16 @@ func (w *CacheWarmer) WarmAll(ctx context.Context) error {
 		w.logger.Error("failed to warm sync data", "error", err)
 	}
 
-	if err := w.warmSchedules(ctx); err != nil {
-		w.logger.Error("failed to warm schedules", "error", err)
+	popular, tail := w.partitionStops()
+
+	if err := w.warmStops(ctx, "popular", popular); err != nil {
+		w.logger.Error("failed to warm popular stops", "error", err)
+		return err
 	}
 
-	if err := w.warmStopLines(ctx); err != nil {
-		w.logger.Error("failed to warm stop lines", "error", err)
+	if err := w.warmStops(ctx, "long_tail", tail); err != nil {
+		w.logger.Error("failed to warm long tail stops", "error", err)
+		return err
 	}
 
 	w.logger.Info("cache warming completed", "duration_ms", time.Since(start).Milliseconds())
@@ -61,65 +77,94 @@ func (w *CacheWarmer) warmSyncData(ctx context.Context) error {
 	return nil
 }
 
-func (w *CacheWarmer) warmSchedules(ctx context.Context) error {
-	start := time.Now()
-	today := time.Now()
-	tomorrow := today.AddDate(0, 0, 1)
-
+// partitionStops splits known stops into the top-N most requested (most
+// requested first) and the long tail. The tail is empty unless long-tail
+// warming is enabled or no stop has been requested yet.
+func (w *CacheWarmer) partitionStops() (popular, tail []string) {
 	stops := w.store.GetAllStops()
-	warmed := 0
-
+	known := make(map[string]struct{}, len(stops))
 	for _, stop := range stops {
-		todaySchedule := w.store.GetStopScheduleForDate(stop.ID, today)
-		if len(todaySchedule) > 0 {
-			if err := w.cache.SetJSON(ctx, KeyScheduleToday(stop.ID), todaySchedule, w.ttl); err != nil {
-				w.logger.Debug("failed to cache today schedule", "stop_id", stop.ID, "error", err)
-				continue
-			}
-		}
+		known[stop.ID] = struct{}{}
+	}
 
-		tomorrowSchedule := w.store.GetStopScheduleForDate(stop.ID, tomorrow)
-		if len(tomorrowSchedule) > 0 {
-			if err := w.cache.SetJSON(ctx, KeyScheduleTomorrow(stop.ID), tomorrowSchedule, w.ttl); err != nil {
-				w.logger.Debug("failed to cache tomorrow schedule", "stop_id", stop.ID, "error", err)
-				continue
+	seen := make(map[string]struct{})
+	if w.popularity != nil {
+		for _, id := range w.popularity.Ranked(0) {
+			if w.topN > 0 && len(popular) >= w.topN {
+				break
+			}
+			if _, ok := known[id]; ok {
+				popular = append(popular, id)
+				seen[id] = struct{}{}
 			}
 		}
+	}
 
-		warmed++
+	if len(popular) > 0 && !w.warmLongTail {
+		return popular, nil
 	}
 
-	w.logger.Info("warmed schedules",
-		"stops_warmed", warmed,
-		"total_stops", len(stops),
-		"duration_ms", time.Since(start).Milliseconds(),
-	)
-	return nil
+	tail = make([]string, 0, len(stops)-len(popular))
+	for _, stop := range stops {
+		if _, ok := seen[stop.ID]; !ok {
+			tail = append(tail, stop.ID)
+		}
+	}
+	return popular, tail
 }
 
-func (w *CacheWarmer) warmStopLines(ctx context.Context) error {
+func (w *CacheWarmer) warmStops(ctx context.Context, stage string, stopIDs []string) error {
 	start := time.Now()
-	stops := w.store.GetAllStops()
+	today := gtfs.ServiceDay(time.Now())
+	tomorrow := today.AddDate(0, 0, 1)
 	warmed := 0
 
-	for _, stop := range stops {
-		lines := w.store.GetStopLines(stop.ID)
-		if len(lines) > 0 {
-			if err := w.cache.SetJSON(ctx, KeyStopLines(stop.ID), lines, w.ttl); err != nil {
-				w.logger.Debug("failed to cache stop lines", "stop_id", stop.ID, "error", err)
-				continue
-			}
+	for _, stopID := range stopIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if w.warmStop(ctx, stopID, today, tomorrow) {
 			warmed++
 		}
 	}
 
-	w.logger.Info("warmed stop lines",
+	w.logger.Info("warmed stops",
+		"stage", stage,
 		"stops_warmed", warmed,
+		"total_stops", len(stopIDs),
 		"duration_ms", time.Since(start).Milliseconds(),
 	)
 	return nil
 }
 
+func (w *CacheWarmer) warmStop(ctx context.Context, stopID string, today, tomorrow time.Time) bool {
+	todaySchedule := w.store.GetStopScheduleForDate(stopID, today)
+	if len(todaySchedule) > 0 {
+		if err := w.cache.SetJSONSWR(ctx, KeySchedule(stopID, today), todaySchedule, w.ttl, w.staleTTL); err != nil {
+			w.logger.Debug("failed to cache today schedule", "stop_id", stopID, "error", err)
+			return false
+		}
+	}
+
+	tomorrowSchedule := w.store.GetStopScheduleForDate(stopID, tomorrow)
+	if len(tomorrowSchedule) > 0 {
+		if err := w.cache.SetJSONSWR(ctx, KeySchedule(stopID, tomorrow), tomorrowSchedule, w.ttl, w.staleTTL); err != nil {
+			w.logger.Debug("failed to cache tomorrow schedule", "stop_id", stopID, "error", err)
+			return false
+		}
+	}
+
+	lines := w.store.GetStopLines(stopID)
+	if len(lines) > 0 {
+		if err := w.cache.SetJSONSWR(ctx, KeyStopLines(stopID), lines, w.ttl, w.staleTTL); err != nil {
+			w.logger.Debug("failed to cache stop lines", "stop_id", stopID, "error", err)
+			return false
+		}
+	}
+
+	return true
+}
+
 type SyncData struct {
 	Routes        []*domain.Route        `json:"routes"`
 	Stops         []*domain.Stop         `json:"stops"`
@@ -144,22 +189,51 @@ func (w *CacheWarmer) buildSyncData() *SyncData {
 	}
 }
 
+// refreshCheckInterval is how often the refresh schedule is checked against
+// the wall clock, bounding how late a refresh runs after a suspend.
+const refreshCheckInterval = time.Minute
+
+// ScheduleMidnightRefresh rewarms the cache shortly after midnight in the feed
+// timezone. The wall clock is polled instead of sleeping for the computed
+// duration, because monotonic timers stop while a VM is suspended; a refresh
+// that was missed that way runs as soon as the process resumes.
 func (w *CacheWarmer) ScheduleMidnightRefresh(ctx context.Context) {
-	for {
-		now := time.Now()
-		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 5, 0, 0, now.Location())
-		waitDuration := midnight.Sub(now)
+	next := nextRefreshTime(time.Now())
+	w.logger.Info("scheduled next cache refresh", "at", next, "in", time.Until(next).Round(time.Second))
 
-		w.logger.Info("scheduled next cache refresh", "at", midnight, "in", waitDuration)
+	ticker := time.NewTicker(refreshCheckInterval)
+	defer ticker.Stop()
 
+	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(waitDuration):
-			w.logger.Info("midnight cache refresh starting")
+		case <-ticker.C:
+			now := time.Now().Round(0) // strip the monotonic reading, compare wall time
+			if now.Before(next) {
+				continue
+			}
+
+			if late := now.Sub(next); late > 2*refreshCheckInterval {
+				w.logger.Warn("catching up missed cache refresh", "scheduled_at", next, "late_by", late.Round(time.Second))
+			} else {
+				w.logger.Info("midnight cache refresh starting")
+			}
 			if err := w.WarmAll(ctx); err != nil {
 				w.logger.Error("midnight cache refresh failed", "error", err)
 			}
+
+			next = nextRefreshTime(now)
+			w.logger.Info("scheduled next cache refresh", "at", next, "in", time.Until(next).Round(time.Second))
 		}
 	}
 }
+
+// nextRefreshTime returns 00:05 on the day after now in the feed timezone.
+// time.Date normalizes through DST transitions, so 23h and 25h days resolve
+// to the correct wall-clock instant.
+func nextRefreshTime(now time.Time) time.Time {
+	loc := gtfs.Location()
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 5, 0, 0, loc)
+}
@@ -0,0 +1,85 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Purger asks a CDN to drop cached responses tagged with surrogate keys by
+// calling a configurable webhook.
+type Purger struct {
+	url    string
+	token  string
+	client *http.Client
+	logger *slog.Logger
+}
+
+// PurgeRequest is the JSON body posted to the purge webhook.
+type PurgeRequest struct {
+	SurrogateKeys []string `json:"surrogate_keys"`
+	Reason        string   `json:"reason"`
+}
+
+// NewPurger returns a purger for the webhook at url. The token, if set, is
+// sent as a bearer token.
+func NewPurger(url, token string, logger *slog.Logger) *Purger {
+	return &Purger{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger.With("component", "cdn_purger"),
+	}
+}
+
+// Purge requests invalidation of all responses tagged with any of keys,
+// retrying once on failure.
+func (p *Purger) Purge(ctx context.Context, reason string, keys ...string) error {
+	body, err := json.Marshal(PurgeRequest{SurrogateKeys: keys, Reason: reason})
+	if err != nil {
+		return fmt.Errorf("marshal purge request: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = p.send(ctx, body)
+		if err == nil {
+			p.logger.Info("CDN purge requested", "keys", keys, "reason", reason)
+			return nil
+		}
+		if attempt == 2 || ctx.Err() != nil {
+			return err
+		}
+		p.logger.Warn("CDN purge failed, retrying", "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (p *Purger) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send purge request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("purge webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
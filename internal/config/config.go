@@ -1,11 +1,10 @@
 package config
 
 import (
-	"fmt"
 	"log/slog"
 	"os"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -21,130 +20,378 @@ type Config struct {
 	WarsawResourceID string
 	PollInterval     time.Duration
 
+	UpstreamBreakerThreshold int
+	UpstreamBreakerCooldown  time.Duration
+
 	VehicleStaleAfter time.Duration
 	TileZoomLevel     int
+	// TileZoomLegacy, if set, is a previously configured TileZoomLevel whose
+	// tile IDs are still accepted, mapped onto the current zoom, until
+	// TileZoomLegacyUntil (zero: indefinitely).
+	TileZoomLegacy      int
+	TileZoomLegacyUntil time.Time
+
+	// WSSnapshotChunkBytes bounds the size of one WS snapshot message on the
+	// wire, after compression on connections using permessage-deflate.
+	WSSnapshotChunkBytes int
+	// WSMaxTiles caps the tiles one WS client may subscribe to by ID.
+	WSMaxTiles int
+	// WSMaxLines caps the lines one WS client may follow with subscribe_lines.
+	WSMaxLines int
+	// WSDeltaCoalesce > 0 sends each WS client its deltas at most this
+	// often, merged by vehicle.
+	WSDeltaCoalesce time.Duration
+	// WSSlowClientTimeout > 0 disconnects WS and SSE clients whose send
+	// buffer has been full for this long.
+	WSSlowClientTimeout time.Duration
+	// WSCompression is the permessage-deflate mode offered to WS clients:
+	// "disabled", "no_context_takeover" or "context_takeover". Messages
+	// smaller than WSCompressionThreshold bytes are sent uncompressed.
+	WSCompression          string
+	WSCompressionThreshold int
+	// WSAuthTokens and WSAuthSecrets, when either is set, require WS
+	// clients to present one of the tokens or a token signed with one of
+	// the secrets.
+	WSAuthTokens  []string
+	WSAuthSecrets []string
 
 	GTFSEnabled        bool
 	GTFSURL            string
 	GTFSUpdateInterval time.Duration
+	GTFSArchiveKeep    int
+	// PredictionInterval is how often vehicles are matched to scheduled
+	// trips for the GTFS-RT TripUpdates feed.
+	PredictionInterval time.Duration
+	// GTFSShapeCacheSize > 0 keeps full-resolution shapes on disk and only
+	// this many of them in memory, next to shapes simplified to
+	// GTFSShapeSimplifyMeters.
+	GTFSShapeCacheSize      int
+	GTFSShapeSimplifyMeters int
+	// ReadyRequireGTFS keeps /readyz unready until the first GTFS dataset is
+	// loaded, or ReadyGTFSGrace has passed since startup; 0 waits for it
+	// indefinitely.
+	ReadyRequireGTFS bool
+	ReadyGTFSGrace   time.Duration
+	// UnitGroupTypes lists the vehicle types ("bus", "tram") whose units
+	// running the same line and brigade within UnitGroupDistance meters
+	// are grouped as one coupled vehicle.
+	UnitGroupTypes    []string
+	UnitGroupDistance int
+	// PositionPrecision > 0 rounds stored vehicle positions to this many
+	// decimal places; 5 is about a meter. 0 keeps upstream precision.
+	PositionPrecision int
+
+	// BunchingThreshold is the ratio of a gap to the scheduled headway
+	// below which vehicles are flagged as bunched, checked every
+	// BunchingInterval.
+	BunchingThreshold float64
+	BunchingInterval  time.Duration
+
+	// MatcherCanary names a matcher variant run alongside the served one,
+	// whose disagreements are only logged and counted: "full_shapes"
+	// matches against full-resolution shapes. Empty disables it.
+	MatcherCanary string
 
-	RedisEnabled     bool
-	RedisAddr        string
-	RedisPassword    string
-	RedisDB          int
-	CacheTTL         time.Duration
-	CacheWarmOnStart bool
+	RedisEnabled        bool
+	RedisAddr           string
+	RedisPassword       string
+	RedisDB             int
+	RedisHealthInterval time.Duration
+	CacheTTL            time.Duration
+	CacheStaleTTL       time.Duration
+	CacheWarmOnStart    bool
+	CacheWarmTopN       int
+	CacheWarmAll        bool
+	// DevicePrefsTTL is how long device preferences are kept after their
+	// last write.
+	DevicePrefsTTL time.Duration
 
+	// RateLimitPerWindow is the per-IP budget, refilled gradually over
+	// RateLimitWindow; RateLimitBurst lets clients exceed it briefly.
 	RateLimitPerWindow int
 	RateLimitWindow    time.Duration
+	RateLimitBurst     int
 	RateLimitWhitelist []string
+	// RateLimitExemptPaths bypass the limiter; RateLimitPathBudgets give path
+	// prefixes their own per-window budget.
+	RateLimitExemptPaths []string
+	RateLimitPathBudgets map[string]int
+
+	ConcurrencyBulk   int
+	ConcurrencyShapes int
+
+	// GzipMinSize and GzipLevel tune response compression. GzipContentTypes
+	// limits it to those media types (empty: all but compressed formats);
+	// GzipExcludePaths are path prefixes never compressed.
+	GzipMinSize      int
+	GzipLevel        int
+	GzipContentTypes []string
+	GzipExcludePaths []string
+
+	// CDNPurgeURL, if set, is called with the GTFS surrogate key on every
+	// GTFS update.
+	CDNPurgeURL   string
+	CDNPurgeToken string
+
+	// ReplicationToken enables the replication snapshot endpoint; peers
+	// authenticate with it. ReplicationPeerURL is a peer's snapshot URL to
+	// bootstrap from on start.
+	ReplicationToken   string
+	ReplicationPeerURL string
+
+	// LeaderElection lets only the instance holding a Redis lock poll
+	// upstream; the others stream its deltas. Requires Redis, a replication
+	// token and ReplicationAdvertiseURL, the base URL peers reach this
+	// instance at.
+	LeaderElection          bool
+	LeaderLockTTL           time.Duration
+	ReplicationAdvertiseURL string
+	// HubFanout is how the deltas of a poll reach the other instances:
+	// "local" keeps them on the polling instance (followers of a leader
+	// stream them), "redis" publishes them on a Redis channel and "nats"
+	// on NATSFanoutSubject, for all instances to apply.
+	HubFanout string
+	// NATSURL is the NATS server (nats://[user:pass@]host:port) for the
+	// "nats" fanout and the firehose. NATSFirehoseSubject, when set, gets
+	// every delta batch this instance polled, for external consumers.
+	NATSURL             string
+	NATSFanoutSubject   string
+	NATSFirehoseSubject string
+
+	// FleetFile lists vehicle propulsion for emission estimates.
+	FleetFile         string
+	AnalyticsKeepDays int
+	// SegmentLength is the length in meters of the shape segments speeds
+	// are aggregated into.
+	SegmentLength int
+	// ServiceSpanTolerance is how far a line's first or last observed
+	// vehicle may be off its first or last scheduled departure.
+	ServiceSpanTolerance time.Duration
+
+	// GeofencesFile is a JSON array of fences watched for vehicles entering
+	// and leaving, next to those created through the admin API. Events are
+	// posted to GeofenceWebhookURL when set.
+	GeofencesFile        string
+	GeofenceWebhookURL   string
+	GeofenceWebhookToken string
+
+	// Daily position snapshots, uploaded to S3-compatible storage when
+	// SnapshotS3Bucket is set.
+	SnapshotDir            string
+	SnapshotUploadInterval time.Duration
+	SnapshotRetentionDays  int
+	SnapshotS3Endpoint     string
+	SnapshotS3Region       string
+	SnapshotS3Bucket       string
+	SnapshotS3Prefix       string
+	SnapshotS3AccessKey    string
+	SnapshotS3SecretKey    string
+	SnapshotS3PathStyle    bool
+
+	// HistoryDir stores every accepted vehicle position for the history
+	// API; empty disables it. HistoryMaxRange caps one query.
+	HistoryDir           string
+	HistoryRetentionDays int
+	HistoryMaxRange      time.Duration
+
+	// AdminToken enables the /v1/admin API; empty disables it.
+	AdminToken string
+
+	// PrivacyMode drops vehicle numbers and brigades from public responses
+	// and replaces vehicle keys by opaque IDs derived with PrivacySecret
+	// (random per start when empty). Requests bearing AdminToken are exempt.
+	PrivacyMode   bool
+	PrivacySecret string
+
+	// AlertsFeedURL is the RSS feed of ZTM disruption notices; empty
+	// disables the alert ingestor.
+	AlertsFeedURL      string
+	AlertsPollInterval time.Duration
+
+	// GBFSFeeds are the auto-discovery URLs of shared bike and scooter
+	// systems shown as the micromobility layer; empty disables it.
+	GBFSFeeds        []string
+	GBFSPollInterval time.Duration
+
+	// POIFile is a GeoJSON FeatureCollection of static points of interest
+	// (Park & Ride lots, ticket machines, ZTM points); empty disables the
+	// layer.
+	POIFile string
+
+	// DEMDir holds SRTM .hgt height tiles covering the network, enabling
+	// ?elevation=true on shapes; empty disables it.
+	DEMDir string
+
+	// AccessLog logs every request, or the share AccessLogSampleRate of
+	// them; server errors are always logged.
+	AccessLog           bool
+	AccessLogSampleRate float64
+
+	// DebugAddr is the address of an internal listener serving pprof and
+	// runtime debug endpoints without authentication; empty disables it.
+	DebugAddr string
+
+	// OTelEndpoint is the base URL of an OpenTelemetry collector receiving
+	// traces over OTLP/HTTP; empty disables tracing. OTelSampleRatio is the
+	// share of new traces recorded.
+	OTelEndpoint    string
+	OTelServiceName string
+	OTelSampleRatio float64
+	OTelHeaders     map[string]string
+
+	// settings lists every variable read, for Settings.
+	settings []Setting
 }
 
 func Load() (*Config, error) {
-	apiKey := os.Getenv("WARSAW_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("WARSAW_API_KEY environment variable is required")
-	}
+	e := &env{}
+	cfg := &Config{
+		LogLevel:        e.getLogLevel("LOG_LEVEL", slog.LevelInfo),
+		HTTPAddr:        e.get("HTTP_ADDR", ":8080"),
+		ReadTimeout:     e.getDuration("READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:    e.getDuration("WRITE_TIMEOUT", 10*time.Second),
+		ShutdownTimeout: e.getDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
 
-	return &Config{
-		LogLevel:        getLogLevelEnv("LOG_LEVEL", slog.LevelInfo),
-		HTTPAddr:        getEnv("HTTP_ADDR", ":8080"),
-		ReadTimeout:     getDurationEnv("READ_TIMEOUT", 10*time.Second),
-		WriteTimeout:    getDurationEnv("WRITE_TIMEOUT", 10*time.Second),
-		ShutdownTimeout: getDurationEnv("SHUTDOWN_TIMEOUT", 30*time.Second),
-
-		WarsawAPIBaseURL: getEnv("WARSAW_API_URL", "https://api.um.warszawa.pl/api/action/busestrams_get"),
-		WarsawAPIKey:     apiKey,
-		WarsawResourceID: getEnv("WARSAW_RESOURCE_ID", "f2e5503e-927d-4ad3-9500-4ab9e55deb59"),
-		PollInterval:     getDurationEnv("POLL_INTERVAL", 10*time.Second),
-
-		VehicleStaleAfter: getDurationEnv("VEHICLE_STALE_AFTER", 5*time.Minute),
-		TileZoomLevel:     getIntEnv("TILE_ZOOM_LEVEL", 14),
-
-		GTFSEnabled:        getBoolEnv("GTFS_ENABLED", true),
-		GTFSURL:            getEnv("GTFS_URL", "https://mkuran.pl/gtfs/warsaw.zip"),
-		GTFSUpdateInterval: getDurationEnv("GTFS_UPDATE_INTERVAL", 24*time.Hour),
-
-		RedisEnabled:     getBoolEnv("REDIS_ENABLED", false),
-		RedisAddr:        getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisPassword:    getEnv("REDIS_PASSWORD", ""),
-		RedisDB:          getIntEnv("REDIS_DB", 0),
-		CacheTTL:         getDurationEnv("CACHE_TTL", 24*time.Hour),
-		CacheWarmOnStart: getBoolEnv("CACHE_WARM_ON_START", true),
-
-		RateLimitPerWindow: getIntEnv("RATE_LIMIT_PER_WINDOW", 120),
-		RateLimitWindow:    getDurationEnv("RATE_LIMIT_WINDOW", time.Minute),
-		RateLimitWhitelist: getCSVEnv("RATE_LIMIT_WHITELIST"),
-	}, nil
-}
+		WarsawAPIBaseURL: e.get("WARSAW_API_URL", "https://api.um.warszawa.pl/api/action/busestrams_get"),
+		WarsawAPIKey:     e.get("WARSAW_API_KEY", ""),
+		WarsawResourceID: e.get("WARSAW_RESOURCE_ID", "f2e5503e-927d-4ad3-9500-4ab9e55deb59"),
+		PollInterval:     e.getDuration("POLL_INTERVAL", 10*time.Second),
 
-func getEnv(key, defaultVal string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return defaultVal
-}
+		UpstreamBreakerThreshold: e.getInt("UPSTREAM_BREAKER_THRESHOLD", 5),
+		UpstreamBreakerCooldown:  e.getDuration("UPSTREAM_BREAKER_COOLDOWN", time.Minute),
 
-func getDurationEnv(key string, defaultVal time.Duration) time.Duration {
-	if v := os.Getenv(key); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			return d
-		}
-	}
-	return defaultVal
-}
+		VehicleStaleAfter: e.getDuration("VEHICLE_STALE_AFTER", 5*time.Minute),
+		TileZoomLevel:     e.getInt("TILE_ZOOM_LEVEL", 14),
 
-func getIntEnv(key string, defaultVal int) int {
-	if v := os.Getenv(key); v != "" {
-		if i, err := strconv.Atoi(v); err == nil {
-			return i
-		}
-	}
-	return defaultVal
-}
+		TileZoomLegacy:      e.getInt("TILE_ZOOM_LEGACY", 0),
+		TileZoomLegacyUntil: e.getTime("TILE_ZOOM_LEGACY_UNTIL"),
 
-func getBoolEnv(key string, defaultVal bool) bool {
-	if v := os.Getenv(key); v != "" {
-		if b, err := strconv.ParseBool(v); err == nil {
-			return b
-		}
-	}
-	return defaultVal
-}
+		WSSnapshotChunkBytes: e.getInt("WS_SNAPSHOT_CHUNK_BYTES", 256<<10),
+		WSMaxTiles:           e.getInt("WS_MAX_TILES", 200),
+		WSMaxLines:           e.getInt("WS_MAX_LINES", 20),
 
-func getLogLevelEnv(key string, defaultVal slog.Level) slog.Level {
-	v := os.Getenv(key)
-	if v == "" {
-		return defaultVal
-	}
+		WSDeltaCoalesce:        e.getDuration("WS_DELTA_COALESCE", 0),
+		WSSlowClientTimeout:    e.getDuration("WS_SLOW_CLIENT_TIMEOUT", 30*time.Second),
+		WSCompression:          e.get("WS_COMPRESSION", "no_context_takeover"),
+		WSCompressionThreshold: e.getInt("WS_COMPRESSION_THRESHOLD", 512),
+		WSAuthTokens:           e.getCSV("WS_AUTH_TOKENS"),
+		WSAuthSecrets:          e.getCSV("WS_AUTH_SECRETS"),
 
-	switch strings.ToLower(v) {
-	case "debug":
-		return slog.LevelDebug
-	case "info":
-		return slog.LevelInfo
-	case "warn", "warning":
-		return slog.LevelWarn
-	case "error":
-		return slog.LevelError
-	default:
-		return defaultVal
-	}
-}
+		GTFSEnabled:        e.getBool("GTFS_ENABLED", true),
+		GTFSURL:            e.get("GTFS_URL", "https://mkuran.pl/gtfs/warsaw.zip"),
+		GTFSUpdateInterval: e.getDuration("GTFS_UPDATE_INTERVAL", 24*time.Hour),
+		GTFSArchiveKeep:    e.getInt("GTFS_ARCHIVE_KEEP", 5),
+		PredictionInterval: e.getDuration("PREDICTION_INTERVAL", 15*time.Second),
+
+		GTFSShapeCacheSize:      e.getInt("GTFS_SHAPE_CACHE_SIZE", 0),
+		GTFSShapeSimplifyMeters: e.getInt("GTFS_SHAPE_SIMPLIFY_METERS", 5),
+		ReadyRequireGTFS:        e.getBool("READY_REQUIRE_GTFS", true),
+		ReadyGTFSGrace:          e.getDuration("READY_GTFS_GRACE", 5*time.Minute),
+		MatcherCanary:           e.get("MATCHER_CANARY", ""),
+
+		UnitGroupTypes:    e.getCSVDefault("UNIT_GROUP_TYPES", []string{"tram"}),
+		UnitGroupDistance: e.getInt("UNIT_GROUP_DISTANCE", 100),
+		PositionPrecision: e.getInt("POSITION_PRECISION", 0),
+
+		BunchingThreshold: e.getFloat("BUNCHING_THRESHOLD", 0.5),
+		BunchingInterval:  e.getDuration("BUNCHING_INTERVAL", 30*time.Second),
+
+		RedisEnabled:        e.getBool("REDIS_ENABLED", false),
+		RedisAddr:           e.get("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:       e.get("REDIS_PASSWORD", ""),
+		RedisDB:             e.getInt("REDIS_DB", 0),
+		RedisHealthInterval: e.getDuration("REDIS_HEALTH_INTERVAL", 10*time.Second),
+		CacheTTL:            e.getDuration("CACHE_TTL", 24*time.Hour),
+		CacheStaleTTL:       e.getDuration("CACHE_STALE_TTL", time.Hour),
+		CacheWarmOnStart:    e.getBool("CACHE_WARM_ON_START", true),
+		CacheWarmTopN:       e.getInt("CACHE_WARM_TOP_N", 500),
+		CacheWarmAll:        e.getBool("CACHE_WARM_ALL", false),
+		DevicePrefsTTL:      e.getDuration("DEVICE_PREFS_TTL", 180*24*time.Hour),
+
+		RateLimitPerWindow:   e.getInt("RATE_LIMIT_PER_WINDOW", 120),
+		RateLimitWindow:      e.getDuration("RATE_LIMIT_WINDOW", time.Minute),
+		RateLimitBurst:       e.getInt("RATE_LIMIT_BURST", 15),
+		RateLimitWhitelist:   e.getCSV("RATE_LIMIT_WHITELIST"),
+		RateLimitExemptPaths: e.getCSVDefault("RATE_LIMIT_EXEMPT_PATHS", []string{"/healthz", "/readyz"}),
+		RateLimitPathBudgets: e.getIntMap("RATE_LIMIT_PATH_BUDGETS"),
+
+		ConcurrencyBulk:   e.getInt("CONCURRENCY_BULK", 4),
+		ConcurrencyShapes: e.getInt("CONCURRENCY_SHAPES", 16),
+
+		GzipMinSize:      e.getInt("GZIP_MIN_SIZE", 1024),
+		GzipLevel:        e.getInt("GZIP_LEVEL", 6),
+		GzipContentTypes: e.getCSV("GZIP_CONTENT_TYPES"),
+		GzipExcludePaths: e.getCSVDefault("GZIP_EXCLUDE_PATHS", []string{"/v1/ws", "/v1/stream", "/metrics", "/healthz", "/readyz"}),
+
+		CDNPurgeURL:   e.get("CDN_PURGE_URL", ""),
+		CDNPurgeToken: e.get("CDN_PURGE_TOKEN", ""),
+
+		ReplicationToken:   e.get("REPLICATION_TOKEN", ""),
+		ReplicationPeerURL: e.get("REPLICATION_PEER_URL", ""),
+
+		LeaderElection:          e.getBool("LEADER_ELECTION", false),
+		LeaderLockTTL:           e.getDuration("LEADER_LOCK_TTL", 15*time.Second),
+		ReplicationAdvertiseURL: e.get("REPLICATION_ADVERTISE_URL", ""),
+		HubFanout:               e.get("HUB_FANOUT", "local"),
+		NATSURL:                 e.get("NATS_URL", ""),
+		NATSFanoutSubject:       e.get("NATS_FANOUT_SUBJECT", "wabus.hub.deltas"),
+		NATSFirehoseSubject:     e.get("NATS_FIREHOSE_SUBJECT", ""),
+
+		FleetFile:         e.get("FLEET_FILE", ""),
+		AnalyticsKeepDays: e.getInt("ANALYTICS_KEEP_DAYS", 7),
+		SegmentLength:     e.getInt("SEGMENT_LENGTH_METERS", 250),
+
+		ServiceSpanTolerance: e.getDuration("SERVICE_SPAN_TOLERANCE", 15*time.Minute),
+
+		GeofencesFile:        e.get("GEOFENCES_FILE", ""),
+		GeofenceWebhookURL:   e.get("GEOFENCE_WEBHOOK_URL", ""),
+		GeofenceWebhookToken: e.get("GEOFENCE_WEBHOOK_TOKEN", ""),
+
+		SnapshotDir:            e.get("SNAPSHOT_DIR", filepath.Join(os.TempDir(), "wabus-snapshots")),
+		SnapshotUploadInterval: e.getDuration("SNAPSHOT_UPLOAD_INTERVAL", time.Hour),
+		SnapshotRetentionDays:  e.getInt("SNAPSHOT_RETENTION_DAYS", 90),
+		SnapshotS3Endpoint:     e.get("SNAPSHOT_S3_ENDPOINT", "https://s3.amazonaws.com"),
+		SnapshotS3Region:       e.get("SNAPSHOT_S3_REGION", "us-east-1"),
+		SnapshotS3Bucket:       e.get("SNAPSHOT_S3_BUCKET", ""),
+		SnapshotS3Prefix:       e.get("SNAPSHOT_S3_PREFIX", "wabus/"),
+		SnapshotS3AccessKey:    e.get("SNAPSHOT_S3_ACCESS_KEY", ""),
+		SnapshotS3SecretKey:    e.get("SNAPSHOT_S3_SECRET_KEY", ""),
+		SnapshotS3PathStyle:    e.getBool("SNAPSHOT_S3_PATH_STYLE", false),
+
+		HistoryDir:           e.get("HISTORY_DIR", ""),
+		HistoryRetentionDays: e.getInt("HISTORY_RETENTION_DAYS", 7),
+		HistoryMaxRange:      e.getDuration("HISTORY_MAX_RANGE", 24*time.Hour),
+
+		AdminToken: e.get("ADMIN_TOKEN", ""),
+
+		PrivacyMode:   e.getBool("PRIVACY_MODE", false),
+		PrivacySecret: e.get("PRIVACY_SECRET", ""),
+
+		AlertsFeedURL:      e.get("ALERTS_FEED_URL", "https://www.wtp.waw.pl/feed/?post_type=impediment"),
+		AlertsPollInterval: e.getDuration("ALERTS_POLL_INTERVAL", 5*time.Minute),
+
+		GBFSFeeds:        e.getCSV("GBFS_FEEDS"),
+		GBFSPollInterval: e.getDuration("GBFS_POLL_INTERVAL", time.Minute),
+
+		POIFile: e.get("POI_FILE", ""),
+		DEMDir:  e.get("DEM_DIR", ""),
+
+		AccessLog:           e.getBool("ACCESS_LOG", false),
+		AccessLogSampleRate: e.getFloat("ACCESS_LOG_SAMPLE_RATE", 1),
+
+		DebugAddr: e.get("DEBUG_ADDR", ""),
 
-func getCSVEnv(key string) []string {
-	v := strings.TrimSpace(os.Getenv(key))
-	if v == "" {
-		return nil
+		OTelEndpoint:    e.get("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OTelServiceName: e.get("OTEL_SERVICE_NAME", "wabus"),
+		OTelSampleRatio: e.getFloat("OTEL_TRACES_SAMPLER_ARG", 1),
+		OTelHeaders:     e.getStringMap("OTEL_EXPORTER_OTLP_HEADERS"),
 	}
 
-	parts := strings.Split(v, ",")
-	result := make([]string, 0, len(parts))
-	for _, p := range parts {
-		t := strings.TrimSpace(p)
-		if t != "" {
-			result = append(result, t)
-		}
+	cfg.validate(e)
+	cfg.settings = e.settings
+	if len(e.problems) > 0 {
+		sort.Strings(e.problems)
+		return nil, &Error{Problems: e.problems}
 	}
-	return result
+	return cfg, nil
 }
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting is one configuration variable as read at startup.
+type Setting struct {
+	Key   string
+	Value string // "(set)" for secrets
+	// Default is true when the variable was unset and Value is the default.
+	Default bool
+}
+
+// Settings lists every configuration variable with its effective value,
+// sorted by key. Secrets are masked.
+func (c *Config) Settings() []Setting {
+	settings := append([]Setting(nil), c.settings...)
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+// secretSuffixes mark variables whose values are never reported.
+// _HEADERS covers OTEL_EXPORTER_OTLP_HEADERS, which carries collector
+// credentials.
+var secretSuffixes = []string{"_TOKEN", "_TOKENS", "_SECRET", "_SECRETS", "_PASSWORD", "_KEY", "_HEADERS"}
+
+// env reads configuration variables. Values that don't parse are collected
+// as problems instead of silently replaced by the default, and every
+// variable read is recorded for the startup report.
+type env struct {
+	problems []string
+	settings []Setting
+}
+
+func (e *env) problem(key, format string, args ...any) {
+	e.problems = append(e.problems, key+": "+fmt.Sprintf(format, args...))
+}
+
+// lookup returns the variable's value, recording it, or defaultVal for
+// the report when it is unset.
+func (e *env) lookup(key, defaultVal string) (string, bool) {
+	v, set := os.LookupEnv(key)
+	if v == "" {
+		set = false
+	}
+	setting := Setting{Key: key, Value: v, Default: !set}
+	if !set {
+		setting.Value = defaultVal
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) && setting.Value != "" {
+			setting.Value = "(set)"
+		}
+	}
+	e.settings = append(e.settings, setting)
+	return v, set
+}
+
+func (e *env) get(key, defaultVal string) string {
+	if v, ok := e.lookup(key, defaultVal); ok {
+		return v
+	}
+	return defaultVal
+}
+
+func (e *env) getDuration(key string, defaultVal time.Duration) time.Duration {
+	v, ok := e.lookup(key, defaultVal.String())
+	if !ok {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.problem(key, "%q is not a duration such as 500ms, 10s or 5m", v)
+		return defaultVal
+	}
+	return d
+}
+
+// getTime parses an RFC 3339 time or a YYYY-MM-DD date (midnight UTC);
+// unset is the zero time.
+func (e *env) getTime(key string) time.Time {
+	v, ok := e.lookup(key, "")
+	if !ok {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		e.problem(key, "%q is not an RFC 3339 time or YYYY-MM-DD date", v)
+	}
+	return t
+}
+
+func (e *env) getInt(key string, defaultVal int) int {
+	v, ok := e.lookup(key, strconv.Itoa(defaultVal))
+	if !ok {
+		return defaultVal
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		e.problem(key, "%q is not an integer", v)
+		return defaultVal
+	}
+	return i
+}
+
+func (e *env) getFloat(key string, defaultVal float64) float64 {
+	v, ok := e.lookup(key, strconv.FormatFloat(defaultVal, 'g', -1, 64))
+	if !ok {
+		return defaultVal
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.problem(key, "%q is not a number", v)
+		return defaultVal
+	}
+	return f
+}
+
+func (e *env) getBool(key string, defaultVal bool) bool {
+	v, ok := e.lookup(key, strconv.FormatBool(defaultVal))
+	if !ok {
+		return defaultVal
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.problem(key, "%q is not a boolean (true or false)", v)
+		return defaultVal
+	}
+	return b
+}
+
+func (e *env) getLogLevel(key string, defaultVal slog.Level) slog.Level {
+	v, ok := e.lookup(key, strings.ToLower(defaultVal.String()))
+	if !ok {
+		return defaultVal
+	}
+
+	switch strings.ToLower(v) {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		e.problem(key, "%q is not one of debug, info, warn, error", v)
+		return defaultVal
+	}
+}
+
+func (e *env) getCSV(key string) []string {
+	v, _ := e.lookup(key, "")
+	return splitCSV(v)
+}
+
+// getCSVDefault is getCSV with a default for an unset variable; set but
+// empty is an empty list.
+func (e *env) getCSVDefault(key string, defaultVal []string) []string {
+	v, set := os.LookupEnv(key)
+	if !set {
+		e.lookup(key, strings.Join(defaultVal, ","))
+		return defaultVal
+	}
+	e.settings = append(e.settings, Setting{Key: key, Value: v})
+	return splitCSV(v)
+}
+
+// getIntMap parses "key=int,key=int" pairs.
+func (e *env) getIntMap(key string) map[string]int {
+	result := make(map[string]int)
+	for _, pair := range e.getCSV(key) {
+		k, v, ok := strings.Cut(pair, "=")
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil || strings.TrimSpace(k) == "" {
+			e.problem(key, "%q is not key=integer", pair)
+			continue
+		}
+		result[strings.TrimSpace(k)] = i
+	}
+	return result
+}
+
+// getStringMap parses "key=value,key=value" pairs.
+func (e *env) getStringMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range e.getCSV(key) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			e.problem(key, "%q is not key=value", pair)
+			continue
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return result
+}
+
+func splitCSV(v string) []string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+
+	parts := strings.Split(v, ",")
+	result := make([]string, 0, len(parts))
+	for _, p := range parts {
+		t := strings.TrimSpace(p)
+		if t != "" {
+			result = append(result, t)
+		}
+	}
+	return result
+}
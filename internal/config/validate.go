@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Error lists every problem found in the configuration.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid configuration (%d problems): %s", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// validate checks the parsed values, adding a problem for each one out of
+// range.
+func (c *Config) validate(e *env) {
+	if c.WarsawAPIKey == "" {
+		e.problem("WARSAW_API_KEY", "is required")
+	}
+	if _, _, err := net.SplitHostPort(c.HTTPAddr); err != nil {
+		e.problem("HTTP_ADDR", "%q is not host:port", c.HTTPAddr)
+	}
+
+	positive := map[string]time.Duration{
+		"READ_TIMEOUT":              c.ReadTimeout,
+		"WRITE_TIMEOUT":             c.WriteTimeout,
+		"SHUTDOWN_TIMEOUT":          c.ShutdownTimeout,
+		"POLL_INTERVAL":             c.PollInterval,
+		"UPSTREAM_BREAKER_COOLDOWN": c.UpstreamBreakerCooldown,
+		"VEHICLE_STALE_AFTER":       c.VehicleStaleAfter,
+		"GTFS_UPDATE_INTERVAL":      c.GTFSUpdateInterval,
+		"PREDICTION_INTERVAL":       c.PredictionInterval,
+		"BUNCHING_INTERVAL":         c.BunchingInterval,
+		"REDIS_HEALTH_INTERVAL":     c.RedisHealthInterval,
+		"CACHE_TTL":                 c.CacheTTL,
+		"DEVICE_PREFS_TTL":          c.DevicePrefsTTL,
+		"RATE_LIMIT_WINDOW":         c.RateLimitWindow,
+		"LEADER_LOCK_TTL":           c.LeaderLockTTL,
+		"SNAPSHOT_UPLOAD_INTERVAL":  c.SnapshotUploadInterval,
+		"HISTORY_MAX_RANGE":         c.HistoryMaxRange,
+		"ALERTS_POLL_INTERVAL":      c.AlertsPollInterval,
+		"GBFS_POLL_INTERVAL":        c.GBFSPollInterval,
+	}
+	for key, d := range positive {
+		if d <= 0 {
+			e.problem(key, "must be positive, got %s", d)
+		}
+	}
+	nonNegative := map[string]time.Duration{
+		"CACHE_STALE_TTL":        c.CacheStaleTTL,
+		"READY_GTFS_GRACE":       c.ReadyGTFSGrace,
+		"WS_DELTA_COALESCE":      c.WSDeltaCoalesce,
+		"WS_SLOW_CLIENT_TIMEOUT": c.WSSlowClientTimeout,
+		"SERVICE_SPAN_TOLERANCE": c.ServiceSpanTolerance,
+	}
+	for key, d := range nonNegative {
+		if d < 0 {
+			e.problem(key, "must not be negative, got %s", d)
+		}
+	}
+
+	type intRange struct {
+		value, min, max int
+	}
+	const unbounded = int(^uint(0) >> 1)
+	ints := map[string]intRange{
+		"UPSTREAM_BREAKER_THRESHOLD": {c.UpstreamBreakerThreshold, 1, unbounded},
+		"TILE_ZOOM_LEVEL":            {c.TileZoomLevel, 0, 22},
+		"TILE_ZOOM_LEGACY":           {c.TileZoomLegacy, 0, 22},
+		"WS_SNAPSHOT_CHUNK_BYTES":    {c.WSSnapshotChunkBytes, 0, unbounded},
+		"WS_MAX_TILES":               {c.WSMaxTiles, 1, unbounded},
+		"WS_MAX_LINES":               {c.WSMaxLines, 1, unbounded},
+		"WS_COMPRESSION_THRESHOLD":   {c.WSCompressionThreshold, 0, unbounded},
+		"GTFS_ARCHIVE_KEEP":          {c.GTFSArchiveKeep, 0, unbounded},
+		"GTFS_SHAPE_CACHE_SIZE":      {c.GTFSShapeCacheSize, 0, unbounded},
+		"GTFS_SHAPE_SIMPLIFY_METERS": {c.GTFSShapeSimplifyMeters, 0, unbounded},
+		"UNIT_GROUP_DISTANCE":        {c.UnitGroupDistance, 0, unbounded},
+		"POSITION_PRECISION":         {c.PositionPrecision, 0, 6},
+		"REDIS_DB":                   {c.RedisDB, 0, unbounded},
+		"CACHE_WARM_TOP_N":           {c.CacheWarmTopN, 0, unbounded},
+		"RATE_LIMIT_PER_WINDOW":      {c.RateLimitPerWindow, 1, unbounded},
+		"RATE_LIMIT_BURST":           {c.RateLimitBurst, 0, unbounded},
+		"CONCURRENCY_BULK":           {c.ConcurrencyBulk, 0, unbounded},
+		"CONCURRENCY_SHAPES":         {c.ConcurrencyShapes, 0, unbounded},
+		"GZIP_MIN_SIZE":              {c.GzipMinSize, 0, unbounded},
+		"GZIP_LEVEL":                 {c.GzipLevel, 1, 9},
+		"ANALYTICS_KEEP_DAYS":        {c.AnalyticsKeepDays, 1, unbounded},
+		"SEGMENT_LENGTH_METERS":      {c.SegmentLength, 0, unbounded},
+		"SNAPSHOT_RETENTION_DAYS":    {c.SnapshotRetentionDays, 0, unbounded},
+		"HISTORY_RETENTION_DAYS":     {c.HistoryRetentionDays, 0, unbounded},
+	}
+	for key, r := range ints {
+		switch {
+		case r.value < r.min && r.max == unbounded:
+			e.problem(key, "must be at least %d, got %d", r.min, r.value)
+		case r.value < r.min || r.value > r.max:
+			e.problem(key, "must be between %d and %d, got %d", r.min, r.max, r.value)
+		}
+	}
+	for path, budget := range c.RateLimitPathBudgets {
+		if budget < 1 {
+			e.problem("RATE_LIMIT_PATH_BUDGETS", "budget of %s must be at least 1, got %d", path, budget)
+		}
+	}
+	if c.BunchingThreshold <= 0 {
+		e.problem("BUNCHING_THRESHOLD", "must be positive, got %g", c.BunchingThreshold)
+	}
+	if c.AccessLogSampleRate < 0 || c.AccessLogSampleRate > 1 {
+		e.problem("ACCESS_LOG_SAMPLE_RATE", "must be between 0 and 1, got %g", c.AccessLogSampleRate)
+	}
+	if c.OTelSampleRatio < 0 || c.OTelSampleRatio > 1 {
+		e.problem("OTEL_TRACES_SAMPLER_ARG", "must be between 0 and 1, got %g", c.OTelSampleRatio)
+	}
+
+	oneOf(e, "WS_COMPRESSION", c.WSCompression, "disabled", "no_context_takeover", "context_takeover")
+	oneOf(e, "HUB_FANOUT", c.HubFanout, "local", "redis", "nats")
+	if (c.HubFanout == "nats" || c.NATSFirehoseSubject != "") && c.NATSURL == "" {
+		e.problem("NATS_URL", "is required with HUB_FANOUT=nats or NATS_FIREHOSE_SUBJECT")
+	}
+	if c.NATSURL != "" {
+		if u, err := url.Parse(c.NATSURL); err != nil || u.Scheme != "nats" || u.Host == "" {
+			e.problem("NATS_URL", "%q is not a nats:// URL", c.NATSURL)
+		}
+	}
+	checkSubject(e, "NATS_FANOUT_SUBJECT", c.NATSFanoutSubject)
+	checkSubject(e, "NATS_FIREHOSE_SUBJECT", c.NATSFirehoseSubject)
+	oneOf(e, "MATCHER_CANARY", c.MatcherCanary, "", "full_shapes")
+	for _, t := range c.UnitGroupTypes {
+		oneOf(e, "UNIT_GROUP_TYPES", t, "bus", "tram")
+	}
+
+	urls := map[string]string{
+		"WARSAW_API_URL":              c.WarsawAPIBaseURL,
+		"GTFS_URL":                    c.GTFSURL,
+		"CDN_PURGE_URL":               c.CDNPurgeURL,
+		"REPLICATION_PEER_URL":        c.ReplicationPeerURL,
+		"REPLICATION_ADVERTISE_URL":   c.ReplicationAdvertiseURL,
+		"GEOFENCE_WEBHOOK_URL":        c.GeofenceWebhookURL,
+		"SNAPSHOT_S3_ENDPOINT":        c.SnapshotS3Endpoint,
+		"ALERTS_FEED_URL":             c.AlertsFeedURL,
+		"OTEL_EXPORTER_OTLP_ENDPOINT": c.OTelEndpoint,
+	}
+	for key, v := range urls {
+		checkURL(e, key, v)
+	}
+	for _, v := range c.GBFSFeeds {
+		checkURL(e, "GBFS_FEEDS", v)
+	}
+}
+
+func checkURL(e *env, key, v string) {
+	if v == "" {
+		return
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.problem(key, "%q is not an http(s) URL", v)
+	}
+}
+
+// checkSubject reports a NATS subject that can't be published to: empty
+// tokens, whitespace or wildcards.
+func checkSubject(e *env, key, v string) {
+	if v == "" {
+		return
+	}
+	for _, token := range strings.Split(v, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			e.problem(key, "%q is not a valid NATS subject", v)
+			return
+		}
+	}
+}
+
+func oneOf(e *env, key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	var names []string
+	for _, a := range allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	e.problem(key, "%q is not one of %s", value, strings.Join(names, ", "))
+}
@@ -0,0 +1,83 @@
+package domain
+
+import "time"
+
+// AlertSource identifies where an alert came from.
+type AlertSource string
+
+const (
+	AlertSourceManual AlertSource = "manual"
+	AlertSourceZTM    AlertSource = "ztm"
+)
+
+// AlertSeverity ranks how disruptive an alert is.
+type AlertSeverity string
+
+const (
+	AlertSeverityInfo    AlertSeverity = "info"
+	AlertSeverityWarning AlertSeverity = "warning"
+	AlertSeveritySevere  AlertSeverity = "severe"
+)
+
+// Valid reports whether s is a known severity.
+func (s AlertSeverity) Valid() bool {
+	switch s {
+	case AlertSeverityInfo, AlertSeverityWarning, AlertSeveritySevere:
+		return true
+	}
+	return false
+}
+
+// Alert is a service disruption or notice shown to riders. Lines and StopIDs
+// scope it; an alert with neither applies to the whole network.
+type Alert struct {
+	ID          string        `json:"id"`
+	Source      AlertSource   `json:"source"`
+	Severity    AlertSeverity `json:"severity"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	URL         string        `json:"url,omitempty"`
+	Lines       []string      `json:"lines,omitempty"`
+	StopIDs     []string      `json:"stop_ids,omitempty"`
+	ActiveFrom  *time.Time    `json:"active_from,omitempty"`
+	ActiveUntil *time.Time    `json:"active_until,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// IsActive reports whether the alert's active period contains t.
+func (a *Alert) IsActive(t time.Time) bool {
+	if a.ActiveFrom != nil && t.Before(*a.ActiveFrom) {
+		return false
+	}
+	if a.ActiveUntil != nil && !t.Before(*a.ActiveUntil) {
+		return false
+	}
+	return true
+}
+
+// AffectsLine reports whether the alert applies to the given line.
+func (a *Alert) AffectsLine(line string) bool {
+	if len(a.Lines) == 0 && len(a.StopIDs) == 0 {
+		return true
+	}
+	for _, l := range a.Lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// AffectsStop reports whether the alert applies to the given stop.
+func (a *Alert) AffectsStop(stopID string) bool {
+	if len(a.Lines) == 0 && len(a.StopIDs) == 0 {
+		return true
+	}
+	for _, id := range a.StopIDs {
+		if id == stopID {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,7 @@
 package domain
 
+import "time"
+
 // RouteType distinguishes transport types in GTFS
 type RouteType int
 
@@ -45,6 +47,8 @@ type Route struct {
 	Type      RouteType `json:"type"`
 	Color     string    `json:"color"`
 	TextColor string    `json:"text_color"`
+	// ShapesVersion changes only when the route's shapes do.
+	ShapesVersion string `json:"shapes_version,omitempty"`
 }
 
 // ShapePoint represents a single point in a route shape
@@ -52,6 +56,8 @@ type ShapePoint struct {
 	Lat      float64 `json:"lat"`
 	Lon      float64 `json:"lon"`
 	Sequence int     `json:"sequence"`
+	// Elevation in meters, only set when requested and covered by the DEM.
+	Elevation *float64 `json:"elevation,omitempty"`
 }
 
 // Shape represents the geographic path of a route
@@ -59,6 +65,10 @@ type Shape struct {
 	ID          string       `json:"id"`
 	Points      []ShapePoint `json:"points"`
 	DirectionID *int         `json:"direction_id,omitempty"`
+	// Total climb and descent in meters along the points with an
+	// elevation, set with them.
+	ElevationGain *float64 `json:"elevation_gain,omitempty"`
+	ElevationLoss *float64 `json:"elevation_loss,omitempty"`
 }
 
 // Stop represents a transit stop from GTFS
@@ -81,6 +91,10 @@ type StopTime struct {
 	ArrivalTime   string `json:"arrival_time"`
 	DepartureTime string `json:"departure_time"`
 	StopSequence  int    `json:"stop_sequence"`
+
+	// Set only for queries resolved at an instant (?at=).
+	ServiceDate string     `json:"service_date,omitempty"`
+	DepartureAt *time.Time `json:"departure_at,omitempty"`
 }
 
 // Calendar represents service availability by day of week
@@ -106,11 +120,64 @@ type CalendarDate struct {
 
 // TripTimeEntry stores the shape and time range for a trip on a route
 type TripTimeEntry struct {
+	TripID       string
+	Headsign     string
 	ShapeID      string
 	ServiceID    string
 	DirectionID  int
-	StartMinutes int // minutes since midnight (GTFS format, can be >1440)
-	EndMinutes   int // minutes since midnight
+	StartMinutes int    // minutes since midnight (GTFS format, can be >1440)
+	EndMinutes   int    // minutes since midnight
+	StartStopID  string // stop of the first departure
+	EndStopID    string // stop of the last arrival
+}
+
+// ServiceSpan is when a route runs on a service day, from the first trip's
+// departure to the last trip's arrival.
+type ServiceSpan struct {
+	FirstDeparture time.Time
+	LastDeparture  time.Time
+	LastArrival    time.Time
+	Trips          int
+}
+
+// ActiveTrip is a trip run scheduled to be en route at a given time.
+type ActiveTrip struct {
+	TripID        string    `json:"trip_id"`
+	Headsign      string    `json:"headsign,omitempty"`
+	ShapeID       string    `json:"shape_id"`
+	DirectionID   int       `json:"direction_id"`
+	ServiceDate   string    `json:"service_date"` // YYYY-MM-DD
+	StartStopID   string    `json:"start_stop_id"`
+	StartStopName string    `json:"start_stop_name,omitempty"`
+	EndStopID     string    `json:"end_stop_id"`
+	EndStopName   string    `json:"end_stop_name,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	Progress      float64   `json:"progress"` // percent of the scheduled run time elapsed
+}
+
+// ScheduledTrip is one run of a trip on a service day, with its stop times
+// resolved to instants.
+type ScheduledTrip struct {
+	TripID      string
+	RouteID     string
+	Line        string
+	ShapeID     string
+	Headsign    string
+	DirectionID int
+	ServiceDate time.Time // midnight of the service day in the feed timezone
+	StartTime   string    // first departure as GTFS HH:MM:SS, may exceed 24:00
+	Stops       []ScheduledStop
+}
+
+// ScheduledStop is a stop of a ScheduledTrip, ordered by stop sequence.
+type ScheduledStop struct {
+	StopID    string
+	Sequence  int
+	Lat       float64
+	Lon       float64
+	Arrival   time.Time
+	Departure time.Time
 }
 
 // TripMeta stores compact trip metadata used when decoding stop schedules.
@@ -147,4 +214,7 @@ type StopLine struct {
 	Type      RouteType `json:"type"`
 	Color     string    `json:"color"`
 	Headsigns []string  `json:"headsigns"`
+	// RemainingToday counts the line's departures from the stop left in
+	// the current service day; set only when requested.
+	RemainingToday *int `json:"remaining_today,omitempty"`
 }
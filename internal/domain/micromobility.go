@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+// MicromobilityStation is a bike-share docking station. IDs are prefixed
+// with the system ID, as several systems may be ingested.
+type MicromobilityStation struct {
+	ID                string    `json:"id"`
+	System            string    `json:"system"`
+	Name              string    `json:"name"`
+	Lat               float64   `json:"lat"`
+	Lon               float64   `json:"lon"`
+	TileID            string    `json:"tileId"`
+	Capacity          int       `json:"capacity,omitempty"`
+	VehiclesAvailable int       `json:"vehiclesAvailable"`
+	DocksAvailable    int       `json:"docksAvailable"`
+	IsRenting         bool      `json:"isRenting"`
+	IsReturning       bool      `json:"isReturning"`
+	LastReported      time.Time `json:"lastReported,omitzero"`
+}
+
+// MicromobilityVehicle is a bike or scooter available outside a station.
+// Reserved and disabled vehicles aren't listed.
+type MicromobilityVehicle struct {
+	ID          string  `json:"id"`
+	System      string  `json:"system"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	TileID      string  `json:"tileId"`
+	TypeID      string  `json:"typeId,omitempty"`
+	RangeMeters float64 `json:"rangeMeters,omitempty"`
+}
+
+// MicromobilitySystem summarizes one ingested system.
+type MicromobilitySystem struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Stations  int       `json:"stations"`
+	Vehicles  int       `json:"vehicles"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
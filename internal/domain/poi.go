@@ -0,0 +1,20 @@
+package domain
+
+// Conventional POI types. The layer accepts any type the file uses.
+const (
+	POITypeParkAndRide   = "park_and_ride"
+	POITypeTicketMachine = "ticket_machine"
+	POITypeZTMPoint      = "ztm_point"
+)
+
+// POI is a static point of interest, e.g. a Park & Ride lot. Properties
+// carries the remaining GeoJSON feature properties (capacity, opening
+// hours, ...) as given.
+type POI struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Name       string         `json:"name"`
+	Lat        float64        `json:"lat"`
+	Lon        float64        `json:"lon"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
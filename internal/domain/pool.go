@@ -0,0 +1,24 @@
+package domain
+
+import "sync"
+
+// vehiclePool recycles the Vehicle structs of positions that were fetched
+// but not stored, most of every poll when vehicles stand still, so polls
+// don't allocate thousands of them anew.
+var vehiclePool = sync.Pool{
+	New: func() any { return new(Vehicle) },
+}
+
+// NewVehicle returns a zeroed Vehicle, reusing a released one if there is
+// one.
+func NewVehicle() *Vehicle {
+	return vehiclePool.Get().(*Vehicle)
+}
+
+// ReleaseVehicle hands v back for reuse by NewVehicle. Only release
+// vehicles nothing else references: never ones that were stored, sent in a
+// delta or passed to another goroutine.
+func ReleaseVehicle(v *Vehicle) {
+	*v = Vehicle{}
+	vehiclePool.Put(v)
+}
@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // VehicleType distinguishes buses from trams
 type VehicleType int
@@ -10,6 +13,18 @@ const (
 	VehicleTypeTram VehicleType = 2
 )
 
+// ParseVehicleType parses "bus" or "tram".
+func ParseVehicleType(s string) (VehicleType, bool) {
+	switch s {
+	case "bus":
+		return VehicleTypeBus, true
+	case "tram":
+		return VehicleTypeTram, true
+	default:
+		return 0, false
+	}
+}
+
 func (t VehicleType) String() string {
 	switch t {
 	case VehicleTypeBus:
@@ -21,18 +36,59 @@ func (t VehicleType) String() string {
 	}
 }
 
+// SnappedPosition is a vehicle's position projected onto the shape of the
+// trip it runs.
+type SnappedPosition struct {
+	Lat           float64 `json:"lat"`
+	Lon           float64 `json:"lon"`
+	ShapeID       string  `json:"shapeId"`
+	DistanceAlong float64 `json:"distanceAlong"` // meters from the start of the shape
+	Offset        float64 `json:"offset"`        // meters between the raw and snapped position
+}
+
 // Vehicle represents a single bus or tram position
 type Vehicle struct {
-	Key           string      `json:"key"`
-	VehicleNumber string      `json:"vehicleNumber"`
-	Type          VehicleType `json:"type"`
-	Line          string      `json:"line"`
-	Brigade       string      `json:"brigade"`
-	Lat           float64     `json:"lat"`
-	Lon           float64     `json:"lon"`
-	Timestamp     time.Time   `json:"timestamp"`
-	TileID        string      `json:"tileId"`
-	UpdatedAt     time.Time   `json:"updatedAt"`
+	Key           string           `json:"key"`
+	VehicleNumber string           `json:"vehicleNumber,omitempty"`
+	Type          VehicleType      `json:"type"`
+	Line          string           `json:"line"`
+	Brigade       string           `json:"brigade,omitempty"`
+	Lat           float64          `json:"lat"`
+	Lon           float64          `json:"lon"`
+	Timestamp     time.Time        `json:"timestamp"`
+	TileID        string           `json:"tileId"`
+	DirectionID   *int             `json:"directionId,omitempty"`
+	Headsign      string           `json:"headsign,omitempty"`
+	Bearing       *int             `json:"bearing,omitempty"`  // degrees clockwise from north, 0-359; unset until the vehicle moves
+	Speed         *float64         `json:"speed,omitempty"`    // km/h between the last two positions; unset until the second one
+	SpeedAvg      *float64         `json:"speedAvg,omitempty"` // km/h, exponentially smoothed Speed
+	Snapped       *SnappedPosition `json:"snapped,omitempty"`
+	Delay         *int             `json:"delay,omitempty"`   // seconds behind schedule, negative when early; unset until matched to a trip
+	Units         []string         `json:"units,omitempty"`   // vehicle numbers of coupled units, this lead one first
+	LeadKey       string           `json:"leadKey,omitempty"` // key of the lead unit this one is coupled behind
+	UpdatedAt     time.Time        `json:"updatedAt"`
+}
+
+// MaxPositionPrecision is the most decimal places positions are rounded to;
+// finer than that, rounding would hide changes the store detects.
+const MaxPositionPrecision = 6
+
+// RoundPosition rounds the position and the snapped position to decimals
+// places; 5 places are about a meter. The snapped position is replaced
+// rather than modified, so copies of v are left alone.
+func (v *Vehicle) RoundPosition(decimals int) {
+	v.Lat, v.Lon = RoundCoord(v.Lat, decimals), RoundCoord(v.Lon, decimals)
+	if v.Snapped != nil {
+		snapped := *v.Snapped
+		snapped.Lat, snapped.Lon = RoundCoord(snapped.Lat, decimals), RoundCoord(snapped.Lon, decimals)
+		v.Snapped = &snapped
+	}
+}
+
+// RoundCoord rounds a coordinate to decimals places.
+func RoundCoord(c float64, decimals int) float64 {
+	scale := math.Pow10(decimals)
+	return math.Round(c*scale) / scale
 }
 
 // DeltaType indicates whether a vehicle was updated or removed
@@ -49,6 +105,9 @@ type VehicleDelta struct {
 	Vehicle *Vehicle  `json:"vehicle,omitempty"`
 	Key     string    `json:"key,omitempty"`
 	TileID  string    `json:"tileId"`
+	// VehicleType and Line are set on removes too, where Vehicle is nil.
+	VehicleType VehicleType `json:"vehicleType"`
+	Line        string      `json:"line,omitempty"`
 }
 
 // BoundingBox represents a geographic rectangle
@@ -59,6 +118,11 @@ type BoundingBox struct {
 	MaxLon float64 `json:"maxLon"`
 }
 
+// ServiceArea generously bounds the ZTM network. Vehicle positions outside
+// it are GPS glitches or unset (0,0) coordinates, and clients following a
+// position outside it have nothing to see.
+var ServiceArea = BoundingBox{MinLat: 51.8, MaxLat: 52.7, MinLon: 20.3, MaxLon: 21.8}
+
 // Contains checks if a point is within the bounding box
 func (bb *BoundingBox) Contains(lat, lon float64) bool {
 	return lat >= bb.MinLat && lat <= bb.MaxLat &&
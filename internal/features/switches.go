@@ -0,0 +1,86 @@
+// Package features holds switches that turn expensive subsystems off while
+// the server runs, e.g. on degraded hardware, without a restart that would
+// drop every WS client.
+package features
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Subsystems that can be switched off at runtime.
+const (
+	// Interpolation is tagging vehicles with the delay interpolated from
+	// the schedule at their position, and the prediction update each poll
+	// triggers. Predictions are still refreshed every PREDICTION_INTERVAL.
+	Interpolation = "interpolation"
+	// Analytics is recording deltas for distance, segment speed and
+	// service span statistics. Statistics already recorded are still
+	// served.
+	Analytics = "analytics"
+)
+
+// Switches is a fixed set of named on/off switches, all on initially. A nil
+// *Switches has every subsystem on.
+type Switches struct {
+	switches map[string]*atomic.Bool
+}
+
+func NewSwitches(names ...string) *Switches {
+	s := &Switches{switches: make(map[string]*atomic.Bool, len(names))}
+	for _, name := range names {
+		on := &atomic.Bool{}
+		on.Store(true)
+		s.switches[name] = on
+	}
+	return s
+}
+
+// Enabled reports whether the subsystem name is on. Subsystems without a
+// switch always are.
+func (s *Switches) Enabled(name string) bool {
+	if s == nil {
+		return true
+	}
+	on, ok := s.switches[name]
+	return !ok || on.Load()
+}
+
+// Set turns the subsystem name on or off, returning false if it has no
+// switch.
+func (s *Switches) Set(name string, enabled bool) bool {
+	if s == nil {
+		return false
+	}
+	on, ok := s.switches[name]
+	if !ok {
+		return false
+	}
+	on.Store(enabled)
+	return true
+}
+
+// Names returns the names of the switches, sorted.
+func (s *Switches) Names() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.switches))
+	for name := range s.switches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// State returns whether each switch is on, keyed by name.
+func (s *Switches) State() map[string]bool {
+	state := make(map[string]bool)
+	if s == nil {
+		return state
+	}
+	for name, on := range s.switches {
+		state[name] = on.Load()
+	}
+	return state
+}
@@ -0,0 +1,314 @@
+// Package geofence watches vehicles crossing named polygons, e.g. a bus lane
+// or a depot, and emits enter and exit events.
+package geofence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+// Fence sources.
+const (
+	SourceConfig = "config" // GEOFENCES_FILE, read-only at runtime
+	SourceAdmin  = "admin"  // created through the admin API
+)
+
+var (
+	ErrNotFound = errors.New("geofence not found")
+	ErrReadOnly = errors.New("geofence is defined in the config file")
+)
+
+// Fence is a named polygon. Polygon vertices are [lat, lon] pairs; the ring
+// closes implicitly.
+type Fence struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Polygon [][2]float64 `json:"polygon"`
+	// Lines limits the fence to vehicles of these lines; empty watches all.
+	Lines  []string `json:"lines,omitempty"`
+	Source string   `json:"source"`
+}
+
+// Validate checks that the fence has a name and a usable polygon.
+func (f *Fence) Validate() error {
+	if f.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(f.Polygon) < 3 {
+		return errors.New("polygon needs at least 3 vertices")
+	}
+	for _, p := range f.Polygon {
+		if p[0] < -90 || p[0] > 90 || p[1] < -180 || p[1] > 180 {
+			return fmt.Errorf("invalid vertex [%v, %v]", p[0], p[1])
+		}
+	}
+	return nil
+}
+
+// Event types.
+const (
+	EventEnter = "enter"
+	EventExit  = "exit"
+)
+
+// Event is a vehicle crossing a fence boundary, at the first position seen
+// on the other side.
+type Event struct {
+	Type       string             `json:"type"`
+	FenceID    string             `json:"fenceId"`
+	FenceName  string             `json:"fenceName"`
+	VehicleKey string             `json:"vehicleKey"`
+	Line       string             `json:"line"`
+	Vehicle    domain.VehicleType `json:"vehicleType"`
+	Lat        float64            `json:"lat"`
+	Lon        float64            `json:"lon"`
+	Timestamp  time.Time          `json:"timestamp"`
+}
+
+// FenceStats counts the events of one fence since start.
+type FenceStats struct {
+	Inside int   `json:"inside"`
+	Enters int64 `json:"enters"`
+	Exits  int64 `json:"exits"`
+}
+
+type fence struct {
+	*Fence
+	lines                          map[string]bool
+	minLat, maxLat, minLon, maxLon float64
+	inside                         map[string]bool // vehicle keys
+	enters, exits                  int64
+}
+
+func newFence(f *Fence) *fence {
+	w := &fence{Fence: f, inside: make(map[string]bool)}
+	if len(f.Lines) > 0 {
+		w.lines = make(map[string]bool, len(f.Lines))
+		for _, l := range f.Lines {
+			w.lines[l] = true
+		}
+	}
+	w.minLat, w.maxLat = f.Polygon[0][0], f.Polygon[0][0]
+	w.minLon, w.maxLon = f.Polygon[0][1], f.Polygon[0][1]
+	for _, p := range f.Polygon[1:] {
+		w.minLat, w.maxLat = min(w.minLat, p[0]), max(w.maxLat, p[0])
+		w.minLon, w.maxLon = min(w.minLon, p[1]), max(w.maxLon, p[1])
+	}
+	return w
+}
+
+// contains tests the point against the polygon by ray casting, treating
+// coordinates as planar, which is fine at city scale.
+func (f *fence) contains(lat, lon float64) bool {
+	if lat < f.minLat || lat > f.maxLat || lon < f.minLon || lon > f.maxLon {
+		return false
+	}
+	in := false
+	poly := f.Polygon
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		if (poly[i][0] > lat) != (poly[j][0] > lat) &&
+			lon < (poly[j][1]-poly[i][1])*(lat-poly[i][0])/(poly[j][0]-poly[i][0])+poly[i][1] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Watcher tracks which vehicles are inside which fences from the deltas of
+// every poll and hands the resulting events to its sinks.
+type Watcher struct {
+	mu       sync.Mutex
+	fences   map[string]*fence
+	sinks    []func(events []Event)
+	onChange func(admin []*Fence)
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{fences: make(map[string]*fence)}
+}
+
+// LoadFile reads fences from a JSON array of fences, marking them as config
+// fences. An empty path loads nothing.
+func LoadFile(path string) ([]*Fence, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read geofences file: %w", err)
+	}
+	var fences []*Fence
+	if err := json.Unmarshal(data, &fences); err != nil {
+		return nil, fmt.Errorf("parse geofences file: %w", err)
+	}
+	for i, f := range fences {
+		if f.ID == "" {
+			return nil, fmt.Errorf("geofence %d: id is required", i)
+		}
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("geofence %s: %w", f.ID, err)
+		}
+		f.Source = SourceConfig
+	}
+	return fences, nil
+}
+
+// Load adds fences without running the change callback, for fences from the
+// config file and those persisted from the admin API.
+func (w *Watcher) Load(fences []*Fence) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, f := range fences {
+		w.fences[f.ID] = newFence(f)
+	}
+}
+
+// AddSink registers a function receiving the events of every poll that
+// produced any. Sinks run synchronously and must not block.
+func (w *Watcher) AddSink(fn func(events []Event)) {
+	w.sinks = append(w.sinks, fn)
+}
+
+// SetOnChange registers a callback invoked with all admin fences after one
+// is created or deleted, e.g. to persist them.
+func (w *Watcher) SetOnChange(fn func(admin []*Fence)) {
+	w.onChange = fn
+}
+
+// Create adds an admin fence under a new ID. Vehicles already inside it
+// produce enter events on their next position.
+func (w *Watcher) Create(f *Fence) *Fence {
+	b := make([]byte, 8)
+	rand.Read(b)
+	created := *f
+	created.ID = hex.EncodeToString(b)
+	created.Source = SourceAdmin
+
+	w.mu.Lock()
+	w.fences[created.ID] = newFence(&created)
+	w.mu.Unlock()
+	w.changed()
+	return &created
+}
+
+// Delete removes an admin fence.
+func (w *Watcher) Delete(id string) error {
+	w.mu.Lock()
+	f, ok := w.fences[id]
+	switch {
+	case !ok:
+		w.mu.Unlock()
+		return ErrNotFound
+	case f.Source != SourceAdmin:
+		w.mu.Unlock()
+		return ErrReadOnly
+	}
+	delete(w.fences, id)
+	w.mu.Unlock()
+	w.changed()
+	return nil
+}
+
+func (w *Watcher) changed() {
+	if w.onChange == nil {
+		return
+	}
+	var admin []*Fence
+	for _, f := range w.List() {
+		if f.Source == SourceAdmin {
+			admin = append(admin, f)
+		}
+	}
+	w.onChange(admin)
+}
+
+// List returns every fence ordered by name.
+func (w *Watcher) List() []*Fence {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make([]*Fence, 0, len(w.fences))
+	for _, f := range w.fences {
+		result = append(result, f.Fence)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Stats returns the counters of every fence by ID.
+func (w *Watcher) Stats() map[string]FenceStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := make(map[string]FenceStats, len(w.fences))
+	for id, f := range w.fences {
+		stats[id] = FenceStats{Inside: len(f.inside), Enters: f.enters, Exits: f.exits}
+	}
+	return stats
+}
+
+// Record implements ingestor.Recorder. Vehicles dropping out of the feed
+// are forgotten without an exit event, as where they went is unknown.
+func (w *Watcher) Record(deltas []domain.VehicleDelta) {
+	var events []Event
+
+	w.mu.Lock()
+	for _, d := range deltas {
+		if d.Type == domain.DeltaRemove {
+			for _, f := range w.fences {
+				delete(f.inside, d.Key)
+			}
+			continue
+		}
+		v := d.Vehicle
+		if v == nil {
+			continue
+		}
+		for _, f := range w.fences {
+			in := (f.lines == nil || f.lines[v.Line]) && f.contains(v.Lat, v.Lon)
+			if in == f.inside[v.Key] {
+				continue
+			}
+			eventType := EventExit
+			if in {
+				eventType = EventEnter
+				f.inside[v.Key] = true
+				f.enters++
+			} else {
+				delete(f.inside, v.Key)
+				f.exits++
+			}
+			events = append(events, Event{
+				Type:       eventType,
+				FenceID:    f.ID,
+				FenceName:  f.Name,
+				VehicleKey: v.Key,
+				Line:       v.Line,
+				Vehicle:    v.Type,
+				Lat:        v.Lat,
+				Lon:        v.Lon,
+				Timestamp:  v.Timestamp,
+			})
+		}
+	}
+	w.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	for _, sink := range w.sinks {
+		sink(events)
+	}
+}
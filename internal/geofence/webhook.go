@@ -0,0 +1,91 @@
+package geofence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// webhookQueue is the number of event batches waiting to be posted before
+// further ones are dropped.
+const webhookQueue = 64
+
+// Webhook posts geofence events as a JSON array to a URL, one request per
+// poll producing events, in order.
+type Webhook struct {
+	url    string
+	token  string
+	client *http.Client
+	queue  chan []Event
+	logger *slog.Logger
+}
+
+// NewWebhook returns a webhook posting to url. The token, if set, is sent as
+// a bearer token.
+func NewWebhook(url, token string, logger *slog.Logger) *Webhook {
+	return &Webhook{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan []Event, webhookQueue),
+		logger: logger.With("component", "geofence_webhook"),
+	}
+}
+
+// Send queues events for posting without blocking; batches are dropped
+// while the queue is full.
+func (h *Webhook) Send(events []Event) {
+	select {
+	case h.queue <- events:
+	default:
+		h.logger.Warn("geofence webhook queue full, dropping events", "events", len(events))
+	}
+}
+
+// Run posts queued batches until ctx is cancelled. A failed post is retried
+// once.
+func (h *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case events := <-h.queue:
+			body, err := json.Marshal(events)
+			if err != nil {
+				continue
+			}
+			if err := h.post(ctx, body); err != nil {
+				h.logger.Warn("geofence webhook failed, retrying", "error", err)
+				if err := h.post(ctx, body); err != nil {
+					h.logger.Error("geofence webhook failed", "events", len(events), "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (h *Webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wabus/internal/features"
+	"wabus/internal/hub"
+	"wabus/internal/middleware"
+)
+
+// AdminHandler serves operational admin endpoints that don't belong to a
+// specific resource.
+type AdminHandler struct {
+	rateLimiter *middleware.RateLimiter
+	hub         *hub.Hub
+	switches    *features.Switches
+	logger      *slog.Logger
+}
+
+func NewAdminHandler(rateLimiter *middleware.RateLimiter) *AdminHandler {
+	return &AdminHandler{rateLimiter: rateLimiter}
+}
+
+// SetHub enables the hub introspection endpoint.
+func (h *AdminHandler) SetHub(hb *hub.Hub) {
+	h.hub = hb
+}
+
+// SetSwitches enables the endpoints switching subsystems on and off.
+func (h *AdminHandler) SetSwitches(s *features.Switches, logger *slog.Logger) {
+	h.switches = s
+	h.logger = logger
+}
+
+type RateLimitTopResponse struct {
+	Consumers  []middleware.ConsumerStats `json:"consumers"`
+	Count      int                        `json:"count"`
+	ServerTime time.Time                  `json:"server_time"`
+}
+
+// RateLimitTop lists the heaviest consumers in the current rate limit
+// window; ?limit= caps the list (default 20).
+func (h *AdminHandler) RateLimitTop(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	consumers := h.rateLimiter.Top(limit)
+	respondJSON(w, http.StatusOK, RateLimitTopResponse{
+		Consumers:  consumers,
+		Count:      len(consumers),
+		ServerTime: time.Now(),
+	})
+}
+
+type HubResponse struct {
+	hub.Snapshot
+	ServerTime time.Time `json:"server_time"`
+}
+
+// GetHub describes the hub's subscriptions: subscribers per tile and line,
+// and each client's subscription sizes and send buffer, fullest first.
+// ?limit= caps each list (default 100).
+func (h *AdminHandler) GetHub(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, HubResponse{Snapshot: h.hub.Snapshot(limit), ServerTime: time.Now()})
+}
+
+type FeaturesResponse struct {
+	Features   map[string]bool `json:"features"`
+	ServerTime time.Time       `json:"server_time"`
+}
+
+// ListFeatures reports which of the subsystems that can be switched off at
+// runtime are on.
+func (h *AdminHandler) ListFeatures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, FeaturesResponse{Features: h.switches.State(), ServerTime: time.Now()})
+}
+
+type FeatureRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// SetFeature switches a subsystem on or off on this instance until it
+// restarts, e.g. to shed load on degraded hardware. Clients stay connected.
+func (h *AdminHandler) SetFeature(w http.ResponseWriter, r *http.Request) {
+	var req FeatureRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Enabled == nil {
+		respondError(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+
+	name := r.PathValue("name")
+	if !h.switches.Set(name, *req.Enabled) {
+		respondError(w, http.StatusNotFound, "unknown feature, expected one of: "+strings.Join(h.switches.Names(), ", "))
+		return
+	}
+	h.logger.InfoContext(r.Context(), "feature switched", "feature", name, "enabled", *req.Enabled)
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, FeaturesResponse{Features: h.switches.State(), ServerTime: time.Now()})
+}
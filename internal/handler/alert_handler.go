@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+)
+
+type AlertHandler struct {
+	alerts *store.AlertStore
+}
+
+func NewAlertHandler(alerts *store.AlertStore) *AlertHandler {
+	return &AlertHandler{alerts: alerts}
+}
+
+type AlertsResponse struct {
+	Alerts     []*domain.Alert `json:"alerts"`
+	Count      int             `json:"count"`
+	ServerTime time.Time       `json:"server_time"`
+}
+
+// AlertRequest is the body of admin create and update requests.
+type AlertRequest struct {
+	Severity    domain.AlertSeverity `json:"severity"`
+	Title       string               `json:"title"`
+	Description string               `json:"description"`
+	URL         string               `json:"url"`
+	Lines       []string             `json:"lines"`
+	StopIDs     []string             `json:"stop_ids"`
+	ActiveFrom  *time.Time           `json:"active_from"`
+	ActiveUntil *time.Time           `json:"active_until"`
+}
+
+// ListAlerts returns currently active alerts, optionally narrowed to those
+// affecting ?line= or ?stop=.
+func (h *AlertHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	line := r.URL.Query().Get("line")
+	stopID := r.URL.Query().Get("stop")
+
+	alerts := make([]*domain.Alert, 0)
+	for _, a := range h.alerts.List(true, now) {
+		if line != "" && !a.AffectsLine(line) {
+			continue
+		}
+		if stopID != "" && !a.AffectsStop(stopID) {
+			continue
+		}
+		alerts = append(alerts, a)
+	}
+
+	respondJSON(w, http.StatusOK, AlertsResponse{Alerts: alerts, Count: len(alerts), ServerTime: now})
+}
+
+// AdminListAlerts returns all alerts, including expired and scheduled ones.
+func (h *AlertHandler) AdminListAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts := h.alerts.List(false, time.Now())
+	respondJSON(w, http.StatusOK, AlertsResponse{Alerts: alerts, Count: len(alerts), ServerTime: time.Now()})
+}
+
+func (h *AlertHandler) AdminCreateAlert(w http.ResponseWriter, r *http.Request) {
+	alert, ok := decodeAlertRequest(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusCreated, h.alerts.Create(alert))
+}
+
+func (h *AlertHandler) AdminUpdateAlert(w http.ResponseWriter, r *http.Request) {
+	alert, ok := decodeAlertRequest(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := h.alerts.Update(r.PathValue("id"), alert)
+	if errors.Is(err, store.ErrAlertNotFound) {
+		respondError(w, http.StatusNotFound, "alert not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, updated)
+}
+
+func (h *AlertHandler) AdminDeleteAlert(w http.ResponseWriter, r *http.Request) {
+	if err := h.alerts.Delete(r.PathValue("id")); errors.Is(err, store.ErrAlertNotFound) {
+		respondError(w, http.StatusNotFound, "alert not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeAlertRequest(w http.ResponseWriter, r *http.Request) (*domain.Alert, bool) {
+	var req AlertRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return nil, false
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		respondError(w, http.StatusBadRequest, "title is required")
+		return nil, false
+	}
+	if req.Severity == "" {
+		req.Severity = domain.AlertSeverityInfo
+	}
+	if !req.Severity.Valid() {
+		respondError(w, http.StatusBadRequest, "invalid severity: must be info, warning or severe")
+		return nil, false
+	}
+	if req.ActiveFrom != nil && req.ActiveUntil != nil && !req.ActiveUntil.After(*req.ActiveFrom) {
+		respondError(w, http.StatusBadRequest, "active_until must be after active_from")
+		return nil, false
+	}
+
+	return &domain.Alert{
+		Severity:    req.Severity,
+		Title:       req.Title,
+		Description: req.Description,
+		URL:         req.URL,
+		Lines:       req.Lines,
+		StopIDs:     req.StopIDs,
+		ActiveFrom:  req.ActiveFrom,
+		ActiveUntil: req.ActiveUntil,
+	}, true
+}
@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wabus/internal/analytics"
+	"wabus/internal/store"
+	"wabus/pkg/gtfs"
+)
+
+type AnalyticsHandler struct {
+	redaction
+	distance *analytics.DistanceTracker
+	bunching *analytics.BunchingAnalyzer
+	segments *analytics.SegmentTracker
+	gtfs     *store.GTFSStore
+	keepDays int
+
+	spans         *analytics.ServiceSpanTracker
+	spanTolerance time.Duration
+}
+
+func NewAnalyticsHandler(distance *analytics.DistanceTracker) *AnalyticsHandler {
+	return &AnalyticsHandler{distance: distance}
+}
+
+// SetBunching enables the bunching endpoint.
+func (h *AnalyticsHandler) SetBunching(analyzer *analytics.BunchingAnalyzer) {
+	h.bunching = analyzer
+}
+
+// SetSegments enables the segment speed endpoint, drawing segments on the
+// line shapes of gtfsStore. keepDays is how many days the tracker keeps.
+func (h *AnalyticsHandler) SetSegments(tracker *analytics.SegmentTracker, gtfsStore *store.GTFSStore, keepDays int) {
+	h.segments = tracker
+	h.gtfs = gtfsStore
+	h.keepDays = keepDays
+}
+
+// SetServiceSpans enables the first/last departure verification endpoint.
+func (h *AnalyticsHandler) SetServiceSpans(tracker *analytics.ServiceSpanTracker, gtfsStore *store.GTFSStore, tolerance time.Duration) {
+	h.spans = tracker
+	h.gtfs = gtfsStore
+	h.spanTolerance = tolerance
+}
+
+type EmissionsFigures struct {
+	DistanceKm float64 `json:"distance_km"`
+	KgCO2      float64 `json:"kg_co2"`
+}
+
+type LineEmissions struct {
+	Line string `json:"line"`
+	Type string `json:"type"`
+	EmissionsFigures
+	ByPropulsion map[analytics.Propulsion]EmissionsFigures `json:"by_propulsion"`
+}
+
+type EmissionsResponse struct {
+	Date string `json:"date"`
+	// TrackingSince is when distance tracking started; days before it are
+	// empty and the day it falls on is incomplete.
+	TrackingSince time.Time                  `json:"tracking_since"`
+	Total         EmissionsFigures           `json:"total"`
+	Lines         []LineEmissions            `json:"lines"`
+	Factors       []analytics.EmissionFactor `json:"factors"`
+	ServerTime    time.Time                  `json:"server_time"`
+}
+
+// GetEmissions returns a rough estimate of the distance traveled and CO2
+// emitted per line on a service day (?date=YYYY-MM-DD, default today),
+// optionally narrowed to ?line=.
+func (h *AnalyticsHandler) GetEmissions(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	day := gtfs.ServiceDay(now)
+	if v := r.URL.Query().Get("date"); v != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", v, gtfs.Location())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid date format, use YYYY-MM-DD")
+			return
+		}
+		day = parsed
+	}
+	lineFilter := r.URL.Query().Get("line")
+
+	resp := EmissionsResponse{
+		Date:          day.Format("2006-01-02"),
+		TrackingSince: h.distance.Since(),
+		Lines:         make([]LineEmissions, 0),
+		Factors:       analytics.EmissionFactors,
+		ServerTime:    now,
+	}
+
+	var totalMeters, totalKg float64
+	for _, d := range h.distance.Day(day) {
+		if lineFilter != "" && d.Line != lineFilter {
+			continue
+		}
+		n := len(resp.Lines)
+		if n == 0 || resp.Lines[n-1].Line != d.Line || resp.Lines[n-1].Type != d.Type.String() {
+			resp.Lines = append(resp.Lines, LineEmissions{
+				Line:         d.Line,
+				Type:         d.Type.String(),
+				ByPropulsion: make(map[analytics.Propulsion]EmissionsFigures),
+			})
+			n++
+		}
+		le := &resp.Lines[n-1]
+		le.DistanceKm += d.Meters / 1000
+		le.KgCO2 += d.KgCO2
+		le.ByPropulsion[d.Propulsion] = roundFigures(d.Meters/1000, d.KgCO2)
+		totalMeters += d.Meters
+		totalKg += d.KgCO2
+	}
+	for i := range resp.Lines {
+		resp.Lines[i].EmissionsFigures = roundFigures(resp.Lines[i].DistanceKm, resp.Lines[i].KgCO2)
+	}
+	resp.Total = roundFigures(totalMeters/1000, totalKg)
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func roundFigures(km, kg float64) EmissionsFigures {
+	return EmissionsFigures{
+		DistanceKm: math.Round(km*10) / 10,
+		KgCO2:      math.Round(kg*10) / 10,
+	}
+}
+
+type BunchingResponse struct {
+	// Threshold is the ratio of gap to scheduled headway below which
+	// vehicles are bunched.
+	Threshold  float64            `json:"threshold"`
+	Bunches    []*analytics.Bunch `json:"bunches"`
+	Count      int                `json:"count"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+	ServerTime time.Time          `json:"server_time"`
+}
+
+// GetBunching returns the vehicles found bunched behind the one ahead of them
+// by the last analyzer pass, optionally narrowed to ?line=.
+func (h *AnalyticsHandler) GetBunching(w http.ResponseWriter, r *http.Request) {
+	all, updatedAt := h.bunching.Bunches()
+	lineFilter := r.URL.Query().Get("line")
+	redacts := h.redacts(r)
+
+	bunches := make([]*analytics.Bunch, 0, len(all))
+	for _, b := range all {
+		if lineFilter != "" && b.Line != lineFilter {
+			continue
+		}
+		if redacts {
+			redacted := *b
+			redacted.VehicleKey = h.redactor.ID(b.VehicleKey)
+			redacted.AheadKey = h.redactor.ID(b.AheadKey)
+			b = &redacted
+		}
+		bunches = append(bunches, b)
+	}
+
+	respondJSON(w, http.StatusOK, BunchingResponse{
+		Threshold:  h.bunching.Threshold(),
+		Bunches:    bunches,
+		Count:      len(bunches),
+		UpdatedAt:  updatedAt,
+		ServerTime: time.Now(),
+	})
+}
+
+type SegmentsResponse struct {
+	Line           string                   `json:"line"`
+	Days           int                      `json:"days"`
+	Hours          string                   `json:"hours,omitempty"`
+	SegmentLengthM float64                  `json:"segment_length_m"`
+	Shapes         []analytics.ShapeProfile `json:"shapes"`
+	ServerTime     time.Time                `json:"server_time"`
+}
+
+// GetSegments returns the observed speed profile along each shape of ?line=
+// in fixed-length segments, over the last ?days= service days (default all
+// kept) and optionally the local ?hours= (7 or 7-9), flagging chronically
+// slow segments.
+func (h *AnalyticsHandler) GetSegments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	line := q.Get("line")
+	if line == "" {
+		respondError(w, http.StatusBadRequest, "line parameter is required")
+		return
+	}
+	route, ok := h.gtfs.GetRouteByLine(line)
+	if !ok {
+		respondLineNotFound(w, h.gtfs, line, "route not found")
+		return
+	}
+
+	days := h.keepDays
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > h.keepDays {
+			respondError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(h.keepDays))
+			return
+		}
+		days = n
+	}
+
+	var hours map[int]bool
+	hoursParam := q.Get("hours")
+	if hoursParam != "" {
+		from, to, ok := parseHourRange(hoursParam)
+		if !ok {
+			respondError(w, http.StatusBadRequest, "invalid hours parameter, use H or H-H with hours 0-23")
+			return
+		}
+		hours = make(map[int]bool)
+		for hour := from; hour <= to; hour++ {
+			hours[hour] = true
+		}
+	}
+
+	now := time.Now()
+	since := gtfs.ServiceDay(now).AddDate(0, 0, -(days - 1))
+	shapes := h.segments.Profiles(line, h.gtfs.GetSimplifiedRouteShapes(route.ID), since, hours)
+
+	respondJSON(w, http.StatusOK, SegmentsResponse{
+		Line:           line,
+		Days:           days,
+		Hours:          hoursParam,
+		SegmentLengthM: h.segments.Length(),
+		Shapes:         shapes,
+		ServerTime:     now,
+	})
+}
+
+// parseHourRange parses "7" or "7-9" into an inclusive range of hours.
+func parseHourRange(v string) (from, to int, ok bool) {
+	first, last, isRange := strings.Cut(v, "-")
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, false
+	}
+	to = from
+	if isRange {
+		if to, err = strconv.Atoi(last); err != nil {
+			return 0, 0, false
+		}
+	}
+	return from, to, from >= 0 && to <= 23 && from <= to
+}
+
+type ServiceSpanResponse struct {
+	Date             string `json:"date"`
+	ToleranceSeconds int    `json:"tolerance_seconds"`
+	// TrackingSince is when observation started; departures before it
+	// aren't judged.
+	TrackingSince time.Time                   `json:"tracking_since"`
+	Lines         []analytics.LineServiceSpan `json:"lines"`
+	IssueCount    int                         `json:"issue_count"`
+	ServerTime    time.Time                   `json:"server_time"`
+}
+
+// GetServiceSpan compares each line's first and last observed vehicle on a
+// service day (?date=YYYY-MM-DD, default today) with its first and last
+// scheduled departure, optionally narrowed to ?line= or, with ?issues=true,
+// to lines with discrepancies.
+func (h *AnalyticsHandler) GetServiceSpan(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	now := time.Now()
+	day := gtfs.ServiceDay(now)
+	if v := q.Get("date"); v != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", v, gtfs.Location())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid date format, use YYYY-MM-DD")
+			return
+		}
+		day = parsed
+	}
+	lineFilter := q.Get("line")
+	issuesOnly := q.Get("issues") == "true"
+	redacts := h.redacts(r)
+
+	resp := ServiceSpanResponse{
+		Date:             day.Format("2006-01-02"),
+		ToleranceSeconds: int(h.spanTolerance.Seconds()),
+		TrackingSince:    h.spans.Since(),
+		Lines:            make([]analytics.LineServiceSpan, 0),
+		ServerTime:       now,
+	}
+	for _, s := range h.spans.Compare(day, h.gtfs.ScheduledSpans(day), h.spanTolerance, now) {
+		if lineFilter != "" && s.Line != lineFilter {
+			continue
+		}
+		if len(s.Issues) > 0 {
+			resp.IssueCount++
+		} else if issuesOnly {
+			continue
+		}
+		if redacts {
+			if s.FirstVehicle != "" {
+				s.FirstVehicle = h.redactor.ID(s.FirstVehicle)
+			}
+			if s.LastVehicle != "" {
+				s.LastVehicle = h.redactor.ID(s.LastVehicle)
+			}
+		}
+		resp.Lines = append(resp.Lines, s)
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
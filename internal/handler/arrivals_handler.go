@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"wabus/internal/prediction"
+	"wabus/internal/store"
+	"wabus/pkg/gtfs"
+)
+
+// ArrivalsHandler serves arrival predictions and departure boards for
+// live vehicles.
+type ArrivalsHandler struct {
+	redaction
+	predictor *prediction.Predictor
+	gtfsStore *store.GTFSStore
+	alerts    *store.AlertStore
+}
+
+func NewArrivalsHandler(predictor *prediction.Predictor, gtfsStore *store.GTFSStore) *ArrivalsHandler {
+	return &ArrivalsHandler{predictor: predictor, gtfsStore: gtfsStore}
+}
+
+type StopArrival struct {
+	Line             string    `json:"line"`
+	Headsign         string    `json:"headsign,omitempty"`
+	TripID           string    `json:"trip_id"`
+	VehicleKey       string    `json:"vehicle_key"`
+	VehicleNumber    string    `json:"vehicle_number,omitempty"`
+	StopSequence     int       `json:"stop_sequence"`
+	ScheduledArrival time.Time `json:"scheduled_arrival"`
+	PredictedArrival time.Time `json:"predicted_arrival"`
+	DelaySeconds     int       `json:"delay_seconds"`
+	StopsAway        int       `json:"stops_away"`
+	AtStop           bool      `json:"at_stop,omitempty"`
+}
+
+type StopArrivalsResponse struct {
+	StopID      string        `json:"stop_id"`
+	Arrivals    []StopArrival `json:"arrivals"`
+	Count       int           `json:"count"`
+	PredictedAt time.Time     `json:"predicted_at"`
+	ServerTime  time.Time     `json:"server_time"`
+}
+
+// GetStopArrivals lists the live vehicles predicted to call at a stop,
+// soonest first. ?line= keeps one line; ?limit= caps the list (default 20).
+func (h *ArrivalsHandler) GetStopArrivals(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := h.gtfsStore.GetStopByID(id); !ok {
+		respondError(w, http.StatusNotFound, "stop not found")
+		return
+	}
+
+	line := r.URL.Query().Get("line")
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	arrivals, predictedAt := h.predictor.Arrivals(id)
+	result := make([]StopArrival, 0, min(len(arrivals), limit))
+	for _, a := range arrivals {
+		if len(result) == limit {
+			break
+		}
+		trip := a.Assignment.Trip
+		if line != "" && trip.Line != line {
+			continue
+		}
+		result = append(result, StopArrival{
+			Line:             trip.Line,
+			Headsign:         trip.Headsign,
+			TripID:           trip.TripID,
+			VehicleKey:       a.Assignment.Vehicle.Key,
+			VehicleNumber:    a.Assignment.Vehicle.VehicleNumber,
+			StopSequence:     a.Prediction.Stop.Sequence,
+			ScheduledArrival: a.Prediction.Stop.Arrival,
+			PredictedArrival: a.Prediction.Arrival,
+			DelaySeconds:     int(a.Prediction.ArrivalDelay / time.Second),
+			StopsAway:        a.StopsAway,
+			AtStop:           a.StopsAway == 0 && a.Assignment.AtStop,
+		})
+	}
+	if h.redacts(r) {
+		for i := range result {
+			result[i].VehicleKey, result[i].VehicleNumber = h.redactor.ID(result[i].VehicleKey), ""
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	respondJSON(w, http.StatusOK, StopArrivalsResponse{
+		StopID:      id,
+		Arrivals:    result,
+		Count:       len(result),
+		PredictedAt: predictedAt,
+		ServerTime:  time.Now(),
+	})
+}
+
+type StopDeparture struct {
+	Line          string    `json:"line"`
+	Headsign      string    `json:"headsign,omitempty"`
+	TripID        string    `json:"trip_id"`
+	StopSequence  int       `json:"stop_sequence"`
+	Scheduled     time.Time `json:"scheduled"`
+	Expected      time.Time `json:"expected"`
+	DelaySeconds  int       `json:"delay_seconds"`
+	IsRealtime    bool      `json:"is_realtime"`
+	VehicleKey    string    `json:"vehicle_key,omitempty"`
+	VehicleNumber string    `json:"vehicle_number,omitempty"`
+}
+
+type StopDeparturesResponse struct {
+	StopID     string          `json:"stop_id"`
+	StopName   string          `json:"stop_name"`
+	Departures []StopDeparture `json:"departures"`
+	Count      int             `json:"count"`
+	ServerTime time.Time       `json:"server_time"`
+}
+
+// departureLookBack is how long after its scheduled time a departure may
+// still be expected, as a late vehicle is.
+const departureLookBack = 30 * time.Minute
+
+// GetStopDepartures is a live departure board: the next scheduled departures
+// from a stop, with the expected time of those run by a tracked vehicle.
+// Departures of trips already past the stop are left out. ?line= keeps one
+// line; ?limit= caps the list (default 10).
+func (h *ArrivalsHandler) GetStopDepartures(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	stop, ok := h.gtfsStore.GetStopByID(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "stop not found")
+		return
+	}
+
+	line := r.URL.Query().Get("line")
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	now := time.Now()
+	departures := departureBoard(h.predictor, h.gtfsStore, id, line, limit, now)
+	if h.redacts(r) {
+		for i := range departures {
+			if departures[i].VehicleKey != "" {
+				departures[i].VehicleKey, departures[i].VehicleNumber = h.redactor.ID(departures[i].VehicleKey), ""
+			}
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	respondJSON(w, http.StatusOK, StopDeparturesResponse{
+		StopID:     id,
+		StopName:   stop.Name,
+		Departures: departures,
+		Count:      len(departures),
+		ServerTime: now,
+	})
+}
+
+// departureBoard lists the next departures from a stop as of now, with the
+// expected time of those run by a tracked vehicle, soonest expected first.
+// Departures of trips already past the stop are left out; line, when set,
+// keeps one line. Vehicle keys are not redacted.
+func departureBoard(predictor *prediction.Predictor, gtfsStore *store.GTFSStore, id, line string, limit int, now time.Time) []StopDeparture {
+	assignments, _ := predictor.Assignments()
+	byTrip := make(map[string]*prediction.Assignment, len(assignments))
+	for _, a := range assignments {
+		byTrip[prediction.TripKey(a.Trip)] = a
+	}
+
+	departures := make([]StopDeparture, 0, limit)
+	for _, st := range gtfsStore.GetStopScheduleAt(id, now.Add(-departureLookBack)) {
+		if line != "" && st.Line != line {
+			continue
+		}
+		serviceDate, err := time.ParseInLocation("2006-01-02", st.ServiceDate, gtfs.Location())
+		if err != nil {
+			continue
+		}
+		d := StopDeparture{
+			Line:         st.Line,
+			Headsign:     st.Headsign,
+			TripID:       st.TripID,
+			StopSequence: st.StopSequence,
+			Scheduled:    *st.DepartureAt,
+			Expected:     *st.DepartureAt,
+		}
+		if a, ok := byTrip[prediction.RunKey(st.TripID, serviceDate)]; ok {
+			sp, ahead := stopPrediction(a, st.StopSequence)
+			if !ahead {
+				continue
+			}
+			d.Expected = sp.Departure
+			d.DelaySeconds = int(sp.DepartureDelay / time.Second)
+			d.IsRealtime = true
+			d.VehicleKey = a.Vehicle.Key
+			d.VehicleNumber = a.Vehicle.VehicleNumber
+		}
+		if d.Expected.Before(now) {
+			continue
+		}
+		departures = append(departures, d)
+	}
+
+	// Delays can overtake scheduled order; the board lists expected times.
+	sort.SliceStable(departures, func(i, j int) bool { return departures[i].Expected.Before(departures[j].Expected) })
+	if len(departures) > limit {
+		departures = departures[:limit]
+	}
+	return departures
+}
+
+// stopPrediction returns the assignment's prediction at the stop with the
+// given sequence, or false when the vehicle is already past it.
+func stopPrediction(a *prediction.Assignment, sequence int) (prediction.StopPrediction, bool) {
+	for _, sp := range a.Predictions() {
+		if sp.Stop.Sequence == sequence {
+			return sp, true
+		}
+	}
+	return prediction.StopPrediction{}, false
+}
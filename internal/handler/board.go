@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+	"wabus/pkg/gtfs"
+)
+
+// boardMaxAlerts caps the alerts listed under the departures.
+const boardMaxAlerts = 3
+
+// SetAlerts lists the alerts affecting a stop or its departing lines on
+// its rendered board.
+func (h *ArrivalsHandler) SetAlerts(alerts *store.AlertStore) {
+	h.alerts = alerts
+}
+
+// board is what a rendered departure board shows.
+type board struct {
+	StopName string
+	Rows     []boardRow
+	Alerts   []string
+	Clock    string
+}
+
+type boardRow struct {
+	Line     string
+	Headsign string
+	Time     string
+	Realtime bool
+}
+
+// GetStopBoard renders the departure board of a stop for displays that
+// can't run a client: ?format=html (default), a page reloading itself
+// every 30s, or ?format=png, a black and white image of ?width= by
+// ?height= pixels (default 800x480) for e-ink panels. ?line= and ?limit=
+// (default 8) as for departures.
+func (h *ArrivalsHandler) GetStopBoard(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	stop, ok := h.gtfsStore.GetStopByID(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "stop not found")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "html" && format != "png" {
+		respondError(w, http.StatusBadRequest, "invalid format parameter: expected html or png")
+		return
+	}
+	limit := 8
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 20 {
+			respondError(w, http.StatusBadRequest, "invalid limit parameter: expected 1-20")
+			return
+		}
+		limit = n
+	}
+	width, height := 800, 480
+	if format == "png" {
+		var ok bool
+		if width, ok = boardDimension(w, r, "width", width); !ok {
+			return
+		}
+		if height, ok = boardDimension(w, r, "height", height); !ok {
+			return
+		}
+	}
+
+	now := time.Now()
+	departures := departureBoard(h.predictor, h.gtfsStore, id, r.URL.Query().Get("line"), limit, now)
+	b := board{StopName: stop.Name, Clock: now.In(gtfs.Location()).Format("15:04")}
+	lines := make(map[string]struct{})
+	for _, d := range departures {
+		b.Rows = append(b.Rows, boardRow{Line: d.Line, Headsign: d.Headsign, Time: boardTime(d, now), Realtime: d.IsRealtime})
+		lines[d.Line] = struct{}{}
+	}
+	if h.alerts != nil {
+		for _, a := range h.alerts.List(true, now) {
+			if len(b.Alerts) == boardMaxAlerts {
+				break
+			}
+			if boardAlert(a, id, lines) {
+				b.Alerts = append(b.Alerts, a.Title)
+			}
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if format == "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, renderBoard(b, width, height)); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to render board")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	boardTemplate.Execute(w, b)
+}
+
+func boardDimension(w http.ResponseWriter, r *http.Request, param string, def int) (int, bool) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 200 || n > 2000 {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s parameter: expected 200-2000", param))
+		return 0, false
+	}
+	return n, true
+}
+
+// boardAlert reports whether an alert belongs on the board of a stop with
+// the given departing lines.
+func boardAlert(a *domain.Alert, stopID string, lines map[string]struct{}) bool {
+	if a.AffectsStop(stopID) {
+		return true
+	}
+	for line := range lines {
+		if a.AffectsLine(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// boardTime shows a departure as minutes to go when it is tracked and
+// within the hour, and as the clock time otherwise.
+func boardTime(d StopDeparture, now time.Time) string {
+	until := d.Expected.Sub(now)
+	if d.IsRealtime && until < time.Hour {
+		if until < time.Minute {
+			return "now"
+		}
+		return fmt.Sprintf("%d min", int(until/time.Minute))
+	}
+	return d.Expected.In(gtfs.Location()).Format("15:04")
+}
+
+var boardTemplate = template.Must(template.New("board").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.StopName}}</title>
+<style>
+body { margin: 0; padding: 1em; font-family: sans-serif; background: #fff; color: #000; }
+h1 { display: flex; justify-content: space-between; margin: 0 0 .5em; font-size: 1.6em; border-bottom: 3px solid #000; }
+table { width: 100%; border-collapse: collapse; font-size: 1.4em; }
+td { padding: .2em .3em; border-bottom: 1px solid #000; }
+td.line { width: 3.5em; font-weight: bold; }
+td.time { text-align: right; white-space: nowrap; }
+td.time.live { font-weight: bold; }
+ul { margin: 1em 0 0; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<h1><span>{{.StopName}}</span><span>{{.Clock}}</span></h1>
+{{if .Rows}}<table>
+{{range .Rows}}<tr><td class="line">{{.Line}}</td><td>{{.Headsign}}</td><td class="time{{if .Realtime}} live{{end}}">{{.Time}}</td></tr>
+{{end}}</table>{{else}}<p>No departures</p>{{end}}
+{{if .Alerts}}<ul>
+{{range .Alerts}}<li>{{.}}</li>
+{{end}}</ul>{{end}}
+</body>
+</html>
+`))
+
+var (
+	boardFontsOnce          sync.Once
+	boardRegular, boardBold *opentype.Font
+)
+
+func boardFonts() (*opentype.Font, *opentype.Font) {
+	boardFontsOnce.Do(func() {
+		boardRegular, _ = opentype.Parse(goregular.TTF)
+		boardBold, _ = opentype.Parse(gobold.TTF)
+	})
+	return boardRegular, boardBold
+}
+
+// renderBoard draws the board in black on white, without grey levels, as
+// e-ink panels show them. Text is sized to the height; rows that don't fit
+// are left out.
+func renderBoard(b board, width, height int) image.Image {
+	regular, bold := boardFonts()
+	size := float64(height) / 16
+	face := func(f *opentype.Font, scale float64) font.Face {
+		face, _ := opentype.NewFace(f, &opentype.FaceOptions{Size: size * scale, DPI: 72, Hinting: font.HintingFull})
+		return face
+	}
+	titleFace, lineFace, textFace, smallFace := face(bold, 1.2), face(bold, 1), face(regular, 1), face(regular, 0.75)
+
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range gray.Pix {
+		gray.Pix[i] = 0xff
+	}
+	margin := int(size / 2)
+	draw := func(f font.Face, text string, x, baseline int) {
+		d := font.Drawer{Dst: gray, Src: image.Black, Face: f, Dot: fixed.P(x, baseline)}
+		d.DrawString(text)
+	}
+	rightAligned := func(f font.Face, text string, right, baseline int) int {
+		x := right - font.MeasureString(f, text).Ceil()
+		draw(f, text, x, baseline)
+		return x
+	}
+	rule := func(y, thickness int) {
+		for dy := 0; dy < thickness; dy++ {
+			for x := margin; x < width-margin; x++ {
+				gray.SetGray(x, y+dy, color.Gray{})
+			}
+		}
+	}
+
+	lineHeight := int(size * 1.5)
+	y := margin + int(size*1.2)
+	clockX := rightAligned(titleFace, b.Clock, width-margin, y)
+	draw(titleFace, fitText(titleFace, b.StopName, clockX-2*margin), margin, y)
+	y += int(size / 2)
+	rule(y, 3)
+
+	alertsHeight := 0
+	if len(b.Alerts) > 0 {
+		alertsHeight = len(b.Alerts)*int(size) + margin
+	}
+	if len(b.Rows) == 0 {
+		draw(textFace, "No departures", margin, y+lineHeight)
+	}
+	lineWidth := font.MeasureString(lineFace, "N000").Ceil() + margin
+	for _, row := range b.Rows {
+		if y+lineHeight > height-margin-alertsHeight {
+			break
+		}
+		y += lineHeight
+		timeFace := textFace
+		if row.Realtime {
+			timeFace = lineFace
+		}
+		timeX := rightAligned(timeFace, row.Time, width-margin, y)
+		draw(lineFace, row.Line, margin, y)
+		draw(textFace, fitText(textFace, row.Headsign, timeX-margin-lineWidth-margin), margin+lineWidth, y)
+	}
+
+	if len(b.Alerts) > 0 {
+		y = height - margin - alertsHeight
+		rule(y, 1)
+		for _, title := range b.Alerts {
+			y += int(size)
+			draw(smallFace, fitText(smallFace, "! "+title, width-2*margin), margin, y)
+		}
+	}
+
+	// Threshold the anti-aliased text to two colors.
+	img := image.NewPaletted(gray.Bounds(), color.Palette{color.White, color.Black})
+	for i, v := range gray.Pix {
+		if v < 0x80 {
+			img.Pix[i] = 1
+		}
+	}
+	return img
+}
+
+// fitText shortens text with an ellipsis to at most width pixels.
+func fitText(f font.Face, text string, width int) string {
+	if font.MeasureString(f, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if s := string(runes) + "…"; font.MeasureString(f, s).Ceil() <= width {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+
+	"wabus/internal/domain"
+	"wabus/internal/features"
+	"wabus/internal/hub"
+)
+
+// Capabilities describes what this deployment serves, so clients can adapt
+// to servers configured differently. It is fixed at startup, except for
+// subsystems an admin switches off at runtime.
+type Capabilities struct {
+	Features  FeatureCapabilities  `json:"features"`
+	Realtime  RealtimeCapabilities `json:"realtime"`
+	WebSocket WSCapabilities       `json:"websocket"`
+}
+
+// FeatureCapabilities lists the optional subsystems and whether they are
+// enabled. Endpoints of disabled ones answer 404 feature_disabled.
+type FeatureCapabilities struct {
+	// GTFS covers routes, stops, shapes, arrivals and everything else
+	// derived from the schedule.
+	GTFS          bool `json:"gtfs"`
+	Redis         bool `json:"redis"`
+	TripUpdates   bool `json:"trip_updates"`
+	ServiceAlerts bool `json:"service_alerts"`
+	History       bool `json:"history"`
+	Segments      bool `json:"segments"`
+	Micromobility bool `json:"micromobility"`
+	POI           bool `json:"poi"`
+	Elevation     bool `json:"elevation"`
+	DevicePrefs   bool `json:"device_prefs"`
+	// Privacy is true when vehicle numbers and keys are pseudonymized.
+	Privacy bool `json:"privacy"`
+	// Interpolation is true while vehicles are tagged with their delay.
+	// It and Analytics can be switched off at runtime.
+	Interpolation bool `json:"interpolation"`
+	Analytics     bool `json:"analytics"`
+}
+
+// RealtimeCapabilities describes the live vehicle feed.
+type RealtimeCapabilities struct {
+	VehicleTypes []string `json:"vehicle_types"`
+	TileZoom     int      `json:"tile_zoom"`
+	// LegacyTileZoom is a previous tile zoom still accepted, if any.
+	LegacyTileZoom int `json:"legacy_tile_zoom,omitempty"`
+	// PositionPrecision is the decimal places positions are rounded to, if
+	// they are.
+	PositionPrecision int  `json:"position_precision,omitempty"`
+	SSE               bool `json:"sse"`
+}
+
+// WSCapabilities describes the /v1/ws protocol as this server speaks it.
+type WSCapabilities struct {
+	ProtocolVersions []int    `json:"protocol_versions"`
+	Formats          []string `json:"formats"`
+	// Compression is the permessage-deflate mode offered, or "disabled".
+	Compression  string `json:"compression"`
+	AuthRequired bool   `json:"auth_required"`
+	MaxTiles     int    `json:"max_tiles"`
+	MaxLines     int    `json:"max_lines"`
+}
+
+// CapabilitiesHandler serves the capabilities of the deployment.
+type CapabilitiesHandler struct {
+	caps     Capabilities
+	switches *features.Switches
+}
+
+// NewCapabilitiesHandler serves caps, filling in what the handler package
+// itself defines: the vehicle types and WS protocol.
+func NewCapabilitiesHandler(caps Capabilities) *CapabilitiesHandler {
+	caps.Realtime.VehicleTypes = []string{domain.VehicleTypeBus.String(), domain.VehicleTypeTram.String()}
+	caps.WebSocket.ProtocolVersions = []int{WSProtocolVersion}
+	caps.WebSocket.Formats = []string{hub.FormatJSON.String(), hub.FormatProtobuf.String()}
+	if caps.WebSocket.Compression == "" {
+		caps.WebSocket.Compression = "disabled"
+	}
+	return &CapabilitiesHandler{caps: caps}
+}
+
+// SetSwitches reports the subsystems switched off at runtime as disabled.
+func (h *CapabilitiesHandler) SetSwitches(s *features.Switches) {
+	h.switches = s
+}
+
+func (h *CapabilitiesHandler) GetCapabilities(w http.ResponseWriter, r *http.Request) {
+	caps := h.caps
+	caps.Features.Interpolation = caps.Features.Interpolation && h.switches.Enabled(features.Interpolation)
+	caps.Features.Analytics = caps.Features.Analytics && h.switches.Enabled(features.Analytics)
+	w.Header().Set("Cache-Control", "no-cache")
+	respondJSON(w, http.StatusOK, caps)
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// NewDebugHandler serves profiling and runtime inspection endpoints for the
+// internal debug listener. None of them are authenticated; the listener
+// must only be reachable by operators.
+func NewDebugHandler(logger *slog.Logger) http.Handler {
+	logger = logger.With("component", "debug")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/goroutines", debugGoroutines)
+	mux.HandleFunc("GET /debug/memstats", debugMemStats)
+	mux.HandleFunc("GET /debug/heapdump", debugHeapDump(logger))
+	mux.HandleFunc("POST /debug/gc", debugGC(logger))
+	return mux
+}
+
+// debugGoroutines writes the stacks of all goroutines as text, as a
+// panic would.
+func debugGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// MemStatsResponse is the part of runtime.MemStats that explains where
+// memory went, in bytes.
+type MemStatsResponse struct {
+	HeapAlloc    uint64    `json:"heap_alloc"`
+	HeapInuse    uint64    `json:"heap_inuse"`
+	HeapIdle     uint64    `json:"heap_idle"`
+	HeapReleased uint64    `json:"heap_released"`
+	HeapObjects  uint64    `json:"heap_objects"`
+	StackInuse   uint64    `json:"stack_inuse"`
+	Sys          uint64    `json:"sys"`
+	TotalAlloc   uint64    `json:"total_alloc"`
+	NumGC        uint32    `json:"num_gc"`
+	LastGC       time.Time `json:"last_gc,omitzero"`
+	PauseTotalMS float64   `json:"pause_total_ms"`
+	NextGC       uint64    `json:"next_gc"`
+	// GOGC and MemoryLimit are the collector settings; MemoryLimit is
+	// math.MaxInt64 unless GOMEMLIMIT sets one.
+	GOGC        int   `json:"gogc"`
+	MemoryLimit int64 `json:"memory_limit"`
+	Goroutines  int   `json:"goroutines"`
+}
+
+func readMemStats() MemStatsResponse {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	settings := []metrics.Sample{{Name: "/gc/gogc:percent"}, {Name: "/gc/gomemlimit:bytes"}}
+	metrics.Read(settings)
+	resp := MemStatsResponse{
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapIdle:     m.HeapIdle,
+		HeapReleased: m.HeapReleased,
+		HeapObjects:  m.HeapObjects,
+		StackInuse:   m.StackInuse,
+		Sys:          m.Sys,
+		TotalAlloc:   m.TotalAlloc,
+		NumGC:        m.NumGC,
+		PauseTotalMS: float64(m.PauseTotalNs) / 1e6,
+		NextGC:       m.NextGC,
+		GOGC:         int(settings[0].Value.Uint64()),
+		MemoryLimit:  int64(settings[1].Value.Uint64()),
+		Goroutines:   runtime.NumGoroutine(),
+	}
+	if m.LastGC > 0 {
+		resp.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	return resp
+}
+
+func debugMemStats(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, readMemStats())
+}
+
+// GCResponse reports the heap before and after a forced collection.
+type GCResponse struct {
+	Before     MemStatsResponse `json:"before"`
+	After      MemStatsResponse `json:"after"`
+	DurationMS float64          `json:"duration_ms"`
+}
+
+// debugGC runs a collection and returns freed memory to the OS, to tell
+// live data from garbage not yet collected.
+func debugGC(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		before := readMemStats()
+		start := time.Now()
+		debug.FreeOSMemory()
+		resp := GCResponse{Before: before, After: readMemStats(), DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+		logger.InfoContext(r.Context(), "forced garbage collection",
+			"heap_before", resp.Before.HeapAlloc,
+			"heap_after", resp.After.HeapAlloc,
+			"duration_ms", resp.DurationMS,
+		)
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
+
+// debugHeapDump streams a runtime/debug.WriteHeapDump of the whole heap,
+// which shows every object rather than the sampled allocations of the heap
+// profile. The process is stopped while it is written, and the dump is
+// about the size of the heap, so it is staged in a temporary file.
+func debugHeapDump(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := os.CreateTemp("", "wabus-heapdump-*")
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to create heap dump file")
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		start := time.Now()
+		debug.WriteHeapDump(f.Fd())
+		info, err := f.Stat()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to write heap dump")
+			return
+		}
+		logger.InfoContext(r.Context(), "wrote heap dump", "bytes", info.Size(), "duration", time.Since(start))
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			respondError(w, http.StatusInternalServerError, "failed to read heap dump")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="wabus-%s.heapdump"`, start.UTC().Format("20060102T150405Z")))
+		w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
+		io.Copy(w, f)
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"wabus/internal/cache"
+)
+
+const (
+	deviceTokenMinLen  = 16
+	deviceTokenMaxLen  = 128
+	devicePrefsMaxList = 100
+	devicePrefsMaxID   = 64
+)
+
+// DeviceHandler stores favorites of the companion app per device token, so
+// devices sharing a token see the same favorites.
+type DeviceHandler struct {
+	cache *cache.RedisCache
+	ttl   time.Duration
+}
+
+// NewDeviceHandler keeps preferences in c; each write keeps them for ttl.
+func NewDeviceHandler(c *cache.RedisCache, ttl time.Duration) *DeviceHandler {
+	return &DeviceHandler{cache: c, ttl: ttl}
+}
+
+type DevicePrefs struct {
+	FavoriteStops []string  `json:"favorite_stops"`
+	FavoriteLines []string  `json:"favorite_lines"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DevicePrefsRequest is the body of PUT requests; it replaces the stored
+// preferences.
+type DevicePrefsRequest struct {
+	FavoriteStops []string `json:"favorite_stops"`
+	FavoriteLines []string `json:"favorite_lines"`
+}
+
+func (h *DeviceHandler) GetPrefs(w http.ResponseWriter, r *http.Request) {
+	token, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+	var prefs DevicePrefs
+	found, err := h.cache.GetJSON(r.Context(), cache.KeyDevicePrefs(token), &prefs)
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "device preferences are temporarily unavailable")
+		return
+	}
+	if !found {
+		respondError(w, http.StatusNotFound, "no preferences stored for this device")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, prefs)
+}
+
+func (h *DeviceHandler) PutPrefs(w http.ResponseWriter, r *http.Request) {
+	token, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+	var req DevicePrefsRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 32<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	prefs := DevicePrefs{UpdatedAt: time.Now().UTC()}
+	var msg string
+	if prefs.FavoriteStops, msg = normalizeFavorites("favorite_stops", req.FavoriteStops); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if prefs.FavoriteLines, msg = normalizeFavorites("favorite_lines", req.FavoriteLines); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if err := h.cache.SetJSON(r.Context(), cache.KeyDevicePrefs(token), prefs, h.ttl); err != nil {
+		respondError(w, http.StatusServiceUnavailable, "device preferences are temporarily unavailable")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, prefs)
+}
+
+// deviceToken returns the token of "Authorization: Bearer <token>". It is
+// a header rather than part of the path so access logs don't record it. It
+// answers 401 without one, and 400 if it is too short to be unguessable or
+// holds characters other than letters, digits, '-' and '_'.
+func deviceToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="device"`)
+		respondError(w, http.StatusUnauthorized, "device token required as Authorization: Bearer <token>")
+		return "", false
+	}
+	valid := len(token) >= deviceTokenMinLen && len(token) <= deviceTokenMaxLen
+	for i := 0; valid && i < len(token); i++ {
+		c := token[i]
+		valid = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+	}
+	if !valid {
+		respondError(w, http.StatusBadRequest, "invalid device token: use 16 to 128 letters, digits, '-' or '_'")
+		return "", false
+	}
+	return token, true
+}
+
+// normalizeFavorites trims and deduplicates ids, keeping their order. It
+// returns a message for the client if the list is too long or an id empty
+// or too long.
+func normalizeFavorites(field string, ids []string) ([]string, string) {
+	if len(ids) > devicePrefsMaxList {
+		return nil, field + " may hold at most 100 entries"
+	}
+	result := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || len(id) > devicePrefsMaxID {
+			return nil, field + " entries must be 1 to 64 characters"
+		}
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result, ""
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"math"
+
+	"wabus/internal/domain"
+)
+
+// withElevation returns copies of shapes with the elevation of every point
+// covered by the DEM and the climb and descent along them. Stored shapes
+// are shared and left untouched.
+func (h *GTFSHandler) withElevation(shapes []*domain.Shape) []*domain.Shape {
+	result := make([]*domain.Shape, len(shapes))
+	for i, s := range shapes {
+		shape := *s
+		shape.Points = make([]domain.ShapePoint, len(s.Points))
+		var gain, loss float64
+		var prev *float64
+		for j, p := range s.Points {
+			if ele, ok := h.elevation.Elevation(p.Lat, p.Lon); ok {
+				ele = math.Round(ele*10) / 10
+				p.Elevation = &ele
+				if prev != nil {
+					if d := ele - *prev; d > 0 {
+						gain += d
+					} else {
+						loss -= d
+					}
+				}
+				prev = &ele
+			}
+			shape.Points[j] = p
+		}
+		if prev != nil {
+			gain, loss = math.Round(gain*10)/10, math.Round(loss*10)/10
+			shape.ElevationGain, shape.ElevationLoss = &gain, &loss
+		}
+		result[i] = &shape
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"wabus/internal/geofence"
+)
+
+type GeofenceHandler struct {
+	watcher *geofence.Watcher
+}
+
+func NewGeofenceHandler(watcher *geofence.Watcher) *GeofenceHandler {
+	return &GeofenceHandler{watcher: watcher}
+}
+
+// GeofenceInfo is a fence with its event counts since start.
+type GeofenceInfo struct {
+	*geofence.Fence
+	geofence.FenceStats
+}
+
+type GeofencesResponse struct {
+	Geofences  []GeofenceInfo `json:"geofences"`
+	Count      int            `json:"count"`
+	ServerTime time.Time      `json:"server_time"`
+}
+
+// GeofenceRequest is the body of admin create requests.
+type GeofenceRequest struct {
+	Name    string       `json:"name"`
+	Polygon [][2]float64 `json:"polygon"`
+	Lines   []string     `json:"lines"`
+}
+
+// ListGeofences returns every fence with the vehicles inside it and its
+// enter and exit counts.
+func (h *GeofenceHandler) ListGeofences(w http.ResponseWriter, r *http.Request) {
+	stats := h.watcher.Stats()
+	fences := h.watcher.List()
+	result := make([]GeofenceInfo, len(fences))
+	for i, f := range fences {
+		result[i] = GeofenceInfo{Fence: f, FenceStats: stats[f.ID]}
+	}
+	respondJSON(w, http.StatusOK, GeofencesResponse{Geofences: result, Count: len(result), ServerTime: time.Now()})
+}
+
+func (h *GeofenceHandler) AdminCreateGeofence(w http.ResponseWriter, r *http.Request) {
+	var req GeofenceRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	fence := &geofence.Fence{Name: strings.TrimSpace(req.Name), Polygon: req.Polygon, Lines: req.Lines}
+	if err := fence.Validate(); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusCreated, h.watcher.Create(fence))
+}
+
+func (h *GeofenceHandler) AdminDeleteGeofence(w http.ResponseWriter, r *http.Request) {
+	switch err := h.watcher.Delete(r.PathValue("id")); {
+	case errors.Is(err, geofence.ErrNotFound):
+		respondError(w, http.StatusNotFound, "geofence not found")
+		return
+	case errors.Is(err, geofence.ErrReadOnly):
+		respondError(w, http.StatusConflict, "geofence is defined in GEOFENCES_FILE")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
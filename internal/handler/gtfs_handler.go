@@ -7,24 +7,96 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"wabus/internal/cache"
 	"wabus/internal/domain"
 	"wabus/internal/store"
+	"wabus/pkg/dem"
+	"wabus/pkg/gtfs"
 )
 
 type GTFSHandler struct {
-	store  *store.GTFSStore
-	cache  *cache.RedisCache
-	logger *slog.Logger
+	store      *store.GTFSStore
+	cache      *cache.RedisCache
+	popularity *cache.StopPopularity
+	cacheTTL   time.Duration
+	staleTTL   time.Duration
+	refreshing sync.Map // cache key -> struct{}, background revalidations in flight
+	archive    *store.GTFSArchive
+	pois       *store.POIStore
+	elevation  *dem.DEM
+	logger     *slog.Logger
+
+	bodiesMu sync.Mutex // serializes building bodies
+	bodies   atomic.Pointer[prewarmedBodies]
+
+	bundleMu sync.Mutex // serializes building the shape bundle
+	bundle   atomic.Pointer[shapeBundle]
 }
 
-func NewGTFSHandler(store *store.GTFSStore, redisCache *cache.RedisCache, logger *slog.Logger) *GTFSHandler {
+func NewGTFSHandler(store *store.GTFSStore, redisCache *cache.RedisCache, popularity *cache.StopPopularity, cacheTTL, staleTTL time.Duration, logger *slog.Logger) *GTFSHandler {
 	return &GTFSHandler{
-		store:  store,
-		cache:  redisCache,
-		logger: logger.With("handler", "gtfs"),
+		store:      store,
+		cache:      redisCache,
+		popularity: popularity,
+		cacheTTL:   cacheTTL,
+		staleTTL:   staleTTL,
+		logger:     logger.With("handler", "gtfs"),
+	}
+}
+
+// SetArchive enables ?as_of= queries against archived datasets.
+func (h *GTFSHandler) SetArchive(archive *store.GTFSArchive) {
+	h.archive = archive
+}
+
+// SetPOIs includes the points of interest in /v1/sync for offline clients.
+func (h *GTFSHandler) SetPOIs(pois *store.POIStore) {
+	h.pois = pois
+}
+
+// SetElevation enables ?elevation=true on shapes, looking points up in d.
+func (h *GTFSHandler) SetElevation(d *dem.DEM) {
+	h.elevation = d
+}
+
+// ArchiveResponse lists the archived GTFS datasets.
+type ArchiveResponse struct {
+	Datasets []gtfs.ArchiveEntry `json:"datasets"`
+	Count    int                 `json:"count"`
+}
+
+// GetArchive lists the datasets ?as_of= queries can resolve against.
+func (h *GTFSHandler) GetArchive(w http.ResponseWriter, r *http.Request) {
+	if h.archive == nil {
+		respondErrorCode(w, http.StatusNotFound, errCodeFeatureDisabled, "GTFS archive is disabled on this server")
+		return
+	}
+	entries := h.archive.Entries()
+	respondJSON(w, http.StatusOK, ArchiveResponse{Datasets: entries, Count: len(entries)})
+}
+
+// Values of the X-Cache response header.
+const (
+	cacheStatusHit   = "hit"
+	cacheStatusStale = "stale"
+	cacheStatusMiss  = "miss"
+)
+
+// RequireLoaded wraps a GTFS endpoint so it answers 503 not_ready until the
+// first dataset has loaded, rather than empty lists or spurious 404s.
+func (h *GTFSHandler) RequireLoaded(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.store.GetStats().IsLoaded {
+			h.logger.DebugContext(r.Context(), "GTFS request before data loaded", "path", r.URL.Path)
+			w.Header().Set("Retry-After", "30")
+			respondErrorCode(w, http.StatusServiceUnavailable, errCodeNotReady, "GTFS data is loading, please retry")
+			return
+		}
+		next(w, r)
 	}
 }
 
@@ -36,31 +108,35 @@ type RoutesResponse struct {
 
 func (h *GTFSHandler) ListRoutes(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	h.logger.Debug("ListRoutes request",
+	h.logger.DebugContext(r.Context(), "ListRoutes request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"remote_addr", r.RemoteAddr,
 	)
 
-	routes := h.store.GetAllRoutes()
+	if h.tagStatic(w, r, "routes") {
+		return
+	}
+
+	bodies, _, err := h.prewarmed()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to encode routes")
+		return
+	}
 
-	h.logger.Debug("ListRoutes response",
-		"count", len(routes),
+	h.logger.DebugContext(r.Context(), "ListRoutes response",
+		"bytes", len(bodies.routes.plain),
 		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
-	respondJSON(w, http.StatusOK, RoutesResponse{
-		Routes:     routes,
-		Count:      len(routes),
-		ServerTime: time.Now(),
-	})
+	serveStaticBody(w, r, bodies.routes)
 }
 
 func (h *GTFSHandler) GetRoute(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	line := r.PathValue("line")
 
-	h.logger.Debug("GetRoute request",
+	h.logger.DebugContext(r.Context(), "GetRoute request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"line", line,
@@ -68,19 +144,23 @@ func (h *GTFSHandler) GetRoute(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if line == "" {
-		h.logger.Warn("GetRoute bad request", "error", "missing line parameter")
+		h.logger.WarnContext(r.Context(), "GetRoute bad request", "error", "missing line parameter")
 		respondError(w, http.StatusBadRequest, "missing line parameter")
 		return
 	}
 
 	route, ok := h.store.GetRouteByLine(line)
 	if !ok {
-		h.logger.Debug("GetRoute not found", "line", line)
-		respondError(w, http.StatusNotFound, "route not found")
+		h.logger.DebugContext(r.Context(), "GetRoute not found", "line", line)
+		respondLineNotFound(w, h.store, line, "route not found")
 		return
 	}
 
-	h.logger.Debug("GetRoute response",
+	if h.tagStatic(w, r, surrogateKeyLine(line)) {
+		return
+	}
+
+	h.logger.DebugContext(r.Context(), "GetRoute response",
 		"line", line,
 		"route_id", route.ID,
 		"duration_ms", time.Since(start).Milliseconds(),
@@ -99,7 +179,7 @@ func (h *GTFSHandler) GetRouteShape(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	line := r.PathValue("line")
 
-	h.logger.Debug("GetRouteShape request",
+	h.logger.DebugContext(r.Context(), "GetRouteShape request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"line", line,
@@ -107,43 +187,71 @@ func (h *GTFSHandler) GetRouteShape(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if line == "" {
-		h.logger.Warn("GetRouteShape bad request", "error", "missing line parameter")
+		h.logger.WarnContext(r.Context(), "GetRouteShape bad request", "error", "missing line parameter")
 		respondError(w, http.StatusBadRequest, "missing line parameter")
 		return
 	}
 
 	route, ok := h.store.GetRouteByLine(line)
 	if !ok {
-		h.logger.Debug("GetRouteShape route not found", "line", line)
-		respondError(w, http.StatusNotFound, "route not found")
+		h.logger.DebugContext(r.Context(), "GetRouteShape route not found", "line", line)
+		respondLineNotFound(w, h.store, line, "route not found")
 		return
 	}
 
-	timeParam := r.URL.Query().Get("time")
+	at, timeFiltered, err := parseAtParams(r)
+	if err != nil {
+		h.logger.WarnContext(r.Context(), "GetRouteShape bad time", "error", err)
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	elevation := r.URL.Query().Get("elevation") == "true"
+	if elevation && h.elevation == nil {
+		respondErrorCode(w, http.StatusNotFound, errCodeFeatureDisabled, "Shape elevation is disabled on this server")
+		return
+	}
 
 	var shapes []*domain.Shape
-	if timeParam != "" {
-		timeMinutes := parseTimeToMinutes(timeParam)
-		shapes = h.store.GetActiveRouteShapes(route.ID, time.Now(), timeMinutes)
-		h.logger.Debug("GetRouteShape filtered by time",
+	if timeFiltered {
+		h.tagResponse(w, surrogateKeyLine(line))
+		shapes = h.store.GetActiveRouteShapesAt(route.ID, at)
+		h.logger.DebugContext(r.Context(), "GetRouteShape filtered by time",
 			"line", line,
-			"time_param", timeParam,
-			"time_minutes", timeMinutes,
+			"at", at,
 		)
 	} else {
+		// Shapes keep their ETag across GTFS updates that don't touch them.
+		version := route.ShapesVersion
+		if version == "" {
+			version = h.datasetVersion()
+		}
+		version = "shapes-" + version
+		if elevation {
+			version += "-elevation"
+		}
+		if h.tagStaticVersion(w, r, version, surrogateKeyLine(line)) {
+			return
+		}
 		shapes = h.store.GetRouteShapes(route.ID)
 	}
+	if shapes == nil {
+		shapes = []*domain.Shape{}
+	}
+	if elevation {
+		shapes = h.withElevation(shapes)
+	}
 
 	totalPoints := 0
 	for _, s := range shapes {
 		totalPoints += len(s.Points)
 	}
 
-	h.logger.Debug("GetRouteShape response",
+	h.logger.DebugContext(r.Context(), "GetRouteShape response",
 		"line", line,
 		"shapes_count", len(shapes),
 		"total_points", totalPoints,
-		"time_filtered", timeParam != "",
+		"time_filtered", timeFiltered,
 		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
@@ -164,7 +272,7 @@ func (h *GTFSHandler) GetRouteStops(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	line := r.PathValue("line")
 
-	h.logger.Debug("GetRouteStops request",
+	h.logger.DebugContext(r.Context(), "GetRouteStops request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"line", line,
@@ -172,21 +280,28 @@ func (h *GTFSHandler) GetRouteStops(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if line == "" {
-		h.logger.Warn("GetRouteStops bad request", "error", "missing line parameter")
+		h.logger.WarnContext(r.Context(), "GetRouteStops bad request", "error", "missing line parameter")
 		respondError(w, http.StatusBadRequest, "missing line parameter")
 		return
 	}
 
 	route, ok := h.store.GetRouteByLine(line)
 	if !ok {
-		h.logger.Debug("GetRouteStops route not found", "line", line)
-		respondError(w, http.StatusNotFound, "route not found")
+		h.logger.DebugContext(r.Context(), "GetRouteStops route not found", "line", line)
+		respondLineNotFound(w, h.store, line, "route not found")
+		return
+	}
+
+	if h.tagStatic(w, r, surrogateKeyLine(line)) {
 		return
 	}
 
 	stops := h.store.GetRouteStops(route.ID)
+	if stops == nil {
+		stops = []*domain.Stop{}
+	}
 
-	h.logger.Debug("GetRouteStops response",
+	h.logger.DebugContext(r.Context(), "GetRouteStops response",
 		"line", line,
 		"stops_count", len(stops),
 		"duration_ms", time.Since(start).Milliseconds(),
@@ -199,6 +314,67 @@ func (h *GTFSHandler) GetRouteStops(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+type ActiveTripsResponse struct {
+	Line       string               `json:"line"`
+	At         time.Time            `json:"at"`
+	Trips      []*domain.ActiveTrip `json:"trips"`
+	Count      int                  `json:"count"`
+	ServerTime time.Time            `json:"server_time"`
+}
+
+// GetActiveTrips lists the line's trips scheduled to be en route at ?at=
+// (default now), with their end stops and how far through the run they are.
+// These are the trips the time-filtered shape endpoint draws from.
+func (h *GTFSHandler) GetActiveTrips(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	line := r.PathValue("line")
+
+	h.logger.DebugContext(r.Context(), "GetActiveTrips request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"line", line,
+		"remote_addr", r.RemoteAddr,
+	)
+
+	route, ok := h.store.GetRouteByLine(line)
+	if !ok {
+		h.logger.DebugContext(r.Context(), "GetActiveTrips route not found", "line", line)
+		respondLineNotFound(w, h.store, line, "route not found")
+		return
+	}
+
+	at, ok, err := parseAtParams(r)
+	if err != nil {
+		h.logger.WarnContext(r.Context(), "GetActiveTrips bad time", "error", err)
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ok {
+		at = time.Now()
+	}
+
+	h.tagResponse(w, surrogateKeyLine(line))
+	trips := h.store.GetActiveTripsAt(route.ID, at)
+	if trips == nil {
+		trips = []*domain.ActiveTrip{}
+	}
+
+	h.logger.DebugContext(r.Context(), "GetActiveTrips response",
+		"line", line,
+		"at", at,
+		"trips_count", len(trips),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+
+	respondJSON(w, http.StatusOK, ActiveTripsResponse{
+		Line:       line,
+		At:         at,
+		Trips:      trips,
+		Count:      len(trips),
+		ServerTime: time.Now(),
+	})
+}
+
 type StopsResponse struct {
 	Stops      []*domain.Stop `json:"stops"`
 	Count      int            `json:"count"`
@@ -207,31 +383,35 @@ type StopsResponse struct {
 
 func (h *GTFSHandler) ListStops(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	h.logger.Debug("ListStops request",
+	h.logger.DebugContext(r.Context(), "ListStops request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"remote_addr", r.RemoteAddr,
 	)
 
-	stops := h.store.GetAllStops()
+	if h.tagStatic(w, r, "stops") {
+		return
+	}
+
+	bodies, _, err := h.prewarmed()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to encode stops")
+		return
+	}
 
-	h.logger.Debug("ListStops response",
-		"count", len(stops),
+	h.logger.DebugContext(r.Context(), "ListStops response",
+		"bytes", len(bodies.stops.plain),
 		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
-	respondJSON(w, http.StatusOK, StopsResponse{
-		Stops:      stops,
-		Count:      len(stops),
-		ServerTime: time.Now(),
-	})
+	serveStaticBody(w, r, bodies.stops)
 }
 
 func (h *GTFSHandler) GetStop(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	id := r.PathValue("id")
 
-	h.logger.Debug("GetStop request",
+	h.logger.DebugContext(r.Context(), "GetStop request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"stop_id", id,
@@ -239,19 +419,23 @@ func (h *GTFSHandler) GetStop(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if id == "" {
-		h.logger.Warn("GetStop bad request", "error", "missing stop id")
+		h.logger.WarnContext(r.Context(), "GetStop bad request", "error", "missing stop id")
 		respondError(w, http.StatusBadRequest, "missing stop id")
 		return
 	}
 
 	stop, ok := h.store.GetStopByID(id)
 	if !ok {
-		h.logger.Debug("GetStop not found", "stop_id", id)
+		h.logger.DebugContext(r.Context(), "GetStop not found", "stop_id", id)
 		respondError(w, http.StatusNotFound, "stop not found")
 		return
 	}
 
-	h.logger.Debug("GetStop response",
+	if h.tagStatic(w, r, surrogateKeyStop(id)) {
+		return
+	}
+
+	h.logger.DebugContext(r.Context(), "GetStop response",
 		"stop_id", id,
 		"stop_name", stop.Name,
 		"duration_ms", time.Since(start).Milliseconds(),
@@ -262,7 +446,7 @@ func (h *GTFSHandler) GetStop(w http.ResponseWriter, r *http.Request) {
 
 func (h *GTFSHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	h.logger.Debug("GetStats request",
+	h.logger.DebugContext(r.Context(), "GetStats request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"remote_addr", r.RemoteAddr,
@@ -270,7 +454,7 @@ func (h *GTFSHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 
 	stats := h.store.GetStats()
 
-	h.logger.Debug("GetStats response",
+	h.logger.DebugContext(r.Context(), "GetStats response",
 		"routes_count", stats.RoutesCount,
 		"shapes_count", stats.ShapesCount,
 		"stops_count", stats.StopsCount,
@@ -282,105 +466,176 @@ func (h *GTFSHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 }
 
 type StopScheduleResponse struct {
-	StopTimes  []*domain.StopTime  `json:"stop_times"`
-	Count      int                 `json:"count"`
-	ServerTime time.Time           `json:"server_time"`
+	// StopTimes holds []*domain.StopTime, or the fields selected with
+	// ?fields= as objects or, with ?compact=true, as arrays.
+	StopTimes  interface{}        `json:"stop_times"`
+	Fields     []string           `json:"fields,omitempty"` // column order of compact stop times
+	Count      int                `json:"count"`
+	Dataset    *gtfs.ArchiveEntry `json:"dataset,omitempty"` // set for ?as_of= queries
+	ServerTime time.Time          `json:"server_time"`
 }
 
 func (h *GTFSHandler) GetStopSchedule(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	id := r.PathValue("id")
 	dateParam := r.URL.Query().Get("date")
+	atParam := r.URL.Query().Get("at")
+	asOfParam := r.URL.Query().Get("as_of")
 
-	h.logger.Debug("GetStopSchedule request",
+	h.logger.DebugContext(r.Context(), "GetStopSchedule request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"stop_id", id,
 		"date", dateParam,
+		"as_of", asOfParam,
 		"remote_addr", r.RemoteAddr,
 	)
 
 	if id == "" {
-		h.logger.Warn("GetStopSchedule bad request", "error", "missing stop id")
+		h.logger.WarnContext(r.Context(), "GetStopSchedule bad request", "error", "missing stop id")
 		respondError(w, http.StatusBadRequest, "missing stop id")
 		return
 	}
 
-	stop, ok := h.store.GetStopByID(id)
+	projection, err := parseStopTimeProjection(r, atParam != "")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// ?as_of= answers from the dataset that was in use that day, bypassing
+	// the cache. Without ?date= or ?at= it also selects that day.
+	src := h.store
+	var dataset *gtfs.ArchiveEntry
+	if asOfParam != "" {
+		if h.archive == nil {
+			respondErrorCode(w, http.StatusNotFound, errCodeFeatureDisabled, "GTFS archive is disabled on this server")
+			return
+		}
+		asOf, err := time.ParseInLocation("2006-01-02", asOfParam, gtfs.Location())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid as_of format, use YYYY-MM-DD")
+			return
+		}
+		archived, entry, err := h.archive.StoreAsOf(asOf)
+		if err != nil {
+			h.logger.DebugContext(r.Context(), "GetStopSchedule as_of unavailable", "as_of", asOfParam, "error", err)
+			respondError(w, http.StatusNotFound, "no archived GTFS dataset for as_of date")
+			return
+		}
+		src = archived
+		dataset = &entry
+		if dateParam == "" && atParam == "" {
+			dateParam = asOfParam
+		}
+	}
+
+	stop, ok := src.GetStopByID(id)
 	if !ok {
-		h.logger.Debug("GetStopSchedule stop not found", "stop_id", id)
+		h.logger.DebugContext(r.Context(), "GetStopSchedule stop not found", "stop_id", id)
 		respondError(w, http.StatusNotFound, "stop not found")
 		return
 	}
+	h.recordStopRequest(id)
 
 	var schedule []*domain.StopTime
-	cacheHit := false
+	cacheStatus := ""
 	ctx := r.Context()
 
-	if dateParam != "" {
+	if atParam != "" {
+		at, err := parseAt(atParam)
+		if err != nil {
+			h.logger.WarnContext(r.Context(), "GetStopSchedule bad at", "at", atParam, "error", err)
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.tagResponse(w, surrogateKeyStop(id))
+		schedule = src.GetStopScheduleAt(id, at)
+	} else if dateParam != "" {
+		h.tagResponse(w, surrogateKeyStop(id))
 		var filterDate time.Time
 		var err error
 
 		if dateParam == "today" {
-			filterDate = time.Now()
-			if h.tryGetFromCache(ctx, cache.KeyScheduleToday(id), &schedule) {
-				cacheHit = true
-				h.logger.Debug("GetStopSchedule cache hit", "stop_id", id, "key", "today")
-			}
+			filterDate = gtfs.ServiceDay(time.Now())
 		} else if dateParam == "tomorrow" {
-			filterDate = time.Now().AddDate(0, 0, 1)
-			if h.tryGetFromCache(ctx, cache.KeyScheduleTomorrow(id), &schedule) {
-				cacheHit = true
-				h.logger.Debug("GetStopSchedule cache hit", "stop_id", id, "key", "tomorrow")
-			}
+			filterDate = gtfs.ServiceDay(time.Now()).AddDate(0, 0, 1)
 		} else {
-			filterDate, err = time.Parse("2006-01-02", dateParam)
+			filterDate, err = time.ParseInLocation("2006-01-02", dateParam, gtfs.Location())
 			if err != nil {
-				h.logger.Warn("GetStopSchedule bad date format", "date", dateParam, "error", err)
+				h.logger.WarnContext(r.Context(), "GetStopSchedule bad date format", "date", dateParam, "error", err)
 				respondError(w, http.StatusBadRequest, "invalid date format, use YYYY-MM-DD, 'today', or 'tomorrow'")
 				return
 			}
 		}
 
-		if !cacheHit {
-			schedule = h.store.GetStopScheduleForDate(id, filterDate)
+		if dataset != nil || !h.cacheableDate(filterDate) {
+			schedule = src.GetStopScheduleForDate(id, filterDate)
+		} else {
+			cacheKey := cache.KeySchedule(id, filterDate)
+			cacheStatus = h.tryGetFromCache(ctx, cacheKey, &schedule, func() interface{} {
+				return h.store.GetStopScheduleForDate(id, filterDate)
+			})
+			if cacheStatus != cacheStatusHit && cacheStatus != cacheStatusStale {
+				schedule = h.store.GetStopScheduleForDate(id, filterDate)
+				if cacheStatus == cacheStatusMiss {
+					h.fillCache(cacheKey, schedule)
+				}
+			}
 		}
-		h.logger.Debug("GetStopSchedule filtered by date",
+		h.logger.DebugContext(r.Context(), "GetStopSchedule filtered by date",
 			"stop_id", id,
 			"date", filterDate.Format("2006-01-02"),
 			"weekday", filterDate.Weekday().String(),
-			"cache_hit", cacheHit,
+			"cache_status", cacheStatus,
 		)
 	} else {
+		if h.tagStatic(w, r, surrogateKeyStop(id)) {
+			return
+		}
 		schedule = h.store.GetStopSchedule(id)
 	}
 
-	h.logger.Debug("GetStopSchedule response",
+	setCacheHeader(w, cacheStatus)
+	if schedule == nil {
+		schedule = []*domain.StopTime{}
+	}
+
+	h.logger.DebugContext(r.Context(), "GetStopSchedule response",
 		"stop_id", id,
 		"stop_name", stop.Name,
 		"schedule_count", len(schedule),
 		"filtered_by_date", dateParam != "",
+		"at", atParam,
 		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
+	var fields []string
+	if projection.compact {
+		fields = projection.fields
+	}
 	respondJSON(w, http.StatusOK, StopScheduleResponse{
-		StopTimes:  schedule,
+		StopTimes:  projection.apply(schedule),
+		Fields:     fields,
 		Count:      len(schedule),
+		Dataset:    dataset,
 		ServerTime: time.Now(),
 	})
 }
 
 type StopLinesResponse struct {
-	Lines      []*domain.StopLine  `json:"lines"`
-	Count      int                 `json:"count"`
-	ServerTime time.Time           `json:"server_time"`
+	Lines      []*domain.StopLine `json:"lines"`
+	Count      int                `json:"count"`
+	ServerTime time.Time          `json:"server_time"`
 }
 
+// GetStopLines lists the lines serving a stop. ?remaining=true adds how many
+// departures each has left today, which makes the response uncacheable.
 func (h *GTFSHandler) GetStopLines(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	id := r.PathValue("id")
 
-	h.logger.Debug("GetStopLines request",
+	h.logger.DebugContext(r.Context(), "GetStopLines request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"stop_id", id,
@@ -388,27 +643,48 @@ func (h *GTFSHandler) GetStopLines(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if id == "" {
-		h.logger.Warn("GetStopLines bad request", "error", "missing stop id")
+		h.logger.WarnContext(r.Context(), "GetStopLines bad request", "error", "missing stop id")
 		respondError(w, http.StatusBadRequest, "missing stop id")
 		return
 	}
 
 	stop, ok := h.store.GetStopByID(id)
 	if !ok {
-		h.logger.Debug("GetStopLines stop not found", "stop_id", id)
+		h.logger.DebugContext(r.Context(), "GetStopLines stop not found", "stop_id", id)
 		respondError(w, http.StatusNotFound, "stop not found")
 		return
 	}
+	h.recordStopRequest(id)
+
+	remaining := r.URL.Query().Get("remaining") == "true"
+	if remaining {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else if h.tagStatic(w, r, surrogateKeyStop(id)) {
+		return
+	}
 
 	var lines []*domain.StopLine
-	cacheHit := false
 	ctx := r.Context()
 
-	if h.tryGetFromCache(ctx, cache.KeyStopLines(id), &lines) {
-		cacheHit = true
-		h.logger.Debug("GetStopLines cache hit", "stop_id", id)
-	} else {
+	cacheStatus := h.tryGetFromCache(ctx, cache.KeyStopLines(id), &lines, func() interface{} {
+		return h.store.GetStopLines(id)
+	})
+	if cacheStatus != cacheStatusHit && cacheStatus != cacheStatusStale {
 		lines = h.store.GetStopLines(id)
+		if cacheStatus == cacheStatusMiss {
+			h.fillCache(cache.KeyStopLines(id), lines)
+		}
+	}
+	setCacheHeader(w, cacheStatus)
+	if lines == nil {
+		lines = []*domain.StopLine{}
+	}
+	if remaining {
+		counts := h.store.CountRemainingDepartures(id, time.Now())
+		for _, l := range lines {
+			n := counts[l.RouteID]
+			l.RemainingToday = &n
+		}
 	}
 
 	lineNames := make([]string, len(lines))
@@ -416,12 +692,12 @@ func (h *GTFSHandler) GetStopLines(w http.ResponseWriter, r *http.Request) {
 		lineNames[i] = l.Line
 	}
 
-	h.logger.Debug("GetStopLines response",
+	h.logger.DebugContext(r.Context(), "GetStopLines response",
 		"stop_id", id,
 		"stop_name", stop.Name,
 		"lines_count", len(lines),
 		"lines", lineNames,
-		"cache_hit", cacheHit,
+		"cache_status", cacheStatus,
 		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
@@ -437,13 +713,14 @@ type SyncResponse struct {
 	Stops         []*domain.Stop         `json:"stops"`
 	Calendars     []*domain.Calendar     `json:"calendars"`
 	CalendarDates []*domain.CalendarDate `json:"calendar_dates"`
+	POIs          []*domain.POI          `json:"pois,omitempty"`
 	Version       string                 `json:"version"`
 	GeneratedAt   time.Time              `json:"generated_at"`
 }
 
 func (h *GTFSHandler) GetSync(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	h.logger.Debug("GetSync request",
+	h.logger.DebugContext(r.Context(), "GetSync request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"remote_addr", r.RemoteAddr,
@@ -451,31 +728,25 @@ func (h *GTFSHandler) GetSync(w http.ResponseWriter, r *http.Request) {
 
 	stats := h.store.GetStats()
 
-	// Return 503 if GTFS data is not loaded yet
-	if !stats.IsLoaded {
-		h.logger.Warn("GetSync called but GTFS data not loaded yet")
-		w.Header().Set("Retry-After", "30")
-		respondError(w, http.StatusServiceUnavailable, "GTFS data is loading, please retry")
-		return
+	// The POIs only change on restart, but may then change without the
+	// dataset, so they are part of the ETag.
+	version := h.datasetVersion()
+	if h.pois != nil {
+		version += "-" + h.pois.Version()
 	}
-	etag := fmt.Sprintf(`"%x"`, stats.LastUpdate.Unix())
-
-	if r.Header.Get("If-None-Match") == etag {
-		h.logger.Debug("GetSync not modified (ETag match)")
-		w.WriteHeader(http.StatusNotModified)
+	if h.tagStaticVersion(w, r, version, "sync") {
+		h.logger.DebugContext(r.Context(), "GetSync not modified (ETag match)")
 		return
 	}
 
-	w.Header().Set("ETag", etag)
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-
 	ctx := r.Context()
 
 	if h.cache != nil {
 		var syncData SyncResponse
 		found, err := h.cache.GetJSONCompressed(ctx, cache.KeySyncFull, &syncData)
 		if err == nil && found {
-			h.logger.Debug("GetSync cache hit", "duration_ms", time.Since(start).Milliseconds())
+			h.logger.DebugContext(r.Context(), "GetSync cache hit", "duration_ms", time.Since(start).Milliseconds())
+			syncData.POIs = h.syncPOIs()
 			respondJSON(w, http.StatusOK, syncData)
 			return
 		}
@@ -488,11 +759,12 @@ func (h *GTFSHandler) GetSync(w http.ResponseWriter, r *http.Request) {
 		Stops:         h.store.GetAllStops(),
 		Calendars:     calendars,
 		CalendarDates: calendarDates,
+		POIs:          h.syncPOIs(),
 		Version:       stats.LastUpdate.Format("2006-01-02"),
 		GeneratedAt:   time.Now(),
 	}
 
-	h.logger.Debug("GetSync response",
+	h.logger.DebugContext(r.Context(), "GetSync response",
 		"routes", len(syncData.Routes),
 		"stops", len(syncData.Stops),
 		"calendars", len(syncData.Calendars),
@@ -503,6 +775,14 @@ func (h *GTFSHandler) GetSync(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, syncData)
 }
 
+// syncPOIs returns every point of interest, or nil without the layer.
+func (h *GTFSHandler) syncPOIs() []*domain.POI {
+	if h.pois == nil {
+		return nil
+	}
+	return h.pois.POIs(store.POIFilter{})
+}
+
 type SyncCheckResponse struct {
 	Version    string    `json:"version"`
 	HasUpdates bool      `json:"has_updates"`
@@ -513,7 +793,7 @@ func (h *GTFSHandler) CheckSync(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	sinceParam := r.URL.Query().Get("since")
 
-	h.logger.Debug("CheckSync request",
+	h.logger.DebugContext(r.Context(), "CheckSync request",
 		"method", r.Method,
 		"path", r.URL.Path,
 		"since", sinceParam,
@@ -522,14 +802,6 @@ func (h *GTFSHandler) CheckSync(w http.ResponseWriter, r *http.Request) {
 
 	stats := h.store.GetStats()
 
-	// Return 503 if GTFS data is not loaded yet
-	if !stats.IsLoaded {
-		h.logger.Warn("CheckSync called but GTFS data not loaded yet")
-		w.Header().Set("Retry-After", "30")
-		respondError(w, http.StatusServiceUnavailable, "GTFS data is loading, please retry")
-		return
-	}
-
 	version := stats.LastUpdate.Format("2006-01-02")
 
 	hasUpdates := true
@@ -540,7 +812,7 @@ func (h *GTFSHandler) CheckSync(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	h.logger.Debug("CheckSync response",
+	h.logger.DebugContext(r.Context(), "CheckSync response",
 		"version", version,
 		"has_updates", hasUpdates,
 		"duration_ms", time.Since(start).Milliseconds(),
@@ -553,20 +825,125 @@ func (h *GTFSHandler) CheckSync(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *GTFSHandler) tryGetFromCache(ctx context.Context, key string, dest interface{}) bool {
+// tryGetFromCache loads key into dest and reports the cache status, or ""
+// when no cache is configured. A stale entry is still decoded into dest and
+// served, while refresh recomputes it in the background (stale-while-revalidate).
+func (h *GTFSHandler) tryGetFromCache(ctx context.Context, key string, dest interface{}, refresh func() interface{}) string {
 	if h.cache == nil {
-		return false
+		return ""
+	}
+	found, stale, err := h.cache.GetJSONSWR(ctx, key, dest)
+	if err != nil || !found {
+		return cacheStatusMiss
+	}
+	if stale {
+		ServerStats.IncCacheStale()
+		h.revalidate(key, refresh)
+		return cacheStatusStale
 	}
-	found, err := h.cache.GetJSON(ctx, key, dest)
-	return err == nil && found
+	return cacheStatusHit
 }
 
-// parseTimeToMinutes parses "HH:MM" or "now" to minutes since midnight.
-func parseTimeToMinutes(s string) int {
+// revalidate recomputes a stale cache entry in the background, at most once
+// per key at a time.
+func (h *GTFSHandler) revalidate(key string, refresh func() interface{}) {
+	if _, inFlight := h.refreshing.LoadOrStore(key, struct{}{}); inFlight {
+		return
+	}
+	go func() {
+		defer h.refreshing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := h.cache.SetJSONSWR(ctx, key, refresh(), h.cacheTTL, h.staleTTL); err != nil {
+			h.logger.Debug("cache revalidation failed", "key", key, "error", err)
+			return
+		}
+		h.logger.Debug("cache revalidated", "key", key)
+	}()
+}
+
+// cacheableDate reports whether schedules for day are worth caching: today,
+// tomorrow and dates the dataset schedules service around. Any other date a
+// client asks for is computed each time, so crawling arbitrary dates can't
+// fill Redis with entries nobody reads again.
+func (h *GTFSHandler) cacheableDate(day time.Time) bool {
+	today := gtfs.ServiceDay(time.Now())
+	if day.Equal(today) || day.Equal(today.AddDate(0, 0, 1)) {
+		return true
+	}
+	return h.store.InServiceRange(day)
+}
+
+// fillCache stores a value computed after a cache miss without delaying the response.
+func (h *GTFSHandler) fillCache(key string, value interface{}) {
+	if h.cache == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := h.cache.SetJSONSWR(ctx, key, value, h.cacheTTL, h.staleTTL); err != nil {
+			h.logger.Debug("cache fill failed", "key", key, "error", err)
+		}
+	}()
+}
+
+func setCacheHeader(w http.ResponseWriter, status string) {
+	if status != "" {
+		w.Header().Set("X-Cache", status)
+	}
+}
+
+func (h *GTFSHandler) recordStopRequest(stopID string) {
+	if h.popularity != nil {
+		h.popularity.Record(stopID)
+	}
+}
+
+// atLayouts are the accepted formats of the ?at= parameter. Values without a
+// zone are interpreted in the feed timezone.
+var atLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+}
+
+// parseAt parses an ?at= value: "now", RFC 3339, or a local date-time.
+func parseAt(s string) (time.Time, error) {
 	if s == "now" {
-		now := time.Now()
-		return now.Hour()*60 + now.Minute()
+		return time.Now(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
 	}
+	for _, layout := range atLayouts {
+		if t, err := time.ParseInLocation(layout, s, gtfs.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid at %q, use YYYY-MM-DDTHH:MM, RFC 3339, or 'now'", s)
+}
+
+// parseAtParams resolves the instant a time-filtered query refers to. ?at= is
+// preferred; the legacy ?time=HH:MM (or "now") means that clock time today in
+// the feed timezone. ok is false when neither parameter is set.
+func parseAtParams(r *http.Request) (at time.Time, ok bool, err error) {
+	if v := r.URL.Query().Get("at"); v != "" {
+		at, err = parseAt(v)
+		return at, err == nil, err
+	}
+	if v := r.URL.Query().Get("time"); v != "" {
+		if v == "now" {
+			return time.Now(), true, nil
+		}
+		minutes := parseTimeToMinutes(v)
+		today := gtfs.ServiceDay(time.Now())
+		return time.Date(today.Year(), today.Month(), today.Day(), 0, minutes, 0, 0, gtfs.Location()), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// parseTimeToMinutes parses "HH:MM" to minutes since midnight.
+func parseTimeToMinutes(s string) int {
 	parts := strings.Split(s, ":")
 	if len(parts) < 2 {
 		return 0
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/prediction"
+	"wabus/internal/store"
+	"wabus/pkg/gtfsrt"
+)
+
+// GTFSRTHandler serves GTFS Realtime feeds built from live vehicles.
+type GTFSRTHandler struct {
+	redaction
+	predictor *prediction.Predictor
+	alerts    *store.AlertStore
+	gtfs      *store.GTFSStore
+}
+
+func NewGTFSRTHandler(predictor *prediction.Predictor) *GTFSRTHandler {
+	return &GTFSRTHandler{predictor: predictor}
+}
+
+// SetAlerts enables the ServiceAlerts feed, resolving the lines of alerts
+// to routes of gtfsStore.
+func (h *GTFSRTHandler) SetAlerts(alerts *store.AlertStore, gtfsStore *store.GTFSStore) {
+	h.alerts = alerts
+	h.gtfs = gtfsStore
+}
+
+// GetTripUpdates returns predicted arrivals and departures of every trip a
+// live vehicle is matched to, as a GTFS-RT TripUpdates protobuf feed.
+// ?format=json returns the same feed as JSON for debugging.
+func (h *GTFSRTHandler) GetTripUpdates(w http.ResponseWriter, r *http.Request) {
+	feed := h.predictor.TripUpdates()
+	if h.redacts(r) {
+		for _, e := range feed.Entities {
+			if v := e.TripUpdate.Vehicle; v != nil {
+				v.ID, v.Label = h.redactor.ID(v.ID), ""
+			}
+		}
+	}
+
+	respondFeed(w, r, feed)
+}
+
+// GetServiceAlerts returns the alerts not yet over, manual and ingested
+// alike, as a GTFS-RT ServiceAlerts protobuf feed. Lines are given by
+// their GTFS route; alerts naming only lines missing from the schedule are
+// left out. ?format=json returns the same feed as JSON for debugging.
+func (h *GTFSRTHandler) GetServiceAlerts(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	feed := &gtfsrt.FeedMessage{Timestamp: now, Entities: []gtfsrt.FeedEntity{}}
+	for _, a := range h.alerts.List(false, now) {
+		if a.ActiveUntil != nil && !now.Before(*a.ActiveUntil) {
+			continue
+		}
+		if alert, ok := h.serviceAlert(a); ok {
+			feed.Entities = append(feed.Entities, gtfsrt.FeedEntity{ID: a.ID, Alert: alert})
+		}
+	}
+	respondFeed(w, r, feed)
+}
+
+// networkRouteTypes are the route types an alert scoped to no line or stop
+// informs, i.e. the whole network.
+var networkRouteTypes = []int{int(domain.RouteTypeBus), int(domain.RouteTypeTram)}
+
+func (h *GTFSRTHandler) serviceAlert(a *domain.Alert) (*gtfsrt.Alert, bool) {
+	alert := &gtfsrt.Alert{
+		URL:             a.URL,
+		HeaderText:      a.Title,
+		DescriptionText: a.Description,
+		SeverityLevel:   alertSeverityLevel(a.Severity),
+	}
+	if a.ActiveFrom != nil || a.ActiveUntil != nil {
+		alert.ActivePeriods = []gtfsrt.TimeRange{{Start: a.ActiveFrom, End: a.ActiveUntil}}
+	}
+
+	if len(a.Lines) == 0 && len(a.StopIDs) == 0 {
+		for _, t := range networkRouteTypes {
+			alert.InformedEntities = append(alert.InformedEntities, gtfsrt.EntitySelector{RouteType: &t})
+		}
+		return alert, true
+	}
+	for _, line := range a.Lines {
+		if route, ok := h.gtfs.GetRouteByLine(line); ok {
+			alert.InformedEntities = append(alert.InformedEntities, gtfsrt.EntitySelector{RouteID: route.ID})
+		}
+	}
+	for _, stopID := range a.StopIDs {
+		alert.InformedEntities = append(alert.InformedEntities, gtfsrt.EntitySelector{StopID: stopID})
+	}
+	return alert, len(alert.InformedEntities) > 0
+}
+
+func alertSeverityLevel(s domain.AlertSeverity) gtfsrt.SeverityLevel {
+	switch s {
+	case domain.AlertSeverityInfo:
+		return gtfsrt.SeverityInfo
+	case domain.AlertSeverityWarning:
+		return gtfsrt.SeverityWarning
+	case domain.AlertSeveritySevere:
+		return gtfsrt.SeveritySevere
+	}
+	return gtfsrt.SeverityUnknown
+}
+
+// respondFeed writes feed as protobuf, or as JSON with ?format=json.
+func respondFeed(w http.ResponseWriter, r *http.Request, feed *gtfsrt.FeedMessage) {
+	switch r.URL.Query().Get("format") {
+	case "", "pb", "protobuf":
+		w.Header().Set("Content-Type", gtfsrt.ContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		w.Write(feed.Marshal())
+	case "json":
+		respondJSON(w, http.StatusOK, feed)
+	default:
+		respondError(w, http.StatusBadRequest, "invalid format parameter, use protobuf or json")
+	}
+}
@@ -1,23 +1,81 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
 
+	"wabus/internal/cache"
+	"wabus/internal/hub"
 	"wabus/internal/ingestor"
 	"wabus/internal/store"
+	"wabus/pkg/warsawapi"
+)
+
+// Component states of /healthz/details.
+const (
+	HealthPass     = "pass"
+	HealthFail     = "fail"
+	HealthDisabled = "disabled"
+)
+
+const (
+	// redisPingTimeout bounds the Redis ping of /healthz/details.
+	redisPingTimeout = 2 * time.Second
+	// hubStallAfter is how long the hub may miss its heartbeat before it
+	// counts as stuck.
+	hubStallAfter = 5 * time.Second
 )
 
 type HealthHandler struct {
 	ingestor *ingestor.Ingestor
 	store    *store.Store
+	cache    *cache.RedisCache
+	upstream *warsawapi.Client
+
+	upstreamStale time.Duration
+	gtfs          *store.GTFSStore
+	gtfsStale     time.Duration
+	hub           *hub.Hub
+
+	gtfsIngestor   *ingestor.GTFSIngestor
+	gtfsReadyUntil time.Time // zero waits indefinitely
 }
 
-func NewHealthHandler(ing *ingestor.Ingestor, s *store.Store) *HealthHandler {
+func NewHealthHandler(ing *ingestor.Ingestor, s *store.Store, redisCache *cache.RedisCache, upstream *warsawapi.Client) *HealthHandler {
 	return &HealthHandler{
 		ingestor: ing,
 		store:    s,
+		cache:    redisCache,
+		upstream: upstream,
+	}
+}
+
+// SetUpstreamStaleness fails the upstream component of /healthz/details when
+// the last successful poll is older than d.
+func (h *HealthHandler) SetUpstreamStaleness(d time.Duration) {
+	h.upstreamStale = d
+}
+
+// SetGTFS adds the GTFS dataset to /healthz/details, failing when it isn't
+// loaded or is older than staleness.
+func (h *HealthHandler) SetGTFS(gtfsStore *store.GTFSStore, staleness time.Duration) {
+	h.gtfs = gtfsStore
+	h.gtfsStale = staleness
+}
+
+// SetHub adds the liveness of the hub's run loop to /healthz/details.
+func (h *HealthHandler) SetHub(hb *hub.Hub) {
+	h.hub = hb
+}
+
+// SetGTFSReadiness keeps /readyz unready until ing has loaded a dataset or
+// grace has passed from now; a zero grace waits indefinitely.
+func (h *HealthHandler) SetGTFSReadiness(ing *ingestor.GTFSIngestor, grace time.Duration) {
+	h.gtfsIngestor = ing
+	if grace > 0 {
+		h.gtfsReadyUntil = time.Now().Add(grace)
 	}
 }
 
@@ -28,23 +86,231 @@ func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
 }
 
 type ReadyResponse struct {
-	Ready        bool      `json:"ready"`
-	VehicleCount int       `json:"vehicleCount"`
-	ServerTime   time.Time `json:"serverTime"`
+	Ready        bool              `json:"ready"`
+	VehicleCount int               `json:"vehicleCount"`
+	Cache        string            `json:"cache"`
+	GTFS         string            `json:"gtfs,omitempty"`
+	Upstream     *warsawapi.Health `json:"upstream,omitempty"`
+	ServerTime   time.Time         `json:"serverTime"`
 }
 
 func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
-	ready := h.ingestor.IsReady()
+	gtfs := h.gtfsReadiness(time.Now())
+	ready := h.ingestor.IsReady() && gtfs != gtfsLoading
 	status := http.StatusOK
 	if !ready {
 		status = http.StatusServiceUnavailable
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(ReadyResponse{
+	resp := ReadyResponse{
 		Ready:        ready,
 		VehicleCount: h.store.Count(),
+		Cache:        h.cacheState(),
+		GTFS:         gtfs,
 		ServerTime:   time.Now(),
+	}
+	// ?deep=true adds upstream details for monitoring.
+	if r.URL.Query().Get("deep") == "true" {
+		upstream := h.upstream.Health()
+		resp.Upstream = &upstream
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GTFS readiness states of /readyz.
+const (
+	gtfsLoaded  = "loaded"
+	gtfsLoading = "loading"
+	// gtfsGraceExpired is ready without a dataset, after the grace period.
+	gtfsGraceExpired = "grace_expired"
+)
+
+// gtfsReadiness is "" when readiness doesn't wait for GTFS.
+func (h *HealthHandler) gtfsReadiness(now time.Time) string {
+	switch {
+	case h.gtfsIngestor == nil:
+		return ""
+	case h.gtfsIngestor.IsReady():
+		return gtfsLoaded
+	case !h.gtfsReadyUntil.IsZero() && now.After(h.gtfsReadyUntil):
+		return gtfsGraceExpired
+	default:
+		return gtfsLoading
+	}
+}
+
+type UpstreamHealthResponse struct {
+	Healthy    bool             `json:"healthy"`
+	Upstream   warsawapi.Health `json:"upstream"`
+	ServerTime time.Time        `json:"serverTime"`
+}
+
+// Upstream reports the health of the Warsaw API feed. It answers 503 while the
+// circuit breaker is open, so monitoring can tell an upstream outage apart
+// from a problem in this server.
+func (h *HealthHandler) Upstream(w http.ResponseWriter, r *http.Request) {
+	health := h.upstream.Health()
+	healthy := health.BreakerState != warsawapi.BreakerOpen
+
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(UpstreamHealthResponse{
+		Healthy:    healthy,
+		Upstream:   health,
+		ServerTime: time.Now(),
 	})
 }
+
+// cacheState reports "disabled", "up" or "down". A down cache degrades
+// latency but does not make the server unready.
+func (h *HealthHandler) cacheState() string {
+	if h.cache == nil {
+		return "disabled"
+	}
+	if h.cache.Available() {
+		return "up"
+	}
+	return "down"
+}
+
+// ComponentHealth is the state of one subsystem in /healthz/details.
+type ComponentHealth struct {
+	// Status is pass, fail or disabled.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// LastSuccess is the last successful upstream poll or GTFS update, or
+	// the hub's last heartbeat.
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	AgeSeconds  *int       `json:"ageSeconds,omitempty"`
+	LatencyMS   *float64   `json:"latencyMs,omitempty"`
+}
+
+type HealthDetailsResponse struct {
+	// Status is fail when any component fails, pass otherwise.
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+	ServerTime time.Time                  `json:"serverTime"`
+}
+
+// Details reports each subsystem with pass or fail: the upstream API, the
+// GTFS dataset, Redis and the hub. It answers 503 when any fails, so a
+// monitor can alert on it and an operator can see which one it is.
+func (h *HealthHandler) Details(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	resp := HealthDetailsResponse{
+		Status: HealthPass,
+		Components: map[string]ComponentHealth{
+			"upstream": h.upstreamComponent(now),
+			"gtfs":     h.gtfsComponent(now),
+			"redis":    h.redisComponent(r.Context()),
+			"hub":      h.hubComponent(now),
+		},
+		ServerTime: now,
+	}
+	status := http.StatusOK
+	for _, c := range resp.Components {
+		if c.Status == HealthFail {
+			resp.Status = HealthFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// upstreamComponent fails while the breaker is open or, on the instance
+// polling upstream, when the last successful poll is too old. Followers get
+// positions from the leader and don't poll.
+func (h *HealthHandler) upstreamComponent(now time.Time) ComponentHealth {
+	health := h.upstream.Health()
+	c := ComponentHealth{Status: HealthPass, Error: health.LastError}
+	if !health.LastSuccess.IsZero() {
+		c.LastSuccess, c.AgeSeconds = since(health.LastSuccess, now)
+	}
+	switch {
+	case health.BreakerState == warsawapi.BreakerOpen:
+		c.Status = HealthFail
+	case !h.ingestor.IsLeader():
+	case health.LastSuccess.IsZero():
+		c.Status = HealthFail
+		if c.Error == "" {
+			c.Error = "no successful poll yet"
+		}
+	case h.upstreamStale > 0 && now.Sub(health.LastSuccess) > h.upstreamStale:
+		c.Status = HealthFail
+		if c.Error == "" {
+			c.Error = "last successful poll is stale"
+		}
+	}
+	return c
+}
+
+func (h *HealthHandler) gtfsComponent(now time.Time) ComponentHealth {
+	if h.gtfs == nil {
+		return ComponentHealth{Status: HealthDisabled}
+	}
+	stats := h.gtfs.GetStats()
+	if !stats.IsLoaded {
+		return ComponentHealth{Status: HealthFail, Error: "dataset not loaded"}
+	}
+	c := ComponentHealth{Status: HealthPass}
+	c.LastSuccess, c.AgeSeconds = since(stats.LastUpdate, now)
+	if h.gtfsStale > 0 && now.Sub(stats.LastUpdate) > h.gtfsStale {
+		c.Status = HealthFail
+		c.Error = "dataset is stale"
+	}
+	return c
+}
+
+// redisComponent pings Redis now rather than reporting the monitor's last
+// check, which may be a while old.
+func (h *HealthHandler) redisComponent(ctx context.Context) ComponentHealth {
+	if h.cache == nil {
+		return ComponentHealth{Status: HealthDisabled}
+	}
+	ctx, cancel := context.WithTimeout(ctx, redisPingTimeout)
+	defer cancel()
+	latency, err := h.cache.Ping(ctx)
+	ms := float64(latency.Microseconds()) / 1000
+	c := ComponentHealth{Status: HealthPass, LatencyMS: &ms}
+	if err != nil {
+		c.Status = HealthFail
+		c.Error = err.Error()
+	}
+	return c
+}
+
+func (h *HealthHandler) hubComponent(now time.Time) ComponentHealth {
+	if h.hub == nil {
+		return ComponentHealth{Status: HealthDisabled}
+	}
+	beat := h.hub.LastHeartbeat()
+	if beat.IsZero() {
+		return ComponentHealth{Status: HealthFail, Error: "hub not running"}
+	}
+	c := ComponentHealth{Status: HealthPass}
+	c.LastSuccess, c.AgeSeconds = since(beat, now)
+	if now.Sub(beat) > hubStallAfter {
+		c.Status = HealthFail
+		c.Error = "hub loop stalled"
+	}
+	return c
+}
+
+// since returns t and its age in whole seconds at now, for the optional
+// fields of ComponentHealth.
+func since(t, now time.Time) (*time.Time, *int) {
+	age := int(now.Sub(t).Seconds())
+	return &t, &age
+}
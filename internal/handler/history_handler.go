@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"wabus/internal/history"
+	"wabus/pkg/gtfs"
+)
+
+// defaultHistoryWindow is the window of a history query without ?from=.
+const defaultHistoryWindow = time.Hour
+
+// HistoryHandler serves recorded vehicle positions.
+type HistoryHandler struct {
+	redaction
+	store    *history.Store
+	maxRange time.Duration
+}
+
+func NewHistoryHandler(store *history.Store, maxRange time.Duration) *HistoryHandler {
+	return &HistoryHandler{store: store, maxRange: maxRange}
+}
+
+type VehicleHistoryResponse struct {
+	Key        string             `json:"key"`
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"`
+	Positions  []history.Position `json:"positions"`
+	Count      int                `json:"count"`
+	ServerTime time.Time          `json:"serverTime"`
+}
+
+// GetVehicleHistory returns the positions recorded for a vehicle in the
+// window given by ?from=, ?to= and ?date= (see parseWindow), oldest first.
+func (h *HistoryHandler) GetVehicleHistory(w http.ResponseWriter, r *http.Request) {
+	ref := r.PathValue("key")
+	if ref == "" {
+		respondError(w, http.StatusBadRequest, "missing vehicle key")
+		return
+	}
+	key, ok := h.vehicleKey(r, ref, nil)
+	if !ok {
+		respondError(w, http.StatusNotFound, "vehicle not found")
+		return
+	}
+
+	from, to, ok := h.parseWindow(w, r)
+	if !ok {
+		return
+	}
+
+	positions, err := h.store.Query(key, from, to)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to read history")
+		return
+	}
+	if positions == nil {
+		positions = []history.Position{}
+	}
+	if h.redacts(r) {
+		for i := range positions {
+			positions[i].Brigade = ""
+		}
+	}
+
+	respondJSON(w, http.StatusOK, VehicleHistoryResponse{
+		Key:        ref,
+		From:       from,
+		To:         to,
+		Positions:  positions,
+		Count:      len(positions),
+		ServerTime: time.Now(),
+	})
+}
+
+// playbackFlushEvery is how many positions are written between flushes of
+// a playback stream.
+const playbackFlushEvery = 1000
+
+// GetLinePlayback streams the positions recorded for every vehicle of a line
+// in the window given by ?from=, ?to= and ?date= (see parseWindow) as
+// newline-delimited JSON, oldest first, for replaying movements.
+func (h *HistoryHandler) GetLinePlayback(w http.ResponseWriter, r *http.Request) {
+	line := r.PathValue("line")
+	from, to, ok := h.parseWindow(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := h.store.QueryLine(line, from, to)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to read history")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	redacts := h.redacts(r)
+	for i, e := range entries {
+		if redacts {
+			e.Key, e.Brigade = h.redactor.ID(e.Key), ""
+		}
+		if err := enc.Encode(e); err != nil {
+			return
+		}
+		if (i+1)%playbackFlushEvery == 0 {
+			rc.Flush()
+		}
+	}
+}
+
+// parseWindow resolves the time window of a history query, answering the
+// request itself when it is invalid. With ?date=YYYY-MM-DD, ?from= and ?to=
+// are HH:MM on that day (default the whole day); without it they take the
+// formats of ?at= on GTFS endpoints (default the last hour, up to now).
+func (h *HistoryHandler) parseWindow(w http.ResponseWriter, r *http.Request) (from, to time.Time, ok bool) {
+	q := r.URL.Query()
+	date := q.Get("date")
+	parse := parseAt
+	if date != "" {
+		day, err := time.ParseInLocation("2006-01-02", date, gtfs.Location())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid date parameter, use YYYY-MM-DD")
+			return from, to, false
+		}
+		from, to = day, day.AddDate(0, 0, 1)
+		parse = func(v string) (time.Time, error) {
+			return time.ParseInLocation("2006-01-02 15:04", date+" "+v, gtfs.Location())
+		}
+	} else {
+		to = time.Now()
+		from = to.Add(-defaultHistoryWindow)
+	}
+
+	if v := q.Get("from"); v != "" {
+		t, err := parse(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid from parameter")
+			return from, to, false
+		}
+		from = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := parse(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid to parameter")
+			return from, to, false
+		}
+		to = t
+	}
+	if !from.Before(to) {
+		respondError(w, http.StatusBadRequest, "from must be before to")
+		return from, to, false
+	}
+	if h.maxRange > 0 && to.Sub(from) > h.maxRange {
+		respondError(w, http.StatusBadRequest, "range exceeds "+h.maxRange.String())
+		return from, to, false
+	}
+	return from, to, true
+}
@@ -2,21 +2,34 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"wabus/internal/domain"
+	"wabus/internal/hub"
 	"wabus/internal/store"
 )
 
 type HTTPHandler struct {
-	store *store.Store
+	redaction
+	store      *store.Store
+	zoom       int
+	legacyZoom *hub.LegacyZoom
 }
 
-func NewHTTPHandler(store *store.Store) *HTTPHandler {
-	return &HTTPHandler{store: store}
+// NewHTTPHandler creates the vehicle REST handler; zoom is the tile zoom
+// level vehicles are indexed at.
+func NewHTTPHandler(store *store.Store, zoom int) *HTTPHandler {
+	return &HTTPHandler{store: store, zoom: zoom}
+}
+
+// SetLegacyZoom accepts tiles at a previously configured zoom on
+// /v1/tiles while legacy is active, even below the usual minimum zoom.
+func (h *HTTPHandler) SetLegacyZoom(legacy *hub.LegacyZoom) {
+	h.legacyZoom = legacy
 }
 
 type VehiclesResponse struct {
@@ -54,7 +67,27 @@ func (h *HTTPHandler) ListVehicles(w http.ResponseWriter, r *http.Request) {
 		opts.BBox = bbox
 	}
 
+	if v := r.URL.Query().Get("min_delay"); v != "" {
+		minDelay, err := strconv.Atoi(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid min_delay parameter: must be seconds")
+			return
+		}
+		opts.MinDelay = &minDelay
+	}
+
+	opts.LeadsOnly = r.URL.Query().Get("grouped") == "true"
+
+	precision, ok := parsePrecision(w, r)
+	if !ok {
+		return
+	}
+
 	vehicles := h.store.List(opts)
+	if h.redacts(r) {
+		vehicles = h.redactor.Vehicles(vehicles)
+	}
+	vehicles = roundVehicles(vehicles, precision)
 
 	respondJSON(w, http.StatusOK, VehiclesResponse{
 		Vehicles:   vehicles,
@@ -64,21 +97,139 @@ func (h *HTTPHandler) ListVehicles(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) GetVehicle(w http.ResponseWriter, r *http.Request) {
-	key := r.PathValue("key")
-	if key == "" {
+	ref := r.PathValue("key")
+	if ref == "" {
 		respondError(w, http.StatusBadRequest, "missing vehicle key")
 		return
 	}
 
-	vehicle, ok := h.store.Get(key)
+	key, ok := h.vehicleKey(r, ref, h.store.Keys)
+	var vehicle *domain.Vehicle
+	if ok {
+		vehicle, ok = h.store.Get(key)
+	}
 	if !ok {
 		respondError(w, http.StatusNotFound, "vehicle not found")
 		return
 	}
+	precision, ok := parsePrecision(w, r)
+	if !ok {
+		return
+	}
+	if h.redacts(r) {
+		vehicle = h.redactor.Vehicle(vehicle)
+	}
+	if precision >= 0 {
+		vehicle = roundVehicles([]*domain.Vehicle{vehicle}, precision)[0]
+	}
 
 	respondJSON(w, http.StatusOK, vehicle)
 }
 
+// parsePrecision reads the precision query parameter, the decimal places
+// to round positions to, or -1 without one. It responds 400 on invalid
+// values.
+func parsePrecision(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("precision")
+	if v == "" {
+		return -1, true
+	}
+	precision, err := strconv.Atoi(v)
+	if err != nil || precision < 0 || precision > domain.MaxPositionPrecision {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid precision parameter: must be 0-%d decimal places", domain.MaxPositionPrecision))
+		return 0, false
+	}
+	return precision, true
+}
+
+// roundVehicles returns copies of vehicles with positions rounded to
+// precision decimal places, or vehicles themselves for a negative precision.
+// Stored vehicles are shared, so they are never rounded in place.
+func roundVehicles(vehicles []*domain.Vehicle, precision int) []*domain.Vehicle {
+	if precision < 0 {
+		return vehicles
+	}
+	rounded := make([]*domain.Vehicle, len(vehicles))
+	for i, v := range vehicles {
+		c := *v
+		c.RoundPosition(precision)
+		rounded[i] = &c
+	}
+	return rounded
+}
+
+// maxTileZoomOut limits how far below the index zoom a tile request may go,
+// bounding the number of index tiles one request reads.
+const maxTileZoomOut = 2
+
+type TileVehiclesResponse struct {
+	TileID     string            `json:"tileId"`
+	Vehicles   []*domain.Vehicle `json:"vehicles"`
+	Count      int               `json:"count"`
+	ServerTime time.Time         `json:"serverTime"`
+}
+
+// GetTileVehicles returns the vehicles in one slippy-map tile, the REST
+// equivalent of a WS snapshot. Tiles at other zoom levels than the index are
+// mapped onto it.
+func (h *HTTPHandler) GetTileVehicles(w http.ResponseWriter, r *http.Request) {
+	z, errZ := strconv.Atoi(r.PathValue("z"))
+	x, errX := strconv.Atoi(r.PathValue("x"))
+	y, errY := strconv.Atoi(r.PathValue("y"))
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > 22 {
+		respondError(w, http.StatusBadRequest, "invalid tile: expected /v1/tiles/{z}/{x}/{y}/vehicles")
+		return
+	}
+	if maxXY := 1<<z - 1; x < 0 || x > maxXY || y < 0 || y > maxXY {
+		respondError(w, http.StatusBadRequest, "tile coordinates out of range for zoom")
+		return
+	}
+	legacy := false
+	if h.legacyZoom != nil && z == h.legacyZoom.Zoom() {
+		now := time.Now()
+		if legacy = h.legacyZoom.Active(now); legacy {
+			h.legacyZoom.Record(1, now)
+		} else {
+			h.legacyZoom.RecordExpired()
+		}
+	}
+	if z < h.zoom-maxTileZoomOut && !legacy {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("zoom too low: minimum is %d", h.zoom-maxTileZoomOut))
+		return
+	}
+	precision, ok := parsePrecision(w, r)
+	if !ok {
+		return
+	}
+
+	vehicles := h.store.SnapshotForTiles(hub.TilesCovering(z, x, y, h.zoom))
+	if z > h.zoom {
+		minLat, minLon, maxLat, maxLon := hub.TileBounds(z, x, y)
+		bbox := domain.BoundingBox{MinLat: minLat, MinLon: minLon, MaxLat: maxLat, MaxLon: maxLon}
+		filtered := vehicles[:0]
+		for _, v := range vehicles {
+			if bbox.Contains(v.Lat, v.Lon) {
+				filtered = append(filtered, v)
+			}
+		}
+		vehicles = filtered
+	}
+	if vehicles == nil {
+		vehicles = []*domain.Vehicle{}
+	}
+	if h.redacts(r) {
+		vehicles = h.redactor.Vehicles(vehicles)
+	}
+	vehicles = roundVehicles(vehicles, precision)
+
+	respondJSON(w, http.StatusOK, TileVehiclesResponse{
+		TileID:     fmt.Sprintf("%d/%d/%d", z, x, y),
+		Vehicles:   vehicles,
+		Count:      len(vehicles),
+		ServerTime: time.Now(),
+	})
+}
+
 func parseBBox(parts []string) (*domain.BoundingBox, error) {
 	minLat, err := strconv.ParseFloat(parts[0], 64)
 	if err != nil {
@@ -103,9 +254,18 @@ func parseBBox(parts []string) (*domain.BoundingBox, error) {
 }
 
 type errorResponse struct {
-	Error string `json:"error"`
+	Error       string   `json:"error"`
+	Code        string   `json:"code,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
 }
 
+// Machine-readable error codes for subsystem availability and access.
+const (
+	errCodeFeatureDisabled = "feature_disabled"
+	errCodeNotReady        = "not_ready"
+	errCodeTokenExpired    = "token_expired"
+)
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -115,3 +275,32 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 func respondError(w http.ResponseWriter, status int, message string) {
 	respondJSON(w, status, errorResponse{Error: message})
 }
+
+func respondErrorCode(w http.ResponseWriter, status int, code, message string) {
+	respondJSON(w, status, errorResponse{Error: message, Code: code})
+}
+
+// maxLineSuggestions caps the "did you mean" lines of a route 404.
+const maxLineSuggestions = 3
+
+// respondLineNotFound answers a request for an unknown line with a 404
+// suggesting the closest known lines.
+//
+// Endpoints 404 only when the resource named in the path (a line, stop,
+// vehicle or alert) doesn't exist. A known resource with nothing to list,
+// and query filters matching nothing, answer 200 with an empty list.
+func respondLineNotFound(w http.ResponseWriter, gtfsStore *store.GTFSStore, line, message string) {
+	resp := errorResponse{Error: message, Suggestions: gtfsStore.SuggestLines(line, maxLineSuggestions)}
+	if len(resp.Suggestions) > 0 {
+		resp.Error += ", did you mean " + strings.Join(resp.Suggestions, ", ") + "?"
+	}
+	respondJSON(w, http.StatusNotFound, resp)
+}
+
+// FeatureDisabled answers every request with a 404 feature_disabled error.
+// It is mounted in place of the routes of a subsystem turned off by config.
+func FeatureDisabled(feature string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondErrorCode(w, http.StatusNotFound, errCodeFeatureDisabled, feature+" is disabled on this server")
+	}
+}
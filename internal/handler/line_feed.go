@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+)
+
+// atomFeed is an Atom feed whose entries carry GeoRSS Simple points.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	GeoRSS  string      `xml:"xmlns:georss,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Updated string `xml:"updated"`
+	Summary string `xml:"summary"`
+	Point   string `xml:"georss:point"`
+}
+
+// GetLineFeed serves the vehicles of a line as an Atom feed with one entry
+// per vehicle located by a GeoRSS point, for feed readers and monitoring
+// tools that can't use the JSON or WS APIs.
+func (h *LineHandler) GetLineFeed(w http.ResponseWriter, r *http.Request) {
+	line := r.PathValue("line")
+	if line == "" {
+		respondError(w, http.StatusBadRequest, "missing line")
+		return
+	}
+
+	vehicles := h.vehicles.List(store.ListOptions{Line: line})
+	if _, known := h.gtfs.GetRouteByLine(line); !known && len(vehicles) == 0 {
+		respondLineNotFound(w, h.gtfs, line, "line not found")
+		return
+	}
+	if h.redacts(r) {
+		vehicles = h.redactor.Vehicles(vehicles)
+	}
+	sort.Slice(vehicles, func(i, j int) bool { return vehicles[i].Key < vehicles[j].Key })
+
+	updated := time.Time{}
+	entries := make([]atomEntry, 0, len(vehicles))
+	for _, v := range vehicles {
+		if v.Timestamp.After(updated) {
+			updated = v.Timestamp
+		}
+		entries = append(entries, atomEntry{
+			ID:      "urn:wabus:vehicle:" + v.Key,
+			Title:   vehicleFeedTitle(v),
+			Updated: v.Timestamp.UTC().Format(time.RFC3339),
+			Summary: vehicleFeedSummary(v),
+			Point:   strconv.FormatFloat(v.Lat, 'f', -1, 64) + " " + strconv.FormatFloat(v.Lon, 'f', -1, 64),
+		})
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	feed := atomFeed{
+		GeoRSS:  "http://www.georss.org/georss",
+		ID:      "urn:wabus:line:" + line + ":vehicles",
+		Title:   "Line " + line + " vehicles",
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "WaBus"},
+		Link:    atomLink{Rel: "self", Href: r.URL.RequestURI()},
+		Entries: entries,
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
+
+// vehicleFeedTitle reads like "Bus 1234 to Centrum".
+func vehicleFeedTitle(v *domain.Vehicle) string {
+	kind := v.Type.String()
+	title := strings.ToUpper(kind[:1]) + kind[1:]
+	if v.VehicleNumber != "" {
+		title += " " + v.VehicleNumber
+	}
+	if v.Headsign != "" {
+		title += " to " + v.Headsign
+	}
+	return title
+}
+
+// vehicleFeedSummary lists what is known of the vehicle's run, e.g.
+// "Line 180, brigade 3, 2 min late, 24 km/h".
+func vehicleFeedSummary(v *domain.Vehicle) string {
+	parts := []string{"Line " + v.Line}
+	if v.Brigade != "" {
+		parts = append(parts, "brigade "+v.Brigade)
+	}
+	if v.Delay != nil {
+		switch minutes := *v.Delay / 60; {
+		case minutes > 0:
+			parts = append(parts, fmt.Sprintf("%d min late", minutes))
+		case minutes < 0:
+			parts = append(parts, fmt.Sprintf("%d min early", -minutes))
+		default:
+			parts = append(parts, "on time")
+		}
+	}
+	if v.Speed != nil {
+		parts = append(parts, fmt.Sprintf("%.0f km/h", *v.Speed))
+	}
+	return strings.Join(parts, ", ")
+}
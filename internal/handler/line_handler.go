@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wabus/internal/analytics"
+	"wabus/internal/domain"
+	"wabus/internal/matcher"
+	"wabus/internal/store"
+)
+
+// LineHandler serves per-line views combining live vehicles with the
+// GTFS schedule.
+type LineHandler struct {
+	redaction
+	vehicles *store.Store
+	gtfs     *store.GTFSStore
+	matcher  *matcher.Matcher
+}
+
+func NewLineHandler(vehicleStore *store.Store, gtfsStore *store.GTFSStore) *LineHandler {
+	return &LineHandler{vehicles: vehicleStore, gtfs: gtfsStore}
+}
+
+// SetMatcher enables endpoints that follow vehicles along line shapes.
+func (h *LineHandler) SetMatcher(m *matcher.Matcher) {
+	h.matcher = m
+}
+
+type LineLiveStats struct {
+	Vehicles    int            `json:"vehicles"`
+	ByDirection map[string]int `json:"by_direction,omitempty"`
+	// Averages cover the vehicles with a smoothed speed or a delay; they are
+	// null when none has one.
+	AvgSpeedKmh     *float64 `json:"avg_speed_kmh"`
+	AvgDelaySeconds *float64 `json:"avg_delay_seconds"`
+}
+
+type LineScheduledStats struct {
+	Vehicles    int            `json:"vehicles"`
+	ByDirection map[string]int `json:"by_direction,omitempty"`
+}
+
+type LineStatsResponse struct {
+	Line      string              `json:"line"`
+	RouteID   string              `json:"route_id,omitempty"`
+	Live      LineLiveStats       `json:"live"`
+	Scheduled *LineScheduledStats `json:"scheduled,omitempty"`
+	// Coverage is live / scheduled vehicles; omitted when nothing is scheduled.
+	Coverage   *float64  `json:"coverage,omitempty"`
+	ServerTime time.Time `json:"server_time"`
+}
+
+// GetLineStats reports how many vehicles of a line are running compared to
+// how many the schedule expects right now.
+func (h *LineHandler) GetLineStats(w http.ResponseWriter, r *http.Request) {
+	line := r.PathValue("line")
+	if line == "" {
+		respondError(w, http.StatusBadRequest, "missing line")
+		return
+	}
+
+	now := time.Now()
+	resp := LineStatsResponse{Line: line, ServerTime: now}
+
+	vehicles := h.vehicles.List(store.ListOptions{Line: line})
+	resp.Live.Vehicles = len(vehicles)
+	var speedSum, delaySum float64
+	var speeds, delays int
+	for _, v := range vehicles {
+		if v.SpeedAvg != nil {
+			speedSum += *v.SpeedAvg
+			speeds++
+		}
+		if v.Delay != nil {
+			delaySum += float64(*v.Delay)
+			delays++
+		}
+		if v.DirectionID == nil {
+			continue
+		}
+		if resp.Live.ByDirection == nil {
+			resp.Live.ByDirection = make(map[string]int)
+		}
+		resp.Live.ByDirection[strconv.Itoa(*v.DirectionID)]++
+	}
+	if speeds > 0 {
+		avg := math.Round(speedSum/float64(speeds)*10) / 10
+		resp.Live.AvgSpeedKmh = &avg
+	}
+	if delays > 0 {
+		avg := math.Round(delaySum / float64(delays))
+		resp.Live.AvgDelaySeconds = &avg
+	}
+
+	route, known := h.gtfs.GetRouteByLine(line)
+	if known {
+		resp.RouteID = route.ID
+
+		scheduled := &LineScheduledStats{}
+		for dir, n := range h.gtfs.CountActiveTrips(route.ID, now) {
+			if scheduled.ByDirection == nil {
+				scheduled.ByDirection = make(map[string]int)
+			}
+			scheduled.ByDirection[strconv.Itoa(dir)] = n
+			scheduled.Vehicles += n
+		}
+		resp.Scheduled = scheduled
+
+		if scheduled.Vehicles > 0 {
+			coverage := float64(resp.Live.Vehicles) / float64(scheduled.Vehicles)
+			resp.Coverage = &coverage
+		}
+	}
+
+	if !known && len(vehicles) == 0 {
+		respondLineNotFound(w, h.gtfs, line, "line not found")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+type HeadwaysResponse struct {
+	Line       string                        `json:"line"`
+	RouteID    string                        `json:"route_id"`
+	Directions []analytics.DirectionHeadways `json:"directions"`
+	ServerTime time.Time                     `json:"server_time"`
+}
+
+// GetHeadways returns the current gaps between consecutive vehicles of a line
+// in each direction, measured along the shape they are snapped to, next to
+// the headway scheduled right now.
+func (h *LineHandler) GetHeadways(w http.ResponseWriter, r *http.Request) {
+	line := r.PathValue("line")
+	route, ok := h.gtfs.GetRouteByLine(line)
+	if !ok {
+		respondLineNotFound(w, h.gtfs, line, "route not found")
+		return
+	}
+
+	now := time.Now()
+	vehicles := h.vehicles.List(store.ListOptions{Line: line})
+	directions := analytics.Headways(vehicles, h.gtfs.ScheduledHeadways(route.ID, now))
+	if h.redacts(r) {
+		for _, d := range directions {
+			for i := range d.Gaps {
+				d.Gaps[i].VehicleKey = h.redactor.ID(d.Gaps[i].VehicleKey)
+				d.Gaps[i].AheadKey = h.redactor.ID(d.Gaps[i].AheadKey)
+			}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, HeadwaysResponse{
+		Line:       line,
+		RouteID:    route.ID,
+		Directions: directions,
+		ServerTime: now,
+	})
+}
+
+type EtaPathResponse struct {
+	Line        string              `json:"line"`
+	VehicleKey  string              `json:"vehicle_key"`
+	StopID      string              `json:"stop_id"`
+	ShapeID     string              `json:"shape_id"`
+	DirectionID int                 `json:"direction_id"`
+	Headsign    string              `json:"headsign,omitempty"`
+	Points      []domain.ShapePoint `json:"points"`
+	DistanceM   float64             `json:"distance_m"`
+	ServerTime  time.Time           `json:"server_time"`
+}
+
+// GetEtaPath returns the remaining path along the line's shape from
+// ?vehicle= (a vehicle key, or its opaque ID in privacy mode) to ?stop=,
+// for drawing an approaching vehicle.
+func (h *LineHandler) GetEtaPath(w http.ResponseWriter, r *http.Request) {
+	line := r.PathValue("line")
+	vehicleRef := r.URL.Query().Get("vehicle")
+	stopID := r.URL.Query().Get("stop")
+	if vehicleRef == "" || stopID == "" {
+		respondError(w, http.StatusBadRequest, "vehicle and stop parameters are required")
+		return
+	}
+
+	if _, ok := h.gtfs.GetRouteByLine(line); !ok {
+		respondLineNotFound(w, h.gtfs, line, "route not found")
+		return
+	}
+	vehicleKey, ok := h.vehicleKey(r, vehicleRef, h.vehicles.Keys)
+	var vehicle *domain.Vehicle
+	if ok {
+		vehicle, ok = h.vehicles.Get(vehicleKey)
+	}
+	if !ok {
+		respondError(w, http.StatusNotFound, "vehicle not found")
+		return
+	}
+	if vehicle.Line != line {
+		respondError(w, http.StatusBadRequest, "vehicle is not running on line "+line)
+		return
+	}
+	stop, ok := h.gtfs.GetStopByID(stopID)
+	if !ok {
+		respondError(w, http.StatusNotFound, "stop not found")
+		return
+	}
+
+	path, ok := h.matcher.PathTo(vehicle, stop)
+	if !ok {
+		respondError(w, http.StatusNotFound, "stop is not ahead of the vehicle on this line")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, EtaPathResponse{
+		Line:        line,
+		VehicleKey:  vehicleRef,
+		StopID:      stop.ID,
+		ShapeID:     path.ShapeID,
+		DirectionID: path.DirectionID,
+		Headsign:    path.Headsign,
+		Points:      path.Points,
+		DistanceM:   math.Round(path.Meters),
+		ServerTime:  time.Now(),
+	})
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// GetMetrics serves the server counters in the Prometheus text format.
+func (h *StatsHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	buses, trams := h.vehicleStore.CountByType()
+
+	writeMetric(w, "wabus_uptime_seconds", "gauge", "Seconds since the server started.", time.Since(ServerStats.startTime).Seconds())
+	writeMetric(w, "wabus_requests_total", "counter", "Requests that reached the router.", ServerStats.requestCount.Load())
+	writeMetric(w, "wabus_rate_limited_total", "counter", "Requests rejected by the rate limiter.", ServerStats.rateLimitBlocked.Load())
+	writeLabeledMetric(w, "wabus_vehicles", "gauge", "Vehicles currently in the store.", "type", map[string]int64{
+		"bus":  int64(buses),
+		"tram": int64(trams),
+	})
+
+	writeMetric(w, "wabus_ws_connections", "gauge", "Open WebSocket connections.", ServerStats.wsConnections.Load())
+	writeMetric(w, "wabus_ws_messages_in_total", "counter", "WebSocket messages received.", ServerStats.wsMessagesIn.Load())
+	writeMetric(w, "wabus_ws_messages_out_total", "counter", "WebSocket messages sent.", ServerStats.wsMessagesOut.Load())
+
+	writeMetric(w, "wabus_cache_hits_total", "counter", "Cache hits.", ServerStats.cacheHits.Load())
+	writeMetric(w, "wabus_cache_misses_total", "counter", "Cache misses.", ServerStats.cacheMisses.Load())
+	writeMetric(w, "wabus_cache_stale_served_total", "counter", "Stale cache entries served while revalidating.", ServerStats.cacheStale.Load())
+
+	if h.ingestor != nil {
+		stats := h.ingestor.Stats()
+		writeLabeledMetric(w, "wabus_ingest_filtered_total", "counter", "Vehicles discarded at ingest, by reason.", "reason", map[string]int64{
+			"bad_coords":      stats.FilteredBadCoords,
+			"stale_timestamp": stats.FilteredStale,
+		})
+		writeMetric(w, "wabus_vehicles_pruned_total", "counter", "Vehicles removed after going stale.", stats.Pruned)
+
+		if canary := h.ingestor.CanaryStats(); canary != nil {
+			writeLabeledMetric(w, "wabus_canary_compared_total", "counter", "Vehicles tagged by both the matcher and the canary.", "canary", map[string]int64{canary.Name: canary.Compared})
+			writeLabeledMetric(w, "wabus_canary_mismatches_total", "counter", "Vehicles the canary tagged differently from the matcher.", "canary", map[string]int64{canary.Name: canary.Mismatches})
+			writeLabeledMetric(w, "wabus_canary_skipped_polls_total", "counter", "Polls not compared because the canary was still running.", "canary", map[string]int64{canary.Name: canary.Skipped})
+		}
+	}
+	if h.hub != nil {
+		stats := h.hub.Stats()
+		writeMetric(w, "wabus_hub_dropped_batches_total", "counter", "Delta batches dropped because the broadcast channel was full.", stats.DroppedBatches)
+		writeMetric(w, "wabus_hub_dropped_deltas_total", "counter", "Deltas in dropped batches.", stats.DroppedDeltas)
+		writeMetric(w, "wabus_hub_dropped_messages_total", "counter", "Messages not queued to clients with a full send buffer.", stats.DroppedMessages)
+		writeMetric(w, "wabus_hub_coalesced_deltas_total", "counter", "Deltas replaced by a later delta for the same vehicle while held for a client.", stats.CoalescedDeltas)
+		writeMetric(w, "wabus_hub_evicted_clients_total", "counter", "Clients disconnected because their send buffer stayed full.", stats.EvictedClients)
+	}
+	if h.legacyZoom != nil {
+		stats := h.legacyZoom.Stats()
+		writeMetric(w, "wabus_legacy_zoom_requests_total", "counter", "Requests naming tiles at TILE_ZOOM_LEGACY that were translated.", stats.Requests)
+		writeMetric(w, "wabus_legacy_zoom_tiles_total", "counter", "Tile IDs at TILE_ZOOM_LEGACY translated.", stats.Tiles)
+		writeMetric(w, "wabus_legacy_zoom_expired_total", "counter", "Requests naming tiles at TILE_ZOOM_LEGACY after TILE_ZOOM_LEGACY_UNTIL.", stats.Expired)
+	}
+	if h.fanout != nil {
+		stats := h.fanout.Stats()
+		writeMetric(w, "wabus_fanout_published_total", "counter", "Delta batches published to other instances.", stats.Published)
+		writeMetric(w, "wabus_fanout_received_total", "counter", "Delta batches received from other instances and applied.", stats.Received)
+		writeMetric(w, "wabus_fanout_failed_total", "counter", "Delta batches that failed to publish or decode.", stats.Failed)
+	}
+	if h.firehose != nil {
+		stats := h.firehose.Stats()
+		writeMetric(w, "wabus_firehose_published_total", "counter", "Delta batches published to the NATS firehose subject.", stats.Published)
+		writeMetric(w, "wabus_firehose_failed_total", "counter", "Delta batches that failed to publish to the NATS firehose subject.", stats.Failed)
+	}
+	if h.geofences != nil {
+		inside, enters, exits := make(map[string]int64), make(map[string]int64), make(map[string]int64)
+		for id, stats := range h.geofences.Stats() {
+			inside[id], enters[id], exits[id] = int64(stats.Inside), stats.Enters, stats.Exits
+		}
+		writeLabeledMetric(w, "wabus_geofence_vehicles", "gauge", "Vehicles currently inside a geofence.", "fence", inside)
+		writeLabeledMetric(w, "wabus_geofence_enters_total", "counter", "Vehicles that entered a geofence.", "fence", enters)
+		writeLabeledMetric(w, "wabus_geofence_exits_total", "counter", "Vehicles that left a geofence.", "fence", exits)
+	}
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+func writeLabeledMetric(w io.Writer, name, kind, help, label string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
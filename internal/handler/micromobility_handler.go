@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+)
+
+// MicromobilityHandler serves the bike-share stations and free vehicles of
+// the ingested GBFS systems.
+type MicromobilityHandler struct {
+	store *store.MicromobilityStore
+}
+
+func NewMicromobilityHandler(s *store.MicromobilityStore) *MicromobilityHandler {
+	return &MicromobilityHandler{store: s}
+}
+
+type MicromobilitySystemsResponse struct {
+	Systems    []domain.MicromobilitySystem `json:"systems"`
+	Count      int                          `json:"count"`
+	ServerTime time.Time                    `json:"server_time"`
+}
+
+type MicromobilityStationsResponse struct {
+	Stations   []*domain.MicromobilityStation `json:"stations"`
+	Count      int                            `json:"count"`
+	ServerTime time.Time                      `json:"server_time"`
+}
+
+type MicromobilityVehiclesResponse struct {
+	Vehicles   []*domain.MicromobilityVehicle `json:"vehicles"`
+	Count      int                            `json:"count"`
+	ServerTime time.Time                      `json:"server_time"`
+}
+
+// ListSystems returns the ingested systems with their station and vehicle
+// counts and last refresh.
+func (h *MicromobilityHandler) ListSystems(w http.ResponseWriter, r *http.Request) {
+	systems := h.store.Systems()
+	respondJSON(w, http.StatusOK, MicromobilitySystemsResponse{Systems: systems, Count: len(systems), ServerTime: time.Now()})
+}
+
+// ListStations returns stations, optionally within ?bbox= and of one
+// ?system=. ?available=true keeps only stations renting out a vehicle.
+func (h *MicromobilityHandler) ListStations(w http.ResponseWriter, r *http.Request) {
+	filter, ok := micromobilityFilter(w, r)
+	if !ok {
+		return
+	}
+	stations := h.store.Stations(filter)
+	if r.URL.Query().Get("available") == "true" {
+		available := stations[:0]
+		for _, s := range stations {
+			if s.IsRenting && s.VehiclesAvailable > 0 {
+				available = append(available, s)
+			}
+		}
+		stations = available
+	}
+	respondJSON(w, http.StatusOK, MicromobilityStationsResponse{Stations: stations, Count: len(stations), ServerTime: time.Now()})
+}
+
+// ListVehicles returns the free vehicles available to rent, optionally
+// within ?bbox= and of one ?system=.
+func (h *MicromobilityHandler) ListVehicles(w http.ResponseWriter, r *http.Request) {
+	filter, ok := micromobilityFilter(w, r)
+	if !ok {
+		return
+	}
+	vehicles := h.store.Vehicles(filter)
+	respondJSON(w, http.StatusOK, MicromobilityVehiclesResponse{Vehicles: vehicles, Count: len(vehicles), ServerTime: time.Now()})
+}
+
+func micromobilityFilter(w http.ResponseWriter, r *http.Request) (store.MicromobilityFilter, bool) {
+	filter := store.MicromobilityFilter{System: r.URL.Query().Get("system")}
+	if bboxStr := r.URL.Query().Get("bbox"); bboxStr != "" {
+		parts := strings.Split(bboxStr, ",")
+		if len(parts) != 4 {
+			respondError(w, http.StatusBadRequest, "invalid bbox format: expected minLat,minLon,maxLat,maxLon")
+			return filter, false
+		}
+		bbox, err := parseBBox(parts)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid bbox values: "+err.Error())
+			return filter, false
+		}
+		filter.BBox = bbox
+	}
+	return filter, true
+}
@@ -1,25 +1,61 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 
 	"github.com/klauspost/compress/gzhttp"
 )
 
-func GzipMiddleware(next http.Handler) http.Handler {
-	wrapper, _ := gzhttp.NewWrapper(
-		gzhttp.MinSize(1024),
-		gzhttp.CompressionLevel(6),
+// GzipOptions configures GzipMiddleware.
+type GzipOptions struct {
+	// MinSize is the smallest response body compressed, in bytes.
+	MinSize int
+	// Level is the gzip compression level, 1 (fastest) to 9 (smallest).
+	Level int
+	// ContentTypes limits compression to these media types; empty
+	// compresses all but already compressed formats.
+	ContentTypes []string
+	// ExcludePaths are path prefixes whose responses are never compressed.
+	ExcludePaths []string
+}
+
+// GzipMiddleware compresses responses of clients accepting gzip.
+func GzipMiddleware(opts GzipOptions) (func(http.Handler) http.Handler, error) {
+	contentTypes := gzhttp.ContentTypeFilter(gzhttp.DefaultContentTypeFilter)
+	if len(opts.ContentTypes) > 0 {
+		contentTypes = gzhttp.ContentTypes(opts.ContentTypes)
+	}
+	wrapper, err := gzhttp.NewWrapper(
+		gzhttp.MinSize(opts.MinSize),
+		gzhttp.CompressionLevel(opts.Level),
+		contentTypes,
 	)
-	return wrapper(next)
+	if err != nil {
+		return nil, err
+	}
+	excluded := append([]string(nil), opts.ExcludePaths...)
+	return func(next http.Handler) http.Handler {
+		compressed := wrapper(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range excluded {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			compressed.ServeHTTP(w, r)
+		})
+	}, nil
 }
 
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match")
-		w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Cache, X-Request-ID")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -29,3 +65,23 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireAdmin guards admin endpoints with a static bearer token.
+func RequireAdmin(token string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !hasBearer(r, token) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				respondError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// hasBearer reports whether the request authenticates with token.
+func hasBearer(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"wabus/internal/ingestor"
+)
+
+const (
+	defaultOpsInterval = 5 * time.Second
+	// opsBuffer is how many events a dashboard may fall behind before
+	// further ones are skipped.
+	opsBuffer = 64
+)
+
+// OpsHandler streams operational state to dashboards over a WebSocket: the
+// result of every upstream poll, the steps of GTFS updates, and the /stats
+// statistics at a fixed interval.
+type OpsHandler struct {
+	stats  *StatsHandler
+	token  string
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+// NewOpsHandler serves the ops channel to clients presenting the admin
+// token.
+func NewOpsHandler(stats *StatsHandler, adminToken string, logger *slog.Logger) *OpsHandler {
+	return &OpsHandler{stats: stats, token: adminToken, logger: logger, subs: make(map[chan []byte]struct{})}
+}
+
+// ObservePoll sends a poll result to the connected dashboards.
+func (h *OpsHandler) ObservePoll(result ingestor.PollResult) {
+	h.publish("poll", result)
+}
+
+// ObserveGTFS sends a step of a GTFS update to the connected dashboards.
+func (h *OpsHandler) ObserveGTFS(progress ingestor.GTFSProgress) {
+	h.publish("gtfs", progress)
+}
+
+func (h *OpsHandler) publish(msgType string, payload interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) == 0 {
+		return
+	}
+	data, err := json.Marshal(serverMessage{Type: msgType, Payload: payload})
+	if err != nil {
+		return
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// ServeWS streams "poll" and "gtfs" events as they happen and a "stats"
+// message right away and every ?interval= seconds (default 5, 1-60). The
+// admin token goes in the Authorization header or, for browsers, ?token=.
+// Messages from the client are ignored.
+func (h *OpsHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	interval := defaultOpsInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 60 {
+			respondError(w, http.StatusBadRequest, "invalid interval parameter: expected 1-60 seconds")
+			return
+		}
+		interval = time.Duration(n) * time.Second
+	}
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: []string{"*"}})
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "ops websocket accept failed", "error", err)
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	ctx := conn.CloseRead(r.Context())
+
+	events := make(chan []byte, opsBuffer)
+	h.mu.Lock()
+	h.subs[events] = struct{}{}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.subs, events)
+		h.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	if !h.sendStats(ctx, conn) {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-events:
+			if !opsWrite(ctx, conn, data) {
+				return
+			}
+		case <-ticker.C:
+			if !h.sendStats(ctx, conn) {
+				return
+			}
+		}
+	}
+}
+
+func (h *OpsHandler) authorized(r *http.Request) bool {
+	if hasBearer(r, h.token) {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h *OpsHandler) sendStats(ctx context.Context, conn *websocket.Conn) bool {
+	data, err := json.Marshal(serverMessage{Type: "stats", Payload: h.stats.collect()})
+	if err != nil {
+		return false
+	}
+	return opsWrite(ctx, conn, data)
+}
+
+func opsWrite(ctx context.Context, conn *websocket.Conn, data []byte) bool {
+	writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return conn.Write(writeCtx, websocket.MessageText, data) == nil
+}
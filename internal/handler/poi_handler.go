@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+)
+
+// POIHandler serves the static points of interest layer.
+type POIHandler struct {
+	store *store.POIStore
+}
+
+func NewPOIHandler(s *store.POIStore) *POIHandler {
+	return &POIHandler{store: s}
+}
+
+type POIResponse struct {
+	POIs       []*domain.POI `json:"pois"`
+	Count      int           `json:"count"`
+	ServerTime time.Time     `json:"server_time"`
+}
+
+// ListPOIs returns points of interest, optionally within ?bbox= and of the
+// comma-separated ?type=.
+func (h *POIHandler) ListPOIs(w http.ResponseWriter, r *http.Request) {
+	var filter store.POIFilter
+	if bboxStr := r.URL.Query().Get("bbox"); bboxStr != "" {
+		parts := strings.Split(bboxStr, ",")
+		if len(parts) != 4 {
+			respondError(w, http.StatusBadRequest, "invalid bbox format: expected minLat,minLon,maxLat,maxLon")
+			return
+		}
+		bbox, err := parseBBox(parts)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid bbox values: "+err.Error())
+			return
+		}
+		filter.BBox = bbox
+	}
+	if types := splitParam(r.URL.Query().Get("type")); len(types) > 0 {
+		filter.Types = make(map[string]struct{}, len(types))
+		for _, t := range types {
+			filter.Types[t] = struct{}{}
+		}
+	}
+
+	pois := h.store.POIs(filter)
+	respondJSON(w, http.StatusOK, POIResponse{POIs: pois, Count: len(pois), ServerTime: time.Now()})
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"wabus/internal/middleware"
+)
+
+// QuotaHandler tells clients how much of their rate limit is left, so they
+// can pace themselves instead of running into 429s.
+type QuotaHandler struct {
+	rateLimiter *middleware.RateLimiter
+}
+
+func NewQuotaHandler(rateLimiter *middleware.RateLimiter) *QuotaHandler {
+	return &QuotaHandler{rateLimiter: rateLimiter}
+}
+
+type QuotaResponse struct {
+	middleware.Quota
+	ServerTime time.Time `json:"server_time"`
+}
+
+// GetQuota reports the caller's budgets. The request itself is counted
+// like any other, so the budget it falls under shows one token less.
+func (h *QuotaHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, QuotaResponse{Quota: h.rateLimiter.Quota(r), ServerTime: time.Now()})
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+
+	"wabus/internal/privacy"
+)
+
+// redaction hides vehicle numbers and brigades in privacy mode. Handlers
+// serving vehicles embed it; requests with the admin token see everything.
+type redaction struct {
+	redactor   *privacy.Redactor
+	adminToken string
+}
+
+// SetRedaction enables privacy mode. Requests authenticated with adminToken
+// (when set) are exempt.
+func (x *redaction) SetRedaction(redactor *privacy.Redactor, adminToken string) {
+	x.redactor = redactor
+	x.adminToken = adminToken
+}
+
+// redacts reports whether the response to r must be redacted.
+func (x *redaction) redacts(r *http.Request) bool {
+	return x.redactor != nil && (x.adminToken == "" || !hasBearer(r, x.adminToken))
+}
+
+// vehicleKey resolves a vehicle reference from a redacted request, an
+// opaque ID, to the vehicle key. Other requests reference the key itself.
+// candidates lists keys an unknown ID may stand for; it may be nil.
+func (x *redaction) vehicleKey(r *http.Request, ref string, candidates func() []string) (string, bool) {
+	if !x.redacts(r) {
+		return ref, true
+	}
+	return x.redactor.Resolve(ref, candidates)
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/replication"
+	"wabus/internal/store"
+)
+
+// ReplicationHandler exposes the vehicle store to peer instances.
+type ReplicationHandler struct {
+	store     *store.Store
+	publisher *replication.Publisher
+	logger    *slog.Logger
+}
+
+func NewReplicationHandler(s *store.Store, logger *slog.Logger) *ReplicationHandler {
+	return &ReplicationHandler{store: s, logger: logger}
+}
+
+// SetPublisher enables the delta stream followers replicate from.
+func (h *ReplicationHandler) SetPublisher(p *replication.Publisher) {
+	h.publisher = p
+}
+
+type ReplicationSnapshotResponse struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Count       int               `json:"count"`
+	Vehicles    []*domain.Vehicle `json:"vehicles"`
+}
+
+// GetSnapshot returns every vehicle in the store, including its update time.
+// Peers asking for application/x-gob (or ?format=gob) get the compact
+// binary stream; everyone else gets JSON for debugging.
+func (h *ReplicationHandler) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	vehicles := h.store.List(store.ListOptions{})
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), replication.ContentTypeGob) {
+		format = "gob"
+	}
+
+	switch format {
+	case "gob":
+		w.Header().Set("Content-Type", replication.ContentTypeGob)
+		w.WriteHeader(http.StatusOK)
+		replication.WriteGob(w, vehicles, now)
+	case "", "json":
+		respondJSON(w, http.StatusOK, ReplicationSnapshotResponse{
+			GeneratedAt: now,
+			Count:       len(vehicles),
+			Vehicles:    vehicles,
+		})
+	default:
+		respondError(w, http.StatusBadRequest, "invalid format parameter, use gob or json")
+	}
+}
+
+// StreamDeltas sends a gob snapshot followed by every delta batch the
+// ingestor produces, for a follower to mirror this instance. Followers that
+// can't keep up are disconnected and resync on reconnect.
+func (h *ReplicationHandler) StreamDeltas(w http.ResponseWriter, r *http.Request) {
+	if h.publisher == nil {
+		respondError(w, http.StatusNotFound, "replication stream not enabled")
+		return
+	}
+
+	// Subscribe before taking the snapshot so no batch falls in between;
+	// replaying a batch the snapshot already reflects is harmless.
+	deltas, unsubscribe := h.publisher.Subscribe()
+	defer unsubscribe()
+	vehicles := h.store.List(store.ListOptions{})
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout.
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", replication.ContentTypeGob)
+	w.WriteHeader(http.StatusOK)
+
+	h.logger.InfoContext(r.Context(), "replication follower connected", "remote_addr", r.RemoteAddr, "vehicles", len(vehicles))
+	err := replication.WriteStream(r.Context(), w, func() { rc.Flush() }, vehicles, deltas)
+	h.logger.InfoContext(r.Context(), "replication follower disconnected", "remote_addr", r.RemoteAddr, "error", err)
+}
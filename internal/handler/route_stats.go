@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Latency buckets grow by latencyGrowth from latencyBase, so a percentile
+// read from them is at most 25% above the true value. The last bucket
+// holds everything slower than about six minutes.
+const (
+	latencyBase    = 50 * time.Microsecond
+	latencyGrowth  = 1.25
+	latencyBuckets = 72
+)
+
+// latencyHistogram counts durations in exponential buckets. Recording is a
+// few atomic adds, so it can sit on every request.
+type latencyHistogram struct {
+	counts [latencyBuckets]atomic.Int64
+	max    atomic.Int64 // nanoseconds
+}
+
+func latencyBucket(d time.Duration) int {
+	if d <= latencyBase {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(latencyBase)) / math.Log(latencyGrowth)))
+	return min(i, latencyBuckets-1)
+}
+
+// latencyBound is the upper bound of bucket i.
+func latencyBound(i int) time.Duration {
+	return time.Duration(float64(latencyBase) * math.Pow(latencyGrowth, float64(i)))
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	h.counts[latencyBucket(d)].Add(1)
+	for {
+		m := h.max.Load()
+		if int64(d) <= m || h.max.CompareAndSwap(m, int64(d)) {
+			return
+		}
+	}
+}
+
+// percentiles returns the durations below which the shares qs (0-1) of the
+// recorded durations fall, each capped at the slowest one seen.
+func (h *latencyHistogram) percentiles(qs ...float64) []time.Duration {
+	var counts [latencyBuckets]int64
+	var total int64
+	for i := range counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+	out := make([]time.Duration, len(qs))
+	if total == 0 {
+		return out
+	}
+	maxSeen := time.Duration(h.max.Load())
+	for j, q := range qs {
+		rank := int64(math.Ceil(q * float64(total)))
+		var seen int64
+		for i, c := range counts {
+			seen += c
+			if seen >= rank {
+				out[j] = min(latencyBound(i), maxSeen)
+				break
+			}
+		}
+	}
+	return out
+}
+
+type routeCounters struct {
+	requests     atomic.Int64
+	clientErrors atomic.Int64
+	serverErrors atomic.Int64
+	latency      latencyHistogram
+}
+
+// RouteStats are the request counts and latency percentiles of one route.
+type RouteStats struct {
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"client_errors"`
+	ServerErrors int64   `json:"server_errors"`
+	P50MS        float64 `json:"p50_ms"`
+	P95MS        float64 `json:"p95_ms"`
+	P99MS        float64 `json:"p99_ms"`
+	MaxMS        float64 `json:"max_ms"`
+}
+
+func (s *Stats) route(pattern string) *routeCounters {
+	if c, ok := s.routes.Load(pattern); ok {
+		return c.(*routeCounters)
+	}
+	c, _ := s.routes.LoadOrStore(pattern, &routeCounters{})
+	return c.(*routeCounters)
+}
+
+// RecordRequest implements middleware.RequestRecorder.
+func (s *Stats) RecordRequest(pattern string, status int, duration time.Duration) {
+	s.IncRequests()
+	c := s.route(pattern)
+	c.requests.Add(1)
+	switch {
+	case status >= 500:
+		c.serverErrors.Add(1)
+	case status >= 400:
+		c.clientErrors.Add(1)
+	}
+	if duration >= 0 {
+		c.latency.record(duration)
+	}
+}
+
+// RouteStats returns the statistics of every route requested so far, keyed
+// by route pattern.
+func (s *Stats) RouteStats() map[string]RouteStats {
+	out := make(map[string]RouteStats)
+	s.routes.Range(func(k, v any) bool {
+		c := v.(*routeCounters)
+		p := c.latency.percentiles(0.5, 0.95, 0.99)
+		out[k.(string)] = RouteStats{
+			Requests:     c.requests.Load(),
+			ClientErrors: c.clientErrors.Load(),
+			ServerErrors: c.serverErrors.Load(),
+			P50MS:        durationMS(p[0]),
+			P95MS:        durationMS(p[1]),
+			P99MS:        durationMS(p[2]),
+			MaxMS:        durationMS(time.Duration(c.latency.max.Load())),
+		}
+		return true
+	})
+	return out
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"wabus/pkg/geo"
+	"wabus/pkg/gtfs"
+)
+
+// bundleToleranceMeters is how far bundled shapes may stray from the full
+// ones; enough for drawing routes on a map.
+const bundleToleranceMeters = 5.0
+
+// shapeBundle is every shape of one dataset version, encoded once and
+// addressed by the hash of its content.
+type shapeBundle struct {
+	dataset string
+	version string
+	body    staticBody
+}
+
+// ShapeBundleResponse lists every shape of the dataset.
+type ShapeBundleResponse struct {
+	Shapes []BundledShape `json:"shapes"`
+	Count  int            `json:"count"`
+}
+
+// BundledShape is a shape simplified to a few meters as an encoded
+// polyline, with the lines whose trips follow it.
+type BundledShape struct {
+	ID          string   `json:"id"`
+	Lines       []string `json:"lines"`
+	DirectionID *int     `json:"direction_id,omitempty"`
+	Polyline    string   `json:"polyline"`
+}
+
+// GetShapeBundle serves every shape of the dataset in one response that
+// never changes: without ?version= it redirects to the current bundle's
+// version, which is served with a year-long immutable Cache-Control. An
+// outdated version answers 404 so clients fetch the current one.
+func (h *GTFSHandler) GetShapeBundle(w http.ResponseWriter, r *http.Request) {
+	bundle, err := h.shapeBundle()
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to build shape bundle", "error", err)
+		respondError(w, http.StatusInternalServerError, "failed to build shape bundle")
+		return
+	}
+
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, r.URL.Path+"?version="+url.QueryEscape(bundle.version), http.StatusFound)
+		return
+	}
+	if version != bundle.version {
+		respondError(w, http.StatusNotFound, "shape bundle version not found; fetch /v1/shapes/bundle for the current one")
+		return
+	}
+
+	etag := `"` + bundle.version + `"`
+	h.tagResponse(w)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	serveStaticBody(w, r, bundle.body)
+}
+
+// shapeBundle returns the bundle of the loaded dataset, building it if the
+// dataset changed since it was last built.
+func (h *GTFSHandler) shapeBundle() (*shapeBundle, error) {
+	dataset := h.datasetVersion()
+	if b := h.bundle.Load(); b != nil && b.dataset == dataset {
+		return b, nil
+	}
+
+	h.bundleMu.Lock()
+	defer h.bundleMu.Unlock()
+	if b := h.bundle.Load(); b != nil && b.dataset == dataset {
+		return b, nil
+	}
+
+	start := time.Now()
+	byID := make(map[string]*BundledShape)
+	for _, route := range h.store.GetAllRoutes() {
+		for _, shape := range h.store.GetSimplifiedRouteShapes(route.ID) {
+			if s, ok := byID[shape.ID]; ok {
+				s.Lines = append(s.Lines, route.ShortName)
+				continue
+			}
+			points := gtfs.SimplifyPoints(shape.Points, bundleToleranceMeters)
+			latLons := make([][2]float64, len(points))
+			for i, p := range points {
+				latLons[i] = [2]float64{p.Lat, p.Lon}
+			}
+			byID[shape.ID] = &BundledShape{
+				ID:          shape.ID,
+				Lines:       []string{route.ShortName},
+				DirectionID: shape.DirectionID,
+				Polyline:    geo.EncodePolyline(latLons),
+			}
+		}
+	}
+	shapes := make([]BundledShape, 0, len(byID))
+	for _, s := range byID {
+		sort.Strings(s.Lines)
+		shapes = append(shapes, *s)
+	}
+	sort.Slice(shapes, func(i, j int) bool { return shapes[i].ID < shapes[j].ID })
+
+	body, err := encodeStaticBody(ShapeBundleResponse{Shapes: shapes, Count: len(shapes)})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body.plain)
+	b := &shapeBundle{dataset: dataset, version: hex.EncodeToString(sum[:8]), body: body}
+	h.bundle.Store(b)
+	h.logger.Info("built shape bundle",
+		"shapes", len(shapes),
+		"bytes", len(body.plain),
+		"gzipped_bytes", len(body.gzipped),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+	return b, nil
+}
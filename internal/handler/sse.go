@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"wabus/internal/domain"
+	"wabus/internal/hub"
+)
+
+// ServeSSE streams the vehicles of ?tiles= and ?lines= (comma-separated) as
+// Server-Sent Events, for clients that can't open a WebSocket. Each event's
+// data is one message as sent over /v1/ws: a snapshot of the subscription,
+// then deltas and broadcasts. ?types= limits it to vehicle types and
+// ?interval= spaces deltas as set_update_rate does. The subscription is
+// fixed for the lifetime of the stream.
+func (h *WSHandler) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if h.auth != nil && !h.auth.authorize(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	tileIDs, lines, typeParams := splitParam(q.Get("tiles")), splitParam(q.Get("lines")), splitParam(q.Get("types"))
+	if len(tileIDs) == 0 && len(lines) == 0 {
+		respondError(w, http.StatusBadRequest, "tiles or lines is required")
+		return
+	}
+
+	client := hub.NewClient(uuid.New().String(), 256)
+	var tiles []string
+	var types []domain.VehicleType
+	var wsErr *wsError
+	if len(tileIDs) > 0 {
+		if tiles, wsErr = h.validateTileIDs(tileIDs); wsErr == nil {
+			wsErr = h.checkTileLimit(newWSSession(), tiles)
+		}
+	}
+	if wsErr == nil && len(lines) > 0 {
+		if lines, wsErr = validateLines(lines); wsErr == nil {
+			wsErr = h.checkLineLimit(client, lines)
+		}
+	}
+	if wsErr == nil && len(typeParams) > 0 {
+		types, wsErr = parseVehicleTypes(typeParams)
+	}
+	var interval time.Duration
+	if v := q.Get("interval"); wsErr == nil && v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			wsErr = &wsError{code: wsErrInvalidPayload, message: "invalid interval parameter"}
+		} else {
+			interval, wsErr = parseUpdateInterval(seconds)
+		}
+	}
+	if wsErr != nil {
+		respondErrorCode(w, http.StatusBadRequest, wsErr.code, wsErr.message)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	client.SetTypes(types)
+	client.SetUpdateInterval(interval)
+	h.hub.Register(client)
+	defer h.hub.Unregister(client)
+	if len(tiles) > 0 {
+		h.hub.Subscribe(client, tiles)
+	}
+	if len(lines) > 0 {
+		h.hub.SubscribeLines(client, lines)
+	}
+	vehicles := h.store.SnapshotForTiles(tiles)
+	if len(lines) > 0 {
+		vehicles = mergeVehicles(vehicles, h.store.SnapshotForLines(lines))
+	}
+	h.sendVehicles(client, acceptedVehicles(client, vehicles))
+
+	h.sseLoop(r.Context(), w, rc, client)
+}
+
+// sseLoop writes the client's messages as events until the request ends or
+// the hub closes or evicts the client. A comment line every 30 seconds keeps proxies
+// from timing the stream out.
+func (h *WSHandler) sseLoop(ctx context.Context, w http.ResponseWriter, rc *http.ResponseController, client *hub.Client) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	write := func(parts ...string) bool {
+		// Each write gets its own deadline instead of the server's write
+		// timeout, which the stream outlives.
+		rc.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		for _, p := range parts {
+			if _, err := w.Write([]byte(p)); err != nil {
+				return false
+			}
+		}
+		return rc.Flush() == nil
+	}
+
+	if !write(": connected\n\n") {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-client.Evicted():
+			h.logger.InfoContext(ctx, "closing slow sse client", "client_id", client.ID)
+			return
+
+		case msg, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			// Messages are single-line JSON, so each fits one data field.
+			if !write("data: ", string(msg), "\n\n") {
+				h.logger.DebugContext(ctx, "sse write failed", "client_id", client.ID)
+				return
+			}
+
+		case <-ticker.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// mergeVehicles appends the vehicles of b missing from a.
+func mergeVehicles(a, b []*domain.Vehicle) []*domain.Vehicle {
+	seen := make(map[string]struct{}, len(a))
+	for _, v := range a {
+		seen[v.Key] = struct{}{}
+	}
+	for _, v := range b {
+		if _, ok := seen[v.Key]; !ok {
+			a = append(a, v)
+		}
+	}
+	return a
+}
+
+// splitParam splits a comma-separated query parameter, dropping empty
+// entries.
+func splitParam(v string) []string {
+	var values []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prewarmedBodies are the /v1/routes and /v1/stops responses of one dataset
+// version, encoded and compressed once instead of on every request.
+type prewarmedBodies struct {
+	version string
+	routes  staticBody
+	stops   staticBody
+}
+
+type staticBody struct {
+	plain   []byte
+	gzipped []byte
+}
+
+// Prewarm encodes the /v1/routes and /v1/stops responses of the loaded
+// dataset unless they already are. Requests arriving before it finishes
+// wait for the bodies instead of encoding them again.
+func (h *GTFSHandler) Prewarm() {
+	start := time.Now()
+	if _, built, err := h.prewarmed(); err != nil {
+		h.logger.Error("failed to prewarm static responses", "error", err)
+	} else if built {
+		h.logger.Info("prewarmed static responses", "duration_ms", time.Since(start).Milliseconds())
+	}
+}
+
+// prewarmed returns the bodies of the loaded dataset, building them if the
+// dataset changed since they were last built.
+func (h *GTFSHandler) prewarmed() (bodies *prewarmedBodies, built bool, err error) {
+	// The version is read before the data, so bodies are never tagged with
+	// a newer version than they hold.
+	version := h.datasetVersion()
+	if b := h.bodies.Load(); b != nil && b.version == version {
+		return b, false, nil
+	}
+
+	h.bodiesMu.Lock()
+	defer h.bodiesMu.Unlock()
+	if b := h.bodies.Load(); b != nil && b.version == version {
+		return b, false, nil
+	}
+
+	// server_time is when the bodies were built; it is as old as any copy
+	// a client or CDN revalidated with the ETag.
+	now := time.Now()
+	routes := h.store.GetAllRoutes()
+	b := &prewarmedBodies{version: version}
+	if b.routes, err = encodeStaticBody(RoutesResponse{Routes: routes, Count: len(routes), ServerTime: now}); err != nil {
+		return nil, false, err
+	}
+	stops := h.store.GetAllStops()
+	if b.stops, err = encodeStaticBody(StopsResponse{Stops: stops, Count: len(stops), ServerTime: now}); err != nil {
+		return nil, false, err
+	}
+	h.bodies.Store(b)
+	return b, true, nil
+}
+
+// encodeStaticBody encodes v as respondJSON does, plain and gzipped.
+func encodeStaticBody(v any) (staticBody, error) {
+	var plain bytes.Buffer
+	if err := json.NewEncoder(&plain).Encode(v); err != nil {
+		return staticBody{}, err
+	}
+	var gzipped bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&gzipped, gzip.BestCompression)
+	if err != nil {
+		return staticBody{}, err
+	}
+	if _, err := zw.Write(plain.Bytes()); err != nil {
+		return staticBody{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return staticBody{}, err
+	}
+	return staticBody{plain: plain.Bytes(), gzipped: gzipped.Bytes()}, nil
+}
+
+// serveStaticBody writes a prewarmed body, gzipped if the client accepts
+// it. Responses with a Content-Encoding pass GzipMiddleware untouched.
+func serveStaticBody(w http.ResponseWriter, r *http.Request, body staticBody) {
+	data := body.plain
+	w.Header().Set("Content-Type", "application/json")
+	if acceptsGzip(r) {
+		data = body.gzipped
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding of r allows gzip, either
+// by name or through "*".
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = parsed
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
@@ -4,9 +4,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"wabus/internal/cache"
+	"wabus/internal/geofence"
+	"wabus/internal/hub"
+	"wabus/internal/ingestor"
+	"wabus/internal/middleware"
+	"wabus/internal/replication"
 	"wabus/internal/store"
 )
 
@@ -19,9 +26,17 @@ type Stats struct {
 	wsMessagesOut    atomic.Int64
 	cacheHits        atomic.Int64
 	cacheMisses      atomic.Int64
+	cacheStale       atomic.Int64
+	cacheByClass     sync.Map // key class -> *cacheClassCounters
+	routes           sync.Map // route pattern -> *routeCounters
 	rateLimitBlocked atomic.Int64
 }
 
+type cacheClassCounters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
 // Global stats instance
 var ServerStats = &Stats{
 	startTime: time.Now(),
@@ -34,20 +49,81 @@ func (s *Stats) IncWSMessagesIn()     { s.wsMessagesIn.Add(1) }
 func (s *Stats) IncWSMessagesOut()    { s.wsMessagesOut.Add(1) }
 func (s *Stats) IncCacheHits()        { s.cacheHits.Add(1) }
 func (s *Stats) IncCacheMisses()      { s.cacheMisses.Add(1) }
+func (s *Stats) IncCacheStale()       { s.cacheStale.Add(1) }
 func (s *Stats) IncRateLimitBlocked() { s.rateLimitBlocked.Add(1) }
 
+// RecordCacheHit implements cache.HitRecorder.
+func (s *Stats) RecordCacheHit(class string) {
+	s.IncCacheHits()
+	s.cacheClass(class).hits.Add(1)
+}
+
+// RecordCacheMiss implements cache.HitRecorder.
+func (s *Stats) RecordCacheMiss(class string) {
+	s.IncCacheMisses()
+	s.cacheClass(class).misses.Add(1)
+}
+
+func (s *Stats) cacheClass(class string) *cacheClassCounters {
+	if c, ok := s.cacheByClass.Load(class); ok {
+		return c.(*cacheClassCounters)
+	}
+	c, _ := s.cacheByClass.LoadOrStore(class, &cacheClassCounters{})
+	return c.(*cacheClassCounters)
+}
+
 type StatsHandler struct {
 	vehicleStore *store.Store
 	gtfsStore    *store.GTFSStore
+	cache        *cache.RedisCache
+	concurrency  *middleware.ConcurrencyLimiter
+	ingestor     *ingestor.Ingestor
+	hub          *hub.Hub
+	geofences    *geofence.Watcher
+	legacyZoom   *hub.LegacyZoom
+	fanout       *replication.Fanout
+	firehose     *replication.Fanout
 }
 
-func NewStatsHandler(vehicleStore *store.Store, gtfsStore *store.GTFSStore) *StatsHandler {
+func NewStatsHandler(vehicleStore *store.Store, gtfsStore *store.GTFSStore, redisCache *cache.RedisCache, concurrency *middleware.ConcurrencyLimiter) *StatsHandler {
 	return &StatsHandler{
 		vehicleStore: vehicleStore,
 		gtfsStore:    gtfsStore,
+		cache:        redisCache,
+		concurrency:  concurrency,
 	}
 }
 
+// SetIngestor adds the ingestor's filtered and pruned vehicle counts.
+func (h *StatsHandler) SetIngestor(ing *ingestor.Ingestor) {
+	h.ingestor = ing
+}
+
+// SetHub adds the hub's dropped delta and message counts.
+func (h *StatsHandler) SetHub(hb *hub.Hub) {
+	h.hub = hb
+}
+
+// SetGeofences adds per-fence event counts.
+func (h *StatsHandler) SetGeofences(w *geofence.Watcher) {
+	h.geofences = w
+}
+
+// SetLegacyZoom adds how often tiles at the legacy zoom are still used.
+func (h *StatsHandler) SetLegacyZoom(l *hub.LegacyZoom) {
+	h.legacyZoom = l
+}
+
+// SetFanout adds the delta batches shared with other instances.
+func (h *StatsHandler) SetFanout(f *replication.Fanout) {
+	h.fanout = f
+}
+
+// SetFirehose adds the delta batches published for external consumers.
+func (h *StatsHandler) SetFirehose(f *replication.Fanout) {
+	h.firehose = f
+}
+
 type StatsResponse struct {
 	Server    ServerStatsResponse    `json:"server"`
 	Vehicles  VehicleStatsResponse   `json:"vehicles"`
@@ -55,15 +131,27 @@ type StatsResponse struct {
 	WebSocket WebSocketStatsResponse `json:"websocket"`
 	Cache     CacheStatsResponse     `json:"cache"`
 	Go        GoStatsResponse        `json:"go"`
+
+	Ingest *ingestor.IngestStats `json:"ingest,omitempty"`
+	Canary *ingestor.CanaryStats `json:"canary,omitempty"`
+	Hub    *hub.Stats            `json:"hub,omitempty"`
+
+	LegacyZoom *hub.LegacyZoomStats     `json:"legacy_zoom,omitempty"`
+	Fanout     *replication.FanoutStats `json:"fanout,omitempty"`
+	Firehose   *replication.FanoutStats `json:"firehose,omitempty"`
+
+	Concurrency map[string]interface{} `json:"concurrency,omitempty"`
+
+	Routes map[string]RouteStats `json:"routes"`
 }
 
 type ServerStatsResponse struct {
-	Uptime         string    `json:"uptime"`
-	UptimeSeconds  float64   `json:"uptime_seconds"`
-	StartTime      time.Time `json:"start_time"`
-	RequestCount   int64     `json:"request_count"`
-	RateLimited    int64     `json:"rate_limited"`
-	Version        string    `json:"version"`
+	Uptime        string    `json:"uptime"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	StartTime     time.Time `json:"start_time"`
+	RequestCount  int64     `json:"request_count"`
+	RateLimited   int64     `json:"rate_limited"`
+	Version       string    `json:"version"`
 }
 
 type VehicleStatsResponse struct {
@@ -87,22 +175,36 @@ type WebSocketStatsResponse struct {
 }
 
 type CacheStatsResponse struct {
+	Hits    int64                      `json:"hits"`
+	Misses  int64                      `json:"misses"`
+	Stale   int64                      `json:"stale_served"`
+	Ratio   float64                    `json:"hit_ratio"`
+	Classes map[string]CacheClassStats `json:"classes"`
+	Redis   *cache.RedisHealth         `json:"redis,omitempty"`
+}
+
+type CacheClassStats struct {
 	Hits   int64   `json:"hits"`
 	Misses int64   `json:"misses"`
 	Ratio  float64 `json:"hit_ratio"`
 }
 
 type GoStatsResponse struct {
-	Goroutines   int    `json:"goroutines"`
-	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
-	HeapAllocMB  float64 `json:"heap_alloc_mb"`
-	NumGC        uint32 `json:"num_gc"`
-	GoVersion    string `json:"go_version"`
+	Goroutines  int     `json:"goroutines"`
+	HeapAlloc   uint64  `json:"heap_alloc_bytes"`
+	HeapAllocMB float64 `json:"heap_alloc_mb"`
+	NumGC       uint32  `json:"num_gc"`
+	GoVersion   string  `json:"go_version"`
 }
 
 func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
-	ServerStats.IncRequests()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(h.collect())
+}
 
+// collect gathers the statistics served by /stats.
+func (h *StatsHandler) collect() StatsResponse {
 	uptime := time.Since(ServerStats.startTime)
 
 	// Vehicle stats
@@ -123,6 +225,23 @@ func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 		ratio = float64(hits) / float64(total)
 	}
 
+	classes := make(map[string]CacheClassStats)
+	ServerStats.cacheByClass.Range(func(k, v any) bool {
+		c := v.(*cacheClassCounters)
+		cs := CacheClassStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+		if total := cs.Hits + cs.Misses; total > 0 {
+			cs.Ratio = float64(cs.Hits) / float64(total)
+		}
+		classes[k.(string)] = cs
+		return true
+	})
+
+	var redisHealth *cache.RedisHealth
+	if h.cache != nil {
+		health := h.cache.Health()
+		redisHealth = &health
+	}
+
 	response := StatsResponse{
 		Server: ServerStatsResponse{
 			Uptime:        uptime.Round(time.Second).String(),
@@ -150,9 +269,12 @@ func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 			MessagesOut: ServerStats.wsMessagesOut.Load(),
 		},
 		Cache: CacheStatsResponse{
-			Hits:   hits,
-			Misses: misses,
-			Ratio:  ratio,
+			Hits:    hits,
+			Misses:  misses,
+			Stale:   ServerStats.cacheStale.Load(),
+			Ratio:   ratio,
+			Classes: classes,
+			Redis:   redisHealth,
 		},
 		Go: GoStatsResponse{
 			Goroutines:  runtime.NumGoroutine(),
@@ -161,9 +283,31 @@ func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 			NumGC:       mem.NumGC,
 			GoVersion:   runtime.Version(),
 		},
+		Routes: ServerStats.RouteStats(),
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-cache")
-	json.NewEncoder(w).Encode(response)
+	if h.concurrency != nil {
+		response.Concurrency = h.concurrency.Stats()
+	}
+	if h.ingestor != nil {
+		ingestStats := h.ingestor.Stats()
+		response.Ingest = &ingestStats
+		response.Canary = h.ingestor.CanaryStats()
+	}
+	if h.hub != nil {
+		hubStats := h.hub.Stats()
+		response.Hub = &hubStats
+	}
+	if h.legacyZoom != nil {
+		legacyStats := h.legacyZoom.Stats()
+		response.LegacyZoom = &legacyStats
+	}
+	if h.fanout != nil {
+		fanoutStats := h.fanout.Stats()
+		response.Fanout = &fanoutStats
+	}
+	if h.firehose != nil {
+		firehoseStats := h.firehose.Stats()
+		response.Firehose = &firehoseStats
+	}
+	return response
 }
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+	"wabus/pkg/warsawapi"
+)
+
+// Component and overall states of the status page.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+
+	StatusOperational = "operational"
+	StatusOutage      = "outage"
+)
+
+const (
+	// statusCacheTTL is how long a computed status is served, so the
+	// rate-limit-exempt endpoint stays cheap.
+	statusCacheTTL = 10 * time.Second
+	// Fewer running vehicles than this fraction of the scheduled trips is
+	// degraded service...
+	statusMinRunningRatio = 0.5
+	// ...once at least this many trips are scheduled, so quiet night hours
+	// don't flap.
+	statusMinExpected = 10
+)
+
+// StatusHandler serves a compact summary of the service for status pages.
+type StatusHandler struct {
+	vehicles      *store.Store
+	alerts        *store.AlertStore
+	upstream      *warsawapi.Client
+	feedStale     time.Duration
+	gtfs          *store.GTFSStore
+	gtfsStaleness time.Duration
+
+	mu      sync.Mutex
+	cached  []byte
+	expires time.Time
+}
+
+// NewStatusHandler creates the status handler. Positions older than
+// feedStale make the feed degraded.
+func NewStatusHandler(vehicles *store.Store, alerts *store.AlertStore, upstream *warsawapi.Client, feedStale time.Duration) *StatusHandler {
+	return &StatusHandler{vehicles: vehicles, alerts: alerts, upstream: upstream, feedStale: feedStale}
+}
+
+// SetGTFS adds the GTFS dataset to the status and compares running vehicles
+// with the scheduled trips. Datasets older than staleness are degraded.
+func (h *StatusHandler) SetGTFS(gtfsStore *store.GTFSStore, staleness time.Duration) {
+	h.gtfs = gtfsStore
+	h.gtfsStaleness = staleness
+}
+
+type StatusResponse struct {
+	// Status is operational, degraded or outage.
+	Status   string             `json:"status"`
+	Feed     FeedStatus         `json:"feed"`
+	GTFS     *GTFSDatasetStatus `json:"gtfs,omitempty"`
+	Vehicles VehicleCountStatus `json:"vehicles"`
+	Alerts   []StatusAlert      `json:"alerts"`
+	Updated  time.Time          `json:"updated_at"`
+}
+
+// FeedStatus is the health of the live vehicle feed.
+type FeedStatus struct {
+	Status string `json:"status"`
+	// LatestPosition is the newest vehicle position held.
+	LatestPosition *time.Time `json:"latest_position,omitempty"`
+	AgeSeconds     *int       `json:"age_seconds,omitempty"`
+	// LastSuccess and BreakerState describe this instance's polling of the
+	// upstream API; followers of a leader don't poll.
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	BreakerState string     `json:"breaker_state"`
+}
+
+type GTFSDatasetStatus struct {
+	Status     string     `json:"status"`
+	LastUpdate *time.Time `json:"last_update,omitempty"`
+	AgeSeconds *int       `json:"age_seconds,omitempty"`
+}
+
+// VehicleCountStatus compares the vehicles running with the trips scheduled
+// to be en route; coupled units count once.
+type VehicleCountStatus struct {
+	Status   string   `json:"status"`
+	Running  int      `json:"running"`
+	Expected *int     `json:"expected,omitempty"`
+	Ratio    *float64 `json:"ratio,omitempty"`
+}
+
+// StatusAlert is an active manual alert, without its long description.
+type StatusAlert struct {
+	ID          string               `json:"id"`
+	Severity    domain.AlertSeverity `json:"severity"`
+	Title       string               `json:"title"`
+	URL         string               `json:"url,omitempty"`
+	Lines       []string             `json:"lines,omitempty"`
+	ActiveUntil *time.Time           `json:"active_until,omitempty"`
+}
+
+// GetStatus summarizes feed health, GTFS dataset age, running vs expected
+// vehicles and active manual alerts. The response is computed at most every
+// statusCacheTTL and may be cached publicly for as long.
+func (h *StatusHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	now := time.Now()
+	if h.cached == nil || now.After(h.expires) {
+		data, err := json.Marshal(h.build(now))
+		if err != nil {
+			h.mu.Unlock()
+			respondError(w, http.StatusInternalServerError, "failed to build status")
+			return
+		}
+		h.cached = data
+		h.expires = now.Add(statusCacheTTL)
+	}
+	data := h.cached
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=10")
+	w.Write(data)
+}
+
+func (h *StatusHandler) build(now time.Time) StatusResponse {
+	resp := StatusResponse{Alerts: make([]StatusAlert, 0), Updated: now}
+
+	var latest time.Time
+	for _, v := range h.vehicles.List(store.ListOptions{}) {
+		if v.LeadKey == "" {
+			resp.Vehicles.Running++
+		}
+		if v.Timestamp.After(latest) {
+			latest = v.Timestamp
+		}
+	}
+
+	health := h.upstream.Health()
+	resp.Feed = FeedStatus{Status: StatusOK, BreakerState: health.BreakerState}
+	if !health.LastSuccess.IsZero() {
+		resp.Feed.LastSuccess = &health.LastSuccess
+	}
+	switch {
+	case latest.IsZero():
+		resp.Feed.Status = StatusDown
+	default:
+		resp.Feed.LatestPosition = &latest
+		resp.Feed.AgeSeconds = intPtr(int(now.Sub(latest).Seconds()))
+		if now.Sub(latest) > h.feedStale {
+			resp.Feed.Status = StatusDegraded
+		}
+	}
+	if health.BreakerState == warsawapi.BreakerOpen {
+		resp.Feed.Status = StatusDown
+	}
+
+	resp.Vehicles.Status = StatusOK
+	if h.gtfs != nil {
+		stats := h.gtfs.GetStats()
+		resp.GTFS = &GTFSDatasetStatus{Status: StatusOK}
+		if !stats.IsLoaded {
+			resp.GTFS.Status = StatusDown
+		} else {
+			resp.GTFS.LastUpdate = &stats.LastUpdate
+			resp.GTFS.AgeSeconds = intPtr(int(now.Sub(stats.LastUpdate).Seconds()))
+			if now.Sub(stats.LastUpdate) > h.gtfsStaleness {
+				resp.GTFS.Status = StatusDegraded
+			}
+
+			expected := h.gtfs.CountRunningTrips(now)
+			resp.Vehicles.Expected = &expected
+			if expected > 0 {
+				ratio := math.Round(float64(resp.Vehicles.Running)/float64(expected)*100) / 100
+				resp.Vehicles.Ratio = &ratio
+				switch {
+				case resp.Vehicles.Running == 0:
+					resp.Vehicles.Status = StatusDown
+				case expected >= statusMinExpected && ratio < statusMinRunningRatio:
+					resp.Vehicles.Status = StatusDegraded
+				}
+			}
+		}
+	}
+
+	for _, a := range h.alerts.List(true, now) {
+		if a.Source != domain.AlertSourceManual {
+			continue
+		}
+		resp.Alerts = append(resp.Alerts, StatusAlert{
+			ID:          a.ID,
+			Severity:    a.Severity,
+			Title:       a.Title,
+			URL:         a.URL,
+			Lines:       a.Lines,
+			ActiveUntil: a.ActiveUntil,
+		})
+	}
+
+	resp.Status = StatusOperational
+	switch {
+	case resp.Feed.Status == StatusDown || resp.Vehicles.Status == StatusDown:
+		resp.Status = StatusOutage
+	case resp.Feed.Status != StatusOK || resp.Vehicles.Status != StatusOK ||
+		(resp.GTFS != nil && resp.GTFS.Status != StatusOK):
+		resp.Status = StatusDegraded
+	}
+	return resp
+}
+
+func intPtr(n int) *int {
+	return &n
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wabus/internal/domain"
+)
+
+// stopTimeFields are the fields ?fields= may select, in response order. The
+// last two are only set for ?at= queries.
+var stopTimeFields = []string{
+	"trip_id", "route_id", "line", "headsign", "arrival_time", "departure_time", "stop_sequence",
+	"service_date", "departure_at",
+}
+
+// stopTimeFieldCountNoAt is how many fields are returned by default without ?at=.
+const stopTimeFieldCountNoAt = 7
+
+// stopTimeProjection is the shape of a schedule response chosen with
+// ?fields= and ?compact=.
+type stopTimeProjection struct {
+	fields  []string // nil returns whole objects
+	compact bool
+}
+
+// parseStopTimeProjection reads ?fields= (a comma-separated subset of
+// stopTimeFields) and ?compact=true, which returns every stop time as an
+// array of the field values instead of an object. withAt includes the ?at=
+// fields in the default compact field list.
+func parseStopTimeProjection(r *http.Request, withAt bool) (stopTimeProjection, error) {
+	var p stopTimeProjection
+	if v := r.URL.Query().Get("compact"); v != "" {
+		compact, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid compact parameter, use true or false")
+		}
+		p.compact = compact
+	}
+
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		if p.compact {
+			p.fields = stopTimeFields[:stopTimeFieldCountNoAt]
+			if withAt {
+				p.fields = stopTimeFields
+			}
+		}
+		return p, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if !isStopTimeField(name) {
+			return p, fmt.Errorf("unknown field %q, use %s", name, strings.Join(stopTimeFields, ", "))
+		}
+		seen[name] = true
+		p.fields = append(p.fields, name)
+	}
+	if len(p.fields) == 0 {
+		return p, fmt.Errorf("fields parameter selects no fields")
+	}
+	return p, nil
+}
+
+func isStopTimeField(name string) bool {
+	for _, f := range stopTimeFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the schedule as the projection's JSON value.
+func (p stopTimeProjection) apply(schedule []*domain.StopTime) interface{} {
+	if p.fields == nil {
+		return schedule
+	}
+	if p.compact {
+		rows := make([][]interface{}, len(schedule))
+		for i, st := range schedule {
+			row := make([]interface{}, len(p.fields))
+			for j, f := range p.fields {
+				row[j] = stopTimeValue(st, f)
+			}
+			rows[i] = row
+		}
+		return rows
+	}
+	objects := make([]map[string]interface{}, len(schedule))
+	for i, st := range schedule {
+		obj := make(map[string]interface{}, len(p.fields))
+		for _, f := range p.fields {
+			obj[f] = stopTimeValue(st, f)
+		}
+		objects[i] = obj
+	}
+	return objects
+}
+
+func stopTimeValue(st *domain.StopTime, field string) interface{} {
+	switch field {
+	case "trip_id":
+		return st.TripID
+	case "route_id":
+		return st.RouteID
+	case "line":
+		return st.Line
+	case "headsign":
+		return st.Headsign
+	case "arrival_time":
+		return st.ArrivalTime
+	case "departure_time":
+		return st.DepartureTime
+	case "stop_sequence":
+		return st.StopSequence
+	case "service_date":
+		if st.ServiceDate == "" {
+			return nil
+		}
+		return st.ServiceDate
+	case "departure_at":
+		if st.DepartureAt == nil {
+			return nil
+		}
+		return st.DepartureAt
+	}
+	return nil
+}
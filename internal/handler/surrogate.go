@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Surrogate keys tag cacheable responses so a CDN can purge them selectively.
+// Every GTFS response carries SurrogateKeyGTFS and its dataset version key.
+const SurrogateKeyGTFS = "gtfs"
+
+func surrogateKeyDataset(version string) string { return "gtfs-" + version }
+func surrogateKeyLine(line string) string       { return "line-" + line }
+func surrogateKeyStop(stopID string) string     { return "stop-" + stopID }
+
+func setSurrogateKeys(w http.ResponseWriter, keys ...string) {
+	w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+}
+
+// datasetVersion identifies the loaded GTFS dataset; it changes on every update.
+func (h *GTFSHandler) datasetVersion() string {
+	return strconv.FormatInt(h.store.GetStats().LastUpdate.Unix(), 16)
+}
+
+// tagResponse sets the surrogate keys of a GTFS response.
+func (h *GTFSHandler) tagResponse(w http.ResponseWriter, keys ...string) {
+	setSurrogateKeys(w, append([]string{SurrogateKeyGTFS, surrogateKeyDataset(h.datasetVersion())}, keys...)...)
+}
+
+// tagStatic marks a response that depends only on the loaded dataset as
+// cacheable: surrogate keys, an ETag derived from the dataset version, and
+// Cache-Control. It answers 304 and returns true when the client's copy is
+// current.
+func (h *GTFSHandler) tagStatic(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	return h.tagStaticVersion(w, r, h.datasetVersion(), keys...)
+}
+
+// tagStaticVersion is tagStatic with an ETag derived from version, for
+// responses that change less often than the dataset.
+func (h *GTFSHandler) tagStaticVersion(w http.ResponseWriter, r *http.Request, version string, keys ...string) bool {
+	etag := `"` + version + `"`
+
+	h.tagResponse(w, keys...)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
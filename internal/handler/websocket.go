@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"compress/flate"
 	"context"
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
@@ -16,13 +19,60 @@ import (
 )
 
 type WSHandler struct {
-	hub    *hub.Hub
-	store  *store.Store
-	logger *slog.Logger
+	redaction
+	hub         *hub.Hub
+	store       *store.Store
+	zoom        int
+	chunkBytes  int
+	maxTiles    int
+	maxLines    int
+	compression websocket.CompressionMode
+	threshold   int           // bytes below which messages aren't compressed
+	departures  *wsDepartures // nil without GTFS
+	auth        *wsAuth       // nil: anyone may connect
+	legacyZoom  *hub.LegacyZoom
+	mobility    *store.MicromobilityStore // nil: no subscribe_micromobility
+	snapshotSeq atomic.Uint64
+	logger      *slog.Logger
 }
 
-func NewWSHandler(h *hub.Hub, s *store.Store, logger *slog.Logger) *WSHandler {
-	return &WSHandler{hub: h, store: s, logger: logger}
+// NewWSHandler creates the WS handler; zoom is the tile zoom level vehicles
+// are indexed at, used to turn client positions into tiles. Snapshots whose
+// vehicles take more than chunkBytes on the wire are split into several
+// messages; zero disables splitting.
+func NewWSHandler(h *hub.Hub, s *store.Store, zoom, chunkBytes int, logger *slog.Logger) *WSHandler {
+	return &WSHandler{hub: h, store: s, zoom: zoom, chunkBytes: chunkBytes, maxTiles: defaultWSMaxTiles, maxLines: defaultWSMaxLines, logger: logger}
+}
+
+// SetMaxTiles caps how many tiles one client may subscribe to by ID. Tiles
+// managed by set_position don't count, as their radius is bounded.
+func (h *WSHandler) SetMaxTiles(n int) {
+	if n > 0 {
+		h.maxTiles = n
+	}
+}
+
+// SetMaxLines caps how many lines one client may follow.
+func (h *WSHandler) SetMaxLines(n int) {
+	if n > 0 {
+		h.maxLines = n
+	}
+}
+
+// SetLegacyZoom accepts tile IDs at a previously configured zoom, mapped
+// onto the current one, while legacy is active.
+func (h *WSHandler) SetLegacyZoom(legacy *hub.LegacyZoom) {
+	h.legacyZoom = legacy
+}
+
+// SetCompression offers permessage-deflate to clients supporting it.
+// Messages smaller than threshold bytes are sent uncompressed; zero keeps
+// the library default for the mode.
+func (h *WSHandler) SetCompression(mode websocket.CompressionMode, threshold int) {
+	h.compression = mode
+	if threshold > 0 {
+		h.threshold = threshold
+	}
 }
 
 type WSMessage struct {
@@ -30,14 +80,36 @@ type WSMessage struct {
 	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
+// SubscribePayload subscribes to tiles. Types, when present, sets the
+// vehicle types ("bus", "tram") the connection receives in all its tiles;
+// an empty list receives every type. Lines likewise limits the vehicles
+// in its tiles to some lines. Format, when present, switches the
+// connection's snapshots and deltas to "json" or "protobuf" (binary
+// frames, see /v1/ws/schema?format=proto).
 type SubscribePayload struct {
 	TileIDs []string `json:"tileIds"`
+	Types   []string `json:"types,omitempty"`
+	Lines   []string `json:"lines,omitempty"`
+	Format  string   `json:"format,omitempty"`
 }
 
 type UnsubscribePayload struct {
 	TileIDs []string `json:"tileIds"`
 }
 
+// LinesPayload (un)subscribes lines. Vehicles of subscribed lines are
+// delivered wherever they are, in addition to those in subscribed tiles;
+// the connection's type filter applies to them too.
+type LinesPayload struct {
+	Lines []string `json:"lines"`
+}
+
+// VehiclePayload (un)subscribes one vehicle by key, or by its opaque ID in
+// privacy mode.
+type VehiclePayload struct {
+	Key string `json:"key"`
+}
+
 type SnapshotMessage struct {
 	Type    string          `json:"type"`
 	Payload SnapshotPayload `json:"payload"`
@@ -45,23 +117,101 @@ type SnapshotMessage struct {
 
 type SnapshotPayload struct {
 	Vehicles []*domain.Vehicle `json:"vehicles"`
+	Chunk    *SnapshotChunk    `json:"chunk,omitempty"`
+}
+
+// SnapshotChunk is set when a snapshot was split into several messages.
+// Chunks of one snapshot share ID and arrive in Index order; the one with
+// Final set completes it.
+type SnapshotChunk struct {
+	ID    uint64 `json:"id"`
+	Index int    `json:"index"`
+	Total int    `json:"total"`
+	Final bool   `json:"final"`
+}
+
+// rawSnapshotPayload is SnapshotPayload with pre-encoded vehicles, so chunks
+// can be sized without encoding vehicles twice.
+type rawSnapshotPayload struct {
+	Vehicles []json.RawMessage `json:"vehicles"`
+	Chunk    *SnapshotChunk    `json:"chunk,omitempty"`
 }
 
 type PongMessage struct {
 	Type string `json:"type"`
 }
 
+// SubscriptionAckPayload confirms a subscribe or unsubscribe request and
+// lists the client's tiles after it was applied.
+type SubscriptionAckPayload struct {
+	TileIDs    []string `json:"tileIds"`
+	Subscribed []string `json:"subscribed"`
+	Types      []string `json:"types,omitempty"`
+	Lines      []string `json:"lines,omitempty"`
+	Format     string   `json:"format"`
+}
+
+// LinesAckPayload confirms a subscribe_lines or unsubscribe_lines request
+// and lists the client's lines after it was applied.
+type LinesAckPayload struct {
+	Lines      []string `json:"lines"`
+	Subscribed []string `json:"subscribed"`
+}
+
+// VehicleAckPayload confirms a subscribe_vehicle or unsubscribe_vehicle
+// request and lists the vehicles the client follows after it was applied.
+type VehicleAckPayload struct {
+	Key        string   `json:"key"`
+	Subscribed []string `json:"subscribed"`
+}
+
+// ErrorPayload describes a rejected client message. RequestType is the type
+// of the rejected message, when it could be read; TileIDs lists the offending
+// tiles for invalid_tile and zoom_mismatch, Limit the limit for too_many_tiles.
+type ErrorPayload struct {
+	Code        string   `json:"code"`
+	Message     string   `json:"message"`
+	RequestType string   `json:"requestType,omitempty"`
+	TileIDs     []string `json:"tileIds,omitempty"`
+	Limit       int      `json:"limit,omitempty"`
+}
+
+// serverMessage is the envelope of all server-initiated messages other than
+// snapshot and delta.
+type serverMessage struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// ServeWS upgrades the connection. ?format=protobuf sends snapshots and
+// deltas as binary frames from the start.
 func (h *WSHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
+	if h.auth != nil && !h.auth.authorize(w, r) {
+		return
+	}
+	format := hub.FormatJSON
+	if v := r.URL.Query().Get("format"); v != "" {
+		var ok bool
+		if format, ok = hub.ParseFormat(v); !ok {
+			respondError(w, http.StatusBadRequest, "invalid format, use json or protobuf")
+			return
+		}
+	}
+
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
+		OriginPatterns:       []string{"*"},
+		CompressionMode:      h.compression,
+		CompressionThreshold: h.threshold,
 	})
 	if err != nil {
-		h.logger.Error("websocket accept failed", "error", err)
+		h.logger.ErrorContext(r.Context(), "websocket accept failed", "error", err)
 		return
 	}
 
 	clientID := uuid.New().String()
 	client := hub.NewClient(clientID, 256)
+	client.SetFormat(format)
+	client.SetCompressed(strings.Contains(w.Header().Get("Sec-WebSocket-Extensions"), "permessage-deflate"))
 
 	h.hub.Register(client)
 
@@ -79,11 +229,13 @@ func (h *WSHandler) readLoop(ctx context.Context, conn *websocket.Conn, client *
 		conn.Close(websocket.StatusNormalClosure, "")
 	}()
 
+	session := newWSSession()
+
 	for {
 		msgType, data, err := conn.Read(ctx)
 		if err != nil {
 			if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
-				h.logger.Debug("websocket read error", "client_id", client.ID, "error", err)
+				h.logger.DebugContext(ctx, "websocket read error", "client_id", client.ID, "error", err)
 			}
 			return
 		}
@@ -94,7 +246,8 @@ func (h *WSHandler) readLoop(ctx context.Context, conn *websocket.Conn, client *
 
 		var msg WSMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
-			h.logger.Debug("invalid message format", "client_id", client.ID, "error", err)
+			h.logger.DebugContext(ctx, "invalid message format", "client_id", client.ID, "error", err)
+			h.sendError(client, "", &wsError{code: wsErrInvalidMessage, message: "message is not valid JSON"})
 			continue
 		}
 
@@ -102,24 +255,155 @@ func (h *WSHandler) readLoop(ctx context.Context, conn *websocket.Conn, client *
 		case "subscribe":
 			var payload SubscribePayload
 			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				h.sendError(client, msg.Type, &wsError{code: wsErrInvalidPayload, message: "subscribe payload is malformed"})
+				continue
+			}
+			tiles, wsErr := h.validateTileIDs(payload.TileIDs)
+			if wsErr == nil {
+				wsErr = h.checkTileLimit(session, tiles)
+			}
+			var types []domain.VehicleType
+			if wsErr == nil && payload.Types != nil {
+				types, wsErr = parseVehicleTypes(payload.Types)
+			}
+			var lines []string
+			if wsErr == nil && payload.Lines != nil {
+				lines, wsErr = h.parseLineFilter(payload.Lines)
+			}
+			format := client.Format()
+			if wsErr == nil && payload.Format != "" {
+				format, wsErr = parseFormat(payload.Format)
+			}
+			if wsErr != nil {
+				h.sendError(client, msg.Type, wsErr)
 				continue
 			}
-			if len(payload.TileIDs) > 0 {
-				h.hub.Subscribe(client, payload.TileIDs)
-				h.sendSnapshot(client, payload.TileIDs)
+			client.SetFormat(format)
+			typesChanged := payload.Types != nil && setClientTypes(client, types)
+			if payload.Lines != nil && setClientLineFilter(client, lines) {
+				typesChanged = true
 			}
+			for _, id := range tiles {
+				session.manual[id] = struct{}{}
+			}
+			h.hub.Subscribe(client, tiles)
+			h.sendAck(client, "subscribed", tiles)
+			if typesChanged {
+				// The filters apply to tiles subscribed earlier as well.
+				h.sendSnapshot(client, client.GetTiles())
+			} else {
+				h.sendSnapshot(client, tiles)
+			}
+			h.sendMicromobility(client)
 
 		case "unsubscribe":
 			var payload UnsubscribePayload
 			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				h.sendError(client, msg.Type, &wsError{code: wsErrInvalidPayload, message: "unsubscribe payload is malformed"})
 				continue
 			}
-			if len(payload.TileIDs) > 0 {
-				h.hub.Unsubscribe(client, payload.TileIDs)
+			ids, wsErr := h.validateTileIDs(payload.TileIDs)
+			if wsErr != nil {
+				h.sendError(client, msg.Type, wsErr)
+				continue
 			}
+			// Tiles also covered by set_position stay subscribed.
+			var tiles []string
+			for _, id := range ids {
+				delete(session.manual, id)
+				if !session.positionOwns(id) {
+					tiles = append(tiles, id)
+				}
+			}
+			h.hub.Unsubscribe(client, tiles)
+			h.sendAck(client, "unsubscribed", ids)
+			h.sendMicromobility(client)
+
+		case "subscribe_lines":
+			var payload LinesPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				h.sendError(client, msg.Type, &wsError{code: wsErrInvalidPayload, message: "subscribe_lines payload is malformed"})
+				continue
+			}
+			lines, wsErr := validateLines(payload.Lines)
+			if wsErr == nil {
+				wsErr = h.checkLineLimit(client, lines)
+			}
+			if wsErr != nil {
+				h.sendError(client, msg.Type, wsErr)
+				continue
+			}
+			h.hub.SubscribeLines(client, lines)
+			h.sendLinesAck(client, "lines_subscribed", lines)
+			h.sendVehicles(client, acceptedVehicles(client, h.store.SnapshotForLines(lines)))
+
+		case "unsubscribe_lines":
+			var payload LinesPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				h.sendError(client, msg.Type, &wsError{code: wsErrInvalidPayload, message: "unsubscribe_lines payload is malformed"})
+				continue
+			}
+			lines, wsErr := validateLines(payload.Lines)
+			if wsErr != nil {
+				h.sendError(client, msg.Type, wsErr)
+				continue
+			}
+			h.hub.UnsubscribeLines(client, lines)
+			h.sendLinesAck(client, "lines_unsubscribed", lines)
+
+		case "subscribe_vehicle":
+			var payload VehiclePayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.Key == "" {
+				h.sendError(client, msg.Type, &wsError{code: wsErrInvalidPayload, message: "subscribe_vehicle payload needs a key"})
+				continue
+			}
+			vehicle, wsErr := h.lookupVehicle(payload.Key)
+			if wsErr == nil {
+				wsErr = checkVehicleLimit(client, payload.Key)
+			}
+			if wsErr != nil {
+				h.sendError(client, msg.Type, wsErr)
+				continue
+			}
+			h.hub.SubscribeVehicle(client, payload.Key)
+			h.sendVehicleAck(client, "vehicle_subscribed", payload.Key)
+			h.sendVehicles(client, []*domain.Vehicle{vehicle})
+
+		case "unsubscribe_vehicle":
+			var payload VehiclePayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.Key == "" {
+				h.sendError(client, msg.Type, &wsError{code: wsErrInvalidPayload, message: "unsubscribe_vehicle payload needs a key"})
+				continue
+			}
+			h.hub.UnsubscribeVehicle(client, payload.Key)
+			h.sendVehicleAck(client, "vehicle_unsubscribed", payload.Key)
+
+		case "subscribe_stop":
+			h.handleSubscribeStops(client, msg.Payload)
+
+		case "unsubscribe_stop":
+			h.handleUnsubscribeStops(client, msg.Payload)
+
+		case "subscribe_micromobility":
+			h.handleSubscribeMicromobility(client)
+
+		case "unsubscribe_micromobility":
+			h.handleUnsubscribeMicromobility(client)
+
+		case "set_position":
+			h.handleSetPosition(client, session, msg.Payload)
+
+		case "clear_position":
+			h.handleClearPosition(client, session)
+
+		case "set_update_rate":
+			h.handleSetUpdateRate(client, msg.Payload)
 
 		case "ping":
 			h.sendPong(client)
+
+		default:
+			h.sendError(client, msg.Type, &wsError{code: wsErrUnknownType, message: "unknown message type: " + msg.Type})
 		}
 	}
 }
@@ -133,12 +417,23 @@ func (h *WSHandler) writeLoop(ctx context.Context, conn *websocket.Conn, client
 		case <-ctx.Done():
 			return
 
+		case <-client.Evicted():
+			h.logger.InfoContext(ctx, "closing slow websocket client", "client_id", client.ID)
+			conn.Close(websocket.StatusPolicyViolation, "send buffer full")
+			return
+
 		case msg, ok := <-client.Send:
 			if !ok {
 				return
 			}
+			// JSON messages are objects; binary encodings never start
+			// with '{'.
+			msgType := websocket.MessageText
+			if len(msg) > 0 && msg[0] != '{' {
+				msgType = websocket.MessageBinary
+			}
 			writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			err := conn.Write(writeCtx, websocket.MessageText, msg)
+			err := conn.Write(writeCtx, msgType, msg)
 			cancel()
 			if err != nil {
 				return
@@ -157,14 +452,166 @@ func (h *WSHandler) writeLoop(ctx context.Context, conn *websocket.Conn, client
 
 func (h *WSHandler) sendSnapshot(client *hub.Client, tileIDs []string) {
 	vehicles := h.store.SnapshotForTiles(tileIDs)
+	accepted := vehicles[:0]
+	for _, v := range vehicles {
+		if client.AcceptsInTile(v.Type, v.Line) {
+			accepted = append(accepted, v)
+		}
+	}
+	h.sendVehicles(client, accepted)
+}
 
-	msg := SnapshotMessage{
-		Type: "snapshot",
-		Payload: SnapshotPayload{
-			Vehicles: vehicles,
-		},
+// acceptedVehicles returns the vehicles passing the client's type filter.
+func acceptedVehicles(client *hub.Client, vehicles []*domain.Vehicle) []*domain.Vehicle {
+	accepted := vehicles[:0]
+	for _, v := range vehicles {
+		if client.Accepts(v.Type) {
+			accepted = append(accepted, v)
+		}
+	}
+	return accepted
+}
+
+// sendVehicles sends vehicles as a snapshot in the client's format, split
+// into chunks if needed.
+func (h *WSHandler) sendVehicles(client *hub.Client, vehicles []*domain.Vehicle) {
+	binary := client.Format() == hub.FormatProtobuf
+	encoded := make([]json.RawMessage, 0, len(vehicles))
+	for _, v := range vehicles {
+		// The hub only carries redacted deltas, so snapshots are redacted
+		// for every client alike.
+		if h.redactor != nil {
+			v = h.redactor.Vehicle(v)
+		}
+		if binary {
+			encoded = append(encoded, hub.MarshalVehicleProto(v))
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		encoded = append(encoded, data)
+	}
+
+	chunks := splitSnapshot(encoded, h.chunkBytes, client.Compressed())
+	var id uint64
+	if len(chunks) > 1 {
+		id = h.snapshotSeq.Add(1)
+	}
+
+	for i, chunk := range chunks {
+		var data []byte
+		if binary {
+			vehicles := make([][]byte, len(chunk))
+			for j, v := range chunk {
+				vehicles[j] = v
+			}
+			data = hub.MarshalSnapshotProto(vehicles, id, i, len(chunks))
+		} else {
+			payload := rawSnapshotPayload{Vehicles: chunk}
+			if len(chunks) > 1 {
+				payload.Chunk = &SnapshotChunk{ID: id, Index: i, Total: len(chunks), Final: i == len(chunks)-1}
+			}
+			var err error
+			if data, err = json.Marshal(serverMessage{Type: "snapshot", Payload: payload}); err != nil {
+				return
+			}
+		}
+
+		select {
+		case client.Send <- data:
+			continue
+		default:
+		}
+		// A split snapshot waits for the writer to make room, so that it
+		// arrives whole even when larger than the send buffer.
+		if len(chunks) > 1 && waitSend(client, data) {
+			continue
+		}
+		h.logger.Debug("failed to send snapshot, buffer full", "client_id", client.ID, "chunk", i, "chunks", len(chunks))
+		return
+	}
+}
+
+// snapshotChunkWait bounds how long a snapshot chunk waits for room in the
+// client's send buffer.
+const snapshotChunkWait = 5 * time.Second
+
+// waitSend queues data once the client's send buffer has room, giving up
+// after snapshotChunkWait or when the client is evicted.
+func waitSend(client *hub.Client, data []byte) bool {
+	timer := time.NewTimer(snapshotChunkWait)
+	defer timer.Stop()
+	select {
+	case client.Send <- data:
+		return true
+	case <-client.Evicted():
+	case <-timer.C:
+	}
+	return false
+}
+
+// maxDeflateRatio bounds how much deflate is assumed to shrink a snapshot
+// when sizing chunks, so that a chunk still decodes in bounded memory on the
+// client however well it compresses.
+const maxDeflateRatio = 4
+
+// splitSnapshot groups encoded vehicles into chunks of at most maxBytes each
+// on the wire. On compressed connections, vehicles count at the size deflate
+// leaves of the whole snapshot, as a frame of a deflated message carries the
+// compressed bytes. A single vehicle larger than maxBytes gets a chunk of its
+// own. It always returns at least one (possibly empty) chunk.
+func splitSnapshot(vehicles []json.RawMessage, maxBytes int, compressed bool) [][]json.RawMessage {
+	if maxBytes <= 0 {
+		return [][]json.RawMessage{vehicles}
 	}
+	if compressed {
+		var raw int
+		for _, v := range vehicles {
+			raw += len(v) + 1
+		}
+		if raw <= maxBytes {
+			return [][]json.RawMessage{vehicles}
+		}
+		ratio := min(float64(raw)/float64(max(deflatedSize(vehicles), 1)), maxDeflateRatio)
+		maxBytes = int(float64(maxBytes) * max(ratio, 1))
+	}
+
+	var chunks [][]json.RawMessage
+	start, size := 0, 0
+	for i, v := range vehicles {
+		if i > start && size+len(v) > maxBytes {
+			chunks = append(chunks, vehicles[start:i])
+			start, size = i, 0
+		}
+		size += len(v) + 1 // separating comma
+	}
+	return append(chunks, vehicles[start:])
+}
+
+// deflatedSize is how many bytes permessage-deflate, which compresses at
+// flate.BestSpeed, makes of the vehicles.
+func deflatedSize(vehicles []json.RawMessage) int {
+	var n byteCounter
+	fw, _ := flate.NewWriter(&n, flate.BestSpeed)
+	for _, v := range vehicles {
+		fw.Write(v)
+		fw.Write([]byte{','})
+	}
+	fw.Close()
+	return int(n)
+}
+
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
 
+func (h *WSHandler) sendPong(client *hub.Client) {
+	msg := PongMessage{Type: "pong"}
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return
@@ -173,12 +620,50 @@ func (h *WSHandler) sendSnapshot(client *hub.Client, tileIDs []string) {
 	select {
 	case client.Send <- data:
 	default:
-		h.logger.Debug("failed to send snapshot, buffer full", "client_id", client.ID)
 	}
 }
 
-func (h *WSHandler) sendPong(client *hub.Client) {
-	msg := PongMessage{Type: "pong"}
+func (h *WSHandler) sendAck(client *hub.Client, ackType string, tileIDs []string) {
+	h.sendMessage(client, serverMessage{
+		Type: ackType,
+		Payload: SubscriptionAckPayload{
+			TileIDs:    tileIDs,
+			Subscribed: client.GetTiles(),
+			Types:      typeNames(client.GetTypes()),
+			Lines:      client.GetLineFilter(),
+			Format:     client.Format().String(),
+		},
+	})
+}
+
+func (h *WSHandler) sendLinesAck(client *hub.Client, ackType string, lines []string) {
+	h.sendMessage(client, serverMessage{
+		Type:    ackType,
+		Payload: LinesAckPayload{Lines: lines, Subscribed: client.GetLines()},
+	})
+}
+
+func (h *WSHandler) sendVehicleAck(client *hub.Client, ackType, key string) {
+	h.sendMessage(client, serverMessage{
+		Type:    ackType,
+		Payload: VehicleAckPayload{Key: key, Subscribed: client.GetVehicles()},
+	})
+}
+
+func (h *WSHandler) sendError(client *hub.Client, requestType string, e *wsError) {
+	h.sendMessage(client, serverMessage{
+		Type: "error",
+		Payload: ErrorPayload{
+			Code:        e.code,
+			Message:     e.message,
+			RequestType: requestType,
+			TileIDs:     e.tileIDs,
+			Limit:       e.limit,
+		},
+	})
+}
+
+func (h *WSHandler) sendMessage(client *hub.Client, msg serverMessage) {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return
@@ -187,5 +672,6 @@ func (h *WSHandler) sendPong(client *hub.Client) {
 	select {
 	case client.Send <- data:
 	default:
+		h.logger.Debug("failed to send message, buffer full", "client_id", client.ID, "type", msg.Type)
 	}
 }
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wsAuth holds the tokens WS clients may connect with.
+type wsAuth struct {
+	tokens  [][]byte
+	secrets [][]byte
+}
+
+// SetAuth requires WS clients to present a token, either as
+// "Authorization: Bearer <token>" or, for browsers, as ?token=. A token is
+// accepted if it is one of tokens, or a signed token "<expiry>.<signature>"
+// where expiry is a Unix time in the future and signature the hex
+// HMAC-SHA256 of expiry under one of secrets. Several secrets allow
+// rotating them. Without tokens and secrets, anyone may connect.
+func (h *WSHandler) SetAuth(tokens, secrets []string) {
+	if len(tokens) == 0 && len(secrets) == 0 {
+		h.auth = nil
+		return
+	}
+	auth := &wsAuth{}
+	for _, t := range tokens {
+		auth.tokens = append(auth.tokens, []byte(t))
+	}
+	for _, s := range secrets {
+		auth.secrets = append(auth.secrets, []byte(s))
+	}
+	h.auth = auth
+}
+
+// authorize reports whether r carries an accepted token, answering 401 if
+// it doesn't.
+func (a *wsAuth) authorize(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+
+	switch a.check(token, time.Now()) {
+	case tokenValid:
+		return true
+	case tokenExpired:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ws", error="invalid_token"`)
+		respondErrorCode(w, http.StatusUnauthorized, errCodeTokenExpired, "token expired")
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ws"`)
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+	}
+	return false
+}
+
+type tokenResult int
+
+const (
+	tokenInvalid tokenResult = iota
+	tokenValid
+	tokenExpired
+)
+
+func (a *wsAuth) check(token string, now time.Time) tokenResult {
+	if token == "" {
+		return tokenInvalid
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), t) == 1 {
+			return tokenValid
+		}
+	}
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return tokenInvalid
+	}
+	expiry, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return tokenInvalid
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil {
+		return tokenInvalid
+	}
+	for _, secret := range a.secrets {
+		if !hmac.Equal(mac, wsTokenMAC(secret, payload)) {
+			continue
+		}
+		if now.Unix() >= expiry {
+			return tokenExpired
+		}
+		return tokenValid
+	}
+	return tokenInvalid
+}
+
+func wsTokenMAC(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
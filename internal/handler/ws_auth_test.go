@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedToken(secret string, expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + hex.EncodeToString(wsTokenMAC([]byte(secret), payload))
+}
+
+func TestWSAuthCheck(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	h := &WSHandler{}
+	// "old" is still accepted while clients move to tokens signed by "new".
+	h.SetAuth([]string{"static-token"}, []string{"new", "old"})
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  tokenResult
+	}{
+		{"empty", "", tokenInvalid},
+		{"static", "static-token", tokenValid},
+		{"static prefix", "static", tokenInvalid},
+		{"signed", signedToken("new", now.Add(time.Hour)), tokenValid},
+		{"signed by rotated secret", signedToken("old", now.Add(time.Hour)), tokenValid},
+		{"signed by unknown secret", signedToken("other", now.Add(time.Hour)), tokenInvalid},
+		{"expired", signedToken("new", now.Add(-time.Second)), tokenExpired},
+		{"expiring now", signedToken("new", now), tokenExpired},
+		{"expired with unknown secret", signedToken("other", now.Add(-time.Hour)), tokenInvalid},
+		{"expiry tampered", strconv.FormatInt(now.Add(24*time.Hour).Unix(), 10) + "." + hex.EncodeToString(wsTokenMAC([]byte("new"), strconv.FormatInt(now.Add(time.Hour).Unix(), 10))), tokenInvalid},
+		{"expiry not a number", "soon." + hex.EncodeToString(wsTokenMAC([]byte("new"), "soon")), tokenInvalid},
+		{"signature not hex", strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + ".zz", tokenInvalid},
+		{"no signature", strconv.FormatInt(now.Add(time.Hour).Unix(), 10), tokenInvalid},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := h.auth.check(tc.token, now); got != tc.want {
+				t.Errorf("check(%q) = %d, want %d", tc.token, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWSAuthAuthorize(t *testing.T) {
+	h := &WSHandler{}
+	h.SetAuth([]string{"static-token"}, []string{"secret"})
+	expired := signedToken("secret", time.Now().Add(-time.Minute))
+
+	for _, tc := range []struct {
+		name     string
+		header   string
+		query    string
+		wantOK   bool
+		wantCode string
+	}{
+		{name: "header", header: "Bearer static-token", wantOK: true},
+		{name: "query", query: "static-token", wantOK: true},
+		{name: "header wins over query", header: "Bearer wrong", query: "static-token"},
+		{name: "non-bearer header falls back to query", header: "Basic abc", query: "static-token", wantOK: true},
+		{name: "missing"},
+		{name: "expired", header: "Bearer " + expired, wantCode: errCodeTokenExpired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/ws", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			if tc.query != "" {
+				r.URL.RawQuery = "token=" + tc.query
+			}
+			w := httptest.NewRecorder()
+			if ok := h.auth.authorize(w, r); ok != tc.wantOK {
+				t.Fatalf("authorize = %v, want %v", ok, tc.wantOK)
+			}
+			if tc.wantOK {
+				return
+			}
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("got status %d, want 401", w.Code)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate challenge")
+			}
+			var body errorResponse
+			json.NewDecoder(w.Body).Decode(&body)
+			if body.Code != tc.wantCode {
+				t.Errorf("got error code %q, want %q", body.Code, tc.wantCode)
+			}
+		})
+	}
+}
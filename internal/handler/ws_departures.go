@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"wabus/internal/hub"
+	"wabus/internal/prediction"
+	"wabus/internal/store"
+)
+
+const (
+	// maxWSStops caps the stops whose departures a client receives.
+	maxWSStops = 10
+	// wsBoardSize is how many departures a pushed board lists, as
+	// /v1/stops/{id}/departures does by default.
+	wsBoardSize = 10
+)
+
+// StopsPayload (un)subscribes the departure boards of stops.
+type StopsPayload struct {
+	StopIDs []string `json:"stopIds"`
+}
+
+// StopsAckPayload confirms a subscribe_stop or unsubscribe_stop request and
+// lists the client's stops after it was applied.
+type StopsAckPayload struct {
+	StopIDs    []string `json:"stopIds"`
+	Subscribed []string `json:"subscribed"`
+}
+
+// DeparturesPayload is the departure board of a stop, listed as by
+// /v1/stops/{id}/departures. It replaces the previous board of the stop.
+type DeparturesPayload struct {
+	StopID     string          `json:"stopId"`
+	StopName   string          `json:"stopName"`
+	Departures []StopDeparture `json:"departures"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+}
+
+// wsDepartures builds the departure boards pushed to stop subscribers and
+// remembers the last board pushed per stop, so unchanged boards aren't sent
+// again.
+type wsDepartures struct {
+	predictor *prediction.Predictor
+	gtfsStore *store.GTFSStore
+
+	mu     sync.Mutex
+	pushed map[string][]byte // stop ID -> encoded departures last pushed
+}
+
+// SetDepartures enables subscribe_stop, pushing the departure boards of
+// subscribed stops after every prediction update.
+func (h *WSHandler) SetDepartures(predictor *prediction.Predictor, gtfsStore *store.GTFSStore) {
+	h.departures = &wsDepartures{predictor: predictor, gtfsStore: gtfsStore, pushed: make(map[string][]byte)}
+}
+
+func (h *WSHandler) handleSubscribeStops(client *hub.Client, data json.RawMessage) {
+	const requestType = "subscribe_stop"
+	if h.departures == nil {
+		h.sendError(client, requestType, &wsError{code: wsErrFeatureDisabled, message: "departures are unavailable without GTFS"})
+		return
+	}
+	var payload StopsPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		h.sendError(client, requestType, &wsError{code: wsErrInvalidPayload, message: "subscribe_stop payload is malformed"})
+		return
+	}
+	stopIDs, wsErr := validateStopIDs(payload.StopIDs)
+	if wsErr == nil {
+		wsErr = h.checkStops(client, stopIDs)
+	}
+	if wsErr != nil {
+		h.sendError(client, requestType, wsErr)
+		return
+	}
+
+	h.hub.SubscribeStops(client, stopIDs)
+	h.sendStopsAck(client, "stops_subscribed", stopIDs)
+	now := time.Now()
+	h.departures.mu.Lock()
+	defer h.departures.mu.Unlock()
+	for _, id := range stopIDs {
+		board, ok := h.stopBoard(id, now)
+		if !ok {
+			continue
+		}
+		h.sendMessage(client, serverMessage{Type: "departures", Payload: board})
+		// A stop nobody subscribed to at the last push isn't pushed again
+		// until its board changes from this one.
+		if _, pushed := h.departures.pushed[id]; !pushed {
+			if encoded, err := json.Marshal(board.Departures); err == nil {
+				h.departures.pushed[id] = encoded
+			}
+		}
+	}
+}
+
+func (h *WSHandler) handleUnsubscribeStops(client *hub.Client, data json.RawMessage) {
+	const requestType = "unsubscribe_stop"
+	var payload StopsPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		h.sendError(client, requestType, &wsError{code: wsErrInvalidPayload, message: "unsubscribe_stop payload is malformed"})
+		return
+	}
+	stopIDs, wsErr := validateStopIDs(payload.StopIDs)
+	if wsErr != nil {
+		h.sendError(client, requestType, wsErr)
+		return
+	}
+	h.hub.UnsubscribeStops(client, stopIDs)
+	h.sendStopsAck(client, "stops_unsubscribed", stopIDs)
+}
+
+// checkStops rejects a subscribe_stop naming unknown stops or taking the
+// client past the stops it may subscribe to.
+func (h *WSHandler) checkStops(client *hub.Client, stopIDs []string) *wsError {
+	for _, id := range stopIDs {
+		if _, ok := h.departures.gtfsStore.GetStopByID(id); !ok {
+			return &wsError{code: wsErrStopNotFound, message: "stop not found: " + id}
+		}
+	}
+	current := client.GetStops()
+	have := make(map[string]struct{}, len(current))
+	for _, id := range current {
+		have[id] = struct{}{}
+	}
+	total := len(current)
+	for _, id := range stopIDs {
+		if _, ok := have[id]; !ok {
+			total++
+		}
+	}
+	if total <= maxWSStops {
+		return nil
+	}
+	return &wsError{
+		code:    wsErrTooManyStops,
+		message: fmt.Sprintf("subscription would cover %d stops, the limit is %d", total, maxWSStops),
+		limit:   maxWSStops,
+	}
+}
+
+// PushDepartures sends the departure board of every subscribed stop whose
+// departures changed since they were last pushed, e.g. because a vehicle
+// serving the stop reported a new delay or a departure left.
+func (h *WSHandler) PushDepartures(at time.Time) {
+	if h.departures == nil {
+		return
+	}
+	h.departures.mu.Lock()
+	defer h.departures.mu.Unlock()
+
+	pushed := make(map[string][]byte)
+	for _, id := range h.hub.SubscribedStops() {
+		board, ok := h.stopBoard(id, at)
+		if !ok {
+			continue
+		}
+		encoded, err := json.Marshal(board.Departures)
+		if err != nil {
+			continue
+		}
+		pushed[id] = encoded
+		if bytes.Equal(h.departures.pushed[id], encoded) {
+			continue
+		}
+		data, err := json.Marshal(serverMessage{Type: "departures", Payload: board})
+		if err != nil {
+			continue
+		}
+		h.hub.SendStop(id, data)
+	}
+	// Stops nobody subscribes to any more are forgotten.
+	h.departures.pushed = pushed
+}
+
+// stopBoard builds the departure board of a stop as of at. Vehicle keys
+// are redacted in privacy mode, as the hub's deltas are.
+func (h *WSHandler) stopBoard(id string, at time.Time) (DeparturesPayload, bool) {
+	stop, ok := h.departures.gtfsStore.GetStopByID(id)
+	if !ok {
+		return DeparturesPayload{}, false
+	}
+	departures := departureBoard(h.departures.predictor, h.departures.gtfsStore, id, "", wsBoardSize, at)
+	if h.redactor != nil {
+		for i := range departures {
+			if departures[i].VehicleKey != "" {
+				departures[i].VehicleKey, departures[i].VehicleNumber = h.redactor.ID(departures[i].VehicleKey), ""
+			}
+		}
+	}
+	return DeparturesPayload{StopID: id, StopName: stop.Name, Departures: departures, UpdatedAt: at}, true
+}
+
+func (h *WSHandler) sendStopsAck(client *hub.Client, ackType string, stopIDs []string) {
+	h.sendMessage(client, serverMessage{
+		Type:    ackType,
+		Payload: StopsAckPayload{StopIDs: stopIDs, Subscribed: client.GetStops()},
+	})
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/hub"
+	"wabus/internal/store"
+)
+
+// MicromobilityPayload is the micromobility layer of a client's tiles: the
+// bike-share stations and free vehicles in them. It replaces the previous
+// layer.
+type MicromobilityPayload struct {
+	Stations  []*domain.MicromobilityStation `json:"stations"`
+	Vehicles  []*domain.MicromobilityVehicle `json:"vehicles"`
+	UpdatedAt time.Time                      `json:"updatedAt"`
+}
+
+// SetMicromobility enables subscribe_micromobility, serving the layer from s.
+func (h *WSHandler) SetMicromobility(s *store.MicromobilityStore) {
+	h.mobility = s
+}
+
+func (h *WSHandler) handleSubscribeMicromobility(client *hub.Client) {
+	if h.mobility == nil {
+		h.sendError(client, "subscribe_micromobility", &wsError{code: wsErrFeatureDisabled, message: "micromobility is disabled on this server"})
+		return
+	}
+	h.hub.SubscribeMicromobility(client)
+	h.sendMessage(client, serverMessage{Type: "micromobility_subscribed"})
+	h.sendMicromobility(client)
+}
+
+func (h *WSHandler) handleUnsubscribeMicromobility(client *hub.Client) {
+	h.hub.UnsubscribeMicromobility(client)
+	h.sendMessage(client, serverMessage{Type: "micromobility_unsubscribed"})
+}
+
+// sendMicromobility sends the layer of the client's current tiles if it
+// receives the layer, e.g. after its tiles changed.
+func (h *WSHandler) sendMicromobility(client *hub.Client) {
+	if h.mobility == nil || !client.Micromobility() {
+		return
+	}
+	h.sendMessage(client, serverMessage{Type: "micromobility", Payload: h.micromobilityLayer(client, time.Now())})
+}
+
+// PushMicromobility sends every client receiving the micromobility layer
+// the layer of its tiles, after the store was refreshed.
+func (h *WSHandler) PushMicromobility() {
+	if h.mobility == nil {
+		return
+	}
+	now := time.Now()
+	for _, client := range h.hub.MicromobilityClients() {
+		data, err := json.Marshal(serverMessage{Type: "micromobility", Payload: h.micromobilityLayer(client, now)})
+		if err != nil {
+			continue
+		}
+		h.hub.Send(client, data)
+	}
+}
+
+func (h *WSHandler) micromobilityLayer(client *hub.Client, at time.Time) MicromobilityPayload {
+	tiles := make(map[string]struct{})
+	for _, id := range client.GetTiles() {
+		tiles[id] = struct{}{}
+	}
+	filter := store.MicromobilityFilter{Tiles: tiles}
+	return MicromobilityPayload{
+		Stations:  h.mobility.Stations(filter),
+		Vehicles:  h.mobility.Vehicles(filter),
+		UpdatedAt: at,
+	}
+}
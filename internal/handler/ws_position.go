@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+
+	"wabus/internal/domain"
+	"wabus/internal/hub"
+	"wabus/pkg/geo"
+)
+
+const (
+	defaultPositionRadius = 1000.0 // meters
+	minPositionRadius     = 100.0
+	maxPositionRadius     = 5000.0
+
+	// A new position only changes the subscription once the client has moved
+	// this fraction of the radius away from where tiles were last computed.
+	positionMoveFraction = 0.25
+	// Tiles already subscribed are kept until they are this many radii away,
+	// so a client moving along a tile edge doesn't flap between tiles.
+	positionKeepFactor = 1.5
+)
+
+// SetPositionPayload follows a position. Types and Lines work as in
+// SubscribePayload.
+type SetPositionPayload struct {
+	Lat    *float64 `json:"lat"`
+	Lon    *float64 `json:"lon"`
+	Radius float64  `json:"radius,omitempty"`
+	Types  []string `json:"types,omitempty"`
+	Lines  []string `json:"lines,omitempty"`
+}
+
+// PositionAckPayload reports the tiles a set_position or clear_position
+// changed, and the client's full tile set afterwards.
+type PositionAckPayload struct {
+	Lat        float64  `json:"lat,omitempty"`
+	Lon        float64  `json:"lon,omitempty"`
+	Radius     float64  `json:"radius,omitempty"`
+	Added      []string `json:"added"`
+	Removed    []string `json:"removed"`
+	Subscribed []string `json:"subscribed"`
+	Types      []string `json:"types,omitempty"`
+	Lines      []string `json:"lines,omitempty"`
+}
+
+// wsSession is the per-connection subscription state. Tiles subscribed
+// explicitly and tiles managed by set_position are tracked apart so that
+// moving never drops a tile the client asked for by ID.
+type wsSession struct {
+	manual   map[string]struct{}
+	position *positionSubscription
+}
+
+type positionSubscription struct {
+	lat, lon, radius float64
+	tiles            map[string]struct{}
+}
+
+func newWSSession() *wsSession {
+	return &wsSession{manual: make(map[string]struct{})}
+}
+
+func (s *wsSession) positionOwns(tileID string) bool {
+	if s.position == nil {
+		return false
+	}
+	_, ok := s.position.tiles[tileID]
+	return ok
+}
+
+func (h *WSHandler) handleSetPosition(client *hub.Client, session *wsSession, raw json.RawMessage) {
+	var payload SetPositionPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		h.sendError(client, "set_position", &wsError{code: wsErrInvalidPayload, message: "set_position payload is malformed"})
+		return
+	}
+	if payload.Lat == nil || payload.Lon == nil {
+		h.sendError(client, "set_position", &wsError{code: wsErrInvalidPayload, message: "lat and lon are required"})
+		return
+	}
+	lat, lon := *payload.Lat, *payload.Lon
+	if !domain.ServiceArea.Contains(lat, lon) {
+		h.sendError(client, "set_position", &wsError{code: wsErrInvalidPayload, message: "lat/lon outside the service area"})
+		return
+	}
+	var types []domain.VehicleType
+	if payload.Types != nil {
+		var wsErr *wsError
+		if types, wsErr = parseVehicleTypes(payload.Types); wsErr != nil {
+			h.sendError(client, "set_position", wsErr)
+			return
+		}
+	}
+	var lines []string
+	if payload.Lines != nil {
+		var wsErr *wsError
+		if lines, wsErr = h.parseLineFilter(payload.Lines); wsErr != nil {
+			h.sendError(client, "set_position", wsErr)
+			return
+		}
+	}
+	// A changed filter applies to tiles subscribed earlier as well.
+	resnapshot := payload.Types != nil && setClientTypes(client, types)
+	if payload.Lines != nil && setClientLineFilter(client, lines) {
+		resnapshot = true
+	}
+	if payload.Radius == 0 {
+		payload.Radius = defaultPositionRadius
+	}
+	payload.Radius = math.Max(minPositionRadius, math.Min(payload.Radius, maxPositionRadius))
+
+	prev := session.position
+	if prev != nil && prev.radius == payload.Radius &&
+		geo.Distance(prev.lat, prev.lon, lat, lon) < payload.Radius*positionMoveFraction {
+		h.sendPositionAck(client, prev, nil, nil)
+		if resnapshot {
+			h.sendSnapshot(client, client.GetTiles())
+		}
+		return
+	}
+
+	next := &positionSubscription{
+		lat:    lat,
+		lon:    lon,
+		radius: payload.Radius,
+		tiles:  make(map[string]struct{}),
+	}
+	for _, id := range hub.TilesInRadius(lat, lon, payload.Radius, h.zoom) {
+		next.tiles[id] = struct{}{}
+	}
+
+	var added, removed []string
+	if prev != nil {
+		for id := range prev.tiles {
+			if _, ok := next.tiles[id]; ok {
+				continue
+			}
+			if hub.TileDistance(id, lat, lon) <= payload.Radius*positionKeepFactor {
+				next.tiles[id] = struct{}{}
+				continue
+			}
+			if _, manual := session.manual[id]; !manual {
+				removed = append(removed, id)
+			}
+		}
+	}
+	for id := range next.tiles {
+		if session.positionOwns(id) {
+			continue
+		}
+		if _, manual := session.manual[id]; !manual {
+			added = append(added, id)
+		}
+	}
+	session.position = next
+
+	if len(removed) > 0 {
+		h.hub.Unsubscribe(client, removed)
+	}
+	if len(added) > 0 {
+		h.hub.Subscribe(client, added)
+	}
+	h.sendPositionAck(client, next, added, removed)
+	if resnapshot {
+		h.sendSnapshot(client, client.GetTiles())
+	} else if len(added) > 0 {
+		h.sendSnapshot(client, added)
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		h.sendMicromobility(client)
+	}
+}
+
+func (h *WSHandler) handleClearPosition(client *hub.Client, session *wsSession) {
+	var removed []string
+	if session.position != nil {
+		for id := range session.position.tiles {
+			if _, manual := session.manual[id]; !manual {
+				removed = append(removed, id)
+			}
+		}
+		session.position = nil
+	}
+	if len(removed) > 0 {
+		h.hub.Unsubscribe(client, removed)
+	}
+	h.sendPositionAck(client, nil, nil, removed)
+	if len(removed) > 0 {
+		h.sendMicromobility(client)
+	}
+}
+
+func (h *WSHandler) sendPositionAck(client *hub.Client, pos *positionSubscription, added, removed []string) {
+	payload := PositionAckPayload{
+		Added:      nonNil(added),
+		Removed:    nonNil(removed),
+		Subscribed: client.GetTiles(),
+		Types:      typeNames(client.GetTypes()),
+		Lines:      client.GetLineFilter(),
+	}
+	if pos != nil {
+		payload.Lat, payload.Lon, payload.Radius = pos.lat, pos.lon, pos.radius
+	}
+	h.sendMessage(client, serverMessage{Type: "position", Payload: payload})
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"wabus/internal/hub"
+)
+
+const (
+	minUpdateInterval = time.Second
+	maxUpdateInterval = 5 * time.Minute
+)
+
+// UpdateRatePayload asks for deltas at most every Interval seconds, e.g. 30
+// while an app is in the background. Updates of a vehicle in between are
+// merged into the latest. Zero goes back to the server's pace.
+type UpdateRatePayload struct {
+	Interval float64 `json:"interval"`
+}
+
+// UpdateRateAckPayload reports the interval asked for and the one in
+// effect, which is never shorter than the server's own batching interval.
+type UpdateRateAckPayload struct {
+	Interval  float64 `json:"interval"`
+	Effective float64 `json:"effective"`
+}
+
+// parseUpdateInterval checks an update interval in seconds: zero, or
+// within minUpdateInterval and maxUpdateInterval.
+func parseUpdateInterval(seconds float64) (time.Duration, *wsError) {
+	interval := time.Duration(seconds * float64(time.Second))
+	if seconds != 0 && (interval < minUpdateInterval || interval > maxUpdateInterval) {
+		return 0, &wsError{code: wsErrInvalidPayload, message: fmt.Sprintf("interval must be 0 or %v-%v seconds",
+			minUpdateInterval.Seconds(), maxUpdateInterval.Seconds())}
+	}
+	return interval, nil
+}
+
+func (h *WSHandler) handleSetUpdateRate(client *hub.Client, raw json.RawMessage) {
+	var payload UpdateRatePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		h.sendError(client, "set_update_rate", &wsError{code: wsErrInvalidPayload, message: "set_update_rate payload is malformed"})
+		return
+	}
+	interval, wsErr := parseUpdateInterval(payload.Interval)
+	if wsErr != nil {
+		h.sendError(client, "set_update_rate", wsErr)
+		return
+	}
+	client.SetUpdateInterval(interval)
+	h.sendMessage(client, serverMessage{Type: "update_rate", Payload: UpdateRateAckPayload{
+		Interval:  interval.Seconds(),
+		Effective: max(interval, h.hub.Coalesce()).Seconds(),
+	}})
+}
@@ -0,0 +1,296 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"wabus/internal/hub"
+)
+
+// WSProtocolVersion is bumped on incompatible changes to the WS messages.
+const WSProtocolVersion = 1
+
+// wsMessageSpec describes one WS message type. Payload is a zero value of the
+// payload struct (nil for messages without payload); the schema is derived
+// from it by reflection so it cannot drift from what is actually sent.
+type wsMessageSpec struct {
+	Type        string
+	Direction   string // "client" (client -> server) or "server"
+	Description string
+	Payload     interface{}
+}
+
+var wsMessages = []wsMessageSpec{
+	{"subscribe", "client", "Subscribe to vehicle updates in the given tiles.", SubscribePayload{}},
+	{"unsubscribe", "client", "Stop receiving updates for the given tiles.", UnsubscribePayload{}},
+	{"set_position", "client", "Subscribe to the tiles around a position; the server maintains the tile set as the position changes.", SetPositionPayload{}},
+	{"subscribe_lines", "client", "Receive updates for every vehicle of the given lines, regardless of tiles.", LinesPayload{}},
+	{"unsubscribe_lines", "client", "Stop following the given lines.", LinesPayload{}},
+	{"subscribe_vehicle", "client", "Receive every update of one vehicle, whatever its tile, line or type, until it is removed.", VehiclePayload{}},
+	{"unsubscribe_vehicle", "client", "Stop following a vehicle.", VehiclePayload{}},
+	{"subscribe_stop", "client", "Receive the departure board of the given stops now and whenever it changes.", StopsPayload{}},
+	{"unsubscribe_stop", "client", "Stop receiving the departure boards of the given stops.", StopsPayload{}},
+	{"subscribe_micromobility", "client", "Receive the bike-share stations and free vehicles in the subscribed tiles.", nil},
+	{"unsubscribe_micromobility", "client", "Stop receiving the micromobility layer.", nil},
+	{"clear_position", "client", "Drop the tiles subscribed through set_position.", nil},
+	{"set_update_rate", "client", "Receive deltas at most every interval seconds, merging a vehicle's updates in between; 0 resets.", UpdateRatePayload{}},
+	{"ping", "client", "Application-level keepalive; answered with pong.", nil},
+	{"subscribed", "server", "Acknowledges a subscribe request.", SubscriptionAckPayload{}},
+	{"unsubscribed", "server", "Acknowledges an unsubscribe request.", SubscriptionAckPayload{}},
+	{"lines_subscribed", "server", "Acknowledges a subscribe_lines request.", LinesAckPayload{}},
+	{"lines_unsubscribed", "server", "Acknowledges an unsubscribe_lines request.", LinesAckPayload{}},
+	{"vehicle_subscribed", "server", "Acknowledges a subscribe_vehicle request.", VehicleAckPayload{}},
+	{"vehicle_unsubscribed", "server", "Acknowledges an unsubscribe_vehicle request.", VehicleAckPayload{}},
+	{"stops_subscribed", "server", "Acknowledges a subscribe_stop request.", StopsAckPayload{}},
+	{"stops_unsubscribed", "server", "Acknowledges an unsubscribe_stop request.", StopsAckPayload{}},
+	{"micromobility_subscribed", "server", "Acknowledges a subscribe_micromobility request.", nil},
+	{"micromobility_unsubscribed", "server", "Acknowledges an unsubscribe_micromobility request.", nil},
+	{"position", "server", "Acknowledges set_position or clear_position with the tiles it changed.", PositionAckPayload{}},
+	{"update_rate", "server", "Acknowledges set_update_rate with the interval in effect.", UpdateRateAckPayload{}},
+	{"snapshot", "server", "Current vehicles in newly subscribed tiles, lines or vehicles.", SnapshotPayload{}},
+	{"delta", "server", "Vehicle updates and removals in subscribed tiles, lines and vehicles.", hub.DeltaPayload{}},
+	{"departures", "server", "The departure board of a subscribed stop; replaces the previous one.", DeparturesPayload{}},
+	{"micromobility", "server", "Stations and free vehicles of shared mobility systems in the subscribed tiles; replaces the previous layer.", MicromobilityPayload{}},
+	{"alert", "server", "A service alert was created, updated or deleted.", hub.AlertPayload{}},
+	{"bunching", "server", "The vehicles bunched behind the one ahead changed; replaces the previous list.", hub.BunchingPayload{}},
+	{"geofence", "server", "Vehicles entered or left geofences.", hub.GeofencePayload{}},
+	{"gtfs_updated", "server", "A new GTFS dataset was loaded; re-fetch /v1/sync.", hub.GTFSUpdatedPayload{}},
+	{"error", "server", "A client message was rejected.", ErrorPayload{}},
+	{"pong", "server", "Reply to ping.", nil},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// WSSchema serves the WS protocol as JSON Schema, as TypeScript
+// declarations with ?format=typescript, or the binary frames of the
+// protobuf format with ?format=proto.
+func WSSchema(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("format") {
+	case "typescript":
+		w.Header().Set("Content-Type", "application/typescript; charset=utf-8")
+		w.Write([]byte(buildWSTypeScript()))
+		return
+	case "proto":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(hub.ProtoSchema))
+		return
+	}
+	respondJSON(w, http.StatusOK, buildWSJSONSchema())
+}
+
+func buildWSJSONSchema() map[string]interface{} {
+	defs := make(map[string]interface{})
+	var client, server []interface{}
+
+	for _, m := range wsMessages {
+		props := map[string]interface{}{
+			"type": map[string]interface{}{"const": m.Type},
+		}
+		required := []string{"type"}
+		if m.Payload != nil {
+			props["payload"] = jsonSchemaFor(reflect.TypeOf(m.Payload), defs)
+			required = append(required, "payload")
+		}
+
+		name := messageTypeName(m.Type)
+		defs[name] = map[string]interface{}{
+			"type":                 "object",
+			"description":          m.Description,
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+		ref := map[string]interface{}{"$ref": "#/$defs/" + name}
+		if m.Direction == "client" {
+			client = append(client, ref)
+		} else {
+			server = append(server, ref)
+		}
+	}
+
+	defs["ClientMessage"] = map[string]interface{}{"oneOf": client}
+	defs["ServerMessage"] = map[string]interface{}{"oneOf": server}
+
+	return map[string]interface{}{
+		"$schema":          "https://json-schema.org/draft/2020-12/schema",
+		"$id":              "/v1/ws/schema",
+		"title":            "WaBus WebSocket protocol",
+		"protocol_version": WSProtocolVersion,
+		"oneOf": []interface{}{
+			map[string]interface{}{"$ref": "#/$defs/ClientMessage"},
+			map[string]interface{}{"$ref": "#/$defs/ServerMessage"},
+		},
+		"$defs": defs,
+	}
+}
+
+func jsonSchemaFor(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchemaFor(t.Elem(), defs)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := defs[name]; !ok {
+			defs[name] = nil // reserve to stop recursion
+			props := make(map[string]interface{})
+			var required []string
+			for _, f := range jsonFields(t) {
+				props[f.name] = jsonSchemaFor(f.typ, defs)
+				if !f.optional {
+					required = append(required, f.name)
+				}
+			}
+			defs[name] = map[string]interface{}{
+				"type":       "object",
+				"properties": props,
+				"required":   required,
+			}
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func buildWSTypeScript() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// WaBus WebSocket protocol v%d. Generated from /v1/ws/schema.\n\n", WSProtocolVersion)
+
+	interfaces := make(map[string]string)
+	var client, server []string
+
+	for _, m := range wsMessages {
+		name := messageTypeName(m.Type)
+		var body strings.Builder
+		fmt.Fprintf(&body, "/** %s */\nexport interface %s {\n  type: %q;\n", m.Description, name, m.Type)
+		if m.Payload != nil {
+			fmt.Fprintf(&body, "  payload: %s;\n", tsTypeFor(reflect.TypeOf(m.Payload), interfaces))
+		}
+		body.WriteString("}\n")
+		interfaces[name] = body.String()
+
+		if m.Direction == "client" {
+			client = append(client, name)
+		} else {
+			server = append(server, name)
+		}
+	}
+
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(interfaces[name])
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "export type ClientMessage = %s;\n", strings.Join(client, " | "))
+	fmt.Fprintf(&b, "export type ServerMessage = %s;\n", strings.Join(server, " | "))
+	return b.String()
+}
+
+func tsTypeFor(t reflect.Type, interfaces map[string]string) string {
+	if t == timeType {
+		return "string"
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return tsTypeFor(t.Elem(), interfaces)
+	case reflect.Slice, reflect.Array:
+		return tsTypeFor(t.Elem(), interfaces) + "[]"
+	case reflect.Map:
+		return "Record<string, " + tsTypeFor(t.Elem(), interfaces) + ">"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := interfaces[name]; !ok {
+			interfaces[name] = "" // reserve to stop recursion
+			var body strings.Builder
+			fmt.Fprintf(&body, "export interface %s {\n", name)
+			for _, f := range jsonFields(t) {
+				opt := ""
+				if f.optional {
+					opt = "?"
+				}
+				fmt.Fprintf(&body, "  %s%s: %s;\n", f.name, opt, tsTypeFor(f.typ, interfaces))
+			}
+			body.WriteString("}\n")
+			interfaces[name] = body.String()
+		}
+		return name
+	}
+	return "unknown"
+}
+
+type jsonField struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+}
+
+// jsonFields lists the JSON-visible fields of a struct as encoding/json would.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{
+			name:     name,
+			typ:      f.Type,
+			optional: strings.Contains(opts, "omitempty") || strings.Contains(opts, "omitzero") || f.Type.Kind() == reflect.Ptr,
+		})
+	}
+	return fields
+}
+
+// messageTypeName turns "subscribed" into "SubscribedMessage".
+func messageTypeName(msgType string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(msgType, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	b.WriteString("Message")
+	return b.String()
+}
@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/hub"
+)
+
+// WS error codes sent in ErrorPayload.Code.
+const (
+	wsErrInvalidMessage  = "invalid_message" // not JSON
+	wsErrInvalidPayload  = "invalid_payload" // payload doesn't match the message type
+	wsErrUnknownType     = "unknown_type"
+	wsErrInvalidTile     = "invalid_tile"  // tile ID isn't z/x/y within range
+	wsErrZoomMismatch    = "zoom_mismatch" // tile ID is at another zoom than the server indexes
+	wsErrTooManyTiles    = "too_many_tiles"
+	wsErrTooManyLines    = "too_many_lines"
+	wsErrTooManyVehicles = "too_many_vehicles"
+	wsErrVehicleNotFound = "vehicle_not_found"
+	wsErrInvalidType     = "invalid_vehicle_type"
+	wsErrInvalidFormat   = "invalid_format"
+	wsErrTooManyStops    = "too_many_stops"
+	wsErrStopNotFound    = "stop_not_found"
+	wsErrFeatureDisabled = "feature_disabled" // the server runs without what the request needs
+)
+
+const (
+	// defaultWSMaxTiles caps the tiles a client may subscribe to by ID.
+	defaultWSMaxTiles = 200
+	// defaultWSMaxLines caps the lines a client may follow.
+	defaultWSMaxLines = 20
+	// maxWSVehicles caps the vehicles a client may follow one by one.
+	maxWSVehicles = 10
+	// maxLineLength bounds a line name; Warsaw's are at most a few characters.
+	maxLineLength = 16
+)
+
+// wsError is a rejected client message, sent back as an error message.
+type wsError struct {
+	code    string
+	message string
+	tileIDs []string
+	limit   int
+}
+
+// validateTileIDs checks that every tile ID is a canonical z/x/y at the
+// server's zoom, and returns the IDs without duplicates. Tiles at the legacy
+// zoom are replaced by the tiles covering them while it is accepted.
+func (h *WSHandler) validateTileIDs(tileIDs []string) ([]string, *wsError) {
+	if len(tileIDs) == 0 {
+		return nil, &wsError{code: wsErrInvalidPayload, message: "tileIds must not be empty"}
+	}
+
+	now := time.Now()
+	var invalid, wrongZoom []string
+	var legacy int
+	seen := make(map[string]struct{}, len(tileIDs))
+	unique := make([]string, 0, len(tileIDs))
+	add := func(id string) {
+		if _, dup := seen[id]; !dup {
+			seen[id] = struct{}{}
+			unique = append(unique, id)
+		}
+	}
+	for _, id := range tileIDs {
+		z, x, y, ok := hub.ParseTileID(id)
+		if !ok || fmt.Sprintf("%d/%d/%d", z, x, y) != id || z < 0 || z > 30 ||
+			x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+			invalid = append(invalid, id)
+			continue
+		}
+		if z != h.zoom {
+			if h.legacyZoom != nil && z == h.legacyZoom.Zoom() && h.legacyZoom.Active(now) {
+				legacy++
+				for _, tile := range h.legacyZoom.Translate(x, y) {
+					add(tile)
+				}
+				continue
+			}
+			wrongZoom = append(wrongZoom, id)
+			continue
+		}
+		add(id)
+	}
+
+	if len(invalid) > 0 {
+		return nil, &wsError{
+			code:    wsErrInvalidTile,
+			message: "tile IDs must be z/x/y with x and y in range for z",
+			tileIDs: invalid,
+		}
+	}
+	if len(wrongZoom) > 0 {
+		if h.legacyZoom != nil && namesZoom(wrongZoom, h.legacyZoom.Zoom()) {
+			h.legacyZoom.RecordExpired()
+		}
+		return nil, &wsError{
+			code:    wsErrZoomMismatch,
+			message: fmt.Sprintf("tiles must be at zoom %d", h.zoom),
+			tileIDs: wrongZoom,
+		}
+	}
+	if legacy > 0 {
+		h.legacyZoom.Record(legacy, now)
+	}
+	return unique, nil
+}
+
+// namesZoom reports whether any of the tile IDs is at zoom.
+func namesZoom(tileIDs []string, zoom int) bool {
+	for _, id := range tileIDs {
+		if z, _, _, ok := hub.ParseTileID(id); ok && z == zoom {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTileLimit rejects a subscribe that would take the client's explicitly
+// subscribed tiles past the limit.
+func (h *WSHandler) checkTileLimit(session *wsSession, tileIDs []string) *wsError {
+	total := len(session.manual)
+	for _, id := range tileIDs {
+		if _, ok := session.manual[id]; !ok {
+			total++
+		}
+	}
+	if total <= h.maxTiles {
+		return nil
+	}
+	return &wsError{
+		code:    wsErrTooManyTiles,
+		message: fmt.Sprintf("subscription would cover %d tiles, the limit is %d", total, h.maxTiles),
+		limit:   h.maxTiles,
+	}
+}
+
+// validateLines checks the line names of a (un)subscribe_lines request and
+// returns them without duplicates. Lines without vehicles or timetables are
+// accepted, as a line may start running later.
+func validateLines(lines []string) ([]string, *wsError) {
+	if len(lines) == 0 {
+		return nil, &wsError{code: wsErrInvalidPayload, message: "lines must not be empty"}
+	}
+	seen := make(map[string]struct{}, len(lines))
+	unique := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line == "" || len(line) > maxLineLength || strings.TrimSpace(line) != line {
+			return nil, &wsError{code: wsErrInvalidPayload, message: fmt.Sprintf("invalid line %q", line)}
+		}
+		if _, dup := seen[line]; !dup {
+			seen[line] = struct{}{}
+			unique = append(unique, line)
+		}
+	}
+	return unique, nil
+}
+
+// checkLineLimit rejects a subscribe_lines that would take the client's
+// lines past the limit.
+func (h *WSHandler) checkLineLimit(client *hub.Client, lines []string) *wsError {
+	current := client.GetLines()
+	have := make(map[string]struct{}, len(current))
+	for _, line := range current {
+		have[line] = struct{}{}
+	}
+	total := len(current)
+	for _, line := range lines {
+		if _, ok := have[line]; !ok {
+			total++
+		}
+	}
+	if total <= h.maxLines {
+		return nil
+	}
+	return &wsError{
+		code:    wsErrTooManyLines,
+		message: fmt.Sprintf("subscription would cover %d lines, the limit is %d", total, h.maxLines),
+		limit:   h.maxLines,
+	}
+}
+
+// validateStopIDs checks the stop IDs of a (un)subscribe_stop request and
+// returns them without duplicates.
+func validateStopIDs(stopIDs []string) ([]string, *wsError) {
+	if len(stopIDs) == 0 {
+		return nil, &wsError{code: wsErrInvalidPayload, message: "stopIds must not be empty"}
+	}
+	seen := make(map[string]struct{}, len(stopIDs))
+	unique := make([]string, 0, len(stopIDs))
+	for _, id := range stopIDs {
+		if id == "" || strings.TrimSpace(id) != id {
+			return nil, &wsError{code: wsErrInvalidPayload, message: fmt.Sprintf("invalid stop ID %q", id)}
+		}
+		if _, dup := seen[id]; !dup {
+			seen[id] = struct{}{}
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
+
+// lookupVehicle finds a vehicle to follow by the key clients see: the
+// opaque ID in privacy mode, where the hub only carries redacted deltas.
+func (h *WSHandler) lookupVehicle(ref string) (*domain.Vehicle, *wsError) {
+	key := ref
+	if h.redactor != nil {
+		resolved, ok := h.redactor.Resolve(ref, h.store.Keys)
+		if !ok {
+			return nil, &wsError{code: wsErrVehicleNotFound, message: "vehicle not found: " + ref}
+		}
+		key = resolved
+	}
+	vehicle, ok := h.store.Get(key)
+	if !ok {
+		return nil, &wsError{code: wsErrVehicleNotFound, message: "vehicle not found: " + ref}
+	}
+	return vehicle, nil
+}
+
+// checkVehicleLimit rejects a subscribe_vehicle that would take the client
+// past the vehicles it may follow.
+func checkVehicleLimit(client *hub.Client, key string) *wsError {
+	following := client.GetVehicles()
+	for _, k := range following {
+		if k == key {
+			return nil
+		}
+	}
+	if len(following) < maxWSVehicles {
+		return nil
+	}
+	return &wsError{
+		code:    wsErrTooManyVehicles,
+		message: fmt.Sprintf("clients may follow at most %d vehicles", maxWSVehicles),
+		limit:   maxWSVehicles,
+	}
+}
+
+// parseVehicleTypes parses a types filter ("bus", "tram").
+func parseVehicleTypes(names []string) ([]domain.VehicleType, *wsError) {
+	types := make([]domain.VehicleType, 0, len(names))
+	for _, name := range names {
+		t, ok := domain.ParseVehicleType(name)
+		if !ok {
+			return nil, &wsError{code: wsErrInvalidType, message: fmt.Sprintf("unknown vehicle type %q, use bus or tram", name)}
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// parseFormat parses the format of a subscribe ("json", "protobuf").
+func parseFormat(name string) (hub.Format, *wsError) {
+	f, ok := hub.ParseFormat(name)
+	if !ok {
+		return 0, &wsError{code: wsErrInvalidFormat, message: fmt.Sprintf("unknown format %q, use json or protobuf", name)}
+	}
+	return f, nil
+}
+
+// setClientTypes sets the client's type filter and reports whether it changed.
+func setClientTypes(client *hub.Client, types []domain.VehicleType) bool {
+	before := fmt.Sprint(client.GetTypes())
+	client.SetTypes(types)
+	return fmt.Sprint(client.GetTypes()) != before
+}
+
+// parseLineFilter checks the lines filter of a subscribe or set_position;
+// an empty list removes the filter.
+func (h *WSHandler) parseLineFilter(lines []string) ([]string, *wsError) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	lines, wsErr := validateLines(lines)
+	if wsErr != nil {
+		return nil, wsErr
+	}
+	if len(lines) > h.maxLines {
+		return nil, &wsError{
+			code:    wsErrTooManyLines,
+			message: fmt.Sprintf("filter has %d lines, the limit is %d", len(lines), h.maxLines),
+			limit:   h.maxLines,
+		}
+	}
+	return lines, nil
+}
+
+// setClientLineFilter sets the client's line filter and reports whether it
+// changed.
+func setClientLineFilter(client *hub.Client, lines []string) bool {
+	before := fmt.Sprint(client.GetLineFilter())
+	client.SetLineFilter(lines)
+	return fmt.Sprint(client.GetLineFilter()) != before
+}
+
+// typeNames returns the names of a client type filter, nil for no filter.
+func typeNames(types []domain.VehicleType) []string {
+	if types == nil {
+		return nil
+	}
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return names
+}
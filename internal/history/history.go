@@ -0,0 +1,341 @@
+// Package history keeps every accepted vehicle position on local disk so
+// past traces can be queried after the live store has moved on.
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/pkg/gtfs"
+)
+
+// bucketCount is the number of files an hour's positions are spread over by
+// vehicle key. A vehicle query reads one of them per hour.
+const bucketCount = 32
+
+// Position is one recorded position of a vehicle.
+type Position struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Lat         float64   `json:"lat"`
+	Lon         float64   `json:"lon"`
+	Line        string    `json:"line"`
+	Brigade     string    `json:"brigade,omitempty"`
+	DirectionID *int      `json:"directionId,omitempty"`
+	Bearing     *int      `json:"bearing,omitempty"`
+	Speed       *float64  `json:"speed,omitempty"`
+}
+
+// Store appends the positions of update deltas to CSV files, one directory
+// per service day and hour of the position's timestamp, split into key
+// buckets. A vehicle's trace reads one bucket per hour, a line's every
+// bucket of the hours asked for. Files are only ever appended to, so
+// queries read them while they grow.
+type Store struct {
+	dir       string
+	retention int // days kept, 0 keeps everything
+	logger    *slog.Logger
+
+	mu    sync.Mutex
+	files map[string]*openFile // by path
+}
+
+type openFile struct {
+	hour time.Time
+	file *os.File
+	csv  *csv.Writer
+}
+
+func NewStore(dir string, retentionDays int, logger *slog.Logger) *Store {
+	return &Store{
+		dir:       dir,
+		retention: retentionDays,
+		logger:    logger.With("component", "history"),
+		files:     make(map[string]*openFile),
+	}
+}
+
+func bucketOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % bucketCount)
+}
+
+// hourDir returns the directory of the hour t falls in. Both hours of a DST
+// change back share one.
+func (s *Store) hourDir(t time.Time) string {
+	local := t.In(gtfs.Location())
+	return filepath.Join(s.dir, gtfs.ServiceDay(t).Format("20060102"), fmt.Sprintf("%02d", local.Hour()))
+}
+
+func bucketFile(bucket int) string {
+	return fmt.Sprintf("bucket-%02d.csv", bucket)
+}
+
+// Record appends the vehicles of update deltas.
+func (s *Store) Record(deltas []domain.VehicleDelta) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	written := make(map[*openFile]bool)
+	for _, d := range deltas {
+		if d.Type != domain.DeltaUpdate || d.Vehicle == nil {
+			continue
+		}
+		v := d.Vehicle
+		f, err := s.openLocked(v.Timestamp, filepath.Join(s.hourDir(v.Timestamp), bucketFile(bucketOf(v.Key))))
+		if err != nil {
+			s.logger.Error("failed to open history file", "error", err)
+			continue
+		}
+		f.csv.Write(encode(v))
+		written[f] = true
+	}
+
+	for f := range written {
+		f.csv.Flush()
+		if err := f.csv.Error(); err != nil {
+			s.logger.Error("failed to write history", "file", f.file.Name(), "error", err)
+		}
+	}
+	s.closeOldLocked()
+}
+
+func (s *Store) openLocked(t time.Time, path string) (*openFile, error) {
+	if f, ok := s.files[path]; ok {
+		return f, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f := &openFile{hour: t.Truncate(time.Hour), file: file, csv: csv.NewWriter(file)}
+	s.files[path] = f
+	return f, nil
+}
+
+// closeOldLocked closes the files of hours ended over an hour ago, which
+// only receive late positions and are reopened for them.
+func (s *Store) closeOldLocked() {
+	cutoff := time.Now().Add(-2 * time.Hour)
+	for path, f := range s.files {
+		if f.hour.Before(cutoff) {
+			f.file.Close()
+			delete(s.files, path)
+		}
+	}
+}
+
+// Close flushes and closes the open files.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for path, f := range s.files {
+		f.csv.Flush()
+		errs = append(errs, f.csv.Error(), f.file.Close())
+		delete(s.files, path)
+	}
+	return errors.Join(errs...)
+}
+
+// Entry is a recorded position with the vehicle it belongs to.
+type Entry struct {
+	Key string `json:"key"`
+	Position
+}
+
+// Query returns the positions of a vehicle timestamped within [from, to],
+// oldest first.
+func (s *Store) Query(key string, from, to time.Time) ([]Position, error) {
+	entries, err := s.scan(from, to, []int{bucketOf(key)}, func(record []string) bool {
+		return record[fieldKey] == key
+	})
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]Position, len(entries))
+	for i, e := range entries {
+		positions[i] = e.Position
+	}
+	return positions, nil
+}
+
+// QueryLine returns the positions of every vehicle running line timestamped
+// within [from, to], oldest first.
+func (s *Store) QueryLine(line string, from, to time.Time) ([]Entry, error) {
+	buckets := make([]int, bucketCount)
+	for i := range buckets {
+		buckets[i] = i
+	}
+	return s.scan(from, to, buckets, func(record []string) bool {
+		return record[fieldLine] == line
+	})
+}
+
+// scan reads the buckets of every hour overlapping [from, to] and returns
+// the entries in range whose record matches, oldest first.
+func (s *Store) scan(from, to time.Time, buckets []int, match func(record []string) bool) ([]Entry, error) {
+	var result []Entry
+	seen := make(map[string]bool)
+	for t := from.Truncate(time.Hour); !t.After(to); t = t.Add(time.Hour) {
+		dir := s.hourDir(t)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		for _, b := range buckets {
+			entries, err := readFile(filepath.Join(dir, bucketFile(b)), from, to, match)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, entries...)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Timestamp.Before(result[j].Timestamp) })
+	return result, nil
+}
+
+func readFile(path string, from, to time.Time, match func(record []string) bool) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = len(fields)
+	r.ReuseRecord = true
+	var result []Entry
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		// A line still being written reads as malformed; skip it.
+		if err != nil || !match(record) {
+			continue
+		}
+		p, ok := decode(record)
+		if !ok || p.Timestamp.Before(from) || p.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, Entry{Key: record[fieldKey], Position: p})
+	}
+	return result, nil
+}
+
+// Run deletes the days past retention, once at start and then daily.
+func (s *Store) Run(ctx context.Context) {
+	if s.retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for {
+		s.prune()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Store) prune() {
+	cutoff := gtfs.ServiceDay(time.Now()).AddDate(0, 0, -s.retention).Format("20060102")
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.Warn("failed to list history", "error", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || len(e.Name()) != len("20060102") || e.Name() >= cutoff {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, e.Name())); err != nil {
+			s.logger.Warn("failed to delete history day", "day", e.Name(), "error", err)
+			continue
+		}
+		s.logger.Info("deleted history day", "day", e.Name())
+	}
+}
+
+// fields is the column layout of history files, which have no header.
+var fields = []string{"timestamp_ms", "key", "line", "brigade", "lat", "lon", "direction_id", "bearing", "speed"}
+
+const (
+	fieldKey  = 1
+	fieldLine = 2
+)
+
+func encode(v *domain.Vehicle) []string {
+	record := []string{
+		strconv.FormatInt(v.Timestamp.UnixMilli(), 10),
+		v.Key,
+		v.Line,
+		v.Brigade,
+		strconv.FormatFloat(v.Lat, 'f', 6, 64),
+		strconv.FormatFloat(v.Lon, 'f', 6, 64),
+		"", "", "",
+	}
+	if v.DirectionID != nil {
+		record[6] = strconv.Itoa(*v.DirectionID)
+	}
+	if v.Bearing != nil {
+		record[7] = strconv.Itoa(*v.Bearing)
+	}
+	if v.Speed != nil {
+		record[8] = strconv.FormatFloat(*v.Speed, 'f', 1, 64)
+	}
+	return record
+}
+
+func decode(record []string) (Position, bool) {
+	ms, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Position{}, false
+	}
+	lat, latErr := strconv.ParseFloat(record[4], 64)
+	lon, lonErr := strconv.ParseFloat(record[5], 64)
+	if latErr != nil || lonErr != nil {
+		return Position{}, false
+	}
+	p := Position{
+		Timestamp: time.UnixMilli(ms).UTC(),
+		Lat:       lat,
+		Lon:       lon,
+		Line:      record[2],
+		Brigade:   record[3],
+	}
+	if n, err := strconv.Atoi(record[6]); err == nil {
+		p.DirectionID = &n
+	}
+	if n, err := strconv.Atoi(record[7]); err == nil {
+		p.Bearing = &n
+	}
+	if f, err := strconv.ParseFloat(record[8], 64); err == nil {
+		p.Speed = &f
+	}
+	return p, true
+}
@@ -4,23 +4,82 @@ import (
 	"context"
 	"encoding/json"
 	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"wabus/internal/analytics"
 	"wabus/internal/domain"
+	"wabus/internal/geofence"
 )
 
 type Client struct {
-	ID    string
-	Send  chan []byte
-	tiles map[string]struct{}
-	mu    sync.RWMutex
+	ID       string
+	Send     chan []byte
+	tiles    map[string]struct{}
+	lines    map[string]struct{}
+	vehicles map[string]struct{}
+	stops    map[string]struct{}
+	types    map[domain.VehicleType]struct{} // nil receives all types
+	// lineFilter limits the vehicles received in tiles to these lines; nil
+	// receives all lines.
+	lineFilter map[string]struct{}
+	format     Format
+	// compressed is set when the connection negotiated permessage-deflate.
+	compressed bool
+	// micromobility is set while the client receives the micromobility
+	// layer of its tiles.
+	micromobility bool
+	mu            sync.RWMutex
+
+	// updateInterval, when longer than the hub's coalescing interval, is
+	// the least time between two delta messages to the client.
+	updateInterval time.Duration
+
+	connectedAt time.Time
+	// dropped counts messages skipped because Send was full, and
+	// consecutiveDropped those since a message was last queued. fullSince
+	// is when the first of these was skipped, in Unix nanoseconds.
+	dropped            atomic.Int64
+	consecutiveDropped atomic.Int64
+	fullSince          atomic.Int64
+
+	evicted   chan struct{}
+	evictOnce sync.Once
 }
 
 func NewClient(id string, bufferSize int) *Client {
 	return &Client{
-		ID:    id,
-		Send:  make(chan []byte, bufferSize),
-		tiles: make(map[string]struct{}),
+		ID:       id,
+		Send:     make(chan []byte, bufferSize),
+		tiles:    make(map[string]struct{}),
+		lines:    make(map[string]struct{}),
+		vehicles: make(map[string]struct{}),
+		stops:    make(map[string]struct{}),
+
+		connectedAt: time.Now(),
+		evicted:     make(chan struct{}),
+	}
+}
+
+// Evicted is closed when the hub gives up on a client whose send buffer
+// stayed full; its connection should then be closed. The hub queues nothing
+// more for it.
+func (c *Client) Evicted() <-chan struct{} {
+	return c.evicted
+}
+
+func (c *Client) evict() {
+	c.evictOnce.Do(func() { close(c.evicted) })
+}
+
+func (c *Client) isEvicted() bool {
+	select {
+	case <-c.evicted:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -47,6 +106,124 @@ func (c *Client) RemoveTiles(tileIDs []string) {
 	}
 }
 
+// SetTypes limits the vehicles the client receives to the given types; an
+// empty list receives all of them.
+func (c *Client) SetTypes(types []domain.VehicleType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(types) == 0 {
+		c.types = nil
+		return
+	}
+	c.types = make(map[domain.VehicleType]struct{}, len(types))
+	for _, t := range types {
+		c.types[t] = struct{}{}
+	}
+}
+
+// GetTypes returns the client's type filter, or nil when it receives all types.
+func (c *Client) GetTypes() []domain.VehicleType {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.types == nil {
+		return nil
+	}
+	types := make([]domain.VehicleType, 0, len(c.types))
+	for t := range c.types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// SetFormat sets the encoding of the client's snapshots and deltas.
+func (c *Client) SetFormat(f Format) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.format = f
+}
+
+func (c *Client) Format() Format {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.format
+}
+
+func (c *Client) SetCompressed(compressed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compressed = compressed
+}
+
+// Compressed reports whether messages to the client are deflated on the
+// wire.
+func (c *Client) Compressed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.compressed
+}
+
+// Accepts reports whether the client's type filter lets vehicles of t through.
+func (c *Client) Accepts(t domain.VehicleType) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.types == nil {
+		return true
+	}
+	_, ok := c.types[t]
+	return ok
+}
+
+// SetLineFilter limits the vehicles the client receives in its tiles to the
+// given lines; an empty list removes the filter. Lines subscribed with
+// SubscribeLines and followed vehicles are not affected.
+func (c *Client) SetLineFilter(lines []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(lines) == 0 {
+		c.lineFilter = nil
+		return
+	}
+	c.lineFilter = make(map[string]struct{}, len(lines))
+	for _, line := range lines {
+		c.lineFilter[line] = struct{}{}
+	}
+}
+
+// GetLineFilter returns the client's line filter, or nil when it receives
+// all lines in its tiles.
+func (c *Client) GetLineFilter() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lineFilter == nil {
+		return nil
+	}
+	lines := make([]string, 0, len(c.lineFilter))
+	for line := range c.lineFilter {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// AcceptsInTile reports whether the client receives a vehicle of type t on
+// line in its tiles.
+func (c *Client) AcceptsInTile(t domain.VehicleType, line string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.types != nil {
+		if _, ok := c.types[t]; !ok {
+			return false
+		}
+	}
+	if c.lineFilter != nil {
+		if _, ok := c.lineFilter[line]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Client) GetTiles() []string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -57,30 +234,245 @@ func (c *Client) GetTiles() []string {
 	return tiles
 }
 
+func (c *Client) AddLines(lines []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, line := range lines {
+		c.lines[line] = struct{}{}
+	}
+}
+
+func (c *Client) RemoveLines(lines []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, line := range lines {
+		delete(c.lines, line)
+	}
+}
+
+// GetLines returns the lines the client follows, sorted.
+func (c *Client) GetLines() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	lines := make([]string, 0, len(c.lines))
+	for line := range c.lines {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func (c *Client) AddVehicle(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vehicles[key] = struct{}{}
+}
+
+func (c *Client) RemoveVehicle(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.vehicles, key)
+}
+
+// GetVehicles returns the keys of the vehicles the client follows, sorted.
+func (c *Client) GetVehicles() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]string, 0, len(c.vehicles))
+	for key := range c.vehicles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Client) AddStops(stopIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range stopIDs {
+		c.stops[id] = struct{}{}
+	}
+}
+
+func (c *Client) RemoveStops(stopIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range stopIDs {
+		delete(c.stops, id)
+	}
+}
+
+// Micromobility reports whether the client receives the micromobility layer.
+func (c *Client) Micromobility() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.micromobility
+}
+
+// GetStops returns the stops whose departures the client receives, sorted.
+// SetUpdateInterval makes the hub send the client deltas at most every
+// interval, merging updates of a vehicle in between, e.g. for a background
+// session saving battery. Zero follows the hub's own pace.
+func (c *Client) SetUpdateInterval(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updateInterval = interval
+}
+
+func (c *Client) UpdateInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.updateInterval
+}
+
+func (c *Client) GetStops() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stops := make([]string, 0, len(c.stops))
+	for id := range c.stops {
+		stops = append(stops, id)
+	}
+	sort.Strings(stops)
+	return stops
+}
+
 type Hub struct {
 	mu          sync.RWMutex
 	clients     map[*Client]struct{}
 	tileClients map[string]map[*Client]struct{}
+	lineClients map[string]map[*Client]struct{}
+	// vehicleClients is keyed by vehicle key as carried in deltas, i.e. the
+	// opaque ID in privacy mode.
+	vehicleClients map[string]map[*Client]struct{}
+	stopClients    map[string]map[*Client]struct{}
+	// micromobilityClients receive the micromobility layer of their tiles.
+	micromobilityClients map[*Client]struct{}
 
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan []domain.VehicleDelta
 
+	// coalesce > 0 holds each client's deltas for that long, merged by
+	// vehicle key, instead of sending every batch. pending is only touched
+	// by Run.
+	coalesce time.Duration
+	pending  map[*Client]*pendingDeltas
+	// lastSent is when clients with their own update interval were last
+	// sent deltas. Only touched by Run.
+	lastSent map[*Client]time.Time
+
 	logger *slog.Logger
+
+	droppedBatches  atomic.Int64
+	droppedDeltas   atomic.Int64
+	droppedMessages atomic.Int64
+	coalescedDeltas atomic.Int64
+	evictedClients  atomic.Int64
+	// heartbeat is LastHeartbeat in Unix nanoseconds.
+	heartbeat atomic.Int64
+
+	// slowClientTimeout > 0 evicts clients whose send buffer has been full
+	// for that long.
+	slowClientTimeout time.Duration
+}
+
+// pendingDeltas are a client's deltas held until the next flush. A later
+// delta for a vehicle replaces the held one in its place.
+type pendingDeltas struct {
+	order []string
+	byKey map[string]domain.VehicleDelta
+	// due is when deltas held for a client's own update interval are sent;
+	// zero for those flushed at the hub's coalescing interval.
+	due time.Time
+}
+
+// throttleTick is how often deltas held for clients' own update intervals
+// are checked, bounding how late past its interval a client gets them.
+const throttleTick = 250 * time.Millisecond
+
+// Stats counts data the hub discarded since startup.
+type Stats struct {
+	// DroppedBatches and DroppedDeltas count delta batches (and the deltas
+	// in them) dropped because the broadcast channel was full.
+	DroppedBatches int64 `json:"dropped_batches"`
+	DroppedDeltas  int64 `json:"dropped_deltas"`
+	// DroppedMessages counts messages not queued to a client whose send
+	// buffer was full.
+	DroppedMessages int64 `json:"dropped_messages"`
+	// CoalescedDeltas counts deltas never sent because a later delta for
+	// the same vehicle replaced them while held for a client.
+	CoalescedDeltas int64 `json:"coalesced_deltas"`
+	// EvictedClients counts clients disconnected because their send buffer
+	// stayed full for the slow client timeout.
+	EvictedClients int64 `json:"evicted_clients"`
 }
 
 func NewHub(logger *slog.Logger) *Hub {
 	return &Hub{
-		clients:     make(map[*Client]struct{}),
-		tileClients: make(map[string]map[*Client]struct{}),
-		register:    make(chan *Client, 16),
-		unregister:  make(chan *Client, 16),
-		broadcast:   make(chan []domain.VehicleDelta, 256),
-		logger:      logger,
+		clients:        make(map[*Client]struct{}),
+		tileClients:    make(map[string]map[*Client]struct{}),
+		lineClients:    make(map[string]map[*Client]struct{}),
+		vehicleClients: make(map[string]map[*Client]struct{}),
+		stopClients:    make(map[string]map[*Client]struct{}),
+		register:       make(chan *Client, 16),
+		unregister:     make(chan *Client, 16),
+		broadcast:      make(chan []domain.VehicleDelta, 256),
+		pending:        make(map[*Client]*pendingDeltas),
+		lastSent:       make(map[*Client]time.Time),
+		logger:         logger,
+
+		micromobilityClients: make(map[*Client]struct{}),
 	}
 }
 
+// SetCoalesce makes the hub send each client its deltas at most every
+// interval, merging repeated updates of a vehicle into the latest one.
+// Zero sends every batch as it arrives. Call before Run.
+func (h *Hub) SetCoalesce(interval time.Duration) {
+	h.coalesce = interval
+}
+
+// SetSlowClientTimeout makes the hub evict clients whose send buffer has
+// been full for timeout, rather than dropping their messages for as long as
+// they stay connected. Zero never evicts. Call before Run.
+func (h *Hub) SetSlowClientTimeout(timeout time.Duration) {
+	h.slowClientTimeout = timeout
+}
+
+// Coalesce returns the hub's coalescing interval, the shortest update
+// interval a client gets.
+func (h *Hub) Coalesce() time.Duration {
+	return h.coalesce
+}
+
+// LastHeartbeat is when Run started or last handled its throttle tick, which
+// it does every throttleTick; zero before it started. A heartbeat falling
+// behind means Run is stuck and no deltas reach clients.
+func (h *Hub) LastHeartbeat() time.Time {
+	ns := h.heartbeat.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
 func (h *Hub) Run(ctx context.Context) {
+	h.heartbeat.Store(time.Now().UnixNano())
+	var flush <-chan time.Time
+	if h.coalesce > 0 {
+		ticker := time.NewTicker(h.coalesce)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+	throttle := time.NewTicker(throttleTick)
+	defer throttle.Stop()
+	var slow <-chan time.Time
+	if h.slowClientTimeout > 0 {
+		ticker := time.NewTicker(min(h.slowClientTimeout/4, time.Second))
+		defer ticker.Stop()
+		slow = ticker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -98,6 +490,16 @@ func (h *Hub) Run(ctx context.Context) {
 
 		case deltas := <-h.broadcast:
 			h.fanoutDeltas(deltas)
+
+		case <-flush:
+			h.flushPending()
+
+		case now := <-throttle.C:
+			h.heartbeat.Store(now.UnixNano())
+			h.flushDue(now)
+
+		case now := <-slow:
+			h.evictSlowClients(now)
 		}
 	}
 }
@@ -132,6 +534,174 @@ func (h *Hub) Unsubscribe(client *Client, tileIDs []string) {
 	}
 }
 
+// SubscribeLines makes the client receive the deltas of vehicles serving
+// the given lines, wherever they are.
+func (h *Hub) SubscribeLines(client *Client, lines []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.AddLines(lines)
+
+	for _, line := range lines {
+		if h.lineClients[line] == nil {
+			h.lineClients[line] = make(map[*Client]struct{})
+		}
+		h.lineClients[line][client] = struct{}{}
+	}
+}
+
+func (h *Hub) UnsubscribeLines(client *Client, lines []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.RemoveLines(lines)
+	h.dropLineClient(client, lines)
+}
+
+func (h *Hub) dropLineClient(client *Client, lines []string) {
+	for _, line := range lines {
+		if h.lineClients[line] != nil {
+			delete(h.lineClients[line], client)
+			if len(h.lineClients[line]) == 0 {
+				delete(h.lineClients, line)
+			}
+		}
+	}
+}
+
+// SubscribeVehicle makes the client receive every delta of one vehicle,
+// regardless of its type filter, until the vehicle is removed. The remove
+// delta is the last one sent.
+func (h *Hub) SubscribeVehicle(client *Client, key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.AddVehicle(key)
+	if h.vehicleClients[key] == nil {
+		h.vehicleClients[key] = make(map[*Client]struct{})
+	}
+	h.vehicleClients[key][client] = struct{}{}
+}
+
+func (h *Hub) UnsubscribeVehicle(client *Client, key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.RemoveVehicle(key)
+	h.dropVehicleClient(client, key)
+}
+
+func (h *Hub) dropVehicleClient(client *Client, key string) {
+	if h.vehicleClients[key] != nil {
+		delete(h.vehicleClients[key], client)
+		if len(h.vehicleClients[key]) == 0 {
+			delete(h.vehicleClients, key)
+		}
+	}
+}
+
+// SubscribeStops makes the client receive the departure boards of the
+// given stops, sent with SendStop.
+func (h *Hub) SubscribeStops(client *Client, stopIDs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.AddStops(stopIDs)
+	for _, id := range stopIDs {
+		if h.stopClients[id] == nil {
+			h.stopClients[id] = make(map[*Client]struct{})
+		}
+		h.stopClients[id][client] = struct{}{}
+	}
+}
+
+func (h *Hub) UnsubscribeStops(client *Client, stopIDs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.RemoveStops(stopIDs)
+	h.dropStopClient(client, stopIDs)
+}
+
+func (h *Hub) dropStopClient(client *Client, stopIDs []string) {
+	for _, id := range stopIDs {
+		if h.stopClients[id] != nil {
+			delete(h.stopClients[id], client)
+			if len(h.stopClients[id]) == 0 {
+				delete(h.stopClients, id)
+			}
+		}
+	}
+}
+
+// SubscribedStops returns the stops at least one client subscribed to.
+func (h *Hub) SubscribedStops() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stops := make([]string, 0, len(h.stopClients))
+	for id := range h.stopClients {
+		stops = append(stops, id)
+	}
+	sort.Strings(stops)
+	return stops
+}
+
+// SubscribeMicromobility makes the client receive the micromobility layer
+// of its tiles, sent with Send.
+func (h *Hub) SubscribeMicromobility(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.mu.Lock()
+	client.micromobility = true
+	client.mu.Unlock()
+	h.micromobilityClients[client] = struct{}{}
+}
+
+func (h *Hub) UnsubscribeMicromobility(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client.mu.Lock()
+	client.micromobility = false
+	client.mu.Unlock()
+	delete(h.micromobilityClients, client)
+}
+
+// MicromobilityClients returns the clients receiving the micromobility layer.
+func (h *Hub) MicromobilityClients() []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.micromobilityClients))
+	for client := range h.micromobilityClients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Send queues a message for one connected client, skipping it if its send
+// buffer is full.
+func (h *Hub) Send(client *Client, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	h.queue(client, data)
+}
+
+// SendStop queues a message for every client subscribed to the stop,
+// skipping clients whose send buffer is full.
+func (h *Hub) SendStop(stopID string, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.stopClients[stopID] {
+		h.queue(client, data)
+	}
+}
+
 func (h *Hub) Broadcast(deltas []domain.VehicleDelta) {
 	if len(deltas) == 0 {
 		return
@@ -139,10 +709,70 @@ func (h *Hub) Broadcast(deltas []domain.VehicleDelta) {
 	select {
 	case h.broadcast <- deltas:
 	default:
+		h.droppedBatches.Add(1)
+		h.droppedDeltas.Add(int64(len(deltas)))
 		h.logger.Warn("broadcast channel full, dropping deltas", "count", len(deltas))
 	}
 }
 
+// Stats returns the hub's drop counters.
+func (h *Hub) Stats() Stats {
+	return Stats{
+		DroppedBatches:  h.droppedBatches.Load(),
+		DroppedDeltas:   h.droppedDeltas.Load(),
+		DroppedMessages: h.droppedMessages.Load(),
+		CoalescedDeltas: h.coalescedDeltas.Load(),
+		EvictedClients:  h.evictedClients.Load(),
+	}
+}
+
+// queue puts a message in the client's send buffer, or counts it dropped
+// when the buffer is full.
+func (h *Hub) queue(client *Client, data []byte) {
+	if client.isEvicted() {
+		return
+	}
+	select {
+	case client.Send <- data:
+		client.consecutiveDropped.Store(0)
+		client.fullSince.Store(0)
+	default:
+		h.droppedMessages.Add(1)
+		client.dropped.Add(1)
+		client.consecutiveDropped.Add(1)
+		client.fullSince.CompareAndSwap(0, time.Now().UnixNano())
+		h.logger.Debug("client send buffer full", "client_id", client.ID)
+	}
+}
+
+// evictSlowClients evicts the clients whose send buffer has been full since
+// slowClientTimeout ago. A buffer that drained without a message queued
+// since doesn't count as full.
+func (h *Hub) evictSlowClients(now time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		since := client.fullSince.Load()
+		if since == 0 || client.isEvicted() {
+			continue
+		}
+		if len(client.Send) < cap(client.Send) {
+			client.consecutiveDropped.Store(0)
+			client.fullSince.Store(0)
+			continue
+		}
+		full := now.Sub(time.Unix(0, since))
+		if full < h.slowClientTimeout {
+			continue
+		}
+		client.evict()
+		h.evictedClients.Add(1)
+		h.logger.Warn("evicting slow client", "client_id", client.ID, "full_for", full.Round(time.Second),
+			"dropped", client.consecutiveDropped.Load())
+	}
+}
+
 func (h *Hub) Register(client *Client) {
 	h.register <- client
 }
@@ -158,8 +788,8 @@ func (h *Hub) ClientCount() int {
 }
 
 type DeltaMessage struct {
-	Type    string                 `json:"type"`
-	Payload DeltaPayload           `json:"payload"`
+	Type    string       `json:"type"`
+	Payload DeltaPayload `json:"payload"`
 }
 
 type DeltaPayload struct {
@@ -167,33 +797,289 @@ type DeltaPayload struct {
 	Removes []string          `json:"removes,omitempty"`
 }
 
+type AlertMessage struct {
+	Type    string       `json:"type"`
+	Payload AlertPayload `json:"payload"`
+}
+
+// AlertPayload carries an alert change; for "deleted" only the alert's ID
+// and source are meaningful.
+type AlertPayload struct {
+	Action string        `json:"action"`
+	Alert  *domain.Alert `json:"alert"`
+}
+
+// BroadcastAlert sends an alert change to every connected client, regardless
+// of tile subscriptions.
+func (h *Hub) BroadcastAlert(action string, alert *domain.Alert) {
+	data, err := json.Marshal(AlertMessage{
+		Type:    "alert",
+		Payload: AlertPayload{Action: action, Alert: alert},
+	})
+	if err != nil {
+		return
+	}
+	h.sendAll(data)
+}
+
+type GTFSUpdatedMessage struct {
+	Type    string             `json:"type"`
+	Payload GTFSUpdatedPayload `json:"payload"`
+}
+
+// GTFSUpdatedPayload announces a new GTFS dataset; Version is the one
+// /v1/sync and /v1/sync/check report.
+type GTFSUpdatedPayload struct {
+	Version    string    `json:"version"`
+	LastUpdate time.Time `json:"lastUpdate"`
+}
+
+// BroadcastGTFSUpdated tells every connected client that a new GTFS dataset
+// is loaded, so they can re-fetch /v1/sync.
+func (h *Hub) BroadcastGTFSUpdated(version string, lastUpdate time.Time) {
+	data, err := json.Marshal(GTFSUpdatedMessage{
+		Type:    "gtfs_updated",
+		Payload: GTFSUpdatedPayload{Version: version, LastUpdate: lastUpdate},
+	})
+	if err != nil {
+		return
+	}
+	h.sendAll(data)
+}
+
+type BunchingMessage struct {
+	Type    string          `json:"type"`
+	Payload BunchingPayload `json:"payload"`
+}
+
+// BunchingPayload lists every vehicle currently bunched behind the one
+// ahead of it, replacing the previous list.
+type BunchingPayload struct {
+	Bunches   []*analytics.Bunch `json:"bunches"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+// BroadcastBunching sends the current bunches to every connected client,
+// regardless of tile subscriptions.
+func (h *Hub) BroadcastBunching(bunches []*analytics.Bunch, updatedAt time.Time) {
+	if bunches == nil {
+		bunches = []*analytics.Bunch{}
+	}
+	data, err := json.Marshal(BunchingMessage{
+		Type:    "bunching",
+		Payload: BunchingPayload{Bunches: bunches, UpdatedAt: updatedAt},
+	})
+	if err != nil {
+		return
+	}
+	h.sendAll(data)
+}
+
+type GeofenceMessage struct {
+	Type    string          `json:"type"`
+	Payload GeofencePayload `json:"payload"`
+}
+
+// GeofencePayload carries the geofence crossings of one poll.
+type GeofencePayload struct {
+	Events []geofence.Event `json:"events"`
+}
+
+// BroadcastGeofence sends geofence events to every connected client,
+// regardless of tile subscriptions.
+func (h *Hub) BroadcastGeofence(events []geofence.Event) {
+	data, err := json.Marshal(GeofenceMessage{
+		Type:    "geofence",
+		Payload: GeofencePayload{Events: events},
+	})
+	if err != nil {
+		return
+	}
+	h.sendAll(data)
+}
+
+// sendAll queues a message for every connected client, skipping clients
+// whose send buffer is full.
+func (h *Hub) sendAll(data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		h.queue(client, data)
+	}
+}
+
 func (h *Hub) fanoutDeltas(deltas []domain.VehicleDelta) {
+	removed := h.sendDeltas(deltas)
+	if len(removed) == 0 {
+		return
+	}
+
+	// Vehicle subscriptions end with the vehicle's remove delta.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range removed {
+		for client := range h.vehicleClients[key] {
+			client.RemoveVehicle(key)
+		}
+		delete(h.vehicleClients, key)
+	}
+}
+
+// sendDeltas queues each client's share of deltas, or holds it for the next
+// flush when coalescing, and returns the keys of removed vehicles that
+// clients were following.
+func (h *Hub) sendDeltas(deltas []domain.VehicleDelta) []string {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	clientDeltas := make(map[*Client][]domain.VehicleDelta)
+	var removed []string
 
 	for _, d := range deltas {
-		if clients, ok := h.tileClients[d.TileID]; ok {
-			for client := range clients {
-				clientDeltas[client] = append(clientDeltas[client], d)
+		recipients := make(map[*Client]struct{})
+		for client := range h.tileClients[d.TileID] {
+			if client.AcceptsInTile(d.VehicleType, d.Line) {
+				recipients[client] = struct{}{}
+			}
+		}
+		for client := range h.lineClients[d.Line] {
+			if client.Accepts(d.VehicleType) {
+				recipients[client] = struct{}{}
 			}
 		}
+		key := d.Key
+		if d.Vehicle != nil {
+			key = d.Vehicle.Key
+		}
+		if followers, ok := h.vehicleClients[key]; ok {
+			for client := range followers {
+				recipients[client] = struct{}{}
+			}
+			if d.Type == domain.DeltaRemove {
+				removed = append(removed, key)
+			}
+		}
+		for client := range recipients {
+			clientDeltas[client] = append(clientDeltas[client], d)
+		}
 	}
 
+	now := time.Now()
 	for client, ds := range clientDeltas {
-		msg := buildDeltaMessage(ds)
-		data, err := json.Marshal(msg)
-		if err != nil {
+		switch interval := client.UpdateInterval(); {
+		case interval > h.coalesce:
+			h.throttle(client, ds, interval, now)
+		case h.coalesce > 0:
+			h.hold(client, ds)
+		default:
+			h.deliver(client, ds)
+		}
+	}
+	return removed
+}
+
+// throttle sends deltas to a client with its own update interval right
+// away when the interval has passed since it was last sent any, and holds
+// them until it has otherwise.
+func (h *Hub) throttle(client *Client, deltas []domain.VehicleDelta, interval time.Duration, now time.Time) {
+	if p, ok := h.pending[client]; ok {
+		h.hold(client, deltas)
+		if p.due.IsZero() {
+			p.due = h.lastSent[client].Add(interval)
+		}
+		return
+	}
+	last := h.lastSent[client]
+	if now.Sub(last) >= interval {
+		h.deliver(client, deltas)
+		h.lastSent[client] = now
+		return
+	}
+	h.hold(client, deltas)
+	h.pending[client].due = last.Add(interval)
+}
+
+// hold adds deltas to those held for client, replacing held deltas of the
+// same vehicles.
+func (h *Hub) hold(client *Client, deltas []domain.VehicleDelta) {
+	p, ok := h.pending[client]
+	if !ok {
+		p = &pendingDeltas{byKey: make(map[string]domain.VehicleDelta)}
+		h.pending[client] = p
+	}
+	for _, d := range deltas {
+		key := d.Key
+		if d.Vehicle != nil {
+			key = d.Vehicle.Key
+		}
+		if _, held := p.byKey[key]; held {
+			h.coalescedDeltas.Add(1)
+		} else {
+			p.order = append(p.order, key)
+		}
+		p.byKey[key] = d
+	}
+}
+
+// flushPending sends every client the deltas held for it at the hub's
+// coalescing interval.
+func (h *Hub) flushPending() {
+	if len(h.pending) == 0 {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client, p := range h.pending {
+		if !p.due.IsZero() {
 			continue
 		}
+		h.deliver(client, p.deltas())
+		delete(h.pending, client)
+	}
+}
 
-		select {
-		case client.Send <- data:
-		default:
-			h.logger.Debug("client send buffer full", "client_id", client.ID)
+// flushDue sends clients with their own update interval the deltas held
+// for them once due.
+func (h *Hub) flushDue(now time.Time) {
+	if len(h.pending) == 0 {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client, p := range h.pending {
+		if p.due.IsZero() || now.Before(p.due) {
+			continue
 		}
+		h.deliver(client, p.deltas())
+		h.lastSent[client] = now
+		delete(h.pending, client)
+	}
+}
+
+func (p *pendingDeltas) deltas() []domain.VehicleDelta {
+	deltas := make([]domain.VehicleDelta, 0, len(p.order))
+	for _, key := range p.order {
+		deltas = append(deltas, p.byKey[key])
 	}
+	return deltas
+}
+
+// deliver encodes deltas in the client's format and queues them.
+func (h *Hub) deliver(client *Client, deltas []domain.VehicleDelta) {
+	var data []byte
+	if client.Format() == FormatProtobuf {
+		data = marshalDeltaProto(deltas)
+	} else {
+		var err error
+		if data, err = json.Marshal(buildDeltaMessage(deltas)); err != nil {
+			return
+		}
+	}
+
+	h.queue(client, data)
 }
 
 func buildDeltaMessage(deltas []domain.VehicleDelta) DeltaMessage {
@@ -234,6 +1120,14 @@ func (h *Hub) removeClient(client *Client) {
 			}
 		}
 	}
+	h.dropLineClient(client, client.GetLines())
+	for _, key := range client.GetVehicles() {
+		h.dropVehicleClient(client, key)
+	}
+	h.dropStopClient(client, client.GetStops())
+	delete(h.micromobilityClients, client)
+	delete(h.pending, client)
+	delete(h.lastSent, client)
 
 	delete(h.clients, client)
 	close(client.Send)
@@ -249,4 +1143,10 @@ func (h *Hub) closeAllClients() {
 	}
 	h.clients = make(map[*Client]struct{})
 	h.tileClients = make(map[string]map[*Client]struct{})
+	h.lineClients = make(map[string]map[*Client]struct{})
+	h.vehicleClients = make(map[string]map[*Client]struct{})
+	h.stopClients = make(map[string]map[*Client]struct{})
+	h.micromobilityClients = make(map[*Client]struct{})
+	h.pending = make(map[*Client]*pendingDeltas)
+	h.lastSent = make(map[*Client]time.Time)
 }
@@ -0,0 +1,86 @@
+package hub
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LegacyZoom keeps tile IDs at a previously configured zoom working after
+// TILE_ZOOM_LEVEL changed: until the deadline they are translated to the
+// tiles at the current zoom covering them. Uses are counted so operators can
+// tell when clients have moved on.
+type LegacyZoom struct {
+	zoom    int
+	current int
+	until   time.Time // zero: no deadline
+
+	requests atomic.Int64
+	tiles    atomic.Int64
+	expired  atomic.Int64
+	lastUsed atomic.Int64 // Unix ms
+}
+
+// LegacyZoomStats reports how often tiles at the legacy zoom were used.
+type LegacyZoomStats struct {
+	Zoom  int        `json:"zoom"`
+	Until *time.Time `json:"until,omitempty"`
+	// Requests and Tiles count the requests translated and the legacy tile
+	// IDs they named; Expired counts requests naming legacy tiles after the
+	// deadline.
+	Requests int64      `json:"requests"`
+	Tiles    int64      `json:"tiles"`
+	Expired  int64      `json:"expired"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// NewLegacyZoom accepts tiles at zoom in place of tiles at current until the
+// given deadline; a zero deadline never expires.
+func NewLegacyZoom(zoom, current int, until time.Time) *LegacyZoom {
+	return &LegacyZoom{zoom: zoom, current: current, until: until}
+}
+
+// Zoom returns the legacy zoom.
+func (l *LegacyZoom) Zoom() int {
+	return l.zoom
+}
+
+// Active reports whether legacy tiles are still accepted at now.
+func (l *LegacyZoom) Active(now time.Time) bool {
+	return l.until.IsZero() || now.Before(l.until)
+}
+
+// Translate returns the tiles at the current zoom covering legacy tile x/y.
+func (l *LegacyZoom) Translate(x, y int) []string {
+	return TilesCovering(l.zoom, x, y, l.current)
+}
+
+// Record counts a request that named tiles legacy tile IDs.
+func (l *LegacyZoom) Record(tiles int, now time.Time) {
+	l.requests.Add(1)
+	l.tiles.Add(int64(tiles))
+	l.lastUsed.Store(now.UnixMilli())
+}
+
+// RecordExpired counts a request naming legacy tiles after the deadline.
+func (l *LegacyZoom) RecordExpired() {
+	l.expired.Add(1)
+}
+
+// Stats returns the usage counters.
+func (l *LegacyZoom) Stats() LegacyZoomStats {
+	stats := LegacyZoomStats{
+		Zoom:     l.zoom,
+		Requests: l.requests.Load(),
+		Tiles:    l.tiles.Load(),
+		Expired:  l.expired.Load(),
+	}
+	if !l.until.IsZero() {
+		until := l.until
+		stats.Until = &until
+	}
+	if ms := l.lastUsed.Load(); ms != 0 {
+		last := time.UnixMilli(ms)
+		stats.LastUsed = &last
+	}
+	return stats
+}
@@ -0,0 +1,207 @@
+package hub
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"wabus/internal/domain"
+)
+
+// Format is the encoding of the snapshot and delta messages sent to a
+// client. Other messages are always JSON.
+type Format int
+
+const (
+	FormatJSON Format = iota
+	// FormatProtobuf sends snapshots and deltas as binary frames, each
+	// holding one ServerMessage of ProtoSchema.
+	FormatProtobuf
+)
+
+// ParseFormat parses "json" or "protobuf".
+func ParseFormat(s string) (Format, bool) {
+	switch s {
+	case "json":
+		return FormatJSON, true
+	case "protobuf":
+		return FormatProtobuf, true
+	default:
+		return 0, false
+	}
+}
+
+func (f Format) String() string {
+	if f == FormatProtobuf {
+		return "protobuf"
+	}
+	return "json"
+}
+
+// ProtoSchema defines the binary frames of FormatProtobuf. Times are Unix
+// milliseconds; optional fields are omitted when the JSON field would be.
+const ProtoSchema = `syntax = "proto3";
+
+package wabus.ws;
+
+// One binary WebSocket frame.
+message ServerMessage {
+  oneof message {
+    Snapshot snapshot = 1;
+    Delta delta = 2;
+  }
+}
+
+message Snapshot {
+  repeated Vehicle vehicles = 1;
+  Chunk chunk = 2; // set when the snapshot was split into several messages
+}
+
+message Chunk {
+  uint64 id = 1;
+  uint32 index = 2;
+  uint32 total = 3;
+  bool final = 4;
+}
+
+message Delta {
+  repeated Vehicle updates = 1;
+  repeated string removes = 2;
+}
+
+message Vehicle {
+  string key = 1;
+  string vehicle_number = 2;
+  int32 type = 3; // 1 bus, 2 tram
+  string line = 4;
+  string brigade = 5;
+  double lat = 6;
+  double lon = 7;
+  int64 timestamp = 8;
+  string tile_id = 9;
+  optional int32 direction_id = 10;
+  string headsign = 11;
+  optional int32 bearing = 12;
+  optional double speed = 13;
+  optional double speed_avg = 14;
+  SnappedPosition snapped = 15;
+  optional sint32 delay = 16;
+  repeated string units = 17;
+  string lead_key = 18;
+  int64 updated_at = 19;
+}
+
+message SnappedPosition {
+  double lat = 1;
+  double lon = 2;
+  string shape_id = 3;
+  double distance_along = 4;
+  double offset = 5;
+}
+`
+
+// MarshalVehicleProto encodes a vehicle as a Vehicle message.
+func MarshalVehicleProto(v *domain.Vehicle) []byte {
+	b := appendString(nil, 1, v.Key)
+	if v.VehicleNumber != "" {
+		b = appendString(b, 2, v.VehicleNumber)
+	}
+	b = appendVarint(b, 3, uint64(v.Type))
+	b = appendString(b, 4, v.Line)
+	if v.Brigade != "" {
+		b = appendString(b, 5, v.Brigade)
+	}
+	b = appendDouble(b, 6, v.Lat)
+	b = appendDouble(b, 7, v.Lon)
+	b = appendVarint(b, 8, uint64(v.Timestamp.UnixMilli()))
+	b = appendString(b, 9, v.TileID)
+	if v.DirectionID != nil {
+		b = appendVarint(b, 10, uint64(int64(*v.DirectionID)))
+	}
+	if v.Headsign != "" {
+		b = appendString(b, 11, v.Headsign)
+	}
+	if v.Bearing != nil {
+		b = appendVarint(b, 12, uint64(int64(*v.Bearing)))
+	}
+	if v.Speed != nil {
+		b = appendDouble(b, 13, *v.Speed)
+	}
+	if v.SpeedAvg != nil {
+		b = appendDouble(b, 14, *v.SpeedAvg)
+	}
+	if s := v.Snapped; s != nil {
+		var snapped []byte
+		snapped = appendDouble(snapped, 1, s.Lat)
+		snapped = appendDouble(snapped, 2, s.Lon)
+		snapped = appendString(snapped, 3, s.ShapeID)
+		snapped = appendDouble(snapped, 4, s.DistanceAlong)
+		snapped = appendDouble(snapped, 5, s.Offset)
+		b = appendMessage(b, 15, snapped)
+	}
+	if v.Delay != nil {
+		b = appendVarint(b, 16, protowire.EncodeZigZag(int64(*v.Delay)))
+	}
+	for _, unit := range v.Units {
+		b = appendString(b, 17, unit)
+	}
+	if v.LeadKey != "" {
+		b = appendString(b, 18, v.LeadKey)
+	}
+	return appendVarint(b, 19, uint64(v.UpdatedAt.UnixMilli()))
+}
+
+// MarshalSnapshotProto encodes a ServerMessage carrying a snapshot of
+// vehicles encoded with MarshalVehicleProto. total > 1 marks the snapshot
+// as chunk index of total with the given id.
+func MarshalSnapshotProto(vehicles [][]byte, id uint64, index, total int) []byte {
+	var snapshot []byte
+	for _, v := range vehicles {
+		snapshot = appendMessage(snapshot, 1, v)
+	}
+	if total > 1 {
+		var chunk []byte
+		chunk = appendVarint(chunk, 1, id)
+		chunk = appendVarint(chunk, 2, uint64(index))
+		chunk = appendVarint(chunk, 3, uint64(total))
+		if index == total-1 {
+			chunk = appendVarint(chunk, 4, 1)
+		}
+		snapshot = appendMessage(snapshot, 2, chunk)
+	}
+	return appendMessage(nil, 1, snapshot)
+}
+
+// marshalDeltaProto encodes a ServerMessage carrying deltas.
+func marshalDeltaProto(deltas []domain.VehicleDelta) []byte {
+	var delta []byte
+	for _, d := range deltas {
+		switch d.Type {
+		case domain.DeltaUpdate:
+			delta = appendMessage(delta, 1, MarshalVehicleProto(d.Vehicle))
+		case domain.DeltaRemove:
+			delta = appendString(delta, 2, d.Key)
+		}
+	}
+	return appendMessage(nil, 2, delta)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
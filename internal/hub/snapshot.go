@@ -0,0 +1,128 @@
+package hub
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot describes the hub's current subscriptions, to find hot tiles
+// and clients that don't keep up.
+type Snapshot struct {
+	Clients int `json:"clients"`
+	// Tiles and Lines count the subscribers of each tile and line, most
+	// subscribed first.
+	Tiles []Subscribers `json:"tiles"`
+	Lines []Subscribers `json:"lines"`
+	// ClientDetails lists clients with the fullest send buffer first.
+	ClientDetails []ClientSnapshot `json:"client_details"`
+}
+
+type Subscribers struct {
+	ID          string `json:"id"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// ClientSnapshot is one client's subscriptions and send buffer.
+type ClientSnapshot struct {
+	ID            string    `json:"id"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	Format        string    `json:"format"`
+	Tiles         int       `json:"tiles"`
+	Lines         int       `json:"lines"`
+	Vehicles      int       `json:"vehicles"`
+	Stops         int       `json:"stops"`
+	Micromobility bool      `json:"micromobility,omitempty"`
+	// UpdateInterval is the client's own update interval in seconds.
+	UpdateInterval float64 `json:"update_interval,omitempty"`
+	// Buffered messages are queued but not yet written to the client;
+	// Saturation is their share of BufferSize. Dropped counts messages
+	// skipped because the buffer was full, ConsecutiveDropped those since
+	// one was last queued, from FullSince on.
+	Buffered           int        `json:"buffered"`
+	BufferSize         int        `json:"buffer_size"`
+	Saturation         float64    `json:"saturation"`
+	Dropped            int64      `json:"dropped"`
+	ConsecutiveDropped int64      `json:"consecutive_dropped,omitempty"`
+	FullSince          *time.Time `json:"full_since,omitempty"`
+}
+
+// Snapshot returns the current subscriptions, with at most limit tiles,
+// lines and clients (0 for all).
+func (h *Hub) Snapshot(limit int) Snapshot {
+	h.mu.RLock()
+	snap := Snapshot{
+		Clients: len(h.clients),
+		Tiles:   subscriberCounts(h.tileClients, limit),
+		Lines:   subscriberCounts(h.lineClients, limit),
+	}
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.RUnlock()
+
+	snap.ClientDetails = make([]ClientSnapshot, 0, len(clients))
+	for _, c := range clients {
+		snap.ClientDetails = append(snap.ClientDetails, c.snapshot())
+	}
+	sort.Slice(snap.ClientDetails, func(i, j int) bool {
+		a, b := snap.ClientDetails[i], snap.ClientDetails[j]
+		if a.Saturation != b.Saturation {
+			return a.Saturation > b.Saturation
+		}
+		if a.Dropped != b.Dropped {
+			return a.Dropped > b.Dropped
+		}
+		return a.ID < b.ID
+	})
+	if limit > 0 && len(snap.ClientDetails) > limit {
+		snap.ClientDetails = snap.ClientDetails[:limit]
+	}
+	return snap
+}
+
+func subscriberCounts(subs map[string]map[*Client]struct{}, limit int) []Subscribers {
+	counts := make([]Subscribers, 0, len(subs))
+	for id, clients := range subs {
+		counts = append(counts, Subscribers{ID: id, Subscribers: len(clients)})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Subscribers != counts[j].Subscribers {
+			return counts[i].Subscribers > counts[j].Subscribers
+		}
+		return counts[i].ID < counts[j].ID
+	})
+	if limit > 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+func (c *Client) snapshot() ClientSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := ClientSnapshot{
+		ID:             c.ID,
+		ConnectedAt:    c.connectedAt,
+		Format:         c.format.String(),
+		Tiles:          len(c.tiles),
+		Lines:          len(c.lines),
+		Vehicles:       len(c.vehicles),
+		Stops:          len(c.stops),
+		Micromobility:  c.micromobility,
+		UpdateInterval: c.updateInterval.Seconds(),
+		Buffered:       len(c.Send),
+		BufferSize:     cap(c.Send),
+		Dropped:        c.dropped.Load(),
+
+		ConsecutiveDropped: c.consecutiveDropped.Load(),
+	}
+	if since := c.fullSince.Load(); since != 0 {
+		t := time.Unix(0, since)
+		s.FullSince = &t
+	}
+	if s.BufferSize > 0 {
+		s.Saturation = float64(s.Buffered) / float64(s.BufferSize)
+	}
+	return s
+}
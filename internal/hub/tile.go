@@ -3,6 +3,8 @@ package hub
 import (
 	"fmt"
 	"math"
+
+	"wabus/pkg/geo"
 )
 
 // TileID calculates tile ID for given coordinates at specified zoom level
@@ -69,7 +71,12 @@ func AdjacentTiles(zoom, x, y int) []string {
 	return tiles
 }
 
-// TilesInBBox returns all tile IDs that intersect the given bounding box
+// maxBBoxTiles bounds how many tiles TilesInBBox enumerates, so a box
+// spanning a whole row of tiles, as a radius near a pole does, costs nothing.
+const maxBBoxTiles = 1024
+
+// TilesInBBox returns all tile IDs that intersect the given bounding box, or
+// nil if they are more than maxBBoxTiles.
 func TilesInBBox(minLat, minLon, maxLat, maxLon float64, zoom int) []string {
 	topLeft := TileID(maxLat, minLon, zoom)
 	bottomRight := TileID(minLat, maxLon, zoom)
@@ -80,6 +87,9 @@ func TilesInBBox(minLat, minLon, maxLat, maxLon float64, zoom int) []string {
 	if !ok1 || !ok2 || z1 != z2 {
 		return nil
 	}
+	if x2 < x1 || y2 < y1 || (x2-x1+1)*(y2-y1+1) > maxBBoxTiles {
+		return nil
+	}
 
 	var tiles []string
 	for x := x1; x <= x2; x++ {
@@ -89,3 +99,53 @@ func TilesInBBox(minLat, minLon, maxLat, maxLon float64, zoom int) []string {
 	}
 	return tiles
 }
+
+// TileDistance returns the distance in meters from a point to the nearest
+// edge of a tile, or 0 when the point lies inside it.
+func TileDistance(tileID string, lat, lon float64) float64 {
+	zoom, x, y, ok := ParseTileID(tileID)
+	if !ok {
+		return math.Inf(1)
+	}
+	minLat, minLon, maxLat, maxLon := TileBounds(zoom, x, y)
+	nearLat := math.Max(minLat, math.Min(lat, maxLat))
+	nearLon := math.Max(minLon, math.Min(lon, maxLon))
+	return geo.Distance(lat, lon, nearLat, nearLon)
+}
+
+// TilesInRadius returns the tiles that come within radius meters of a point.
+func TilesInRadius(lat, lon, radius float64, zoom int) []string {
+	dLat := radius / metersPerDegree
+	dLon := radius / (metersPerDegree * math.Cos(lat*math.Pi/180))
+
+	var tiles []string
+	for _, id := range TilesInBBox(lat-dLat, lon-dLon, lat+dLat, lon+dLon, zoom) {
+		if TileDistance(id, lat, lon) <= radius {
+			tiles = append(tiles, id)
+		}
+	}
+	return tiles
+}
+
+// metersPerDegree is the approximate length of one degree of latitude.
+const metersPerDegree = 111320.0
+
+// TilesCovering returns the tile IDs at zoom that together cover tile z/x/y:
+// the tile itself, its ancestor when z is deeper than zoom, or all of its
+// descendants when z is shallower.
+func TilesCovering(z, x, y, zoom int) []string {
+	if z >= zoom {
+		shift := uint(z - zoom)
+		return []string{fmt.Sprintf("%d/%d/%d", zoom, x>>shift, y>>shift)}
+	}
+
+	shift := uint(zoom - z)
+	size := 1 << shift
+	tiles := make([]string, 0, size*size)
+	for dx := 0; dx < size; dx++ {
+		for dy := 0; dy < size; dy++ {
+			tiles = append(tiles, fmt.Sprintf("%d/%d/%d", zoom, x<<shift+dx, y<<shift+dy))
+		}
+	}
+	return tiles
+}
@@ -0,0 +1,137 @@
+package ingestor
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+	"wabus/pkg/ztm"
+)
+
+// maxAlertDescription caps the description copied from a notice.
+const maxAlertDescription = 2000
+
+// AlertIngestor keeps the ZTM alerts in the alert store in sync with the
+// published disruption notices.
+type AlertIngestor struct {
+	client    *ztm.Client
+	store     *store.AlertStore
+	gtfsStore *store.GTFSStore
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// NewAlertIngestor creates an ingestor for the notices feed at url.
+// gtfsStore, when not nil, is used to drop numbers that aren't lines.
+func NewAlertIngestor(url string, alertStore *store.AlertStore, gtfsStore *store.GTFSStore, interval time.Duration, logger *slog.Logger) *AlertIngestor {
+	return &AlertIngestor{
+		client:    ztm.New(url),
+		store:     alertStore,
+		gtfsStore: gtfsStore,
+		interval:  interval,
+		logger:    logger.With("component", "alert_ingestor"),
+	}
+}
+
+func (i *AlertIngestor) Start(ctx context.Context) {
+	i.update(ctx)
+
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			i.update(ctx)
+		}
+	}
+}
+
+func (i *AlertIngestor) update(ctx context.Context) {
+	notices, err := i.client.FetchNotices(ctx)
+	if err != nil {
+		// Keep the previous alerts rather than clearing them on a failed fetch.
+		i.logger.Warn("failed to fetch ZTM notices", "error", err)
+		return
+	}
+
+	alerts := make([]*domain.Alert, 0, len(notices))
+	for _, n := range notices {
+		alerts = append(alerts, i.toAlert(n))
+	}
+	i.store.ReplaceSource(domain.AlertSourceZTM, alerts)
+	i.logger.Debug("ZTM alerts refreshed", "count", len(alerts))
+}
+
+func (i *AlertIngestor) toAlert(n ztm.Notice) *domain.Alert {
+	sum := sha1.Sum([]byte(n.GUID))
+	description := n.Description
+	if len(description) > maxAlertDescription {
+		description = strings.ToValidUTF8(description[:maxAlertDescription], "") + "…"
+	}
+
+	return &domain.Alert{
+		ID:          string(domain.AlertSourceZTM) + "-" + hex.EncodeToString(sum[:6]),
+		Severity:    noticeSeverity(n.Title + " " + n.Description),
+		Title:       n.Title,
+		Description: description,
+		URL:         n.Link,
+		Lines:       i.noticeLines(n.Title + " " + n.Description),
+	}
+}
+
+var (
+	// A line keyword ("linii", "linie:", "tramwaje nr") followed by a list
+	// of line numbers such as "4, 15 i N01".
+	lineListPattern  = regexp.MustCompile(`(?i)\b(?:lini[a-zęą]*|autobus[a-zóęą]*|tramwaj[a-zóęą]*)\s*(?:nr\.?\s*)?:?\s*((?:[A-Z]{0,2}-?\d{1,3}(?:\s*(?:,|;|/|\bi\b|\boraz\b)\s*)?)+)`)
+	lineTokenPattern = regexp.MustCompile(`[A-Z]{0,2}-?\d{1,3}`)
+)
+
+// noticeLines extracts the line numbers a notice names. With GTFS loaded,
+// numbers that aren't lines (dates, stop codes) are dropped.
+func (i *AlertIngestor) noticeLines(text string) []string {
+	checkLines := i.gtfsStore != nil && i.gtfsStore.GetStats().IsLoaded
+
+	var lines []string
+	seen := make(map[string]struct{})
+	for _, match := range lineListPattern.FindAllStringSubmatch(text, -1) {
+		for _, line := range lineTokenPattern.FindAllString(match[1], -1) {
+			if _, dup := seen[line]; dup {
+				continue
+			}
+			if checkLines {
+				if _, ok := i.gtfsStore.GetRouteByLine(line); !ok {
+					continue
+				}
+			}
+			seen[line] = struct{}{}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// noticeSeverity ranks a notice by its wording: suspensions are severe,
+// detours and other disruptions warnings, anything else informational.
+func noticeSeverity(text string) domain.AlertSeverity {
+	text = strings.ToLower(text)
+	for _, word := range []string{"wstrzyman", "zawieszen", "nie kursuj", "suspend"} {
+		if strings.Contains(text, word) {
+			return domain.AlertSeveritySevere
+		}
+	}
+	for _, word := range []string{"objazd", "utrudnien", "zmiana trasy", "zmiany tras", "detour"} {
+		if strings.Contains(text, word) {
+			return domain.AlertSeverityWarning
+		}
+	}
+	return domain.AlertSeverityInfo
+}
@@ -0,0 +1,131 @@
+package ingestor
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"wabus/internal/domain"
+)
+
+// DirectionTagger tags vehicles with the direction of the line they run,
+// like matcher.Matcher.
+type DirectionTagger interface {
+	TagDirections(vehicles []*domain.Vehicle, previous func(key string) (*domain.Vehicle, bool))
+}
+
+// maxLoggedMismatches caps the canary mismatches logged per poll.
+const maxLoggedMismatches = 5
+
+// CanaryStats counts the canary matcher's runs and disagreements.
+type CanaryStats struct {
+	Name       string `json:"name"`
+	Polls      int64  `json:"polls"`
+	Skipped    int64  `json:"skipped"` // polls not compared because the previous run was still going
+	Compared   int64  `json:"compared"`
+	Mismatches int64  `json:"mismatches"`
+}
+
+// canary runs a second tagger on copies of every poll's vehicles and counts
+// where it disagrees with the served one. Its results are never stored.
+type canary struct {
+	name    string
+	tagger  DirectionTagger
+	running atomic.Bool
+
+	polls      atomic.Int64
+	skipped    atomic.Int64
+	compared   atomic.Int64
+	mismatches atomic.Int64
+}
+
+// SetCanary runs tagger alongside the matcher on the same inputs, logging
+// and counting the vehicles it tags differently without serving its tags.
+// name identifies the canary in logs and stats.
+func (i *Ingestor) SetCanary(name string, tagger DirectionTagger) {
+	i.canary = &canary{name: name, tagger: tagger}
+}
+
+// runCanary compares the canary with the tags the matcher set on served.
+// It runs in the background on copies taken before the store is updated, so
+// the canary sees the same previous positions the matcher did.
+func (i *Ingestor) runCanary(served []*domain.Vehicle, previous map[string]*domain.Vehicle) {
+	c := i.canary
+	c.polls.Add(1)
+	if !c.running.CompareAndSwap(false, true) {
+		c.skipped.Add(1)
+		return
+	}
+
+	// served is recycled after the poll, so the tags to compare with are
+	// copied too.
+	inputs := make([]*domain.Vehicle, len(served))
+	wanted := make([]domain.Vehicle, len(served))
+	for n, v := range served {
+		input := *v
+		input.DirectionID, input.Headsign = nil, ""
+		inputs[n] = &input
+		wanted[n] = domain.Vehicle{DirectionID: v.DirectionID, Headsign: v.Headsign}
+	}
+
+	go func() {
+		defer c.running.Store(false)
+		c.tagger.TagDirections(inputs, func(key string) (*domain.Vehicle, bool) {
+			v, ok := previous[key]
+			return v, ok
+		})
+
+		mismatches := 0
+		for n, v := range inputs {
+			want := wanted[n]
+			if sameDirection(want.DirectionID, v.DirectionID) && want.Headsign == v.Headsign {
+				continue
+			}
+			mismatches++
+			if mismatches <= maxLoggedMismatches {
+				i.logger.Info("canary mismatch",
+					"canary", c.name,
+					"vehicle", v.Key,
+					"line", v.Line,
+					"served_direction", directionString(want.DirectionID),
+					"canary_direction", directionString(v.DirectionID),
+					"served_headsign", want.Headsign,
+					"canary_headsign", v.Headsign,
+				)
+			}
+		}
+		c.compared.Add(int64(len(inputs)))
+		c.mismatches.Add(int64(mismatches))
+		if mismatches > 0 {
+			i.logger.Info("canary poll compared", "canary", c.name, "vehicles", len(inputs), "mismatches", mismatches)
+		}
+	}()
+}
+
+// CanaryStats returns the canary's counters, or nil without a canary.
+func (i *Ingestor) CanaryStats() *CanaryStats {
+	c := i.canary
+	if c == nil {
+		return nil
+	}
+	return &CanaryStats{
+		Name:       c.name,
+		Polls:      c.polls.Load(),
+		Skipped:    c.skipped.Load(),
+		Compared:   c.compared.Load(),
+		Mismatches: c.mismatches.Load(),
+	}
+}
+
+func sameDirection(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func directionString(d *int) string {
+	if d == nil {
+		return "none"
+	}
+	return strconv.Itoa(*d)
+}
@@ -0,0 +1,37 @@
+package ingestor
+
+import "wabus/internal/domain"
+
+// GateRecorder passes deltas on to r only while enabled reports true, so a
+// subsystem can be switched off without being unregistered.
+func GateRecorder(r Recorder, enabled func() bool) Recorder {
+	return &gatedRecorder{recorder: r, enabled: enabled}
+}
+
+type gatedRecorder struct {
+	recorder Recorder
+	enabled  func() bool
+}
+
+func (g *gatedRecorder) Record(deltas []domain.VehicleDelta) {
+	if g.enabled() {
+		g.recorder.Record(deltas)
+	}
+}
+
+// GateDelayTagger tags delays with t only while enabled reports true; the
+// vehicles are left without one otherwise.
+func GateDelayTagger(t DelayTagger, enabled func() bool) DelayTagger {
+	return &gatedDelayTagger{tagger: t, enabled: enabled}
+}
+
+type gatedDelayTagger struct {
+	tagger  DelayTagger
+	enabled func() bool
+}
+
+func (g *gatedDelayTagger) TagDelays(vehicles []*domain.Vehicle) {
+	if g.enabled() {
+		g.tagger.TagDelays(vehicles)
+	}
+}
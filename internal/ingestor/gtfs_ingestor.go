@@ -3,13 +3,32 @@ package ingestor
 import (
 	"context"
 	"log/slog"
+	"os"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"wabus/internal/domain"
 	"wabus/internal/store"
+	"wabus/internal/tracing"
 	"wabus/pkg/gtfs"
 )
 
+// GTFSProgress is a step of a GTFS update: "downloading", "parsing",
+// "swapping", then "completed" or "failed". Elapsed is since the update
+// started.
+type GTFSProgress struct {
+	Stage     string    `json:"stage"`
+	At        time.Time `json:"at"`
+	ElapsedMS int64     `json:"elapsed_ms"`
+	Error     string    `json:"error,omitempty"`
+	// Set when completed.
+	Routes int `json:"routes,omitempty"`
+	Stops  int `json:"stops,omitempty"`
+}
+
 type GTFSIngestor struct {
 	downloader     *gtfs.Downloader
 	parser         *gtfs.Parser
@@ -17,6 +36,13 @@ type GTFSIngestor struct {
 	updateInterval time.Duration
 	logger         *slog.Logger
 	onUpdate       func(context.Context)
+	onProgress     func(GTFSProgress)
+	archive        *gtfs.Archive
+
+	// Lazy shape loading; disabled when shapeCacheSize is 0.
+	shapeCacheSize int
+	shapeTolerance float64
+	shapeFile      *gtfs.ShapeFile
 
 	ready   bool
 	readyMu sync.RWMutex
@@ -52,10 +78,19 @@ func (i *GTFSIngestor) Start(ctx context.Context) {
 func (i *GTFSIngestor) update(ctx context.Context) {
 	i.logger.Info("starting GTFS update")
 	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "gtfs update")
+	defer span.End()
+	i.progress(GTFSProgress{Stage: "downloading"}, start)
 
-	reader, data, err := i.downloader.Download(ctx)
+	downloadCtx, downloadSpan := tracing.Tracer().Start(ctx, "gtfs download", trace.WithSpanKind(trace.SpanKindClient))
+	reader, data, err := i.downloader.Download(downloadCtx)
+	tracing.SetError(downloadSpan, err)
+	downloadSpan.SetAttributes(attribute.Int("bytes", len(data)))
+	downloadSpan.End()
 	if err != nil {
+		tracing.SetError(span, err)
 		i.logger.Error("failed to download GTFS", "error", err)
+		i.progress(GTFSProgress{Stage: "failed", Error: err.Error()}, start)
 		return
 	}
 
@@ -67,14 +102,21 @@ func (i *GTFSIngestor) update(ctx context.Context) {
 	i.logger.Info("GTFS fingerprint calculated", "sha256", fingerprint, "cache_dir", cacheDir)
 
 	parseStart := time.Now()
+	i.progress(GTFSProgress{Stage: "parsing"}, start)
+	_, parseSpan := tracing.Tracer().Start(ctx, "gtfs parse")
 	result, cachePath, cacheErr := gtfs.LoadParsedResult(cacheDir, fingerprint)
+	parseSpan.SetAttributes(attribute.Bool("cache_hit", cacheErr == nil))
 	if cacheErr == nil {
 		i.logger.Info("loaded parsed GTFS cache", "path", cachePath)
 	} else {
 		i.logger.Info("parsed GTFS cache miss, parsing ZIP", "path", cachePath, "error", cacheErr)
 		result, err = i.parser.Parse(reader)
 		if err != nil {
+			tracing.SetError(parseSpan, err)
+			parseSpan.End()
+			tracing.SetError(span, err)
 			i.logger.Error("failed to parse GTFS", "error", err)
+			i.progress(GTFSProgress{Stage: "failed", Error: err.Error()}, start)
 			return
 		}
 		if savedPath, saveErr := gtfs.SaveParsedResult(cacheDir, fingerprint, result); saveErr != nil {
@@ -84,9 +126,40 @@ func (i *GTFSIngestor) update(ctx context.Context) {
 		}
 	}
 
+	parseSpan.End()
 	parseDuration := time.Since(parseStart)
 
-	i.store.UpdateAll(result.Routes, result.Shapes, result.Stops, result.RouteShapes, result.StopSchedules, result.StopLines, result.RouteStops, result.RouteTripTimes, result.Trips, result.Calendars, result.CalendarDates, result.ShapeDirections)
+	shapes := result.Shapes
+	var shapeFile *gtfs.ShapeFile
+	if i.shapeCacheSize > 0 {
+		shapes, shapeFile = i.offloadShapes(cacheDir, fingerprint, result.Shapes)
+	}
+
+	if i.shapeCacheSize > 0 {
+		if shapeFile != nil {
+			i.store.SetShapeSource(shapeFile, i.shapeCacheSize)
+		} else {
+			i.store.SetShapeSource(nil, 0)
+		}
+	}
+	_, swapSpan := tracing.Tracer().Start(ctx, "gtfs swap")
+	i.store.SetFeedReport(result.Report)
+	dataset := i.store.PrepareDataset(result.Routes, shapes, result.Stops, result.RouteShapes, result.StopSchedules, result.StopLines, result.RouteStops, result.RouteTripTimes, result.Trips, result.Calendars, result.CalendarDates, result.ShapeDirections)
+
+	i.progress(GTFSProgress{Stage: "swapping"}, start)
+	i.store.SwapDataset(dataset)
+
+	if i.shapeFile != nil {
+		i.shapeFile.Close()
+	}
+	i.shapeFile = shapeFile
+	swapSpan.End()
+
+	if i.archive != nil {
+		if err := i.archive.Record(fingerprint, result, time.Now()); err != nil {
+			i.logger.Warn("failed to archive GTFS dataset", "error", err)
+		}
+	}
 
 	if !i.IsReady() {
 		i.setReady(true)
@@ -96,6 +169,8 @@ func (i *GTFSIngestor) update(ctx context.Context) {
 		i.onUpdate(ctx)
 	}
 
+	span.SetAttributes(attribute.Int("routes", len(result.Routes)), attribute.Int("stops", len(result.Stops)))
+	i.progress(GTFSProgress{Stage: "completed", Routes: len(result.Routes), Stops: len(result.Stops)}, start)
 	i.logger.Info("GTFS update completed",
 		"download_duration", downloadDuration,
 		"parse_duration", parseDuration,
@@ -108,6 +183,20 @@ func (i *GTFSIngestor) update(ctx context.Context) {
 	)
 }
 
+// SetOnProgress reports each step of every update to fn.
+func (i *GTFSIngestor) SetOnProgress(fn func(GTFSProgress)) {
+	i.onProgress = fn
+}
+
+func (i *GTFSIngestor) progress(p GTFSProgress, start time.Time) {
+	if i.onProgress == nil {
+		return
+	}
+	p.At = time.Now()
+	p.ElapsedMS = p.At.Sub(start).Milliseconds()
+	i.onProgress(p)
+}
+
 func (i *GTFSIngestor) IsReady() bool {
 	i.readyMu.RLock()
 	defer i.readyMu.RUnlock()
@@ -123,3 +212,46 @@ func (i *GTFSIngestor) setReady(ready bool) {
 func (i *GTFSIngestor) SetOnUpdate(fn func(context.Context)) {
 	i.onUpdate = fn
 }
+
+// SetShapeCache keeps only shapes simplified to toleranceMeters in memory
+// and loads full-resolution points from a shape file on disk, caching the
+// cacheSize most recently used shapes.
+func (i *GTFSIngestor) SetShapeCache(cacheSize int, toleranceMeters float64) {
+	i.shapeCacheSize = cacheSize
+	i.shapeTolerance = toleranceMeters
+}
+
+// offloadShapes writes the dataset's shapes to a shape file, unless it
+// already exists, and returns the simplified shapes to keep in memory. When
+// the file can't be written or opened, the full shapes stay in memory and the
+// file is nil.
+func (i *GTFSIngestor) offloadShapes(cacheDir, fingerprint string, shapes map[string]*domain.Shape) (map[string]*domain.Shape, *gtfs.ShapeFile) {
+	path := gtfs.ShapeFilePath(cacheDir, fingerprint)
+	if _, err := os.Stat(path); err != nil {
+		if err := gtfs.WriteShapeFile(path, shapes); err != nil {
+			i.logger.Warn("failed to write shape file, keeping full shapes in memory", "path", path, "error", err)
+			return shapes, nil
+		}
+	}
+	file, err := gtfs.OpenShapeFile(path)
+	if err != nil {
+		i.logger.Warn("failed to open shape file, keeping full shapes in memory", "path", path, "error", err)
+		return shapes, nil
+	}
+
+	simplified := make(map[string]*domain.Shape, len(shapes))
+	var fullPoints, keptPoints int
+	for id, shape := range shapes {
+		points := gtfs.SimplifyPoints(shape.Points, i.shapeTolerance)
+		simplified[id] = &domain.Shape{ID: shape.ID, Points: points, DirectionID: shape.DirectionID}
+		fullPoints += len(shape.Points)
+		keptPoints += len(points)
+	}
+	i.logger.Info("shapes offloaded to disk", "path", path, "points", fullPoints, "points_in_memory", keptPoints)
+	return simplified, file
+}
+
+// SetArchive records every loaded dataset in archive.
+func (i *GTFSIngestor) SetArchive(archive *gtfs.Archive) {
+	i.archive = archive
+}
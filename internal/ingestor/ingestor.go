@@ -3,13 +3,20 @@ package ingestor
 import (
 	"context"
 	"log/slog"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"wabus/internal/config"
 	"wabus/internal/domain"
 	"wabus/internal/hub"
+	"wabus/internal/matcher"
 	"wabus/internal/store"
+	"wabus/internal/tracing"
+	"wabus/pkg/geo"
 	"wabus/pkg/warsawapi"
 )
 
@@ -17,6 +24,57 @@ type Broadcaster interface {
 	Broadcast(deltas []domain.VehicleDelta)
 }
 
+// Recorder receives the deltas of every poll, e.g. to persist positions.
+type Recorder interface {
+	Record(deltas []domain.VehicleDelta)
+}
+
+// DelayTagger sets the schedule deviation of vehicles about to be stored,
+// like prediction.Predictor.
+type DelayTagger interface {
+	TagDelays(vehicles []*domain.Vehicle)
+}
+
+// Fanout shares the deltas of local polls with other instances or external
+// consumers.
+type Fanout interface {
+	Publish(deltas []domain.VehicleDelta)
+}
+
+// PollResult summarizes one poll of the upstream API.
+type PollResult struct {
+	At         time.Time `json:"at"`
+	DurationMS int64     `json:"duration_ms"`
+	Buses      int       `json:"buses"`
+	Trams      int       `json:"trams"`
+	Deltas     int       `json:"deltas"`
+	Total      int       `json:"total"`
+	BusError   string    `json:"bus_error,omitempty"`
+	TramError  string    `json:"tram_error,omitempty"`
+}
+
+// PollObserver is told the result of every poll, e.g. to stream it to
+// operators.
+type PollObserver interface {
+	ObservePoll(result PollResult)
+}
+
+// Leadership tells the ingestor whether this instance should poll upstream.
+type Leadership interface {
+	IsLeader() bool
+}
+
+// minBearingMovement is the displacement (meters) between positions needed
+// to compute a bearing; smaller moves are GPS jitter of a standing vehicle.
+const minBearingMovement = 10.0
+
+// IngestStats counts vehicles the ingestor discarded since startup.
+type IngestStats struct {
+	FilteredBadCoords int64 `json:"filtered_bad_coords"`
+	FilteredStale     int64 `json:"filtered_stale_timestamp"`
+	Pruned            int64 `json:"pruned"`
+}
+
 type Ingestor struct {
 	client      *warsawapi.Client
 	store       *store.Store
@@ -24,12 +82,35 @@ type Ingestor struct {
 	config      *config.Config
 	logger      *slog.Logger
 	zoomLevel   int
+	matcher     *matcher.Matcher
+	delays      DelayTagger
+	unitTypes   map[domain.VehicleType]bool // types whose coupled units are grouped
+	recorders   []Recorder
+	observers   []PollObserver
+	leadership  Leadership
+	fanouts     []Fanout
+	canary      *canary
+	// fetched holds the vehicles of a poll, reused across polls.
+	fetched []*domain.Vehicle
 
 	ready   bool
 	readyMu sync.RWMutex
+
+	filteredBadCoords atomic.Int64
+	filteredStale     atomic.Int64
+	pruned            atomic.Int64
 }
 
 func New(client *warsawapi.Client, store *store.Store, broadcaster Broadcaster, cfg *config.Config, logger *slog.Logger) *Ingestor {
+	unitTypes := make(map[domain.VehicleType]bool)
+	for _, name := range cfg.UnitGroupTypes {
+		t, ok := domain.ParseVehicleType(name)
+		if !ok {
+			logger.Warn("unknown vehicle type in UNIT_GROUP_TYPES", "type", name)
+			continue
+		}
+		unitTypes[t] = true
+	}
 	return &Ingestor{
 		client:      client,
 		store:       store,
@@ -37,6 +118,7 @@ func New(client *warsawapi.Client, store *store.Store, broadcaster Broadcaster,
 		config:      cfg,
 		logger:      logger,
 		zoomLevel:   cfg.TileZoomLevel,
+		unitTypes:   unitTypes,
 	}
 }
 
@@ -47,21 +129,38 @@ func (i *Ingestor) Run(ctx context.Context) {
 	pruneTicker := time.NewTicker(i.config.PollInterval * 3)
 	defer pruneTicker.Stop()
 
-	i.poll(ctx)
+	if i.IsLeader() {
+		i.poll(ctx)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			i.poll(ctx)
+			if i.IsLeader() {
+				i.poll(ctx)
+			}
 		case <-pruneTicker.C:
-			i.prune()
+			// Followers get removals from the leader; their own UpdatedAt
+			// only moves when a vehicle changes, so they must not prune.
+			if i.IsLeader() {
+				i.prune()
+			}
 		}
 	}
 }
 
+// IsLeader reports whether this instance polls upstream: without leader
+// election it always does.
+func (i *Ingestor) IsLeader() bool {
+	return i.leadership == nil || i.leadership.IsLeader()
+}
+
 func (i *Ingestor) poll(ctx context.Context) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "ingest poll")
+	defer span.End()
 	var wg sync.WaitGroup
 	var busesMu, tramsMu sync.Mutex
 	var buses, trams []*domain.Vehicle
@@ -94,20 +193,54 @@ func (i *Ingestor) poll(ctx context.Context) {
 		i.logger.Error("failed to fetch trams", "error", tramErr)
 	}
 
-	allVehicles := make([]*domain.Vehicle, 0, len(buses)+len(trams))
-	allVehicles = append(allVehicles, buses...)
-	allVehicles = append(allVehicles, trams...)
+	fetched := append(i.fetched[:0], buses...)
+	fetched = append(fetched, trams...)
+	allVehicles := i.filter(fetched, time.Now())
+	defer func() {
+		// Keep the buffer, not the vehicles.
+		clear(fetched)
+		i.fetched = fetched[:0]
+	}()
 
 	for _, v := range allVehicles {
 		v.TileID = hub.TileID(v.Lat, v.Lon, i.zoomLevel)
 	}
+	i.tagBearings(allVehicles)
+	i.groupUnits(allVehicles)
 
-	deltas := i.store.Update(allVehicles)
+	if i.matcher != nil {
+		i.matcher.TagDirections(allVehicles, i.store.Get)
+	}
+	if i.delays != nil {
+		i.delays.TagDelays(allVehicles)
+	}
+	if i.canary != nil {
+		previous := make(map[string]*domain.Vehicle, len(allVehicles))
+		for _, v := range allVehicles {
+			if prev, ok := i.store.Get(v.Key); ok {
+				previous[v.Key] = prev
+			}
+		}
+		i.runCanary(allVehicles, previous)
+	}
+
+	if i.config.PositionPrecision > 0 {
+		for _, v := range allVehicles {
+			v.RoundPosition(i.config.PositionPrecision)
+		}
+	}
+
+	// The fetched vehicles are the ingestor's own; the store recycles the
+	// ones it doesn't keep.
+	deltas := i.store.UpdateOwned(allVehicles)
 
 	if i.broadcaster != nil {
 		i.broadcaster.Broadcast(deltas)
 	}
 
+	i.record(deltas)
+	i.share(deltas)
+
 	if !i.IsReady() && (busErr == nil || tramErr == nil) {
 		i.setReady(true)
 		i.logger.Info("ingestor ready", "buses", len(buses), "trams", len(trams))
@@ -119,18 +252,226 @@ func (i *Ingestor) poll(ctx context.Context) {
 		"deltas", len(deltas),
 		"total", i.store.Count(),
 	)
+
+	span.SetAttributes(
+		attribute.Int("buses", len(buses)),
+		attribute.Int("trams", len(trams)),
+		attribute.Int("deltas", len(deltas)),
+	)
+	if busErr != nil && tramErr != nil {
+		tracing.SetError(span, busErr)
+	}
+
+	if len(i.observers) > 0 {
+		result := PollResult{
+			At:         start,
+			DurationMS: time.Since(start).Milliseconds(),
+			Buses:      len(buses),
+			Trams:      len(trams),
+			Deltas:     len(deltas),
+			Total:      i.store.Count(),
+		}
+		if busErr != nil {
+			result.BusError = busErr.Error()
+		}
+		if tramErr != nil {
+			result.TramError = tramErr.Error()
+		}
+		for _, o := range i.observers {
+			o.ObservePoll(result)
+		}
+	}
+}
+
+// filter drops vehicles with positions outside the service area and
+// positions older than the stale threshold, which would only be pruned again.
+// Dropped vehicles are released for reuse.
+func (i *Ingestor) filter(vehicles []*domain.Vehicle, now time.Time) []*domain.Vehicle {
+	kept := vehicles[:0]
+	var badCoords, stale int
+	for _, v := range vehicles {
+		switch {
+		case !domain.ServiceArea.Contains(v.Lat, v.Lon):
+			badCoords++
+			domain.ReleaseVehicle(v)
+		case now.Sub(v.Timestamp) > i.config.VehicleStaleAfter:
+			stale++
+			domain.ReleaseVehicle(v)
+		default:
+			kept = append(kept, v)
+		}
+	}
+
+	i.filteredBadCoords.Add(int64(badCoords))
+	i.filteredStale.Add(int64(stale))
+	if badCoords > 0 || stale > 0 {
+		i.logger.Debug("filtered vehicles", "bad_coords", badCoords, "stale_timestamp", stale)
+	}
+	return kept
+}
+
+// tagBearings sets each vehicle's bearing from its previous position in the
+// store, keeping the previous bearing while it stands still.
+func (i *Ingestor) tagBearings(vehicles []*domain.Vehicle) {
+	for _, v := range vehicles {
+		prev, ok := i.store.Get(v.Key)
+		if !ok {
+			continue
+		}
+		if geo.Distance(prev.Lat, prev.Lon, v.Lat, v.Lon) < minBearingMovement {
+			v.Bearing = prev.Bearing
+			continue
+		}
+		bearing := int(math.Round(geo.Bearing(prev.Lat, prev.Lon, v.Lat, v.Lon))) % 360
+		v.Bearing = &bearing
+	}
+}
+
+// Stats returns the counts of vehicles filtered at ingest and pruned.
+func (i *Ingestor) Stats() IngestStats {
+	return IngestStats{
+		FilteredBadCoords: i.filteredBadCoords.Load(),
+		FilteredStale:     i.filteredStale.Load(),
+		Pruned:            i.pruned.Load(),
+	}
 }
 
 func (i *Ingestor) prune() {
 	deltas := i.store.PruneStale()
+	i.pruned.Add(int64(len(deltas)))
 	if len(deltas) > 0 {
 		if i.broadcaster != nil {
 			i.broadcaster.Broadcast(deltas)
 		}
+		i.record(deltas)
+		i.share(deltas)
 		i.logger.Info("pruned stale vehicles", "count", len(deltas))
 	}
 }
 
+func (i *Ingestor) record(deltas []domain.VehicleDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	for _, r := range i.recorders {
+		r.Record(deltas)
+	}
+}
+
+// AddPollObserver reports the result of every poll to o.
+func (i *Ingestor) AddPollObserver(o PollObserver) {
+	i.observers = append(i.observers, o)
+}
+
+// SetMatcher enables tagging vehicles with their GTFS direction.
+func (i *Ingestor) SetMatcher(m *matcher.Matcher) {
+	i.matcher = m
+}
+
+// SetDelayTagger enables tagging vehicles with their schedule deviation.
+func (i *Ingestor) SetDelayTagger(t DelayTagger) {
+	i.delays = t
+}
+
+// AddRecorder makes every poll's deltas available to r.
+func (i *Ingestor) AddRecorder(r Recorder) {
+	i.recorders = append(i.recorders, r)
+}
+
+// Bootstrap seeds the store with vehicles replicated from a peer and marks
+// the ingestor ready when any were loaded, so a standby serves data before
+// its first poll completes.
+func (i *Ingestor) Bootstrap(vehicles []*domain.Vehicle) int {
+	for _, v := range vehicles {
+		v.TileID = hub.TileID(v.Lat, v.Lon, i.zoomLevel)
+	}
+	restored := i.store.Restore(vehicles)
+	if restored > 0 {
+		i.setReady(true)
+	}
+	return restored
+}
+
+// SetLeadership makes the ingestor poll and prune only while l reports this
+// instance as the leader.
+func (i *Ingestor) SetLeadership(l Leadership) {
+	i.leadership = l
+}
+
+// AddFanout makes the ingestor publish the deltas of its polls and prunes to
+// f. Deltas taken in with Apply aren't published again.
+func (i *Ingestor) AddFanout(f Fanout) {
+	i.fanouts = append(i.fanouts, f)
+}
+
+// share publishes locally produced deltas to the fanouts.
+func (i *Ingestor) share(deltas []domain.VehicleDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	for _, f := range i.fanouts {
+		f.Publish(deltas)
+	}
+}
+
+// Resync replaces the store contents with a leader's snapshot, broadcasting
+// the differences, and marks the ingestor ready.
+func (i *Ingestor) Resync(vehicles []*domain.Vehicle) {
+	present := make(map[string]struct{}, len(vehicles))
+	for _, v := range vehicles {
+		v.TileID = hub.TileID(v.Lat, v.Lon, i.zoomLevel)
+		present[v.Key] = struct{}{}
+	}
+
+	deltas := i.store.Update(vehicles)
+	for _, key := range i.store.Keys() {
+		if _, ok := present[key]; ok {
+			continue
+		}
+		if d, ok := i.store.Remove(key); ok {
+			deltas = append(deltas, d)
+		}
+	}
+	i.publish(deltas)
+	i.setReady(true)
+}
+
+// Apply applies a batch of deltas replicated from the leader or shared by
+// another instance. An instance that doesn't poll becomes ready with the
+// first batch.
+func (i *Ingestor) Apply(deltas []domain.VehicleDelta) {
+	var updated []*domain.Vehicle
+	var applied []domain.VehicleDelta
+	for _, d := range deltas {
+		switch {
+		case d.Type == domain.DeltaRemove:
+			if removed, ok := i.store.Remove(d.Key); ok {
+				applied = append(applied, removed)
+			}
+		case d.Vehicle != nil:
+			d.Vehicle.TileID = hub.TileID(d.Vehicle.Lat, d.Vehicle.Lon, i.zoomLevel)
+			updated = append(updated, d.Vehicle)
+		}
+	}
+	applied = append(i.store.Update(updated), applied...)
+	i.publish(applied)
+	if !i.IsReady() && len(deltas) > 0 {
+		i.setReady(true)
+	}
+}
+
+// publish hands deltas not produced by a poll to the broadcaster and
+// recorders.
+func (i *Ingestor) publish(deltas []domain.VehicleDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	if i.broadcaster != nil {
+		i.broadcaster.Broadcast(deltas)
+	}
+	i.record(deltas)
+}
+
 func (i *Ingestor) IsReady() bool {
 	i.readyMu.RLock()
 	defer i.readyMu.RUnlock()
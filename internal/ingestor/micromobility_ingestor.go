@@ -0,0 +1,111 @@
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/hub"
+	"wabus/internal/store"
+	"wabus/pkg/gbfs"
+)
+
+// MicromobilityIngestor keeps the micromobility store in sync with GBFS
+// feeds of shared bikes and scooters.
+type MicromobilityIngestor struct {
+	clients   []*gbfs.Client
+	store     *store.MicromobilityStore
+	zoomLevel int
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// NewMicromobilityIngestor creates an ingestor for the systems whose GBFS
+// auto-discovery files are at urls. zoomLevel is the tile zoom stations and
+// vehicles are tagged with, as vehicles are.
+func NewMicromobilityIngestor(urls []string, s *store.MicromobilityStore, zoomLevel int, interval time.Duration, logger *slog.Logger) *MicromobilityIngestor {
+	clients := make([]*gbfs.Client, len(urls))
+	for i, url := range urls {
+		clients[i] = gbfs.New(url)
+	}
+	return &MicromobilityIngestor{
+		clients:   clients,
+		store:     s,
+		zoomLevel: zoomLevel,
+		interval:  interval,
+		logger:    logger.With("component", "micromobility_ingestor"),
+	}
+}
+
+func (i *MicromobilityIngestor) Start(ctx context.Context) {
+	i.update(ctx)
+
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			i.update(ctx)
+		}
+	}
+}
+
+func (i *MicromobilityIngestor) update(ctx context.Context) {
+	for n, client := range i.clients {
+		feed, err := client.Fetch(ctx)
+		if err != nil {
+			// Keep the previous state rather than clearing it on a failed fetch.
+			i.logger.Warn("failed to fetch GBFS feed", "feed", n, "error", err)
+			continue
+		}
+		system := feed.SystemID
+		if system == "" {
+			system = fmt.Sprintf("gbfs%d", n+1)
+		}
+		name := feed.SystemName
+		if name == "" {
+			name = system
+		}
+
+		stations := make([]*domain.MicromobilityStation, 0, len(feed.Stations))
+		for _, s := range feed.Stations {
+			stations = append(stations, &domain.MicromobilityStation{
+				ID:                system + ":" + s.ID,
+				System:            system,
+				Name:              s.Name,
+				Lat:               s.Lat,
+				Lon:               s.Lon,
+				TileID:            hub.TileID(s.Lat, s.Lon, i.zoomLevel),
+				Capacity:          s.Capacity,
+				VehiclesAvailable: s.VehiclesAvailable,
+				DocksAvailable:    s.DocksAvailable,
+				IsRenting:         s.IsRenting,
+				IsReturning:       s.IsReturning,
+				LastReported:      s.LastReported,
+			})
+		}
+		vehicles := make([]*domain.MicromobilityVehicle, 0, len(feed.Vehicles))
+		for _, v := range feed.Vehicles {
+			if v.IsReserved || v.IsDisabled {
+				continue
+			}
+			vehicles = append(vehicles, &domain.MicromobilityVehicle{
+				ID:          system + ":" + v.ID,
+				System:      system,
+				Lat:         v.Lat,
+				Lon:         v.Lon,
+				TileID:      hub.TileID(v.Lat, v.Lon, i.zoomLevel),
+				TypeID:      v.TypeID,
+				RangeMeters: v.RangeMeters,
+			})
+		}
+
+		i.store.ReplaceSystem(system, name, stations, vehicles, time.Now())
+		i.logger.Debug("GBFS feed refreshed", "system", system, "stations", len(stations), "vehicles", len(vehicles))
+	}
+}
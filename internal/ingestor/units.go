@@ -0,0 +1,61 @@
+package ingestor
+
+import (
+	"sort"
+
+	"wabus/internal/domain"
+	"wabus/pkg/geo"
+)
+
+// unitKey identifies the run a vehicle reports: units coupled into one
+// train report the same line and brigade.
+type unitKey struct {
+	vehicleType domain.VehicleType
+	line        string
+	brigade     string
+}
+
+// groupUnits links vehicles of the grouped types that run the same line and
+// brigade within UnitGroupDistance of each other. The unit with the lowest
+// number leads: it lists every unit's number in Units, and the others point
+// to it with LeadKey so clients can draw one marker.
+func (i *Ingestor) groupUnits(vehicles []*domain.Vehicle) {
+	if len(i.unitTypes) == 0 {
+		return
+	}
+
+	runs := make(map[unitKey][]*domain.Vehicle)
+	for _, v := range vehicles {
+		if i.unitTypes[v.Type] && v.Line != "" && v.Brigade != "" {
+			k := unitKey{v.Type, v.Line, v.Brigade}
+			runs[k] = append(runs[k], v)
+		}
+	}
+
+	maxDistance := float64(i.config.UnitGroupDistance)
+	for _, run := range runs {
+		if len(run) < 2 {
+			continue
+		}
+		sort.Slice(run, func(a, b int) bool { return run[a].VehicleNumber < run[b].VehicleNumber })
+		grouped := make([]bool, len(run))
+		for a, lead := range run {
+			if grouped[a] {
+				continue
+			}
+			units := []string{lead.VehicleNumber}
+			for b := a + 1; b < len(run); b++ {
+				v := run[b]
+				if grouped[b] || geo.Distance(lead.Lat, lead.Lon, v.Lat, v.Lon) > maxDistance {
+					continue
+				}
+				grouped[b] = true
+				v.LeadKey = lead.Key
+				units = append(units, v.VehicleNumber)
+			}
+			if len(units) > 1 {
+				lead.Units = units
+			}
+		}
+	}
+}
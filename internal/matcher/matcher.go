@@ -0,0 +1,299 @@
+package matcher
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+	"wabus/pkg/geo"
+)
+
+const (
+	// maxShapeDistance is how far (meters) a vehicle may be from a shape to match it.
+	maxShapeDistance = 100.0
+	// minMovement is the displacement (meters) needed to trust the heading.
+	minMovement = 10.0
+	// maxHeadingDiff is the largest heading/shape bearing difference accepted.
+	maxHeadingDiff = 75.0
+)
+
+// Matcher relates live vehicles to the GTFS shapes of their line.
+// Shapes are cached per line and rebuilt whenever the GTFS dataset changes.
+type Matcher struct {
+	store  *store.GTFSStore
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	version    time.Time
+	lines      map[string][]*routeShape // line -> shapes
+	fullShapes bool
+}
+
+type routeShape struct {
+	id          string
+	directionID int
+	headsign    string
+	points      []domain.ShapePoint
+	cum         []float64 // distance along the shape at each point
+}
+
+func New(gtfsStore *store.GTFSStore, logger *slog.Logger) *Matcher {
+	return &Matcher{
+		store:  gtfsStore,
+		logger: logger.With("component", "matcher"),
+		lines:  make(map[string][]*routeShape),
+	}
+}
+
+// SetFullShapes makes the matcher use full-resolution shapes instead of the
+// simplified ones kept in memory, e.g. to compare the two as a canary.
+func (m *Matcher) SetFullShapes(full bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fullShapes = full
+	m.version = time.Time{}
+}
+
+// TagDirections sets DirectionID and Headsign on each vehicle by comparing its
+// heading since the previous position with the bearing of nearby line shapes.
+// Vehicles that haven't moved keep their previous tag. Tagged vehicles are
+// also snapped onto the shape of their direction.
+func (m *Matcher) TagDirections(vehicles []*domain.Vehicle, previous func(key string) (*domain.Vehicle, bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshLocked()
+
+	tagged, snapped := 0, 0
+	for _, v := range vehicles {
+		v.Snapped = nil
+		prev, ok := previous(v.Key)
+		if !ok || prev.Line != v.Line {
+			continue
+		}
+
+		var shape *routeShape
+		if geo.Distance(prev.Lat, prev.Lon, v.Lat, v.Lon) < minMovement {
+			v.DirectionID = prev.DirectionID
+			v.Headsign = prev.Headsign
+			if prev.Snapped != nil {
+				shape = m.shapeLocked(v.Line, prev.Snapped.ShapeID)
+			}
+		} else {
+			heading := geo.Bearing(prev.Lat, prev.Lon, v.Lat, v.Lon)
+			if shape = m.matchShapeLocked(v, heading); shape != nil {
+				dir := shape.directionID
+				v.DirectionID = &dir
+				v.Headsign = shape.headsign
+				tagged++
+			}
+		}
+
+		if m.snapLocked(v, shape) {
+			snapped++
+		}
+	}
+
+	m.logger.Debug("tagged vehicle directions", "tagged", tagged, "snapped", snapped, "total", len(vehicles))
+}
+
+// snapLocked projects the vehicle onto shape, or without one onto the
+// nearest line shape of its direction. It reports whether a shape was close
+// enough.
+func (m *Matcher) snapLocked(v *domain.Vehicle, shape *routeShape) bool {
+	if shape == nil {
+		if v.DirectionID == nil {
+			return false
+		}
+		bestDist := maxShapeDistance
+		for _, candidate := range m.lines[v.Line] {
+			if candidate.directionID != *v.DirectionID {
+				continue
+			}
+			if seg, _, dist := nearestSegment(candidate.points, v.Lat, v.Lon); seg >= 0 && dist <= bestDist {
+				shape, bestDist = candidate, dist
+			}
+		}
+		if shape == nil {
+			return false
+		}
+	}
+
+	seg, t, dist := nearestSegment(shape.points, v.Lat, v.Lon)
+	if seg < 0 || dist > maxShapeDistance {
+		return false
+	}
+	a, b := shape.points[seg], shape.points[seg+1]
+	lat, lon := geo.Interpolate(a.Lat, a.Lon, b.Lat, b.Lon, t)
+	v.Snapped = &domain.SnappedPosition{
+		Lat:           lat,
+		Lon:           lon,
+		ShapeID:       shape.id,
+		DistanceAlong: math.Round(shape.cum[seg] + t*(shape.cum[seg+1]-shape.cum[seg])),
+		Offset:        math.Round(dist*10) / 10,
+	}
+	return true
+}
+
+// shapeLocked returns the line's shape with the ID, or nil.
+func (m *Matcher) shapeLocked(line, shapeID string) *routeShape {
+	for _, shape := range m.lines[line] {
+		if shape.id == shapeID {
+			return shape
+		}
+	}
+	return nil
+}
+
+// matchShapeLocked returns the line shape closest to the vehicle whose local
+// bearing agrees with the vehicle heading, or nil.
+func (m *Matcher) matchShapeLocked(v *domain.Vehicle, heading float64) *routeShape {
+	var best *routeShape
+	bestDist := maxShapeDistance
+
+	for _, shape := range m.lines[v.Line] {
+		seg, _, dist := nearestSegment(shape.points, v.Lat, v.Lon)
+		if seg < 0 || dist > bestDist {
+			continue
+		}
+		a, b := shape.points[seg], shape.points[seg+1]
+		if geo.AngleDiff(heading, geo.Bearing(a.Lat, a.Lon, b.Lat, b.Lon)) > maxHeadingDiff {
+			continue
+		}
+		best, bestDist = shape, dist
+	}
+	return best
+}
+
+// nearestSegment returns the index of the shape segment closest to the point,
+// the fraction along it and the distance in meters. The index is -1 for
+// shapes with fewer than two points.
+func nearestSegment(points []domain.ShapePoint, lat, lon float64) (index int, t, dist float64) {
+	index = -1
+	for i := 0; i+1 < len(points); i++ {
+		a, b := points[i], points[i+1]
+		segT, d := geo.ProjectToSegment(lat, lon, a.Lat, a.Lon, b.Lat, b.Lon)
+		if index < 0 || d < dist {
+			index, t, dist = i, segT, d
+		}
+	}
+	return index, t, dist
+}
+
+// refreshLocked drops cached shapes when the GTFS dataset has been swapped.
+func (m *Matcher) refreshLocked() {
+	stats := m.store.GetStats()
+	if stats.LastUpdate.Equal(m.version) {
+		return
+	}
+	m.version = stats.LastUpdate
+	m.lines = make(map[string][]*routeShape)
+
+	for _, route := range m.store.GetAllRoutes() {
+		shapes := m.store.GetSimplifiedRouteShapes
+		if m.fullShapes {
+			shapes = m.store.GetRouteShapes
+		}
+		for _, shape := range shapes(route.ID) {
+			rs := &routeShape{
+				id:       shape.ID,
+				headsign: m.store.GetShapeHeadsign(shape.ID),
+				points:   shape.Points,
+			}
+			if shape.DirectionID != nil {
+				rs.directionID = *shape.DirectionID
+			}
+			rs.cum = make([]float64, len(rs.points))
+			for i := 1; i < len(rs.points); i++ {
+				a, b := rs.points[i-1], rs.points[i]
+				rs.cum[i] = rs.cum[i-1] + geo.Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+			}
+			m.lines[route.ShortName] = append(m.lines[route.ShortName], rs)
+		}
+	}
+	m.logger.Info("matcher shapes rebuilt", "lines", len(m.lines), "version", m.version)
+}
+
+// maxStopDistance is how far (meters) a stop may be from a shape to lie on it.
+const maxStopDistance = 150.0
+
+// Path is the stretch of a line shape between a vehicle and a stop ahead of it.
+type Path struct {
+	ShapeID     string
+	DirectionID int
+	Headsign    string
+	Points      []domain.ShapePoint
+	Meters      float64
+}
+
+// PathTo returns the shape path from a vehicle's position to a stop further
+// along its line. Vehicles with a known direction only match shapes of that
+// direction. ok is false when the vehicle isn't on a line shape or the stop
+// isn't ahead of it on any of them.
+func (m *Matcher) PathTo(v *domain.Vehicle, stop *domain.Stop) (path *Path, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshLocked()
+
+	bestVehicleDist := maxShapeDistance
+	for _, shape := range m.lines[v.Line] {
+		if v.DirectionID != nil && shape.directionID != *v.DirectionID {
+			continue
+		}
+		vSeg, vT, vDist := nearestSegment(shape.points, v.Lat, v.Lon)
+		if vSeg < 0 || vDist > bestVehicleDist {
+			continue
+		}
+
+		// Only look for the stop from the vehicle onwards, so loop lines
+		// don't match the stop's visit on the way out.
+		sSeg, sT, sDist := nearestSegment(shape.points[vSeg:], stop.Lat, stop.Lon)
+		if sSeg < 0 || sDist > maxStopDistance || (sSeg == 0 && sT < vT) {
+			continue
+		}
+		sSeg += vSeg
+
+		if path != nil && vDist == bestVehicleDist {
+			if candidate := buildPath(shape, vSeg, vT, sSeg, sT); candidate.Meters < path.Meters {
+				path = candidate
+			}
+			continue
+		}
+		path, bestVehicleDist = buildPath(shape, vSeg, vT, sSeg, sT), vDist
+	}
+	return path, path != nil
+}
+
+// buildPath cuts a shape from fraction fromT of segment fromSeg to fraction
+// toT of segment toSeg.
+func buildPath(shape *routeShape, fromSeg int, fromT float64, toSeg int, toT float64) *Path {
+	at := func(seg int, t float64) domain.ShapePoint {
+		a, b := shape.points[seg], shape.points[seg+1]
+		lat, lon := geo.Interpolate(a.Lat, a.Lon, b.Lat, b.Lon, t)
+		return domain.ShapePoint{Lat: lat, Lon: lon}
+	}
+
+	points := []domain.ShapePoint{at(fromSeg, fromT)}
+	for i := fromSeg + 1; i <= toSeg; i++ {
+		points = append(points, domain.ShapePoint{Lat: shape.points[i].Lat, Lon: shape.points[i].Lon})
+	}
+	points = append(points, at(toSeg, toT))
+
+	var meters float64
+	for i := range points {
+		points[i].Sequence = i
+		if i > 0 {
+			meters += geo.Distance(points[i-1].Lat, points[i-1].Lon, points[i].Lat, points[i].Lon)
+		}
+	}
+
+	return &Path{
+		ShapeID:     shape.id,
+		DirectionID: shape.directionID,
+		Headsign:    shape.headsign,
+		Points:      points,
+		Meters:      meters,
+	}
+}
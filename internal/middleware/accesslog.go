@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// AccessLog writes one log record per request: method, path, status,
+// response bytes, duration and client IP. The request ID is added by a
+// RequestIDHandler, as for every record logged with the request context.
+type AccessLog struct {
+	logger     *slog.Logger
+	sampleRate float64
+}
+
+// NewAccessLog logs the share sampleRate (0-1) of requests. Server errors
+// are always logged.
+func NewAccessLog(sampleRate float64, logger *slog.Logger) *AccessLog {
+	return &AccessLog{logger: logger.With("component", "access_log"), sampleRate: sampleRate}
+}
+
+// Middleware wraps next with access logging. Wrapped outermost, it sees
+// every request, including rejected ones, and the bytes sent after
+// compression. WebSocket and stream requests are logged when they end.
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		if sw.status < 500 && a.sampleRate < 1 && rand.Float64() >= a.sampleRate {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", getClientIP(r)),
+		}
+		level := slog.LevelInfo
+		if sw.status >= 500 {
+			level = slog.LevelError
+		}
+		a.logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrencyLimiter caps the number of in-flight requests per endpoint
+// class, so bulk consumers of expensive endpoints can't monopolize the
+// store locks. Requests over the cap are rejected immediately with 503.
+type ConcurrencyLimiter struct {
+	mu      sync.Mutex
+	classes map[string]*concurrencyClass
+	logger  *slog.Logger
+}
+
+type concurrencyClass struct {
+	slots    chan struct{}
+	rejected atomic.Int64
+}
+
+func NewConcurrencyLimiter(logger *slog.Logger) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		classes: make(map[string]*concurrencyClass),
+		logger:  logger.With("component", "concurrency_limiter"),
+	}
+}
+
+// Limit wraps next so at most max requests of the class run at once. Handlers
+// sharing a class share its slots. A non-positive max disables the limit.
+func (l *ConcurrencyLimiter) Limit(class string, max int, next http.HandlerFunc) http.HandlerFunc {
+	if max <= 0 {
+		return next
+	}
+
+	l.mu.Lock()
+	c, ok := l.classes[class]
+	if !ok {
+		c = &concurrencyClass{slots: make(chan struct{}, max)}
+		l.classes[class] = c
+	}
+	l.mu.Unlock()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case c.slots <- struct{}{}:
+			defer func() { <-c.slots }()
+			next(w, r)
+		default:
+			c.rejected.Add(1)
+			l.logger.WarnContext(r.Context(), "concurrency limit reached", "class", class, "path", r.URL.Path)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// Stats returns in-flight and rejected request counts per class.
+func (l *ConcurrencyLimiter) Stats() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make(map[string]interface{}, len(l.classes))
+	for name, c := range l.classes {
+		result[name] = map[string]interface{}{
+			"in_flight": len(c.slots),
+			"limit":     cap(c.slots),
+			"rejected":  c.rejected.Load(),
+		}
+	}
+	return result
+}
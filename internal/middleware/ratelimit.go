@@ -2,27 +2,75 @@ package middleware
 
 import (
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"wabus/internal/cache"
 )
 
-// RateLimiter implements a simple token bucket rate limiter per IP
+// RateLimiter implements a token bucket rate limiter per IP. Buckets hold
+// a budget plus a burst allowance and refill continuously at the budget per
+// window, so a client starting up can fire a burst of requests and a steady
+// one never hits a hard window reset.
 type RateLimiter struct {
 	mu        sync.RWMutex
 	clients   map[string]*client
 	rate      int           // requests per window
 	window    time.Duration // time window
+	burst     int           // tokens on top of a budget
 	cleanup   time.Duration // cleanup interval
 	whitelist map[string]struct{}
+	exempt    []string     // path prefixes that bypass the limiter
+	budgets   []pathBudget // path prefixes with their own budget
+	cache     *cache.RedisCache
 	logger    *slog.Logger
+	now       func() time.Time // time.Now, replaced in tests
+}
+
+// pathBudget gives requests under a path prefix a separate per-IP budget.
+type pathBudget struct {
+	prefix string
+	rate   int
 }
 
 type client struct {
-	tokens    int
-	lastReset time.Time
+	tokens     float64
+	lastRefill time.Time
+
+	ip       string
+	budget   string
+	rate     int
+	capacity float64
+	// requests, including rejected ones, and rejections are counted per
+	// window from windowStart, for reporting.
+	windowStart time.Time
+	requests    int
+	rejected    int
+}
+
+// tokensAt is what the bucket holds at now, counting the tokens earned
+// since the last refill.
+func (c *client) tokensAt(now time.Time, window time.Duration) float64 {
+	earned := now.Sub(c.lastRefill).Seconds() * float64(c.rate) / window.Seconds()
+	return min(c.capacity, c.tokens+earned)
+}
+
+func (c *client) refill(now time.Time, window time.Duration) {
+	c.tokens = c.tokensAt(now, window)
+	c.lastRefill = now
+}
+
+// untilTokens is how long a bucket holding tokens takes to hold n.
+func (c *client) untilTokens(tokens, n float64, window time.Duration) time.Duration {
+	if tokens >= n {
+		return 0
+	}
+	return time.Duration((n - tokens) / float64(c.rate) * float64(window))
 }
 
 // NewRateLimiter creates a rate limiter allowing 'rate' requests per 'window'.
@@ -43,6 +91,7 @@ func NewRateLimiter(rate int, window time.Duration, whitelist []string, logger *
 		cleanup:   window * 2,
 		whitelist: wl,
 		logger:    logger.With("component", "rate_limiter"),
+		now:       time.Now,
 	}
 
 	// Start cleanup goroutine
@@ -56,9 +105,11 @@ func (rl *RateLimiter) cleanupLoop() {
 	defer ticker.Stop()
 	for range ticker.C {
 		rl.mu.Lock()
-		now := time.Now()
+		now := rl.now()
 		for ip, c := range rl.clients {
-			if now.Sub(c.lastReset) > rl.cleanup {
+			// A bucket that would be full again is as good as a new one.
+			c.refill(now, rl.window)
+			if c.tokens >= c.capacity && now.Sub(c.windowStart) > rl.window {
 				delete(rl.clients, ip)
 			}
 		}
@@ -66,6 +117,59 @@ func (rl *RateLimiter) cleanupLoop() {
 	}
 }
 
+// SetExemptPaths makes requests whose path starts with any of the prefixes
+// bypass the limiter, e.g. health probes.
+func (rl *RateLimiter) SetExemptPaths(prefixes []string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.exempt = append([]string(nil), prefixes...)
+}
+
+// SetBurst lets every bucket hold burst tokens on top of its budget, so
+// clients may exceed the budget briefly, e.g. a map loading its layers on
+// start. It applies to buckets created afterwards.
+func (rl *RateLimiter) SetBurst(burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.burst = burst
+}
+
+// SetPathBudget counts requests under prefix against a separate budget of
+// rate requests per window per IP instead of the default one. The longest
+// matching prefix wins.
+func (rl *RateLimiter) SetPathBudget(prefix string, rate int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for i := range rl.budgets {
+		if rl.budgets[i].prefix == prefix {
+			rl.budgets[i].rate = rate
+			return
+		}
+	}
+	rl.budgets = append(rl.budgets, pathBudget{prefix: prefix, rate: rate})
+}
+
+// classify returns the budget a path is counted against ("" for the default
+// budget) and its rate, or exempt=true if the path bypasses the limiter.
+func (rl *RateLimiter) classify(path string) (budget string, rate int, exempt bool) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	for _, prefix := range rl.exempt {
+		if strings.HasPrefix(path, prefix) {
+			return "", 0, true
+		}
+	}
+
+	rate = rl.rate
+	for _, b := range rl.budgets {
+		if strings.HasPrefix(path, b.prefix) && len(b.prefix) > len(budget) {
+			budget, rate = b.prefix, b.rate
+		}
+	}
+	return budget, rate, false
+}
+
 func (rl *RateLimiter) IsWhitelisted(ip string) bool {
 	rl.mu.RLock()
 	defer rl.mu.RUnlock()
@@ -75,48 +179,83 @@ func (rl *RateLimiter) IsWhitelisted(ip string) bool {
 
 // Allow checks if a request from the given IP should be allowed
 func (rl *RateLimiter) Allow(ip string) bool {
+	ok, _ := rl.allow(ip, "", rl.rate)
+	return ok
+}
+
+// allow takes a token from the IP's bucket for budget ("" is the default
+// budget), which refills at rate tokens per window. A rejected request
+// learns how long until a token is available.
+func (rl *RateLimiter) allow(ip, budget string, rate int) (bool, time.Duration) {
+	key := bucketKey(ip, budget)
+
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
-	c, exists := rl.clients[ip]
-
+	now := rl.now()
+	c, exists := rl.clients[key]
 	if !exists {
-		rl.clients[ip] = &client{
-			tokens:    rl.rate - 1,
-			lastReset: now,
-		}
-		return true
+		// New clients start with a full bucket.
+		c = rl.newClient(ip, budget, rate, now)
+		rl.clients[key] = c
 	}
+	c.refill(now, rl.window)
 
-	// Reset tokens if window has passed
-	if now.Sub(c.lastReset) > rl.window {
-		c.tokens = rl.rate - 1
-		c.lastReset = now
-		return true
+	if now.Sub(c.windowStart) > rl.window {
+		c.windowStart = now
+		c.requests = 0
+		c.rejected = 0
 	}
+	c.requests++
 
-	// Check if tokens available
-	if c.tokens > 0 {
+	if c.tokens >= 1 {
 		c.tokens--
-		return true
+		return true, 0
 	}
 
-	return false
+	c.rejected++
+	return false, c.untilTokens(c.tokens, 1, rl.window)
+}
+
+func (rl *RateLimiter) newClient(ip, budget string, rate int, now time.Time) *client {
+	capacity := float64(rate + rl.burst)
+	return &client{
+		tokens:      capacity,
+		lastRefill:  now,
+		ip:          ip,
+		budget:      budget,
+		rate:        rate,
+		capacity:    capacity,
+		windowStart: now,
+	}
+}
+
+// retryAfter formats wait as a Retry-After value, rounding up to whole
+// seconds so a client retrying on time finds a token.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+func bucketKey(ip, budget string) string {
+	if budget == "" {
+		return ip
+	}
+	return budget + " " + ip
 }
 
 // Middleware returns an HTTP middleware that applies rate limiting
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ip := getClientIP(r)
-		if rl.IsWhitelisted(ip) {
+		budget, rate, exempt := rl.classify(r.URL.Path)
+		if exempt || rl.IsWhitelisted(ip) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		if !rl.Allow(ip) {
-			rl.logger.Warn("rate limit exceeded", "ip", ip, "path", r.URL.Path)
-			w.Header().Set("Retry-After", "60")
+		if ok, wait := rl.allow(ip, budget, rate); !ok {
+			rl.logger.WarnContext(r.Context(), "rate limit exceeded", "ip", ip, "path", r.URL.Path, "budget", budget)
+			w.Header().Set("Retry-After", retryAfter(wait))
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
@@ -153,10 +292,18 @@ func (rl *RateLimiter) Stats() map[string]interface{} {
 	rl.mu.RLock()
 	defer rl.mu.RUnlock()
 
+	budgets := make(map[string]int, len(rl.budgets))
+	for _, b := range rl.budgets {
+		budgets[b.prefix] = b.rate
+	}
+
 	return map[string]interface{}{
 		"tracked_ips":      len(rl.clients),
 		"rate_per_window":  rl.rate,
+		"burst":            rl.burst,
 		"window_seconds":   rl.window.Seconds(),
 		"whitelist_entries": len(rl.whitelist),
+		"exempt_paths":     rl.exempt,
+		"path_budgets":     budgets,
 	}
 }
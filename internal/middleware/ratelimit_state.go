@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"wabus/internal/cache"
+)
+
+// ConsumerStats describes one IP's use of a budget: requests and
+// rejections in the current window, the budget per window and the burst
+// allowance, the requests it can make right now, and when its bucket is
+// full again if it stops.
+type ConsumerStats struct {
+	IP        string    `json:"ip"`
+	Budget    string    `json:"budget,omitempty"`
+	Requests  int       `json:"requests"`
+	Rejected  int       `json:"rejected"`
+	Limit     int       `json:"limit"`
+	Burst     int       `json:"burst"`
+	Remaining int       `json:"remaining"`
+	FullAt    time.Time `json:"full_at"`
+}
+
+// persistedBucket is the Redis representation of a heavy offender's bucket.
+type persistedBucket struct {
+	IP          string    `json:"ip"`
+	Budget      string    `json:"budget,omitempty"`
+	Tokens      float64   `json:"tokens"`
+	LastRefill  time.Time `json:"last_refill"`
+	Requests    int       `json:"requests"`
+	Rejected    int       `json:"rejected"`
+	WindowStart time.Time `json:"window_start"`
+}
+
+// SetCache enables persisting heavy offenders' buckets to Redis, so a restart
+// doesn't hand abusers a fresh budget.
+func (rl *RateLimiter) SetCache(c *cache.RedisCache) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.cache = c
+}
+
+// Top returns up to n consumers with the most requests in their current
+// window, heaviest first.
+func (rl *RateLimiter) Top(n int) []ConsumerStats {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	now := rl.now()
+	result := make([]ConsumerStats, 0, len(rl.clients))
+	for _, c := range rl.clients {
+		if now.Sub(c.windowStart) > rl.window {
+			continue
+		}
+		result = append(result, c.stats(now, rl.window))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].IP < result[j].IP
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func (c *client) stats(now time.Time, window time.Duration) ConsumerStats {
+	tokens := c.tokensAt(now, window)
+	return ConsumerStats{
+		IP:        c.ip,
+		Budget:    c.budget,
+		Requests:  c.requests,
+		Rejected:  c.rejected,
+		Limit:     c.rate,
+		Burst:     int(c.capacity) - c.rate,
+		Remaining: int(tokens),
+		FullAt:    now.Add(c.untilTokens(tokens, c.capacity, window)),
+	}
+}
+
+// Quota is what a client may still request, by budget.
+type Quota struct {
+	IP string `json:"ip"`
+	// Unlimited is true for whitelisted IPs, which have no budgets.
+	Unlimited     bool            `json:"unlimited"`
+	WindowSeconds float64         `json:"window_seconds"`
+	Budgets       []ConsumerStats `json:"budgets"`
+}
+
+// Quota reports the buckets of the client making r without taking tokens:
+// the default budget first, then each path budget. Budgets the client hasn't
+// used yet are full. Requests counts the current window only.
+func (rl *RateLimiter) Quota(r *http.Request) Quota {
+	ip := getClientIP(r)
+	q := Quota{IP: ip, WindowSeconds: rl.window.Seconds(), Budgets: []ConsumerStats{}}
+	if rl.IsWhitelisted(ip) {
+		q.Unlimited = true
+		return q
+	}
+
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	now := rl.now()
+	budgets := append([]pathBudget{{rate: rl.rate}}, rl.budgets...)
+	for _, b := range budgets {
+		c, ok := rl.clients[bucketKey(ip, b.prefix)]
+		if !ok {
+			c = rl.newClient(ip, b.prefix, b.rate, now)
+		}
+		stats := c.stats(now, rl.window)
+		if now.Sub(c.windowStart) > rl.window {
+			stats.Requests, stats.Rejected = 0, 0
+		}
+		q.Budgets = append(q.Budgets, stats)
+	}
+	return q
+}
+
+// isOffender reports whether a bucket holding tokens is worth persisting:
+// it is less than half full.
+func (c *client) isOffender(tokens float64) bool {
+	return tokens*2 < c.capacity
+}
+
+// Load restores persisted buckets that haven't refilled yet.
+func (rl *RateLimiter) Load(ctx context.Context) error {
+	if rl.cache == nil {
+		return nil
+	}
+
+	values, err := rl.cache.GetPattern(ctx, cache.KeyRateLimitBuckets)
+	if err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	restored := 0
+	for _, value := range values {
+		var b persistedBucket
+		if err := json.Unmarshal(value, &b); err != nil {
+			rl.logger.Warn("skipping invalid persisted rate limit bucket", "error", err)
+			continue
+		}
+		rate := rl.rate
+		for _, pb := range rl.budgets {
+			if pb.prefix == b.Budget {
+				rate = pb.rate
+			}
+		}
+		c := rl.newClient(b.IP, b.Budget, rate, b.LastRefill)
+		c.tokens = min(b.Tokens, c.capacity)
+		c.refill(now, rl.window)
+		if c.tokens >= c.capacity {
+			continue
+		}
+		if now.Sub(b.WindowStart) <= rl.window {
+			c.windowStart, c.requests, c.rejected = b.WindowStart, b.Requests, b.Rejected
+		}
+		rl.clients[bucketKey(b.IP, b.Budget)] = c
+		restored++
+	}
+	rl.logger.Info("restored rate limit state", "buckets", restored)
+	return nil
+}
+
+// Flush writes the buckets of current heavy offenders to Redis, each under
+// its own key, so instances behind a load balancer add to the persisted
+// state instead of replacing each other's. A bucket's key expires once it
+// would have refilled; until then, Load refills it by the time passed, so a
+// client that stopped being an offender needs no delete.
+func (rl *RateLimiter) Flush(ctx context.Context) error {
+	if rl.cache == nil {
+		return nil
+	}
+
+	rl.mu.RLock()
+	now := rl.now()
+	var entries []cache.JSONEntry
+	for key, c := range rl.clients {
+		tokens := c.tokensAt(now, rl.window)
+		if !c.isOffender(tokens) {
+			continue
+		}
+		entries = append(entries, cache.JSONEntry{
+			Key: cache.KeyRateLimitBucket(key),
+			Value: persistedBucket{
+				IP:          c.ip,
+				Budget:      c.budget,
+				Tokens:      tokens,
+				LastRefill:  now,
+				Requests:    c.requests,
+				Rejected:    c.rejected,
+				WindowStart: c.windowStart,
+			},
+			TTL: max(rl.window, c.untilTokens(tokens, c.capacity, rl.window)),
+		})
+	}
+	rl.mu.RUnlock()
+
+	return rl.cache.SetJSONBatch(ctx, entries)
+}
+
+// Run flushes offender state to Redis every interval until ctx is cancelled.
+func (rl *RateLimiter) Run(ctx context.Context, interval time.Duration) {
+	if rl.cache == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := rl.Flush(flushCtx); err != nil {
+				rl.logger.Warn("final rate limit flush failed", "error", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := rl.Flush(ctx); err != nil {
+				rl.logger.Warn("rate limit flush failed", "error", err)
+			}
+		}
+	}
+}
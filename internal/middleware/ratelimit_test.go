@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testLimiter returns a limiter of rate requests per minute plus burst, and
+// a function moving its clock forward.
+func testLimiter(rate, burst int) (*RateLimiter, func(time.Duration)) {
+	now := time.Unix(1_800_000_000, 0)
+	rl := NewRateLimiter(rate, time.Minute, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	rl.SetBurst(burst)
+	rl.now = func() time.Time { return now }
+	return rl, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiterNewClientStartsFull(t *testing.T) {
+	rl, _ := testLimiter(60, 10)
+	c := rl.newClient("10.0.0.1", "/v1/gtfs", 30, rl.now())
+	if c.capacity != 40 || c.tokens != 40 {
+		t.Errorf("got %v tokens of %v, want a full bucket of budget plus burst (40)", c.tokens, c.capacity)
+	}
+}
+
+func TestRateLimiterBurstExhaustion(t *testing.T) {
+	rl, _ := testLimiter(60, 10)
+	for i := range 70 {
+		if ok, _ := rl.allow("10.0.0.1", "", 60); !ok {
+			t.Fatalf("request %d rejected within budget plus burst", i+1)
+		}
+	}
+	ok, wait := rl.allow("10.0.0.1", "", 60)
+	if ok {
+		t.Fatal("request beyond budget plus burst allowed")
+	}
+	if wait != time.Second {
+		t.Errorf("got wait %v, want 1s for one token at 60/min", wait)
+	}
+	if ok, _ := rl.allow("10.0.0.2", "", 60); !ok {
+		t.Error("another IP shares the exhausted bucket")
+	}
+}
+
+func TestRateLimiterPartialRefill(t *testing.T) {
+	rl, advance := testLimiter(6, 0) // a token every 10s
+	for range 6 {
+		rl.allow("10.0.0.1", "", 6)
+	}
+
+	advance(4 * time.Second)
+	ok, wait := rl.allow("10.0.0.1", "", 6)
+	if ok {
+		t.Fatal("allowed with 0.4 tokens")
+	}
+	if wait.Round(time.Millisecond) != 6*time.Second {
+		t.Errorf("got wait %v, want the 6s left until a whole token", wait)
+	}
+
+	advance(6 * time.Second)
+	if ok, _ := rl.allow("10.0.0.1", "", 6); !ok {
+		t.Fatal("rejected once a token refilled")
+	}
+	if ok, _ := rl.allow("10.0.0.1", "", 6); ok {
+		t.Fatal("allowed twice on a single refilled token")
+	}
+
+	// Refilling stops at capacity however long the client stays away.
+	advance(time.Hour)
+	c := rl.clients["10.0.0.1"]
+	c.refill(rl.now(), rl.window)
+	if c.tokens != c.capacity {
+		t.Errorf("got %v tokens after an hour, want capacity %v", c.tokens, c.capacity)
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	rl, advance := testLimiter(6, 0)
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/vehicles", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for range 6 {
+		get()
+	}
+
+	for _, tc := range []struct {
+		advance time.Duration
+		want    string
+	}{
+		{0, "10"},
+		{500 * time.Millisecond, "10"}, // 9.5s rounds up
+		{9 * time.Second, "1"},         // 0.5s rounds up
+	} {
+		advance(tc.advance)
+		w := get()
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("got status %d, want 429", w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != tc.want {
+			t.Errorf("got Retry-After %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestRateLimiterQuota(t *testing.T) {
+	rl, advance := testLimiter(6, 2)
+	for range 5 {
+		rl.allow("10.0.0.1", "", 6)
+	}
+	advance(5 * time.Second)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/quota", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	q := rl.Quota(r)
+	if len(q.Budgets) != 1 {
+		t.Fatalf("got %d budgets, want 1", len(q.Budgets))
+	}
+	b := q.Budgets[0]
+	// 3 tokens left plus half a token earned since.
+	if b.Remaining != 3 || b.Requests != 5 {
+		t.Errorf("got %d remaining after %d requests, want 3 after 5", b.Remaining, b.Requests)
+	}
+	if want := rl.now().Add(45 * time.Second); !b.FullAt.Equal(want) {
+		t.Errorf("got full at %v, want %v for 4.5 tokens at 6/min", b.FullAt, want)
+	}
+}
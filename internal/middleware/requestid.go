@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// maxRequestIDLength bounds inbound request IDs, which end up in every log
+// record of the request.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID gives every request an ID: the inbound X-Request-ID header when
+// a client or proxy sent a usable one, a random one otherwise. The ID is
+// echoed in the response header and carried in the request context, where
+// RequestIDHandler picks it up for log records. Wrap it outermost, so every
+// other middleware sees the ID.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// WithRequestID returns ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID in ctx, or "" outside a request.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts printable ASCII without spaces, so inbound IDs
+// can't forge log fields or response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// RequestIDHandler adds a request_id attribute to records logged with the
+// context of a request, e.g. through Logger.InfoContext.
+type RequestIDHandler struct {
+	slog.Handler
+}
+
+// NewRequestIDHandler wraps h.
+func NewRequestIDHandler(h slog.Handler) *RequestIDHandler {
+	return &RequestIDHandler{Handler: h}
+}
+
+func (h *RequestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFrom(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *RequestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &RequestIDHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *RequestIDHandler) WithGroup(name string) slog.Handler {
+	return &RequestIDHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestRecorder receives the outcome of every routed request, like
+// handler.Stats.
+type RequestRecorder interface {
+	// RecordRequest counts a request to the route pattern, "unmatched" if
+	// none matched, with its response status. duration is negative for
+	// requests that are not timed.
+	RecordRequest(pattern string, status int, duration time.Duration)
+}
+
+// RouteStats reports every request and its latency to recorder by route
+// pattern. It must wrap the ServeMux directly, which sets the matched
+// pattern on the request it is given. WebSocket and event stream requests
+// are counted but not timed, as they last as long as the connection.
+func RouteStats(recorder RequestRecorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			duration := time.Since(start)
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+				strings.HasPrefix(sw.Header().Get("Content-Type"), "text/event-stream") {
+				duration = -1
+			}
+			pattern := r.Pattern
+			if pattern == "" {
+				pattern = "unmatched"
+			}
+			recorder.RecordRequest(pattern, sw.status, duration)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records a server span for every request, continuing the trace of
+// an incoming traceparent header. It must wrap the ServeMux directly: spans
+// are named after the matched route pattern, which the mux sets on the
+// request it is given. WebSocket upgrades are passed through untraced, as
+// a span lasting the whole connection says nothing useful.
+func Tracing(next http.Handler) http.Handler {
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if route := routeOf(r); route != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.route", route))
+		}
+	})
+	return otelhttp.NewHandler(routed, "http",
+		// Called again with the pattern set once the mux has run.
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if route := routeOf(r); route != "" {
+				return r.Method + " " + route
+			}
+			return r.Method
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+		}),
+	)
+}
+
+// routeOf returns the path of the pattern the mux matched r with. Patterns
+// read "GET /v1/vehicles/{key}"; the method prefix is already in the name.
+func routeOf(r *http.Request) string {
+	if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+		return path
+	}
+	return r.Pattern
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingNamesSpansAfterRoutes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/vehicles/{key}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /v1/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	h := Tracing(mux)
+
+	for _, path := range []string{"/v1/vehicles/bus:1000", "/v1/fail"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	upgrade := httptest.NewRequest(http.MethodGet, "/v1/vehicles/bus:1000", nil)
+	upgrade.Header.Set("Upgrade", "websocket")
+	h.ServeHTTP(httptest.NewRecorder(), upgrade)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2: WebSocket upgrades aren't traced", len(spans))
+	}
+	if got := spans[0].Name(); got != "GET /v1/vehicles/{key}" {
+		t.Errorf("got span name %q, want the route", got)
+	}
+	var route string
+	for _, a := range spans[0].Attributes() {
+		if a.Key == attribute.Key("http.route") {
+			route = a.Value.AsString()
+		}
+	}
+	if route != "/v1/vehicles/{key}" {
+		t.Errorf("got http.route %q, want /v1/vehicles/{key}", route)
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("got status %v for a 500, want an error", spans[1].Status())
+	}
+}
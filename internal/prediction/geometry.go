@@ -0,0 +1,88 @@
+package prediction
+
+import (
+	"strings"
+
+	"wabus/internal/domain"
+	"wabus/pkg/geo"
+)
+
+const (
+	// maxShapeDistance is how far (meters) a vehicle may be from its trip's
+	// shape to be on the trip.
+	maxShapeDistance = 150.0
+	// maxStopShapeDistance is how far a stop may be from the shape; trips
+	// whose stops don't all lie on their shape fall back to straight lines.
+	maxStopShapeDistance = 150.0
+	// atStopMeters is how close along the shape a vehicle must be to a stop
+	// to count as standing at it.
+	atStopMeters = 25.0
+)
+
+// geometry measures positions along a trip's shape.
+type geometry struct {
+	points   []domain.ShapePoint
+	cum      []float64 // distance along the shape at each point
+	stopDist []float64 // distance along the shape at each trip stop
+}
+
+// geometryKey identifies trips sharing a shape and stop pattern, which share
+// their geometry.
+func geometryKey(trip *domain.ScheduledTrip) string {
+	var b strings.Builder
+	b.WriteString(trip.ShapeID)
+	for _, s := range trip.Stops {
+		b.WriteByte('|')
+		b.WriteString(s.StopID)
+	}
+	return b.String()
+}
+
+// newGeometry projects the trip's stops onto its shape in order, so a loop
+// passing a stop twice places each visit on the right pass. It returns nil
+// when a stop isn't near the shape.
+func newGeometry(points []domain.ShapePoint, stops []domain.ScheduledStop) *geometry {
+	if len(points) < 2 {
+		return nil
+	}
+	g := &geometry{points: points, cum: make([]float64, len(points)), stopDist: make([]float64, len(stops))}
+	for i := 1; i < len(points); i++ {
+		g.cum[i] = g.cum[i-1] + geo.Distance(points[i-1].Lat, points[i-1].Lon, points[i].Lat, points[i].Lon)
+	}
+
+	from := 0
+	for k, stop := range stops {
+		seg, t, dist := g.nearest(stop.Lat, stop.Lon, from)
+		if dist > maxStopShapeDistance {
+			return nil
+		}
+		g.stopDist[k] = g.at(seg, t)
+		from = seg
+	}
+	return g
+}
+
+// nearest returns the shape segment from index from onwards closest to the
+// point, the fraction along it and the distance in meters.
+func (g *geometry) nearest(lat, lon float64, from int) (seg int, t, dist float64) {
+	seg = -1
+	for i := from; i+1 < len(g.points); i++ {
+		a, b := g.points[i], g.points[i+1]
+		segT, d := geo.ProjectToSegment(lat, lon, a.Lat, a.Lon, b.Lat, b.Lon)
+		if seg < 0 || d < dist {
+			seg, t, dist = i, segT, d
+		}
+	}
+	return seg, t, dist
+}
+
+// at returns the distance along the shape of fraction t of segment seg.
+func (g *geometry) at(seg int, t float64) float64 {
+	return g.cum[seg] + t*(g.cum[seg+1]-g.cum[seg])
+}
+
+// progress returns how far along the shape the point is, and how far off it.
+func (g *geometry) progress(lat, lon float64) (along, off float64) {
+	seg, t, dist := g.nearest(lat, lon, 0)
+	return g.at(seg, t), dist
+}
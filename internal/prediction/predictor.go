@@ -0,0 +1,435 @@
+// Package prediction assigns live vehicles to scheduled GTFS trips and
+// predicts their arrival and departure at the stops still ahead.
+package prediction
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/store"
+	"wabus/pkg/geo"
+	"wabus/pkg/gtfsrt"
+)
+
+const (
+	// tripRefresh is how often the set of candidate trips is rebuilt.
+	tripRefresh = 5 * time.Minute
+	// The candidate window reaches back far enough for late vehicles still
+	// running trips that should have ended, and ahead past the next rebuild.
+	tripLookBack  = 30 * time.Minute
+	tripLookAhead = tripRefresh + 10*time.Minute
+
+	// maxTripDistance is how far (meters) a vehicle may be from the straight
+	// line between two trip stops to be on a trip without a usable shape.
+	maxTripDistance = 300.0
+	// Vehicles further off schedule than this aren't assigned to the trip.
+	maxEarly = 10 * time.Minute
+	maxLate  = 30 * time.Minute
+	// Positions older than this are too stale to predict from.
+	maxPositionAge = 5 * time.Minute
+	// minDwell is the shortest stop a late vehicle is expected to make; the
+	// rest of a longer scheduled dwell is recovered delay.
+	minDwell = 15 * time.Second
+)
+
+// Assignment is a vehicle matched to the trip it is running.
+type Assignment struct {
+	Vehicle *domain.Vehicle
+	Trip    *domain.ScheduledTrip
+	// Next is the index in Trip.Stops of the first stop not yet departed.
+	Next int
+	// AtStop is set when the vehicle is standing at Trip.Stops[Next].
+	AtStop bool
+	// Delay is how far behind schedule the vehicle is; negative when early.
+	Delay time.Duration
+}
+
+// StopPrediction is the predicted arrival and departure at one trip stop.
+type StopPrediction struct {
+	Stop           domain.ScheduledStop
+	Index          int // in Trip.Stops
+	Arrival        time.Time
+	Departure      time.Time
+	ArrivalDelay   time.Duration
+	DepartureDelay time.Duration
+}
+
+// Predictions returns the predicted times at the stops still ahead. Early
+// vehicles are expected to wait for their scheduled departure at the next
+// stop; late vehicles carry their delay forward, recovering the part of each
+// scheduled dwell longer than minDwell.
+func (a *Assignment) Predictions() []StopPrediction {
+	result := make([]StopPrediction, 0, len(a.Trip.Stops)-a.Next)
+	delay := a.Delay
+	for i := a.Next; i < len(a.Trip.Stops); i++ {
+		stop := a.Trip.Stops[i]
+		p := StopPrediction{Stop: stop, Index: i, ArrivalDelay: delay}
+		if delay > 0 {
+			recoverable := max(stop.Departure.Sub(stop.Arrival)-minDwell, 0)
+			delay = max(delay-recoverable, 0)
+		} else {
+			delay = 0
+		}
+		p.DepartureDelay = delay
+		p.Arrival = stop.Arrival.Add(p.ArrivalDelay)
+		p.Departure = stop.Departure.Add(p.DepartureDelay)
+		if p.Departure.Before(p.Arrival) {
+			p.Departure, p.DepartureDelay = p.Arrival, p.Arrival.Sub(stop.Departure)
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// Predictor matches the vehicle store against the GTFS schedule after every
+// ingestor poll.
+type Predictor struct {
+	gtfs     *store.GTFSStore
+	vehicles *store.Store
+	logger   *slog.Logger
+	trigger  chan struct{}
+
+	mu          sync.RWMutex
+	version     time.Time // GTFS dataset the trips were built from
+	tripsBuilt  time.Time
+	tripsByLine map[string][]*domain.ScheduledTrip
+	geometries  map[string]*geometry   // geometryKey -> geometry, nil without a usable shape
+	assignments map[string]*Assignment // vehicle key -> assignment
+	updatedAt   time.Time
+
+	onUpdate func(at time.Time)
+}
+
+func New(gtfsStore *store.GTFSStore, vehicles *store.Store, logger *slog.Logger) *Predictor {
+	return &Predictor{
+		gtfs:        gtfsStore,
+		vehicles:    vehicles,
+		logger:      logger.With("component", "predictor"),
+		trigger:     make(chan struct{}, 1),
+		tripsByLine: make(map[string][]*domain.ScheduledTrip),
+		geometries:  make(map[string]*geometry),
+		assignments: make(map[string]*Assignment),
+	}
+}
+
+// SetOnUpdate registers a callback invoked after every update with the time
+// the predictions were computed for. It runs on the predictor's goroutine.
+func (p *Predictor) SetOnUpdate(fn func(at time.Time)) {
+	p.onUpdate = fn
+}
+
+// Record schedules an update after the ingestor applied a batch of deltas.
+func (p *Predictor) Record(deltas []domain.VehicleDelta) {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run updates the predictions whenever Record is called, and at least every
+// interval, until ctx is cancelled.
+func (p *Predictor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.Update(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+// candidate is a possible vehicle/trip pairing.
+type candidate struct {
+	assignment *Assignment
+	tripKey    string
+}
+
+// Update reassigns every vehicle to a trip as of now.
+func (p *Predictor) Update(now time.Time) {
+	stats := p.gtfs.GetStats()
+	if !stats.IsLoaded {
+		return
+	}
+
+	p.reassign(now, stats)
+	if p.onUpdate != nil {
+		p.onUpdate(now)
+	}
+}
+
+func (p *Predictor) reassign(now time.Time, stats store.GTFSStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !stats.LastUpdate.Equal(p.version) || now.Sub(p.tripsBuilt) >= tripRefresh {
+		p.rebuildTripsLocked(now, stats.LastUpdate)
+	}
+
+	var candidates []candidate
+	for _, v := range p.vehicles.List(store.ListOptions{}) {
+		if now.Sub(v.Timestamp) > maxPositionAge {
+			continue
+		}
+		for _, trip := range p.tripsByLine[v.Line] {
+			if v.DirectionID != nil && *v.DirectionID != trip.DirectionID {
+				continue
+			}
+			if a, ok := locate(v, trip, p.geometries[geometryKey(trip)]); ok {
+				candidates = append(candidates, candidate{assignment: a, tripKey: TripKey(trip)})
+			}
+		}
+	}
+
+	// Pair vehicles and trips closest to schedule first, each at most once.
+	sort.Slice(candidates, func(i, j int) bool {
+		return absDuration(candidates[i].assignment.Delay) < absDuration(candidates[j].assignment.Delay)
+	})
+	assignments := make(map[string]*Assignment)
+	usedTrips := make(map[string]bool)
+	for _, c := range candidates {
+		key := c.assignment.Vehicle.Key
+		if _, taken := assignments[key]; taken || usedTrips[c.tripKey] {
+			continue
+		}
+		assignments[key] = c.assignment
+		usedTrips[c.tripKey] = true
+	}
+
+	p.assignments = assignments
+	p.updatedAt = now
+	p.logger.Debug("predictions updated", "assigned", len(assignments), "candidates", len(candidates))
+}
+
+func (p *Predictor) rebuildTripsLocked(now, version time.Time) {
+	trips := p.gtfs.GetScheduledTrips(now.Add(-tripLookBack), now.Add(tripLookAhead))
+	if !version.Equal(p.version) {
+		p.geometries = make(map[string]*geometry)
+	}
+
+	byLine := make(map[string][]*domain.ScheduledTrip)
+	for _, trip := range trips {
+		if len(trip.Stops) < 2 {
+			continue
+		}
+		byLine[trip.Line] = append(byLine[trip.Line], trip)
+
+		key := geometryKey(trip)
+		if _, ok := p.geometries[key]; !ok {
+			var g *geometry
+			if shape, ok := p.gtfs.GetSimplifiedShape(trip.ShapeID); ok {
+				g = newGeometry(shape.Points, trip.Stops)
+			}
+			p.geometries[key] = g
+		}
+	}
+
+	p.tripsByLine = byLine
+	p.tripsBuilt = now
+	p.version = version
+	p.logger.Debug("candidate trips rebuilt", "trips", len(trips), "lines", len(byLine), "geometries", len(p.geometries))
+}
+
+// locate places the vehicle between two consecutive stops of the trip and
+// derives its delay from the schedule interpolated at that point. Progress is
+// measured along the trip's shape when g is set, otherwise along straight
+// lines between the stops.
+func locate(v *domain.Vehicle, trip *domain.ScheduledTrip, g *geometry) (*Assignment, bool) {
+	seg, into, length, ok := position(v, trip, g)
+	if !ok {
+		return nil, false
+	}
+
+	a := &Assignment{Vehicle: v, Trip: trip}
+	switch {
+	case into < atStopMeters:
+		a.Next, a.AtStop = seg, true
+	case length-into < atStopMeters:
+		a.Next, a.AtStop = seg+1, true
+	default:
+		from, to := trip.Stops[seg], trip.Stops[seg+1]
+		scheduled := from.Departure.Add(time.Duration(into / length * float64(to.Arrival.Sub(from.Departure))))
+		a.Next, a.Delay = seg+1, v.Timestamp.Sub(scheduled)
+	}
+	if a.AtStop {
+		// Standing at a stop is on time anywhere between the scheduled
+		// arrival and departure.
+		stop := trip.Stops[a.Next]
+		switch {
+		case v.Timestamp.Before(stop.Arrival):
+			a.Delay = v.Timestamp.Sub(stop.Arrival)
+		case v.Timestamp.After(stop.Departure):
+			a.Delay = v.Timestamp.Sub(stop.Departure)
+		}
+		if a.Next == len(trip.Stops)-1 && a.Delay >= 0 {
+			// Arrived at the terminus: the trip is over.
+			return nil, false
+		}
+	}
+
+	a.Delay = a.Delay.Round(time.Second)
+	if a.Delay < -maxEarly || a.Delay > maxLate {
+		return nil, false
+	}
+	return a, true
+}
+
+// position returns the trip segment (between stops seg and seg+1) the vehicle
+// is on, how far into it in meters and the segment's length.
+func position(v *domain.Vehicle, trip *domain.ScheduledTrip, g *geometry) (seg int, into, length float64, ok bool) {
+	if g != nil {
+		along, off := g.progress(v.Lat, v.Lon)
+		if off > maxShapeDistance {
+			return 0, 0, 0, false
+		}
+		for seg = 0; seg+2 < len(g.stopDist) && along >= g.stopDist[seg+1]; seg++ {
+		}
+		length = g.stopDist[seg+1] - g.stopDist[seg]
+		into = min(max(along-g.stopDist[seg], 0), length)
+		return seg, into, length, true
+	}
+
+	seg, best := -1, maxTripDistance
+	var segT float64
+	for i := 0; i+1 < len(trip.Stops); i++ {
+		a, b := trip.Stops[i], trip.Stops[i+1]
+		t, d := geo.ProjectToSegment(v.Lat, v.Lon, a.Lat, a.Lon, b.Lat, b.Lon)
+		if d <= best {
+			seg, segT, best = i, t, d
+		}
+	}
+	if seg < 0 {
+		return 0, 0, 0, false
+	}
+	a, b := trip.Stops[seg], trip.Stops[seg+1]
+	length = geo.Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+	return seg, segT * length, length, true
+}
+
+// TagDelays sets the delay of vehicles assigned to a trip by the last
+// update, placing their new position on that trip. Vehicles not assigned,
+// or whose new position no longer fits the trip, are left without one.
+func (p *Predictor) TagDelays(vehicles []*domain.Vehicle) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, v := range vehicles {
+		prev, ok := p.assignments[v.Key]
+		if !ok || prev.Trip.Line != v.Line || (v.DirectionID != nil && *v.DirectionID != prev.Trip.DirectionID) {
+			continue
+		}
+		if a, ok := locate(v, prev.Trip, p.geometries[geometryKey(prev.Trip)]); ok {
+			delay := int(a.Delay / time.Second)
+			v.Delay = &delay
+		}
+	}
+}
+
+// ForVehicle returns the vehicle's current assignment.
+func (p *Predictor) ForVehicle(key string) (*Assignment, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	a, ok := p.assignments[key]
+	return a, ok
+}
+
+// Assignments returns every current assignment ordered by trip, and when
+// they were computed.
+func (p *Predictor) Assignments() ([]*Assignment, time.Time) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]*Assignment, 0, len(p.assignments))
+	for _, a := range p.assignments {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return TripKey(result[i].Trip) < TripKey(result[j].Trip) })
+	return result, p.updatedAt
+}
+
+// Arrival is a vehicle predicted to call at a stop.
+type Arrival struct {
+	Assignment *Assignment
+	Prediction StopPrediction
+	// StopsAway counts the stops the vehicle calls at before this one.
+	StopsAway int
+}
+
+// Arrivals returns the vehicles predicted to call at the stop, soonest
+// first, and when the predictions were computed.
+func (p *Predictor) Arrivals(stopID string) ([]Arrival, time.Time) {
+	assignments, updatedAt := p.Assignments()
+
+	var result []Arrival
+	for _, a := range assignments {
+		for _, sp := range a.Predictions() {
+			if sp.Stop.StopID != stopID {
+				continue
+			}
+			result = append(result, Arrival{Assignment: a, Prediction: sp, StopsAway: sp.Index - a.Next})
+			// A loop may call at the stop twice; the first call is next.
+			break
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Prediction.Arrival.Before(result[j].Prediction.Arrival) })
+	return result, updatedAt
+}
+
+// TripUpdates builds a GTFS-RT TripUpdates feed from the current assignments.
+func (p *Predictor) TripUpdates() *gtfsrt.FeedMessage {
+	assignments, updatedAt := p.Assignments()
+
+	feed := &gtfsrt.FeedMessage{Timestamp: updatedAt, Entities: make([]gtfsrt.FeedEntity, 0, len(assignments))}
+	for _, a := range assignments {
+		direction := a.Trip.DirectionID
+		delay := int32(a.Delay / time.Second)
+		update := &gtfsrt.TripUpdate{
+			Trip: gtfsrt.TripDescriptor{
+				TripID:      a.Trip.TripID,
+				RouteID:     a.Trip.RouteID,
+				DirectionID: &direction,
+				StartTime:   a.Trip.StartTime,
+				StartDate:   a.Trip.ServiceDate.Format("20060102"),
+			},
+			Vehicle:   &gtfsrt.VehicleDescriptor{ID: a.Vehicle.Key, Label: a.Vehicle.VehicleNumber},
+			Timestamp: a.Vehicle.Timestamp,
+			Delay:     &delay,
+		}
+		for _, sp := range a.Predictions() {
+			update.StopTimeUpdates = append(update.StopTimeUpdates, gtfsrt.StopTimeUpdate{
+				StopSequence: sp.Stop.Sequence,
+				StopID:       sp.Stop.StopID,
+				Arrival:      &gtfsrt.StopTimeEvent{Delay: int32(sp.ArrivalDelay / time.Second), Time: sp.Arrival},
+				Departure:    &gtfsrt.StopTimeEvent{Delay: int32(sp.DepartureDelay / time.Second), Time: sp.Departure},
+			})
+		}
+		feed.Entities = append(feed.Entities, gtfsrt.FeedEntity{ID: TripKey(a.Trip), TripUpdate: update})
+	}
+	return feed
+}
+
+// TripKey identifies a trip run: the trip ID and its service date.
+func TripKey(trip *domain.ScheduledTrip) string {
+	return RunKey(trip.TripID, trip.ServiceDate)
+}
+
+// RunKey is the TripKey of the trip run on serviceDate.
+func RunKey(tripID string, serviceDate time.Time) string {
+	return fmt.Sprintf("%s:%s", tripID, serviceDate.Format("20060102"))
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
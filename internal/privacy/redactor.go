@@ -0,0 +1,131 @@
+// Package privacy hides which vehicle runs which duty from public
+// responses: vehicle numbers and brigades are dropped and vehicle keys,
+// which embed the number, are replaced by stable opaque IDs.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+
+	"wabus/internal/domain"
+)
+
+// Redactor derives opaque vehicle IDs with a keyed hash, so they stay the
+// same for a vehicle but can't be mapped back without the secret.
+type Redactor struct {
+	secret []byte
+
+	mu   sync.RWMutex
+	keys map[string]string // opaque ID -> vehicle key, for IDs handed out
+}
+
+// New returns a redactor keyed by secret. An empty secret is replaced by a
+// random one, so IDs change on restart and differ between instances.
+func New(secret string) *Redactor {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &Redactor{secret: key, keys: make(map[string]string)}
+}
+
+// ID returns the opaque ID standing in for a vehicle key.
+func (r *Redactor) ID(key string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(key))
+	id := "v" + hex.EncodeToString(mac.Sum(nil)[:8])
+
+	r.mu.RLock()
+	_, known := r.keys[id]
+	r.mu.RUnlock()
+	if !known {
+		r.mu.Lock()
+		r.keys[id] = key
+		r.mu.Unlock()
+	}
+	return id
+}
+
+// Resolve returns the vehicle key behind an opaque ID. IDs not handed out
+// yet are looked for among candidates, e.g. the keys of live vehicles.
+func (r *Redactor) Resolve(id string, candidates func() []string) (string, bool) {
+	r.mu.RLock()
+	key, ok := r.keys[id]
+	r.mu.RUnlock()
+	if ok || candidates == nil {
+		return key, ok
+	}
+	for _, key := range candidates() {
+		if r.ID(key) == id {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Vehicle returns a copy of v without its number and brigade, keyed by its
+// opaque ID. Coupled units are listed by opaque ID too.
+func (r *Redactor) Vehicle(v *domain.Vehicle) *domain.Vehicle {
+	redacted := *v
+	redacted.Key = r.ID(v.Key)
+	redacted.VehicleNumber = ""
+	redacted.Brigade = ""
+	if v.LeadKey != "" {
+		redacted.LeadKey = r.ID(v.LeadKey)
+	}
+	if len(v.Units) > 0 {
+		redacted.Units = make([]string, len(v.Units))
+		for i, number := range v.Units {
+			redacted.Units[i] = r.ID(strconv.Itoa(int(v.Type)) + ":" + number)
+		}
+	}
+	return &redacted
+}
+
+// Vehicles redacts every vehicle into a new slice.
+func (r *Redactor) Vehicles(vehicles []*domain.Vehicle) []*domain.Vehicle {
+	redacted := make([]*domain.Vehicle, len(vehicles))
+	for i, v := range vehicles {
+		redacted[i] = r.Vehicle(v)
+	}
+	return redacted
+}
+
+// Deltas redacts the vehicles and keys of deltas into a new slice.
+func (r *Redactor) Deltas(deltas []domain.VehicleDelta) []domain.VehicleDelta {
+	redacted := make([]domain.VehicleDelta, len(deltas))
+	for i, d := range deltas {
+		if d.Vehicle != nil {
+			d.Vehicle = r.Vehicle(d.Vehicle)
+		}
+		if d.Key != "" {
+			d.Key = r.ID(d.Key)
+		}
+		redacted[i] = d
+	}
+	return redacted
+}
+
+// Broadcaster receives vehicle deltas, like hub.Hub.
+type Broadcaster interface {
+	Broadcast(deltas []domain.VehicleDelta)
+}
+
+type redactingBroadcaster struct {
+	redactor *Redactor
+	next     Broadcaster
+}
+
+// Broadcaster returns a broadcaster passing redacted deltas on to next.
+func (r *Redactor) Broadcaster(next Broadcaster) Broadcaster {
+	return &redactingBroadcaster{redactor: r, next: next}
+}
+
+func (b *redactingBroadcaster) Broadcast(deltas []domain.VehicleDelta) {
+	b.next.Broadcast(b.redactor.Deltas(deltas))
+}
@@ -0,0 +1,136 @@
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+// Bus carries messages between instances, like Redis pub/sub
+// (cache.RedisCache) or NATS (nats.Client).
+type Bus interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	Subscribe(ctx context.Context, channel string) <-chan []byte
+}
+
+// PayloadLimited is implemented by buses capping the size of a message,
+// like NATS; a limit of 0 is none.
+type PayloadLimited interface {
+	MaxPayload() int
+}
+
+// Fanout shares the deltas of every poll between instances through a bus
+// channel, so the store and WebSocket clients of each instance follow
+// whichever instance polled. Batches too large for the bus are split over
+// several messages. Delivery is at most once: batches published
+// while an instance is disconnected from the bus never reach it. A Fanout
+// that isn't Run only publishes, e.g. a firehose for external consumers.
+type Fanout struct {
+	bus      Bus
+	channel  string
+	instance string
+	applier  Applier
+	logger   *slog.Logger
+
+	published atomic.Int64
+	received  atomic.Int64
+	failed    atomic.Int64
+}
+
+// fanoutMessage is one batch on the channel, tagged with the instance that
+// published it so it can skip its own batches.
+type fanoutMessage struct {
+	Instance string                `json:"instance"`
+	Deltas   []domain.VehicleDelta `json:"deltas"`
+}
+
+// FanoutStats counts batches since startup.
+type FanoutStats struct {
+	Channel   string `json:"channel"`
+	Instance  string `json:"instance"`
+	Published int64  `json:"published"`
+	Received  int64  `json:"received"`
+	Failed    int64  `json:"failed"`
+}
+
+// NewFanout creates a fanout over channel of bus, applying the batches of
+// other instances to applier once Run.
+func NewFanout(bus Bus, channel string, applier Applier, logger *slog.Logger) *Fanout {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Fanout{
+		bus:      bus,
+		channel:  channel,
+		instance: hex.EncodeToString(id),
+		applier:  applier,
+		logger:   logger.With("component", "hub_fanout", "channel", channel),
+	}
+}
+
+// Publish sends the deltas of a local poll to the other instances.
+func (f *Fanout) Publish(deltas []domain.VehicleDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	f.publish(ctx, deltas)
+}
+
+// publish sends deltas as one message, or halves of them in turn when that
+// would exceed the bus's payload limit.
+func (f *Fanout) publish(ctx context.Context, deltas []domain.VehicleDelta) {
+	data, err := json.Marshal(fanoutMessage{Instance: f.instance, Deltas: deltas})
+	if err != nil {
+		f.failed.Add(1)
+		return
+	}
+	if limited, ok := f.bus.(PayloadLimited); ok {
+		if limit := limited.MaxPayload(); limit > 0 && len(data) > limit && len(deltas) > 1 {
+			half := len(deltas) / 2
+			f.publish(ctx, deltas[:half])
+			f.publish(ctx, deltas[half:])
+			return
+		}
+	}
+	if err := f.bus.Publish(ctx, f.channel, data); err != nil {
+		f.failed.Add(1)
+		f.logger.Debug("failed to publish deltas", "count", len(deltas), "error", err)
+		return
+	}
+	f.published.Add(1)
+}
+
+// Run applies the batches other instances publish until ctx is cancelled.
+func (f *Fanout) Run(ctx context.Context) {
+	f.logger.Info("sharing deltas between instances", "instance", f.instance)
+	for data := range f.bus.Subscribe(ctx, f.channel) {
+		var msg fanoutMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			f.failed.Add(1)
+			f.logger.Warn("invalid fanout message", "error", err)
+			continue
+		}
+		if msg.Instance == f.instance {
+			continue
+		}
+		f.received.Add(1)
+		f.applier.Apply(msg.Deltas)
+	}
+}
+
+func (f *Fanout) Stats() FanoutStats {
+	return FanoutStats{
+		Channel:   f.channel,
+		Instance:  f.instance,
+		Published: f.published.Load(),
+		Received:  f.received.Load(),
+		Failed:    f.failed.Load(),
+	}
+}
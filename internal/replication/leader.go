@@ -0,0 +1,96 @@
+package replication
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"wabus/internal/cache"
+)
+
+// Elector decides which of several instances polls the upstream API, using a
+// Redis lock whose value is the holder's advertised base URL. Followers use
+// that URL to stream the leader's deltas.
+//
+// When Redis can't be reached an instance leads on its own: polling twice is
+// better than nobody polling.
+type Elector struct {
+	cache  *cache.RedisCache
+	self   string
+	ttl    time.Duration
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	leader bool
+	holder string
+}
+
+func NewElector(c *cache.RedisCache, self string, ttl time.Duration, logger *slog.Logger) *Elector {
+	return &Elector{
+		cache:  c,
+		self:   self,
+		ttl:    ttl,
+		logger: logger.With("component", "elector"),
+	}
+}
+
+// IsLeader reports whether this instance currently holds the lock.
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader
+}
+
+// Leader returns the base URL of the current leader, or "" if unknown.
+func (e *Elector) Leader() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.holder
+}
+
+// Run campaigns for the lock every third of its TTL until ctx is cancelled,
+// then releases it. Call Campaign once first so leadership is settled
+// before the ingestor starts.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			if err := e.cache.ReleaseLock(releaseCtx, cache.KeyLeaderLock, e.self); err != nil {
+				e.logger.Warn("failed to release leader lock", "error", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			e.Campaign(ctx)
+		}
+	}
+}
+
+// Campaign takes or renews the lock once.
+func (e *Elector) Campaign(ctx context.Context) {
+	holder, err := e.cache.AcquireLock(ctx, cache.KeyLeaderLock, e.self, e.ttl)
+	if err != nil {
+		if !e.IsLeader() {
+			e.logger.Warn("leader lock unavailable, leading alone", "error", err)
+		}
+		e.set(true, e.self)
+		return
+	}
+	e.set(holder == e.self, holder)
+}
+
+func (e *Elector) set(leader bool, holder string) {
+	e.mu.Lock()
+	changed := e.leader != leader || e.holder != holder
+	e.leader, e.holder = leader, holder
+	e.mu.Unlock()
+
+	if changed {
+		e.logger.Info("leadership changed", "leader", leader, "holder", holder)
+	}
+}
@@ -0,0 +1,92 @@
+// Package replication moves the vehicle store state between instances, so a
+// standby can start serving a peer's vehicles before its own first poll.
+package replication
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+// ContentTypeGob is the media type of the binary snapshot stream.
+const ContentTypeGob = "application/x-gob"
+
+// formatVersion is bumped when the stream layout changes incompatibly.
+const formatVersion = 1
+
+// Header precedes the vehicles in a gob snapshot stream.
+type Header struct {
+	Version     int
+	GeneratedAt time.Time
+	Count       int
+}
+
+// WriteGob streams a header followed by one gob value per vehicle.
+func WriteGob(w io.Writer, vehicles []*domain.Vehicle, generatedAt time.Time) error {
+	return writeSnapshot(gob.NewEncoder(w), vehicles, generatedAt)
+}
+
+func writeSnapshot(enc *gob.Encoder, vehicles []*domain.Vehicle, generatedAt time.Time) error {
+	if err := enc.Encode(Header{Version: formatVersion, GeneratedAt: generatedAt, Count: len(vehicles)}); err != nil {
+		return err
+	}
+	for _, v := range vehicles {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadGob reads a stream written by WriteGob.
+func ReadGob(r io.Reader) (Header, []*domain.Vehicle, error) {
+	return readSnapshot(gob.NewDecoder(r))
+}
+
+func readSnapshot(dec *gob.Decoder) (Header, []*domain.Vehicle, error) {
+	var h Header
+	if err := dec.Decode(&h); err != nil {
+		return h, nil, fmt.Errorf("decode header: %w", err)
+	}
+	if h.Version != formatVersion {
+		return h, nil, fmt.Errorf("unsupported snapshot version %d", h.Version)
+	}
+
+	vehicles := make([]*domain.Vehicle, 0, h.Count)
+	for i := 0; i < h.Count; i++ {
+		v := &domain.Vehicle{}
+		if err := dec.Decode(v); err != nil {
+			return h, nil, fmt.Errorf("decode vehicle %d of %d: %w", i+1, h.Count, err)
+		}
+		vehicles = append(vehicles, v)
+	}
+	return h, vehicles, nil
+}
+
+// Fetch downloads a peer's gob snapshot from url, authenticating with token.
+func Fetch(ctx context.Context, url, token string) (Header, []*domain.Vehicle, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	req.Header.Set("Accept", ContentTypeGob)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Header{}, nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return ReadGob(resp.Body)
+}
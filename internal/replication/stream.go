@@ -0,0 +1,244 @@
+package replication
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+// StreamPath is where a leader serves its delta stream, relative to its
+// advertised base URL.
+const StreamPath = "/v1/internal/replication/stream"
+
+const (
+	// streamHeartbeat is how often an empty frame is sent on an idle stream.
+	streamHeartbeat = 10 * time.Second
+	// streamIdleTimeout is how long a follower waits for any frame before
+	// reconnecting.
+	streamIdleTimeout = 3 * streamHeartbeat
+	// subscriberBuffer is how many poll batches a follower may lag behind
+	// before it is disconnected and has to resync.
+	subscriberBuffer = 32
+)
+
+// errStreamClosed ends a stream whose subscription was dropped, either
+// because the follower fell behind or because the publisher shut down.
+var errStreamClosed = errors.New("replication stream closed")
+
+// Frame carries one poll's deltas on the stream; heartbeats are empty.
+type Frame struct {
+	Deltas []domain.VehicleDelta
+}
+
+// Publisher fans the ingestor's deltas out to connected followers.
+type Publisher struct {
+	mu     sync.Mutex
+	subs   map[chan []domain.VehicleDelta]struct{}
+	logger *slog.Logger
+}
+
+func NewPublisher(logger *slog.Logger) *Publisher {
+	return &Publisher{
+		subs:   make(map[chan []domain.VehicleDelta]struct{}),
+		logger: logger.With("component", "replication_publisher"),
+	}
+}
+
+// Record forwards deltas to every follower. Followers that fall behind are
+// dropped; they reconnect and resync from a fresh snapshot.
+func (p *Publisher) Record(deltas []domain.VehicleDelta) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.subs {
+		select {
+		case ch <- deltas:
+		default:
+			p.logger.Warn("dropping slow replication follower")
+			delete(p.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe registers a follower. The channel is closed when the follower
+// is dropped; unsubscribe must be called when the stream ends.
+func (p *Publisher) Subscribe() (deltas <-chan []domain.VehicleDelta, unsubscribe func()) {
+	ch := make(chan []domain.VehicleDelta, subscriberBuffer)
+	p.mu.Lock()
+	p.subs[ch] = struct{}{}
+	p.mu.Unlock()
+
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.subs[ch]; ok {
+			delete(p.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close disconnects all followers, e.g. on shutdown.
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.subs {
+		delete(p.subs, ch)
+		close(ch)
+	}
+}
+
+// Followers returns the number of connected followers.
+func (p *Publisher) Followers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subs)
+}
+
+// WriteStream writes a snapshot like WriteGob, then a Frame per delta batch
+// and a heartbeat on idle, until ctx is cancelled or deltas is closed.
+// flush is called after every write.
+func WriteStream(ctx context.Context, w io.Writer, flush func(), vehicles []*domain.Vehicle, deltas <-chan []domain.VehicleDelta) error {
+	enc := gob.NewEncoder(w)
+	if err := writeSnapshot(enc, vehicles, time.Now()); err != nil {
+		return err
+	}
+	flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var frame Frame
+		select {
+		case <-ctx.Done():
+			return nil
+		case batch, ok := <-deltas:
+			if !ok {
+				return errStreamClosed
+			}
+			frame.Deltas = batch
+		case <-heartbeat.C:
+		}
+		if err := enc.Encode(&frame); err != nil {
+			return err
+		}
+		flush()
+	}
+}
+
+// Applier receives the replicated state on a follower.
+type Applier interface {
+	// Resync replaces the local vehicle set with a leader's snapshot.
+	Resync(vehicles []*domain.Vehicle)
+	// Apply applies one batch of the leader's deltas.
+	Apply(deltas []domain.VehicleDelta)
+}
+
+// Follower mirrors the leader's vehicle state while this instance isn't
+// leading, by streaming the leader's deltas.
+type Follower struct {
+	elector *Elector
+	token   string
+	applier Applier
+	logger  *slog.Logger
+}
+
+func NewFollower(elector *Elector, token string, applier Applier, logger *slog.Logger) *Follower {
+	return &Follower{
+		elector: elector,
+		token:   token,
+		applier: applier,
+		logger:  logger.With("component", "replication_follower"),
+	}
+}
+
+// Run follows whichever instance leads until ctx is cancelled.
+func (f *Follower) Run(ctx context.Context) {
+	for {
+		leader := f.elector.Leader()
+		if !f.elector.IsLeader() && leader != "" {
+			if err := f.follow(ctx, leader); err != nil && ctx.Err() == nil {
+				f.logger.Warn("replication stream ended", "leader", leader, "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// follow streams from leader until the stream fails, goes idle, or
+// leadership moves.
+func (f *Follower) follow(ctx context.Context, leader string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if f.elector.IsLeader() || f.elector.Leader() != leader {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(leader, "/")+StreamPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", ContentTypeGob)
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader returned %s", resp.Status)
+	}
+
+	dec := gob.NewDecoder(resp.Body)
+	_, vehicles, err := readSnapshot(dec)
+	if err != nil {
+		return err
+	}
+	f.applier.Resync(vehicles)
+	f.logger.Info("following leader", "leader", leader, "vehicles", len(vehicles))
+
+	for {
+		var frame Frame
+		if err := dec.Decode(&frame); err != nil {
+			return err
+		}
+		idle.Reset(streamIdleTimeout)
+		if len(frame.Deltas) > 0 {
+			f.applier.Apply(frame.Deltas)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/internal/handler"
+	"wabus/internal/hub"
+	"wabus/internal/testsupport"
+)
+
+// testFeed is one bus line, 509, running north along Marszałkowska.
+func testFeed() *testsupport.GTFSBuilder {
+	today := time.Now()
+	return (&testsupport.GTFSBuilder{}).
+		AddRoute("R509", "509", 3).
+		AddCorridorStops().
+		AddShape("SH509", testsupport.CorridorShape("S1", "S2", "S3")...).
+		AddDailyService("D", today.AddDate(0, 0, -1), today.AddDate(0, 0, 1)).
+		AddTrip("R509", "D", "T1", "Centrum", 0, "SH509", testsupport.CorridorStopTimes("S1", "S2", "S3")...)
+}
+
+func TestVehiclesRoutesAndTiles(t *testing.T) {
+	ts := testsupport.StartServer(t, testsupport.Options{GTFS: testFeed()})
+	ts.WarsawAPI.SetVehicles(domain.VehicleTypeBus,
+		testsupport.FakeVehicle{Number: "1000", Line: "509", Brigade: "1", Lat: 52.2150, Lon: 21.0180},
+		testsupport.FakeVehicle{Number: "1001", Line: "509", Brigade: "2", Lat: 52.2152, Lon: 21.0178},
+	)
+	ts.WaitGTFSLoaded()
+	ts.WaitVehicles(2)
+
+	var vehicles handler.VehiclesResponse
+	ts.GetJSON("/v1/vehicles?line=509", http.StatusOK, &vehicles)
+	if vehicles.Count != 2 {
+		t.Fatalf("got %d vehicles of line 509, want 2", vehicles.Count)
+	}
+	numbers := map[string]bool{}
+	for _, v := range vehicles.Vehicles {
+		numbers[v.VehicleNumber] = true
+	}
+	if !numbers["1000"] || !numbers["1001"] {
+		t.Fatalf("got vehicles %v, want 1000 and 1001", numbers)
+	}
+
+	var route domain.Route
+	ts.GetJSON("/v1/routes/509", http.StatusOK, &route)
+	if route.ID != "R509" || route.ShortName != "509" {
+		t.Fatalf("got route %+v, want R509 for line 509", route)
+	}
+	ts.GetJSON("/v1/routes/999", http.StatusNotFound, nil)
+
+	tile := vehicles.Vehicles[0].TileID
+	if vehicles.Vehicles[1].TileID != tile {
+		t.Fatalf("vehicles in tiles %s and %s, want one tile", tile, vehicles.Vehicles[1].TileID)
+	}
+	ws := ts.DialWS()
+	ws.Send("subscribe", handler.SubscribePayload{TileIDs: []string{tile}})
+	msg := ws.Expect("snapshot", 5*time.Second)
+	var snapshot struct {
+		Vehicles []domain.Vehicle `json:"vehicles"`
+	}
+	if err := json.Unmarshal(msg.Payload, &snapshot); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if len(snapshot.Vehicles) != 2 {
+		t.Fatalf("snapshot of tile %s has %d vehicles, want 2", tile, len(snapshot.Vehicles))
+	}
+}
+
+func TestTileSubscriberGetsMovements(t *testing.T) {
+	ts := testsupport.StartServer(t, testsupport.Options{})
+	ts.WarsawAPI.SetVehicles(domain.VehicleTypeBus,
+		testsupport.FakeVehicle{Number: "1000", Line: "509", Lat: 52.2150, Lon: 21.0180},
+	)
+	ts.WaitVehicles(1)
+
+	var vehicles handler.VehiclesResponse
+	ts.GetJSON("/v1/vehicles", http.StatusOK, &vehicles)
+	ws := ts.DialWS()
+	ws.Send("subscribe", handler.SubscribePayload{TileIDs: []string{vehicles.Vehicles[0].TileID}})
+	ws.Expect("snapshot", 5*time.Second)
+
+	ts.WarsawAPI.SetVehicles(domain.VehicleTypeBus,
+		testsupport.FakeVehicle{Number: "1000", Line: "509", Lat: 52.2151, Lon: 21.0179},
+	)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		msg := ws.Expect("delta", 5*time.Second)
+		var delta hub.DeltaPayload
+		if err := json.Unmarshal(msg.Payload, &delta); err != nil {
+			t.Fatalf("decode delta: %v", err)
+		}
+		for _, v := range delta.Updates {
+			if v.Key == vehicles.Vehicles[0].Key && v.Lat == 52.2151 {
+				return
+			}
+		}
+	}
+	t.Fatal("no delta moved the vehicle")
+}
+
+func TestSetPositionRejectsPositionsOutsideServiceArea(t *testing.T) {
+	ts := testsupport.StartServer(t, testsupport.Options{})
+	ws := ts.DialWS()
+	for _, payload := range []string{
+		`{}`,
+		`{"lat":89.9,"lon":21.0}`,
+		`{"lat":0,"lon":0}`,
+	} {
+		ws.Send("set_position", json.RawMessage(payload))
+		msg := ws.Expect("error", 5*time.Second)
+		var e handler.ErrorPayload
+		if err := json.Unmarshal(msg.Payload, &e); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if e.Code != "invalid_payload" || e.RequestType != "set_position" {
+			t.Errorf("set_position %s: got error %+v, want invalid_payload", payload, e)
+		}
+	}
+
+	ws.Send("set_position", handler.SetPositionPayload{Lat: ptr(52.2297), Lon: ptr(21.0122)})
+	msg := ws.Expect("position", 5*time.Second)
+	var ack handler.PositionAckPayload
+	if err := json.Unmarshal(msg.Payload, &ack); err != nil {
+		t.Fatalf("decode position: %v", err)
+	}
+	if len(ack.Subscribed) == 0 {
+		t.Error("set_position in Warsaw subscribed no tiles")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"wabus/internal/handler"
+	"wabus/internal/store"
+	"wabus/internal/testsupport"
+)
+
+func TestFeedWithoutShapesAndCalendar(t *testing.T) {
+	today := time.Now()
+	feed := (&testsupport.GTFSBuilder{}).
+		AddRoute("R509", "509", 3).
+		AddCorridorStops().
+		AddServiceDate("D", today, 1).
+		AddTrip("R509", "D", "T1", "Centrum", 0, "", testsupport.CorridorStopTimes("S1", "S2", "S3")...)
+	ts := testsupport.StartServer(t, testsupport.Options{GTFS: feed})
+	ts.WaitGTFSLoaded()
+
+	var stats store.GTFSStats
+	ts.GetJSON("/v1/gtfs/stats", http.StatusOK, &stats)
+	if stats.Feed == nil {
+		t.Fatal("no feed report for a feed without shapes.txt and calendar.txt")
+	}
+	if !slices.Equal(stats.Feed.MissingFiles, []string{"shapes.txt", "calendar.txt"}) {
+		t.Errorf("got missing files %v, want shapes.txt and calendar.txt", stats.Feed.MissingFiles)
+	}
+	if stats.Feed.SynthesizedShapes != 1 {
+		t.Errorf("got %d synthesized shapes, want 1", stats.Feed.SynthesizedShapes)
+	}
+	if stats.Feed.CalendarsFromDates != 1 {
+		t.Errorf("got %d calendars from dates, want 1", stats.Feed.CalendarsFromDates)
+	}
+
+	// The synthesized shape runs through the stops of the trip.
+	var shapes handler.ShapesResponse
+	ts.GetJSON("/v1/routes/509/shape", http.StatusOK, &shapes)
+	if shapes.Count != 1 {
+		t.Fatalf("got %d shapes of line 509, want 1", shapes.Count)
+	}
+	points := shapes.Shapes[0].Points
+	if len(points) != 3 || points[0].Lat != 52.2120 || points[2].Lat != 52.2320 {
+		t.Errorf("got shape points %+v, want the three stops in order", points)
+	}
+}
+
+func TestShapesAgainstStopOrder(t *testing.T) {
+	today := time.Now()
+	feed := (&testsupport.GTFSBuilder{}).
+		AddRoute("R509", "509", 3).
+		AddRoute("R520", "520", 3).
+		AddCorridorStops().
+		// SH509 is drawn from Centrum back to Plac Unii Lubelskiej.
+		AddShape("SH509", testsupport.CorridorShape("S3", "S2", "S1")...).
+		// SH520 serves both directions of 520, so it runs backwards for one.
+		AddShape("SH520", testsupport.CorridorShape("S1", "S2", "S3")...).
+		AddDailyService("D", today.AddDate(0, 0, -1), today.AddDate(0, 0, 1)).
+		AddTrip("R509", "D", "T1", "Centrum", 0, "SH509", testsupport.CorridorStopTimes("S1", "S2", "S3")...).
+		AddTrip("R520", "D", "T2", "Centrum", 0, "SH520", testsupport.CorridorStopTimes("S1", "S2", "S3")...).
+		AddTrip("R520", "D", "T3", "Plac Unii Lubelskiej", 1, "SH520", testsupport.CorridorStopTimes("S3", "S2", "S1")...)
+	ts := testsupport.StartServer(t, testsupport.Options{GTFS: feed})
+	ts.WaitGTFSLoaded()
+
+	var stats store.GTFSStats
+	ts.GetJSON("/v1/gtfs/stats", http.StatusOK, &stats)
+	if stats.Feed == nil {
+		t.Fatal("no feed report for a feed with shapes against the stop order")
+	}
+	if stats.Feed.ReversedShapes != 1 {
+		t.Errorf("got %d reversed shapes, want 1", stats.Feed.ReversedShapes)
+	}
+	if !slices.Equal(stats.Feed.BackwardShapes, []string{"SH520"}) {
+		t.Errorf("got backward shapes %v, want SH520", stats.Feed.BackwardShapes)
+	}
+
+	var shapes handler.ShapesResponse
+	ts.GetJSON("/v1/routes/509/shape", http.StatusOK, &shapes)
+	if shapes.Count != 1 {
+		t.Fatalf("got %d shapes of line 509, want 1", shapes.Count)
+	}
+	points := shapes.Shapes[0].Points
+	if len(points) != 3 || points[0].Lat != 52.2120 || points[2].Lat != 52.2320 {
+		t.Errorf("got shape points %+v, want them in stop order", points)
+	}
+}
@@ -0,0 +1,948 @@
+// Package server wires the stores, ingestors, hub and HTTP handlers into
+// the wabus server.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"wabus/internal/analytics"
+	"wabus/internal/cache"
+	"wabus/internal/cdn"
+	"wabus/internal/config"
+	"wabus/internal/domain"
+	"wabus/internal/features"
+	"wabus/internal/geofence"
+	"wabus/internal/handler"
+	"wabus/internal/history"
+	"wabus/internal/hub"
+	"wabus/internal/ingestor"
+	"wabus/internal/matcher"
+	"wabus/internal/middleware"
+	"wabus/internal/prediction"
+	"wabus/internal/privacy"
+	"wabus/internal/replication"
+	"wabus/internal/snapshot"
+	"wabus/internal/store"
+	"wabus/internal/tracing"
+	"wabus/pkg/dem"
+	"wabus/pkg/gtfs"
+	"wabus/pkg/nats"
+	"wabus/pkg/s3"
+	"wabus/pkg/warsawapi"
+)
+
+// Server is a fully wired wabus instance. New builds it, Start runs its
+// background workers and ListenAndServe serves HTTP until Shutdown.
+type Server struct {
+	cfg      *config.Config
+	logger   *slog.Logger
+	srv      *http.Server
+	debugSrv *http.Server
+	handler  http.Handler
+
+	vehicleStore *store.Store
+	gtfsStore    *store.GTFSStore
+	wsHub        *hub.Hub
+	ing          *ingestor.Ingestor
+	gtfsIng      *ingestor.GTFSIngestor
+	predictor    *prediction.Predictor
+	bunching     *analytics.BunchingAnalyzer
+	alertIng     *ingestor.AlertIngestor
+	mobilityIng  *ingestor.MicromobilityIngestor
+	cacheWarmer  *cache.CacheWarmer
+	redisCache   *cache.RedisCache
+	manualAlerts *cache.ManualAlerts
+
+	stopPopularity   *cache.StopPopularity
+	rateLimiter      *middleware.RateLimiter
+	snapshotRecorder *snapshot.Recorder
+	snapshotUploader *snapshot.Uploader
+	historyStore     *history.Store
+	geofenceWebhook  *geofence.Webhook
+	elector          *replication.Elector
+	follower         *replication.Follower
+	fanout           *replication.Fanout
+	natsClient       *nats.Client
+	tracer           *sdktrace.TracerProvider
+	publisher        *replication.Publisher
+}
+
+// New builds a server from cfg. Nothing runs until Start.
+func New(cfg *config.Config, logger *slog.Logger) (*Server, error) {
+	var tracer *sdktrace.TracerProvider
+	if cfg.OTelEndpoint != "" {
+		var err error
+		tracer, err = tracing.Setup(tracing.Config{
+			Endpoint:    cfg.OTelEndpoint,
+			ServiceName: cfg.OTelServiceName,
+			SampleRatio: cfg.OTelSampleRatio,
+			Headers:     cfg.OTelHeaders,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		logger.Info("tracing enabled", "endpoint", cfg.OTelEndpoint, "sample_ratio", cfg.OTelSampleRatio)
+	}
+
+	var redisCache *cache.RedisCache
+	if cfg.RedisEnabled {
+		var err error
+		redisCache, err = cache.NewRedisCache(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, logger)
+		if err != nil {
+			logger.Error("failed to connect to Redis", "error", err)
+			logger.Warn("continuing without Redis cache until it becomes reachable")
+		} else {
+			logger.Info("connected to Redis", "addr", cfg.RedisAddr)
+		}
+		redisCache.SetHitRecorder(handler.ServerStats)
+	}
+
+	vehicleStore := store.New(cfg.VehicleStaleAfter)
+	gtfsStore := store.NewGTFSStore()
+	wsHub := hub.NewHub(logger)
+	wsHub.SetCoalesce(cfg.WSDeltaCoalesce)
+	wsHub.SetSlowClientTimeout(cfg.WSSlowClientTimeout)
+	apiClient := warsawapi.New(cfg.WarsawAPIBaseURL, cfg.WarsawAPIKey, cfg.WarsawResourceID)
+	apiClient.ConfigureBreaker(cfg.UpstreamBreakerThreshold, cfg.UpstreamBreakerCooldown)
+	var redactor *privacy.Redactor
+	var broadcaster ingestor.Broadcaster = wsHub
+	if cfg.PrivacyMode {
+		redactor = privacy.New(cfg.PrivacySecret)
+		broadcaster = redactor.Broadcaster(wsHub)
+	}
+	ing := ingestor.New(apiClient, vehicleStore, broadcaster, cfg, logger)
+
+	fleet, err := analytics.LoadFleet(cfg.FleetFile)
+	if err != nil {
+		return nil, fmt.Errorf("load fleet metadata: %w", err)
+	}
+	if cfg.FleetFile != "" {
+		logger.Info("loaded fleet metadata", "vehicles", fleet.Len())
+	}
+	var elector *replication.Elector
+	var follower *replication.Follower
+	var publisher *replication.Publisher
+	var natsClient *nats.Client
+	if cfg.NATSURL != "" {
+		natsClient, err = nats.New(cfg.NATSURL, "wabus", logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var fanout *replication.Fanout
+	switch cfg.HubFanout {
+	case "redis":
+		if redisCache == nil {
+			return nil, fmt.Errorf("HUB_FANOUT=redis requires REDIS_ENABLED")
+		}
+		fanout = replication.NewFanout(redisCache, cache.ChannelHubDeltas, ing, logger)
+	case "nats":
+		fanout = replication.NewFanout(natsClient, cfg.NATSFanoutSubject, ing, logger)
+	}
+	if fanout != nil {
+		ing.AddFanout(fanout)
+	}
+	var firehose *replication.Fanout
+	if cfg.NATSFirehoseSubject != "" {
+		firehose = replication.NewFanout(natsClient, cfg.NATSFirehoseSubject, nil, logger)
+		ing.AddFanout(firehose)
+	}
+	if cfg.LeaderElection {
+		switch {
+		case redisCache == nil:
+			return nil, fmt.Errorf("LEADER_ELECTION requires REDIS_ENABLED")
+		case fanout == nil && (cfg.ReplicationToken == "" || cfg.ReplicationAdvertiseURL == ""):
+			return nil, fmt.Errorf("LEADER_ELECTION requires REPLICATION_TOKEN and REPLICATION_ADVERTISE_URL unless HUB_FANOUT is redis or nats")
+		}
+		elector = replication.NewElector(redisCache, cfg.ReplicationAdvertiseURL, cfg.LeaderLockTTL, logger)
+		ing.SetLeadership(elector)
+		// With a fanout, followers get the leader's deltas from it
+		// instead of streaming them from the leader.
+		if fanout == nil {
+			follower = replication.NewFollower(elector, cfg.ReplicationToken, ing, logger)
+			publisher = replication.NewPublisher(logger)
+			ing.AddRecorder(publisher)
+		}
+	}
+
+	switches := features.NewSwitches(features.Interpolation, features.Analytics)
+	interpolating := func() bool { return switches.Enabled(features.Interpolation) }
+	recordingAnalytics := func() bool { return switches.Enabled(features.Analytics) }
+
+	distanceTracker := analytics.NewDistanceTracker(fleet, cfg.AnalyticsKeepDays)
+	ing.AddRecorder(ingestor.GateRecorder(distanceTracker, recordingAnalytics))
+
+	fences, err := geofence.LoadFile(cfg.GeofencesFile)
+	if err != nil {
+		return nil, fmt.Errorf("load geofences: %w", err)
+	}
+	geofences := geofence.NewWatcher()
+	geofences.Load(fences)
+	if redisCache != nil {
+		var admin []*geofence.Fence
+		if _, err := redisCache.GetJSON(context.Background(), cache.KeyGeofences, &admin); err != nil {
+			logger.Warn("failed to load geofences", "error", err)
+		}
+		geofences.Load(admin)
+		geofences.SetOnChange(func(admin []*geofence.Fence) {
+			if err := redisCache.SetJSON(context.Background(), cache.KeyGeofences, admin, 0); err != nil {
+				logger.Warn("failed to persist geofences", "error", err)
+			}
+		})
+	}
+	geofences.AddSink(func(events []geofence.Event) {
+		if redactor != nil {
+			events = redactGeofenceEvents(redactor, events)
+		}
+		wsHub.BroadcastGeofence(events)
+	})
+	var geofenceWebhook *geofence.Webhook
+	if cfg.GeofenceWebhookURL != "" {
+		geofenceWebhook = geofence.NewWebhook(cfg.GeofenceWebhookURL, cfg.GeofenceWebhookToken, logger)
+		geofences.AddSink(func(events []geofence.Event) {
+			// Followers replay the leader's deltas; only one instance posts.
+			if elector == nil || elector.IsLeader() {
+				geofenceWebhook.Send(events)
+			}
+		})
+	}
+	ing.AddRecorder(geofences)
+	if len(fences) > 0 {
+		logger.Info("loaded geofences", "fences", len(fences))
+	}
+
+	var snapshotRecorder *snapshot.Recorder
+	var snapshotUploader *snapshot.Uploader
+	if cfg.SnapshotS3Bucket != "" {
+		s3Client, err := s3.New(s3.Config{
+			Endpoint:  cfg.SnapshotS3Endpoint,
+			Region:    cfg.SnapshotS3Region,
+			Bucket:    cfg.SnapshotS3Bucket,
+			AccessKey: cfg.SnapshotS3AccessKey,
+			SecretKey: cfg.SnapshotS3SecretKey,
+			PathStyle: cfg.SnapshotS3PathStyle,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("snapshot storage config: %w", err)
+		}
+		snapshotRecorder = snapshot.NewRecorder(cfg.SnapshotDir, logger)
+		snapshotUploader = snapshot.NewUploader(cfg.SnapshotDir, cfg.SnapshotS3Prefix, s3Client, snapshotRecorder, logger)
+		ing.AddRecorder(snapshotRecorder)
+	}
+
+	var historyStore *history.Store
+	if cfg.HistoryDir != "" {
+		historyStore = history.NewStore(cfg.HistoryDir, cfg.HistoryRetentionDays, logger)
+		ing.AddRecorder(historyStore)
+	}
+
+	stopPopularity := cache.NewStopPopularity(redisCache, logger)
+	if err := stopPopularity.Load(context.Background()); err != nil {
+		logger.Warn("failed to load stop popularity", "error", err)
+	}
+
+	alertStore := store.NewAlertStore()
+	var manualAlerts *cache.ManualAlerts
+	if redisCache != nil {
+		manualAlerts = cache.NewManualAlerts(redisCache, alertStore, logger)
+		if err := manualAlerts.Load(context.Background()); err != nil {
+			logger.Warn("failed to load manual alerts, retrying when Redis is available", "error", err)
+		}
+		redisCache.AddOnStateChange(manualAlerts.OnStateChange)
+	}
+	var alertIng *ingestor.AlertIngestor
+	if cfg.AlertsFeedURL != "" {
+		alertIng = ingestor.NewAlertIngestor(cfg.AlertsFeedURL, alertStore, gtfsStore, cfg.AlertsPollInterval, logger)
+	}
+	alertStore.SetOnChange(func(action string, alert *domain.Alert) {
+		wsHub.BroadcastAlert(action, alert)
+		if alert.Source == domain.AlertSourceManual && manualAlerts != nil {
+			manualAlerts.Changed()
+		}
+	})
+
+	var gtfsIng *ingestor.GTFSIngestor
+	var gtfsArchive *store.GTFSArchive
+	var cacheWarmer *cache.CacheWarmer
+	var shapeMatcher *matcher.Matcher
+	var predictor *prediction.Predictor
+	var bunching *analytics.BunchingAnalyzer
+	var segmentTracker *analytics.SegmentTracker
+	var spanTracker *analytics.ServiceSpanTracker
+	var gtfsHandler *handler.GTFSHandler // assigned below, before the GTFS ingestor starts
+	if cfg.GTFSEnabled {
+		gtfsIng = ingestor.NewGTFSIngestor(cfg.GTFSURL, gtfsStore, cfg.GTFSUpdateInterval, logger)
+		if cfg.GTFSShapeCacheSize > 0 {
+			gtfsIng.SetShapeCache(cfg.GTFSShapeCacheSize, float64(cfg.GTFSShapeSimplifyMeters))
+		}
+		shapeMatcher = matcher.New(gtfsStore, logger)
+		ing.SetMatcher(shapeMatcher)
+		switch cfg.MatcherCanary {
+		case "":
+		case "full_shapes":
+			canaryMatcher := matcher.New(gtfsStore, logger.With("canary", cfg.MatcherCanary))
+			canaryMatcher.SetFullShapes(true)
+			ing.SetCanary(cfg.MatcherCanary, canaryMatcher)
+		default:
+			logger.Warn("unknown matcher canary, not running it", "canary", cfg.MatcherCanary)
+		}
+		predictor = prediction.New(gtfsStore, vehicleStore, logger)
+		ing.AddRecorder(ingestor.GateRecorder(predictor, interpolating))
+		ing.SetDelayTagger(ingestor.GateDelayTagger(predictor, interpolating))
+		if cfg.SegmentLength > 0 {
+			segmentTracker = analytics.NewSegmentTracker(float64(cfg.SegmentLength), cfg.AnalyticsKeepDays)
+			ing.AddRecorder(ingestor.GateRecorder(segmentTracker, recordingAnalytics))
+		}
+		spanTracker = analytics.NewServiceSpanTracker(gtfsStore, cfg.AnalyticsKeepDays)
+		ing.AddRecorder(ingestor.GateRecorder(spanTracker, recordingAnalytics))
+		bunching = analytics.NewBunchingAnalyzer(vehicleStore, gtfsStore, cfg.BunchingThreshold, logger)
+		bunching.SetOnChange(func(bunches []*analytics.Bunch, at time.Time) {
+			if redactor != nil {
+				bunches = redactBunches(redactor, bunches)
+			}
+			wsHub.BroadcastBunching(bunches, at)
+		})
+		gtfsStore.SetOnUpdate(func(stats store.GTFSStats) {
+			// Same version string as /v1/sync.
+			wsHub.BroadcastGTFSUpdated(stats.LastUpdate.Format("2006-01-02"), stats.LastUpdate)
+			// Encode the bulk responses of the new dataset before clients
+			// ask for them.
+			go gtfsHandler.Prewarm()
+		})
+
+		if cfg.GTFSArchiveKeep > 0 {
+			archive, err := gtfs.OpenArchive(gtfs.ParsedCacheDir(), cfg.GTFSArchiveKeep)
+			if err != nil {
+				logger.Warn("GTFS archive unavailable", "error", err)
+			} else {
+				gtfsIng.SetArchive(archive)
+				gtfsArchive = store.NewGTFSArchive(archive)
+			}
+		}
+
+		var purger *cdn.Purger
+		if cfg.CDNPurgeURL != "" {
+			purger = cdn.NewPurger(cfg.CDNPurgeURL, cfg.CDNPurgeToken, logger)
+		}
+
+		if redisCache != nil {
+			cacheWarmer = cache.NewCacheWarmer(redisCache, gtfsStore, stopPopularity, cfg.CacheTTL, cfg.CacheStaleTTL, cfg.CacheWarmTopN, cfg.CacheWarmAll, logger)
+
+			// Redis may come up (or come back) after the GTFS data has loaded;
+			// rewarm so the cache doesn't stay empty until the next GTFS update.
+			redisCache.AddOnStateChange(func(available bool) {
+				if !available || !gtfsStore.GetStats().IsLoaded {
+					return
+				}
+				go func() {
+					logger.Info("Redis became available, warming cache")
+					if err := cacheWarmer.WarmAll(context.Background()); err != nil {
+						logger.Error("cache warming failed", "error", err)
+					}
+				}()
+			})
+		}
+
+		initialLoad := true
+		gtfsIng.SetOnUpdate(func(ctx context.Context) {
+			if purger != nil {
+				if err := purger.Purge(ctx, "gtfs_update", handler.SurrogateKeyGTFS); err != nil {
+					logger.Error("CDN purge failed", "error", err)
+				}
+			}
+
+			if cacheWarmer == nil {
+				return
+			}
+			if initialLoad {
+				initialLoad = false
+				if !cfg.CacheWarmOnStart {
+					logger.Info("GTFS data loaded, skipping cache warming on start")
+					return
+				}
+			}
+			logger.Info("GTFS data updated, warming cache")
+			if err := cacheWarmer.WarmAll(ctx); err != nil {
+				logger.Error("cache warming failed", "error", err)
+			}
+		})
+	}
+
+	// Caps in-flight requests to expensive endpoints.
+	concurrencyLimiter := middleware.NewConcurrencyLimiter(logger)
+	bulk := func(next http.HandlerFunc) http.HandlerFunc {
+		return concurrencyLimiter.Limit("bulk", cfg.ConcurrencyBulk, next)
+	}
+	shapes := func(next http.HandlerFunc) http.HandlerFunc {
+		return concurrencyLimiter.Limit("shapes", cfg.ConcurrencyShapes, next)
+	}
+
+	httpHandler := handler.NewHTTPHandler(vehicleStore, cfg.TileZoomLevel)
+	var legacyZoom *hub.LegacyZoom
+	if cfg.TileZoomLegacy > 0 && cfg.TileZoomLegacy != cfg.TileZoomLevel {
+		legacyZoom = hub.NewLegacyZoom(cfg.TileZoomLegacy, cfg.TileZoomLevel, cfg.TileZoomLegacyUntil)
+		httpHandler.SetLegacyZoom(legacyZoom)
+	}
+	wsHandler := handler.NewWSHandler(wsHub, vehicleStore, cfg.TileZoomLevel, cfg.WSSnapshotChunkBytes, logger)
+	wsHandler.SetMaxTiles(cfg.WSMaxTiles)
+	wsHandler.SetMaxLines(cfg.WSMaxLines)
+	wsHandler.SetAuth(cfg.WSAuthTokens, cfg.WSAuthSecrets)
+	if legacyZoom != nil {
+		wsHandler.SetLegacyZoom(legacyZoom)
+	}
+	switch cfg.WSCompression {
+	case "", "disabled":
+	case "no_context_takeover":
+		wsHandler.SetCompression(websocket.CompressionNoContextTakeover, cfg.WSCompressionThreshold)
+	case "context_takeover":
+		wsHandler.SetCompression(websocket.CompressionContextTakeover, cfg.WSCompressionThreshold)
+	}
+	var poiStore *store.POIStore
+	if cfg.POIFile != "" {
+		pois, err := store.LoadPOIFile(cfg.POIFile)
+		if err != nil {
+			return nil, fmt.Errorf("load points of interest: %w", err)
+		}
+		poiStore = store.NewPOIStore(pois)
+		logger.Info("points of interest loaded", "count", len(pois))
+	}
+	var mobilityStore *store.MicromobilityStore
+	var mobilityIng *ingestor.MicromobilityIngestor
+	if len(cfg.GBFSFeeds) > 0 {
+		mobilityStore = store.NewMicromobilityStore()
+		mobilityIng = ingestor.NewMicromobilityIngestor(cfg.GBFSFeeds, mobilityStore, cfg.TileZoomLevel, cfg.GBFSPollInterval, logger)
+		wsHandler.SetMicromobility(mobilityStore)
+		mobilityStore.SetOnUpdate(wsHandler.PushMicromobility)
+	}
+	if predictor != nil {
+		wsHandler.SetDepartures(predictor, gtfsStore)
+		predictor.SetOnUpdate(wsHandler.PushDepartures)
+	}
+	healthHandler := handler.NewHealthHandler(ing, vehicleStore, redisCache, apiClient)
+	healthHandler.SetUpstreamStaleness(max(3*cfg.PollInterval, 2*time.Minute))
+	healthHandler.SetHub(wsHub)
+	gtfsHandler = handler.NewGTFSHandler(gtfsStore, redisCache, stopPopularity, cfg.CacheTTL, cfg.CacheStaleTTL, logger)
+	if gtfsArchive != nil {
+		gtfsHandler.SetArchive(gtfsArchive)
+	}
+	if poiStore != nil {
+		gtfsHandler.SetPOIs(poiStore)
+	}
+	if cfg.DEMDir != "" {
+		elevation, err := dem.Load(cfg.DEMDir)
+		if err != nil {
+			return nil, fmt.Errorf("load elevation tiles: %w", err)
+		}
+		gtfsHandler.SetElevation(elevation)
+		logger.Info("elevation tiles loaded", "tiles", elevation.Tiles())
+	}
+	lineHandler := handler.NewLineHandler(vehicleStore, gtfsStore)
+	if shapeMatcher != nil {
+		lineHandler.SetMatcher(shapeMatcher)
+	}
+	alertHandler := handler.NewAlertHandler(alertStore)
+	analyticsHandler := handler.NewAnalyticsHandler(distanceTracker)
+	statsHandler := handler.NewStatsHandler(vehicleStore, gtfsStore, redisCache, concurrencyLimiter)
+	statsHandler.SetIngestor(ing)
+	statsHandler.SetHub(wsHub)
+	if fanout != nil {
+		statsHandler.SetFanout(fanout)
+	}
+	if firehose != nil {
+		statsHandler.SetFirehose(firehose)
+	}
+	statsHandler.SetGeofences(geofences)
+	if legacyZoom != nil {
+		statsHandler.SetLegacyZoom(legacyZoom)
+	}
+	geofenceHandler := handler.NewGeofenceHandler(geofences)
+	statusHandler := handler.NewStatusHandler(vehicleStore, alertStore, apiClient, max(3*cfg.PollInterval, 2*time.Minute))
+
+	// Rate limiter (configurable), with optional IP whitelist.
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitPerWindow, cfg.RateLimitWindow, cfg.RateLimitWhitelist, logger)
+	rateLimiter.SetBurst(cfg.RateLimitBurst)
+	// The status page must stay reachable when clients are throttled; its
+	// response is cached.
+	rateLimiter.SetExemptPaths(append(cfg.RateLimitExemptPaths, "/v1/status"))
+	for prefix, rate := range cfg.RateLimitPathBudgets {
+		rateLimiter.SetPathBudget(prefix, rate)
+	}
+	if redisCache != nil {
+		rateLimiter.SetCache(redisCache)
+		if err := rateLimiter.Load(context.Background()); err != nil {
+			logger.Warn("failed to load rate limit state", "error", err)
+		}
+	}
+	adminHandler := handler.NewAdminHandler(rateLimiter)
+	adminHandler.SetHub(wsHub)
+	adminHandler.SetSwitches(switches, logger)
+	if redactor != nil {
+		httpHandler.SetRedaction(redactor, cfg.AdminToken)
+		wsHandler.SetRedaction(redactor, cfg.AdminToken)
+		lineHandler.SetRedaction(redactor, cfg.AdminToken)
+		analyticsHandler.SetRedaction(redactor, cfg.AdminToken)
+	}
+	replicationHandler := handler.NewReplicationHandler(vehicleStore, logger)
+	var legacyTileZoom int
+	if legacyZoom != nil {
+		legacyTileZoom = legacyZoom.Zoom()
+	}
+	capabilitiesHandler := handler.NewCapabilitiesHandler(handler.Capabilities{
+		Features: handler.FeatureCapabilities{
+			GTFS:          cfg.GTFSEnabled,
+			Redis:         redisCache != nil,
+			TripUpdates:   cfg.GTFSEnabled,
+			ServiceAlerts: cfg.GTFSEnabled,
+			History:       historyStore != nil,
+			Segments:      segmentTracker != nil,
+			Micromobility: mobilityStore != nil,
+			POI:           poiStore != nil,
+			Elevation:     cfg.GTFSEnabled && cfg.DEMDir != "",
+			DevicePrefs:   redisCache != nil,
+			Privacy:       redactor != nil,
+			Interpolation: predictor != nil,
+			Analytics:     true,
+		},
+		Realtime: handler.RealtimeCapabilities{
+			TileZoom:          cfg.TileZoomLevel,
+			LegacyTileZoom:    legacyTileZoom,
+			PositionPrecision: cfg.PositionPrecision,
+			SSE:               true,
+		},
+		WebSocket: handler.WSCapabilities{
+			Compression:  cfg.WSCompression,
+			AuthRequired: len(cfg.WSAuthTokens) > 0 || len(cfg.WSAuthSecrets) > 0,
+			MaxTiles:     cfg.WSMaxTiles,
+			MaxLines:     cfg.WSMaxLines,
+		},
+	})
+	capabilitiesHandler.SetSwitches(switches)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/vehicles", httpHandler.ListVehicles)
+	mux.HandleFunc("GET /v1/vehicles/{key}", httpHandler.GetVehicle)
+	mux.HandleFunc("GET /v1/tiles/{z}/{x}/{y}/vehicles", httpHandler.GetTileVehicles)
+	if historyStore != nil {
+		historyHandler := handler.NewHistoryHandler(historyStore, cfg.HistoryMaxRange)
+		if redactor != nil {
+			historyHandler.SetRedaction(redactor, cfg.AdminToken)
+		}
+		mux.HandleFunc("GET /v1/vehicles/{key}/history", historyHandler.GetVehicleHistory)
+		mux.HandleFunc("GET /v1/routes/{line}/playback", bulk(historyHandler.GetLinePlayback))
+	} else {
+		historyDisabled := handler.FeatureDisabled("Vehicle history")
+		mux.HandleFunc("GET /v1/vehicles/{key}/history", historyDisabled)
+		mux.HandleFunc("GET /v1/routes/{line}/playback", historyDisabled)
+	}
+	mux.HandleFunc("/v1/ws", wsHandler.ServeWS)
+	mux.HandleFunc("GET /v1/ws/schema", handler.WSSchema)
+	mux.HandleFunc("GET /v1/stream", wsHandler.ServeSSE)
+	mux.HandleFunc("GET /v1/lines/{line}/stats", lineHandler.GetLineStats)
+	mux.HandleFunc("GET /v1/lines/{line}/vehicles.atom", lineHandler.GetLineFeed)
+	mux.HandleFunc("GET /v1/alerts", alertHandler.ListAlerts)
+	mux.HandleFunc("GET /v1/analytics/emissions", analyticsHandler.GetEmissions)
+	mux.HandleFunc("GET /v1/geofences", geofenceHandler.ListGeofences)
+	mux.HandleFunc("GET /v1/status", statusHandler.GetStatus)
+	mux.HandleFunc("GET /v1/capabilities", capabilitiesHandler.GetCapabilities)
+	mux.HandleFunc("GET /v1/quota", handler.NewQuotaHandler(rateLimiter).GetQuota)
+	if mobilityStore != nil {
+		mobilityHandler := handler.NewMicromobilityHandler(mobilityStore)
+		mux.HandleFunc("GET /v1/micromobility", mobilityHandler.ListSystems)
+		mux.HandleFunc("GET /v1/micromobility/stations", mobilityHandler.ListStations)
+		mux.HandleFunc("GET /v1/micromobility/vehicles", mobilityHandler.ListVehicles)
+	} else {
+		mobilityDisabled := handler.FeatureDisabled("Micromobility")
+		mux.HandleFunc("/v1/micromobility", mobilityDisabled)
+		mux.HandleFunc("/v1/micromobility/", mobilityDisabled)
+	}
+	if poiStore != nil {
+		mux.HandleFunc("GET /v1/poi", handler.NewPOIHandler(poiStore).ListPOIs)
+	} else {
+		mux.HandleFunc("/v1/poi", handler.FeatureDisabled("Points of interest"))
+	}
+	if redisCache != nil {
+		deviceHandler := handler.NewDeviceHandler(redisCache, cfg.DevicePrefsTTL)
+		mux.HandleFunc("GET /v1/devices/me/prefs", deviceHandler.GetPrefs)
+		mux.HandleFunc("PUT /v1/devices/me/prefs", deviceHandler.PutPrefs)
+	} else {
+		mux.HandleFunc("/v1/devices/", handler.FeatureDisabled("Device preference sync"))
+	}
+
+	if cfg.AdminToken != "" {
+		admin := handler.RequireAdmin(cfg.AdminToken)
+		mux.HandleFunc("GET /v1/admin/alerts", admin(alertHandler.AdminListAlerts))
+		mux.HandleFunc("POST /v1/admin/alerts", admin(alertHandler.AdminCreateAlert))
+		mux.HandleFunc("PUT /v1/admin/alerts/{id}", admin(alertHandler.AdminUpdateAlert))
+		mux.HandleFunc("DELETE /v1/admin/alerts/{id}", admin(alertHandler.AdminDeleteAlert))
+		mux.HandleFunc("GET /v1/admin/ratelimit/top", admin(adminHandler.RateLimitTop))
+		mux.HandleFunc("GET /v1/admin/hub", admin(adminHandler.GetHub))
+		mux.HandleFunc("GET /v1/admin/features", admin(adminHandler.ListFeatures))
+		mux.HandleFunc("PUT /v1/admin/features/{name}", admin(adminHandler.SetFeature))
+		mux.HandleFunc("POST /v1/admin/geofences", admin(geofenceHandler.AdminCreateGeofence))
+		mux.HandleFunc("DELETE /v1/admin/geofences/{id}", admin(geofenceHandler.AdminDeleteGeofence))
+
+		// Under /v1/ws rather than /v1/admin so that it is never gzipped;
+		// it checks the token itself to accept it as ?token= too.
+		opsHandler := handler.NewOpsHandler(statsHandler, cfg.AdminToken, logger)
+		ing.AddPollObserver(opsHandler)
+		if gtfsIng != nil {
+			gtfsIng.SetOnProgress(opsHandler.ObserveGTFS)
+		}
+		mux.HandleFunc("GET /v1/ws/ops", opsHandler.ServeWS)
+	} else {
+		mux.HandleFunc("/v1/admin/", handler.FeatureDisabled("Admin API"))
+		mux.HandleFunc("GET /v1/ws/ops", handler.FeatureDisabled("Ops channel"))
+	}
+
+	if cfg.ReplicationToken != "" {
+		peer := handler.RequireAdmin(cfg.ReplicationToken)
+		mux.HandleFunc("GET /v1/internal/replication/snapshot", peer(replicationHandler.GetSnapshot))
+		if publisher != nil {
+			replicationHandler.SetPublisher(publisher)
+			mux.HandleFunc("GET "+replication.StreamPath, peer(replicationHandler.StreamDeltas))
+		}
+	} else {
+		mux.HandleFunc("/v1/internal/", handler.FeatureDisabled("Replication"))
+	}
+
+	if cfg.GTFSEnabled {
+		loaded := gtfsHandler.RequireLoaded
+		mux.HandleFunc("GET /v1/routes", loaded(bulk(gtfsHandler.ListRoutes)))
+		mux.HandleFunc("GET /v1/routes/{line}", loaded(gtfsHandler.GetRoute))
+		mux.HandleFunc("GET /v1/routes/{line}/shape", loaded(shapes(gtfsHandler.GetRouteShape)))
+		mux.HandleFunc("GET /v1/shapes/bundle", loaded(shapes(gtfsHandler.GetShapeBundle)))
+		mux.HandleFunc("GET /v1/routes/{line}/stops", loaded(gtfsHandler.GetRouteStops))
+		mux.HandleFunc("GET /v1/routes/{line}/trips/active", loaded(gtfsHandler.GetActiveTrips))
+		mux.HandleFunc("GET /v1/routes/{line}/eta-path", loaded(lineHandler.GetEtaPath))
+		mux.HandleFunc("GET /v1/routes/{line}/headways", loaded(lineHandler.GetHeadways))
+		mux.HandleFunc("GET /v1/stops", loaded(bulk(gtfsHandler.ListStops)))
+		mux.HandleFunc("GET /v1/stops/{id}", loaded(gtfsHandler.GetStop))
+		mux.HandleFunc("GET /v1/stops/{id}/schedule", loaded(gtfsHandler.GetStopSchedule))
+		mux.HandleFunc("GET /v1/stops/{id}/lines", loaded(gtfsHandler.GetStopLines))
+		arrivalsHandler := handler.NewArrivalsHandler(predictor, gtfsStore)
+		gtfsrtHandler := handler.NewGTFSRTHandler(predictor)
+		if redactor != nil {
+			arrivalsHandler.SetRedaction(redactor, cfg.AdminToken)
+			gtfsrtHandler.SetRedaction(redactor, cfg.AdminToken)
+		}
+		mux.HandleFunc("GET /v1/stops/{id}/arrivals", loaded(arrivalsHandler.GetStopArrivals))
+		mux.HandleFunc("GET /v1/stops/{id}/departures", loaded(arrivalsHandler.GetStopDepartures))
+		arrivalsHandler.SetAlerts(alertStore)
+		mux.HandleFunc("GET /v1/stops/{id}/board", loaded(arrivalsHandler.GetStopBoard))
+		mux.HandleFunc("GET /v1/gtfs/stats", gtfsHandler.GetStats)
+		mux.HandleFunc("GET /v1/gtfs/archive", gtfsHandler.GetArchive)
+		// GTFS-RT consumers are configured with fixed feed URLs, so the
+		// feeds are also served outside the versioned API.
+		mux.HandleFunc("GET /v1/gtfs-rt/trip-updates", loaded(gtfsrtHandler.GetTripUpdates))
+		mux.HandleFunc("GET /gtfs-rt/trip-updates", loaded(gtfsrtHandler.GetTripUpdates))
+		gtfsrtHandler.SetAlerts(alertStore, gtfsStore)
+		mux.HandleFunc("GET /v1/gtfs-rt/service-alerts", loaded(gtfsrtHandler.GetServiceAlerts))
+		mux.HandleFunc("GET /gtfs-rt/service-alerts", loaded(gtfsrtHandler.GetServiceAlerts))
+		analyticsHandler.SetBunching(bunching)
+		mux.HandleFunc("GET /v1/analysis/bunching", loaded(analyticsHandler.GetBunching))
+		if segmentTracker != nil {
+			analyticsHandler.SetSegments(segmentTracker, gtfsStore, max(cfg.AnalyticsKeepDays, 1))
+			mux.HandleFunc("GET /v1/analytics/segments", loaded(analyticsHandler.GetSegments))
+		} else {
+			mux.HandleFunc("GET /v1/analytics/segments", handler.FeatureDisabled("Segment speeds"))
+		}
+		statusHandler.SetGTFS(gtfsStore, 2*cfg.GTFSUpdateInterval)
+		healthHandler.SetGTFS(gtfsStore, 2*cfg.GTFSUpdateInterval)
+		if cfg.ReadyRequireGTFS {
+			healthHandler.SetGTFSReadiness(gtfsIng, cfg.ReadyGTFSGrace)
+		}
+		analyticsHandler.SetServiceSpans(spanTracker, gtfsStore, cfg.ServiceSpanTolerance)
+		mux.HandleFunc("GET /v1/analytics/service-span", loaded(analyticsHandler.GetServiceSpan))
+
+		mux.HandleFunc("GET /v1/sync", loaded(bulk(gtfsHandler.GetSync)))
+		mux.HandleFunc("GET /v1/sync/check", loaded(gtfsHandler.CheckSync))
+	} else {
+		gtfsDisabled := handler.FeatureDisabled("GTFS")
+		for _, prefix := range []string{"/v1/routes", "/v1/stops", "/v1/gtfs", "/v1/gtfs-rt", "/gtfs-rt", "/v1/sync", "/v1/analysis"} {
+			mux.HandleFunc(prefix, gtfsDisabled)
+			mux.HandleFunc(prefix+"/", gtfsDisabled)
+		}
+		mux.HandleFunc("GET /v1/shapes/bundle", gtfsDisabled)
+		mux.HandleFunc("GET /v1/analytics/segments", gtfsDisabled)
+		mux.HandleFunc("GET /v1/analytics/service-span", gtfsDisabled)
+	}
+
+	mux.HandleFunc("GET /healthz", healthHandler.Healthz)
+	mux.HandleFunc("GET /healthz/upstream", healthHandler.Upstream)
+	mux.HandleFunc("GET /healthz/details", healthHandler.Details)
+	mux.HandleFunc("GET /readyz", healthHandler.Readyz)
+	mux.HandleFunc("GET /stats", statsHandler.GetStats)
+	mux.HandleFunc("GET /metrics", statsHandler.GetMetrics)
+
+	gzipMiddleware, err := handler.GzipMiddleware(handler.GzipOptions{
+		MinSize:      cfg.GzipMinSize,
+		Level:        cfg.GzipLevel,
+		ContentTypes: cfg.GzipContentTypes,
+		ExcludePaths: cfg.GzipExcludePaths,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gzip config: %w", err)
+	}
+
+	routed := middleware.RouteStats(handler.ServerStats)(mux)
+	if tracer != nil {
+		routed = middleware.Tracing(routed)
+	}
+
+	// Apply middleware chain: RequestID -> AccessLog -> CORS -> Gzip -> RateLimit -> Tracing -> RouteStats -> Handler
+	finalHandler := handler.CORSMiddleware(
+		gzipMiddleware(
+			rateLimiter.Middleware(routed),
+		),
+	)
+	if cfg.AccessLog {
+		finalHandler = middleware.NewAccessLog(cfg.AccessLogSampleRate, logger).Middleware(finalHandler)
+	}
+	finalHandler = middleware.RequestID(finalHandler)
+
+	srv := &http.Server{
+		Addr:         cfg.HTTPAddr,
+		Handler:      finalHandler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+
+	var debugSrv *http.Server
+	if cfg.DebugAddr != "" {
+		// No write timeout: CPU profiles and traces stream for as long as
+		// requested.
+		debugSrv = &http.Server{
+			Addr:        cfg.DebugAddr,
+			Handler:     handler.NewDebugHandler(logger),
+			ReadTimeout: cfg.ReadTimeout,
+		}
+	}
+
+	if publisher != nil {
+		// Streams aren't hijacked like WebSockets, so Shutdown would wait
+		// for them until its timeout.
+		srv.RegisterOnShutdown(publisher.Close)
+	}
+
+	return &Server{
+		cfg:              cfg,
+		logger:           logger,
+		srv:              srv,
+		debugSrv:         debugSrv,
+		handler:          finalHandler,
+		vehicleStore:     vehicleStore,
+		gtfsStore:        gtfsStore,
+		wsHub:            wsHub,
+		ing:              ing,
+		gtfsIng:          gtfsIng,
+		predictor:        predictor,
+		bunching:         bunching,
+		alertIng:         alertIng,
+		mobilityIng:      mobilityIng,
+		cacheWarmer:      cacheWarmer,
+		redisCache:       redisCache,
+		manualAlerts:     manualAlerts,
+		stopPopularity:   stopPopularity,
+		rateLimiter:      rateLimiter,
+		snapshotRecorder: snapshotRecorder,
+		snapshotUploader: snapshotUploader,
+		historyStore:     historyStore,
+		geofenceWebhook:  geofenceWebhook,
+		elector:          elector,
+		follower:         follower,
+		fanout:           fanout,
+		natsClient:       natsClient,
+		tracer:           tracer,
+		publisher:        publisher,
+	}, nil
+}
+
+// Handler returns the server's HTTP handler with all middleware applied.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// VehicleStore returns the live vehicle store.
+func (s *Server) VehicleStore() *store.Store {
+	return s.vehicleStore
+}
+
+// GTFSStore returns the schedule store.
+func (s *Server) GTFSStore() *store.GTFSStore {
+	return s.gtfsStore
+}
+
+// Start bootstraps from a replication peer when configured and starts the
+// background workers, which run until ctx is cancelled.
+func (s *Server) Start(ctx context.Context) {
+	cfg, logger := s.cfg, s.logger
+
+	go s.wsHub.Run(ctx)
+
+	if cfg.ReplicationPeerURL != "" {
+		bootstrapCtx, bootstrapCancel := context.WithTimeout(ctx, 10*time.Second)
+		header, vehicles, err := replication.Fetch(bootstrapCtx, cfg.ReplicationPeerURL, cfg.ReplicationToken)
+		bootstrapCancel()
+		if err != nil {
+			logger.Warn("failed to bootstrap from peer", "peer", cfg.ReplicationPeerURL, "error", err)
+		} else {
+			restored := s.ing.Bootstrap(vehicles)
+			logger.Info("bootstrapped vehicles from peer",
+				"peer", cfg.ReplicationPeerURL,
+				"vehicles", restored,
+				"snapshot_age", time.Since(header.GeneratedAt).Round(time.Millisecond),
+			)
+		}
+	}
+
+	if s.fanout != nil {
+		go s.fanout.Run(ctx)
+	}
+
+	if s.elector != nil {
+		s.elector.Campaign(ctx)
+		go s.elector.Run(ctx)
+		if s.follower != nil {
+			go s.follower.Run(ctx)
+		}
+	}
+
+	go s.ing.Run(ctx)
+
+	if s.gtfsIng != nil {
+		go s.gtfsIng.Start(ctx)
+		go s.predictor.Run(ctx, cfg.PredictionInterval)
+		go s.bunching.Run(ctx, cfg.BunchingInterval)
+	}
+
+	if s.alertIng != nil {
+		go s.alertIng.Start(ctx)
+	}
+
+	if s.mobilityIng != nil {
+		go s.mobilityIng.Start(ctx)
+	}
+
+	if s.geofenceWebhook != nil {
+		go s.geofenceWebhook.Run(ctx)
+	}
+
+	if s.cacheWarmer != nil {
+		go s.cacheWarmer.ScheduleMidnightRefresh(ctx)
+	}
+
+	go s.stopPopularity.Run(ctx, time.Minute)
+	if s.manualAlerts != nil {
+		go s.manualAlerts.Run(ctx)
+	}
+	go s.rateLimiter.Run(ctx, 10*time.Second)
+
+	if s.snapshotUploader != nil {
+		if cfg.SnapshotRetentionDays > 0 {
+			if err := s.snapshotUploader.ConfigureLifecycle(ctx, cfg.SnapshotRetentionDays); err != nil {
+				logger.Warn("failed to configure snapshot bucket lifecycle", "error", err)
+			}
+		}
+		go s.snapshotUploader.Run(ctx, cfg.SnapshotUploadInterval)
+	}
+
+	if s.historyStore != nil {
+		go s.historyStore.Run(ctx)
+	}
+
+	if s.redisCache != nil {
+		go s.redisCache.Monitor(ctx, cfg.RedisHealthInterval)
+	}
+}
+
+// ListenAndServe serves HTTP on cfg.HTTPAddr until Shutdown, when it
+// returns http.ErrServerClosed.
+// The debug listener, when configured, runs alongside; its failure is
+// logged without stopping the server.
+func (s *Server) ListenAndServe() error {
+	if s.debugSrv != nil {
+		go func() {
+			s.logger.Info("starting debug server", "addr", s.cfg.DebugAddr)
+			if err := s.debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("debug server error", "error", err)
+			}
+		}()
+	}
+	s.logger.Info("starting HTTP server", "addr", s.cfg.HTTPAddr)
+	return s.srv.ListenAndServe()
+}
+
+// Shutdown stops the HTTP server and flushes and closes the snapshot
+// recorder, history store and Redis connection. Cancel the Start context first.
+func (s *Server) Shutdown(ctx context.Context) {
+	if err := s.srv.Shutdown(ctx); err != nil {
+		s.logger.Error("HTTP server shutdown error", "error", err)
+	}
+	if s.debugSrv != nil {
+		// Close rather than Shutdown: a running CPU profile would hold
+		// Shutdown until its end.
+		s.debugSrv.Close()
+	}
+
+	if s.snapshotRecorder != nil {
+		if err := s.snapshotRecorder.Close(); err != nil {
+			s.logger.Error("snapshot recorder close error", "error", err)
+		}
+	}
+
+	if s.historyStore != nil {
+		if err := s.historyStore.Close(); err != nil {
+			s.logger.Error("history store close error", "error", err)
+		}
+	}
+
+	if s.redisCache != nil {
+		if err := s.redisCache.Close(); err != nil {
+			s.logger.Error("Redis close error", "error", err)
+		}
+	}
+
+	if s.natsClient != nil {
+		if err := s.natsClient.Close(); err != nil {
+			s.logger.Error("NATS close error", "error", err)
+		}
+	}
+
+	if s.tracer != nil {
+		if err := s.tracer.Shutdown(ctx); err != nil {
+			s.logger.Error("trace export flush error", "error", err)
+		}
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}
+}
+
+// redactBunches replaces the vehicle keys of bunches by opaque IDs.
+func redactBunches(redactor *privacy.Redactor, bunches []*analytics.Bunch) []*analytics.Bunch {
+	redacted := make([]*analytics.Bunch, len(bunches))
+	for i, b := range bunches {
+		c := *b
+		c.VehicleKey, c.AheadKey = redactor.ID(b.VehicleKey), redactor.ID(b.AheadKey)
+		redacted[i] = &c
+	}
+	return redacted
+}
+
+// redactGeofenceEvents replaces the vehicle keys of events by opaque IDs.
+func redactGeofenceEvents(redactor *privacy.Redactor, events []geofence.Event) []geofence.Event {
+	redacted := make([]geofence.Event, len(events))
+	for i, e := range events {
+		e.VehicleKey = redactor.ID(e.VehicleKey)
+		redacted[i] = e
+	}
+	return redacted
+}
@@ -0,0 +1,46 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"wabus/internal/testsupport"
+)
+
+func TestWSRequiresToken(t *testing.T) {
+	ts := testsupport.StartServer(t, testsupport.Options{
+		Env: map[string]string{"WS_AUTH_TOKENS": "static-token-1234"},
+	})
+	url := "ws" + strings.TrimPrefix(ts.URL("/v1/ws"), "http")
+	dial := func(header http.Header, query string) (*websocket.Conn, *http.Response, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return websocket.Dial(ctx, url+query, &websocket.DialOptions{HTTPHeader: header})
+	}
+
+	_, resp, err := dial(nil, "")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without token: got %v, %v; want 401", resp, err)
+	}
+
+	for name, dialWith := range map[string]func() (*websocket.Conn, *http.Response, error){
+		"header": func() (*websocket.Conn, *http.Response, error) {
+			return dial(http.Header{"Authorization": {"Bearer static-token-1234"}}, "")
+		},
+		"query": func() (*websocket.Conn, *http.Response, error) {
+			return dial(nil, "?token=static-token-1234")
+		},
+	} {
+		conn, _, err := dialWith()
+		if err != nil {
+			t.Errorf("dial with token in %s: %v", name, err)
+			continue
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}
+}
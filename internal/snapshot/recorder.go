@@ -0,0 +1,151 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/pkg/gtfs"
+)
+
+// positionsHeader is the column layout of the daily positions CSV.
+var positionsHeader = []string{"timestamp", "key", "vehicle_number", "type", "line", "brigade", "lat", "lon", "direction_id"}
+
+// Recorder appends every vehicle position update to a gzipped CSV file per
+// service day. Each process starts its own file for the day rather than
+// appending to one a crashed process may have left without a final block,
+// which would make everything behind it unreadable; the uploader joins the
+// files of a day.
+type Recorder struct {
+	mu     sync.Mutex
+	dir    string
+	day    string // YYYYMMDD of the open file
+	file   *os.File
+	gz     *gzip.Writer
+	csv    *csv.Writer
+	logger *slog.Logger
+}
+
+func NewRecorder(dir string, logger *slog.Logger) *Recorder {
+	return &Recorder{
+		dir:    dir,
+		logger: logger.With("component", "snapshot_recorder"),
+	}
+}
+
+// positionsFile returns the local path of the n-th positions file of a day.
+func positionsFile(dir, day string, n int) string {
+	return filepath.Join(dir, "positions-"+day+"-"+strconv.Itoa(n)+".csv.gz")
+}
+
+// Record appends the vehicles of update deltas to the current day's file.
+func (r *Recorder) Record(deltas []domain.VehicleDelta) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	day := gtfs.ServiceDay(time.Now()).Format("20060102")
+	if day != r.day {
+		if err := r.rotateLocked(day); err != nil {
+			r.logger.Error("failed to open positions file", "day", day, "error", err)
+			return
+		}
+	}
+
+	for _, d := range deltas {
+		if d.Type != domain.DeltaUpdate || d.Vehicle == nil {
+			continue
+		}
+		v := d.Vehicle
+		direction := ""
+		if v.DirectionID != nil {
+			direction = strconv.Itoa(*v.DirectionID)
+		}
+		r.csv.Write([]string{
+			v.Timestamp.UTC().Format(time.RFC3339),
+			v.Key,
+			v.VehicleNumber,
+			v.Type.String(),
+			v.Line,
+			v.Brigade,
+			strconv.FormatFloat(v.Lat, 'f', 6, 64),
+			strconv.FormatFloat(v.Lon, 'f', 6, 64),
+			direction,
+		})
+	}
+
+	r.csv.Flush()
+	if err := r.csv.Error(); err != nil {
+		r.logger.Error("failed to write positions", "error", err)
+		return
+	}
+	if err := r.gz.Flush(); err != nil {
+		r.logger.Error("failed to flush positions", "error", err)
+	}
+}
+
+// rotateLocked closes the current file and opens the one for day.
+func (r *Recorder) rotateLocked(day string) error {
+	if err := r.closeLocked(); err != nil {
+		r.logger.Warn("failed to close positions file", "day", r.day, "error", err)
+	}
+
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	var f *os.File
+	for n := 1; ; n++ {
+		var err error
+		f, err = os.OpenFile(positionsFile(r.dir, day, n), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+
+	r.file = f
+	r.gz = gzip.NewWriter(f)
+	r.csv = csv.NewWriter(r.gz)
+	r.day = day
+	r.csv.Write(positionsHeader)
+	return nil
+}
+
+func (r *Recorder) closeLocked() error {
+	if r.file == nil {
+		return nil
+	}
+	r.csv.Flush()
+	gzErr := r.gz.Close()
+	fileErr := r.file.Close()
+	r.file, r.gz, r.csv, r.day = nil, nil, nil, ""
+	if gzErr != nil {
+		return gzErr
+	}
+	return fileErr
+}
+
+// CurrentDay returns the day (YYYYMMDD) of the file being written, or "".
+func (r *Recorder) CurrentDay() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.day
+}
+
+// Close flushes and closes the open file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.closeLocked(); err != nil {
+		return fmt.Errorf("close positions file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"wabus/pkg/gtfs"
+	"wabus/pkg/s3"
+)
+
+// DayStats summarizes one day of recorded positions.
+type DayStats struct {
+	Date      string               `json:"date"`
+	Positions int                  `json:"positions"`
+	Vehicles  int                  `json:"vehicles"`
+	FirstAt   string               `json:"first_at,omitempty"`
+	LastAt    string               `json:"last_at,omitempty"`
+	Lines     map[string]LineStats `json:"lines"`
+}
+
+type LineStats struct {
+	Positions int `json:"positions"`
+	Vehicles  int `json:"vehicles"`
+}
+
+// Uploader moves completed daily position files to S3-compatible storage,
+// recompressed into a single gzip stream and accompanied by a stats summary.
+// Local files are removed once uploaded.
+type Uploader struct {
+	dir      string
+	prefix   string
+	client   *s3.Client
+	recorder *Recorder
+	logger   *slog.Logger
+}
+
+func NewUploader(dir, prefix string, client *s3.Client, recorder *Recorder, logger *slog.Logger) *Uploader {
+	return &Uploader{
+		dir:      dir,
+		prefix:   prefix,
+		client:   client,
+		recorder: recorder,
+		logger:   logger.With("component", "snapshot_uploader"),
+	}
+}
+
+// ConfigureLifecycle makes the bucket expire uploaded snapshots after days,
+// leaving its other lifecycle rules alone.
+func (u *Uploader) ConfigureLifecycle(ctx context.Context, days int) error {
+	return u.client.SetLifecycleRule(ctx, s3.LifecycleRule{
+		ID:             "wabus-snapshots-expiry",
+		Prefix:         u.prefix,
+		ExpirationDays: days,
+	})
+}
+
+// Run uploads completed days every interval until ctx is cancelled.
+func (u *Uploader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := u.UploadCompleted(ctx); err != nil {
+			u.logger.Error("snapshot upload failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// UploadCompleted uploads the local positions files of every day that has
+// ended.
+func (u *Uploader) UploadCompleted(ctx context.Context) error {
+	paths, err := filepath.Glob(filepath.Join(u.dir, "positions-*.csv.gz"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	// positions-<day>-<n>.csv.gz, or positions-<day>.csv.gz from before
+	// each process started its own file.
+	byDay := make(map[string][]string)
+	var days []string
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "positions-"), ".csv.gz")
+		day, _, _ := strings.Cut(name, "-")
+		if byDay[day] == nil {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], path)
+	}
+
+	today := gtfs.ServiceDay(time.Now()).Format("20060102")
+	current := u.recorder.CurrentDay()
+
+	var errs []error
+	for _, day := range days {
+		if day >= today || day == current {
+			continue
+		}
+		if err := u.uploadDay(ctx, day, byDay[day]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", day, err))
+			continue
+		}
+		for _, path := range byDay[day] {
+			if err := os.Remove(path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (u *Uploader) uploadDay(ctx context.Context, day string, paths []string) error {
+	start := time.Now()
+
+	data, stats, err := compactDay(day, paths)
+	if err != nil {
+		return err
+	}
+
+	datePath := day[0:4] + "/" + day[4:6] + "/" + day[6:8]
+	if err := u.client.PutObject(ctx, u.prefix+"positions/"+datePath+"/positions.csv.gz", data, "application/gzip"); err != nil {
+		return err
+	}
+
+	statsJSON, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := u.client.PutObject(ctx, u.prefix+"stats/"+datePath+"/stats.json", statsJSON, "application/json"); err != nil {
+		return err
+	}
+
+	u.logger.Info("uploaded daily snapshot",
+		"day", day,
+		"positions", stats.Positions,
+		"vehicles", stats.Vehicles,
+		"bytes", len(data),
+		"duration", time.Since(start),
+	)
+	return nil
+}
+
+// compactDay rewrites a day's positions files as a single best-compression
+// gzip stream and computes its stats.
+func compactDay(day string, paths []string) ([]byte, *DayStats, error) {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	w := csv.NewWriter(zw)
+	w.Write(positionsHeader)
+
+	stats := &DayStats{
+		Date:  day[0:4] + "-" + day[4:6] + "-" + day[6:8],
+		Lines: make(map[string]LineStats),
+	}
+	vehicles := make(map[string]struct{})
+	lineVehicles := make(map[string]map[string]struct{})
+
+	for _, path := range paths {
+		err := readPositions(path, func(record []string) {
+			w.Write(record)
+
+			ts, key, line := record[0], record[1], record[4]
+			stats.Positions++
+			if stats.FirstAt == "" || ts < stats.FirstAt {
+				stats.FirstAt = ts
+			}
+			if ts > stats.LastAt {
+				stats.LastAt = ts
+			}
+			vehicles[key] = struct{}{}
+			if lineVehicles[line] == nil {
+				lineVehicles[line] = make(map[string]struct{})
+			}
+			lineVehicles[line][key] = struct{}{}
+			ls := stats.Lines[line]
+			ls.Positions++
+			stats.Lines[line] = ls
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+
+	stats.Vehicles = len(vehicles)
+	for line, keys := range lineVehicles {
+		ls := stats.Lines[line]
+		ls.Vehicles = len(keys)
+		stats.Lines[line] = ls
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), stats, nil
+}
+
+// readPositions calls fn with every position row of a positions file. A
+// crash leaves the file without its final block, possibly in the middle of
+// a row, and a file from before each process started its own may continue
+// with another gzip member behind such an end; the rows read up to there
+// are kept.
+func readPositions(path string, fn func(record []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		if err == io.EOF {
+			return nil // crashed before anything was flushed
+		}
+		return err
+	}
+	defer zr.Close()
+
+	reader := csv.NewReader(zr)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var corrupt flate.CorruptInputError
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) != len(positionsHeader) || record[0] == positionsHeader[0] {
+			continue
+		}
+		fn(record)
+	}
+}
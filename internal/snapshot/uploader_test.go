@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+func positionUpdate(number string) []domain.VehicleDelta {
+	return []domain.VehicleDelta{{
+		Type: domain.DeltaUpdate,
+		Vehicle: &domain.Vehicle{
+			Key:           "bus:" + number,
+			VehicleNumber: number,
+			Type:          domain.VehicleTypeBus,
+			Line:          "509",
+			Lat:           52.2297,
+			Lon:           21.0122,
+			Timestamp:     time.Now(),
+		},
+	}}
+}
+
+func TestCompactDayAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// The first process crashes: its file is flushed but never closed.
+	crashed := NewRecorder(dir, logger)
+	crashed.Record(positionUpdate("1000"))
+	day := crashed.CurrentDay()
+
+	restarted := NewRecorder(dir, logger)
+	restarted.Record(positionUpdate("1001"))
+	if err := restarted.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "positions-"+day+"-*.csv.gz"))
+	if len(paths) != 2 {
+		t.Fatalf("got positions files %v, want one per process", paths)
+	}
+	_, stats, err := compactDay(day, paths)
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if stats.Positions != 2 || stats.Vehicles != 2 {
+		t.Errorf("got %d positions of %d vehicles, want 2 of 2", stats.Positions, stats.Vehicles)
+	}
+}
+
+func TestCompactDayAppendedAfterCrash(t *testing.T) {
+	// Files from before each process started its own had the next
+	// process's gzip member appended behind the crashed one's.
+	path := filepath.Join(t.TempDir(), "positions-20260101.csv.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed := gzip.NewWriter(f)
+	io.WriteString(crashed, "timestamp,key,vehicle_number,type,line,brigade,lat,lon,direction_id\n")
+	io.WriteString(crashed, "2026-01-01T06:00:00Z,bus:1000,1000,bus,509,1,52.229700,21.012200,\n")
+	crashed.Flush()
+	appended := gzip.NewWriter(f)
+	io.WriteString(appended, "2026-01-01T06:01:00Z,bus:1001,1001,bus,509,2,52.229700,21.012200,\n")
+	appended.Close()
+	f.Close()
+
+	_, stats, err := compactDay("20260101", []string{path})
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if stats.Positions != 1 {
+		t.Errorf("got %d positions, want the 1 before the unterminated member", stats.Positions)
+	}
+}
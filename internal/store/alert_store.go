@@ -0,0 +1,263 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+// ErrAlertNotFound is returned when updating or deleting an unknown alert.
+var ErrAlertNotFound = errors.New("alert not found")
+
+// Alert change actions passed to the change callback.
+const (
+	AlertCreated = "created"
+	AlertUpdated = "updated"
+	AlertDeleted = "deleted"
+)
+
+// AlertStore holds service alerts from all sources. Manual alerts are
+// authored through the admin API; other sources replace their alerts as a
+// whole on every refresh.
+type AlertStore struct {
+	mu       sync.RWMutex
+	alerts   map[string]*domain.Alert
+	onChange func(action string, alert *domain.Alert)
+}
+
+func NewAlertStore() *AlertStore {
+	return &AlertStore{alerts: make(map[string]*domain.Alert)}
+}
+
+// SetOnChange registers a callback invoked after every alert change. It runs
+// outside the store lock.
+func (s *AlertStore) SetOnChange(fn func(action string, alert *domain.Alert)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// List returns alerts ordered by severity (most severe first), then newest
+// first. With activeOnly, alerts outside their active period at now are skipped.
+func (s *AlertStore) List(activeOnly bool, now time.Time) []*domain.Alert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*domain.Alert, 0, len(s.alerts))
+	for _, a := range s.alerts {
+		if activeOnly && !a.IsActive(now) {
+			continue
+		}
+		result = append(result, copyAlert(a))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		ri, rj := severityRank(result[i].Severity), severityRank(result[j].Severity)
+		if ri != rj {
+			return ri > rj
+		}
+		if !result[i].UpdatedAt.Equal(result[j].UpdatedAt) {
+			return result[i].UpdatedAt.After(result[j].UpdatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// ListSource returns all alerts of one source.
+func (s *AlertStore) ListSource(source domain.AlertSource) []*domain.Alert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*domain.Alert
+	for _, a := range s.alerts {
+		if a.Source == source {
+			result = append(result, copyAlert(a))
+		}
+	}
+	return result
+}
+
+func (s *AlertStore) Get(id string) (*domain.Alert, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.alerts[id]
+	if !ok {
+		return nil, false
+	}
+	return copyAlert(a), true
+}
+
+// Create stores a new manual alert, assigning its ID and timestamps.
+func (s *AlertStore) Create(a *domain.Alert) *domain.Alert {
+	now := time.Now()
+	stored := copyAlert(a)
+	stored.ID = string(domain.AlertSourceManual) + "-" + newAlertID()
+	stored.Source = domain.AlertSourceManual
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+
+	s.mu.Lock()
+	s.alerts[stored.ID] = stored
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	result := copyAlert(stored)
+	if onChange != nil {
+		onChange(AlertCreated, copyAlert(stored))
+	}
+	return result
+}
+
+// Update replaces the content of an existing manual alert.
+func (s *AlertStore) Update(id string, a *domain.Alert) (*domain.Alert, error) {
+	s.mu.Lock()
+	existing, ok := s.alerts[id]
+	if !ok || existing.Source != domain.AlertSourceManual {
+		s.mu.Unlock()
+		return nil, ErrAlertNotFound
+	}
+
+	stored := copyAlert(a)
+	stored.ID = id
+	stored.Source = domain.AlertSourceManual
+	stored.CreatedAt = existing.CreatedAt
+	stored.UpdatedAt = time.Now()
+	s.alerts[id] = stored
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	result := copyAlert(stored)
+	if onChange != nil {
+		onChange(AlertUpdated, copyAlert(stored))
+	}
+	return result, nil
+}
+
+// Delete removes a manual alert.
+func (s *AlertStore) Delete(id string) error {
+	s.mu.Lock()
+	existing, ok := s.alerts[id]
+	if !ok || existing.Source != domain.AlertSourceManual {
+		s.mu.Unlock()
+		return ErrAlertNotFound
+	}
+	delete(s.alerts, id)
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if onChange != nil {
+		onChange(AlertDeleted, existing)
+	}
+	return nil
+}
+
+// LoadManual restores previously persisted manual alerts without firing the
+// change callback.
+func (s *AlertStore) LoadManual(alerts []*domain.Alert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range alerts {
+		if a.ID == "" {
+			continue
+		}
+		stored := copyAlert(a)
+		stored.Source = domain.AlertSourceManual
+		s.alerts[stored.ID] = stored
+	}
+}
+
+// ReplaceSource makes alerts the complete set of alerts of a non-manual
+// source. Alerts are matched by ID: new ones are created, changed ones
+// updated (keeping their CreatedAt) and missing ones deleted, firing the
+// change callback for each.
+func (s *AlertStore) ReplaceSource(source domain.AlertSource, alerts []*domain.Alert) {
+	if source == domain.AlertSourceManual {
+		return
+	}
+	type change struct {
+		action string
+		alert  *domain.Alert
+	}
+	var changes []change
+
+	now := time.Now()
+	seen := make(map[string]struct{}, len(alerts))
+
+	s.mu.Lock()
+	for _, a := range alerts {
+		if a.ID == "" {
+			continue
+		}
+		seen[a.ID] = struct{}{}
+		stored := copyAlert(a)
+		stored.Source = source
+
+		existing, ok := s.alerts[a.ID]
+		switch {
+		case !ok:
+			stored.CreatedAt, stored.UpdatedAt = now, now
+			changes = append(changes, change{AlertCreated, copyAlert(stored)})
+		case sameAlertContent(existing, stored):
+			continue
+		default:
+			stored.CreatedAt, stored.UpdatedAt = existing.CreatedAt, now
+			changes = append(changes, change{AlertUpdated, copyAlert(stored)})
+		}
+		s.alerts[a.ID] = stored
+	}
+	for id, existing := range s.alerts {
+		if _, ok := seen[id]; !ok && existing.Source == source {
+			delete(s.alerts, id)
+			changes = append(changes, change{AlertDeleted, existing})
+		}
+	}
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if onChange != nil {
+		for _, c := range changes {
+			onChange(c.action, c.alert)
+		}
+	}
+}
+
+// sameAlertContent compares everything but the timestamps.
+func sameAlertContent(a, b *domain.Alert) bool {
+	x, y := *a, *b
+	x.CreatedAt, x.UpdatedAt = time.Time{}, time.Time{}
+	y.CreatedAt, y.UpdatedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(&x, &y)
+}
+
+func copyAlert(a *domain.Alert) *domain.Alert {
+	c := *a
+	c.Lines = append([]string(nil), a.Lines...)
+	c.StopIDs = append([]string(nil), a.StopIDs...)
+	return &c
+}
+
+func severityRank(s domain.AlertSeverity) int {
+	switch s {
+	case domain.AlertSeveritySevere:
+		return 2
+	case domain.AlertSeverityWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func newAlertID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"wabus/pkg/gtfs"
+)
+
+// archiveLoadedMax bounds how many historical datasets are kept in memory.
+const archiveLoadedMax = 2
+
+// GTFSArchive serves as-of queries from archived GTFS datasets, loading them
+// on demand into their own GTFSStore.
+type GTFSArchive struct {
+	archive *gtfs.Archive
+
+	mu     sync.Mutex
+	loaded map[string]*GTFSStore // fingerprint -> store
+	order  []string              // fingerprints, least recently used first
+}
+
+func NewGTFSArchive(archive *gtfs.Archive) *GTFSArchive {
+	return &GTFSArchive{
+		archive: archive,
+		loaded:  make(map[string]*GTFSStore),
+	}
+}
+
+// StoreAsOf returns a store holding the dataset that was in use on day.
+func (a *GTFSArchive) StoreAsOf(day time.Time) (*GTFSStore, gtfs.ArchiveEntry, error) {
+	entry, ok := a.archive.Resolve(day)
+	if !ok {
+		return nil, gtfs.ArchiveEntry{}, fmt.Errorf("no archived GTFS dataset covers %s", day.Format("2006-01-02"))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if s, ok := a.loaded[entry.Fingerprint]; ok {
+		a.touchLocked(entry.Fingerprint)
+		return s, entry, nil
+	}
+
+	result, err := a.archive.Load(entry)
+	if err != nil {
+		return nil, entry, fmt.Errorf("load archived dataset: %w", err)
+	}
+
+	s := NewGTFSStore()
+	s.SetFeedReport(result.Report)
+	s.UpdateAll(result.Routes, result.Shapes, result.Stops, result.RouteShapes, result.StopSchedules, result.StopLines, result.RouteStops, result.RouteTripTimes, result.Trips, result.Calendars, result.CalendarDates, result.ShapeDirections)
+
+	a.loaded[entry.Fingerprint] = s
+	a.touchLocked(entry.Fingerprint)
+	for len(a.order) > archiveLoadedMax {
+		delete(a.loaded, a.order[0])
+		a.order = a.order[1:]
+	}
+	return s, entry, nil
+}
+
+func (a *GTFSArchive) touchLocked(fingerprint string) {
+	for i, fp := range a.order {
+		if fp == fingerprint {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+	a.order = append(a.order, fingerprint)
+}
+
+// Entries lists the archived datasets, oldest first.
+func (a *GTFSArchive) Entries() []gtfs.ArchiveEntry {
+	return a.archive.Entries()
+}
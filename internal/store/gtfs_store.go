@@ -2,14 +2,20 @@ package store
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"wabus/internal/domain"
+	"wabus/pkg/gtfs"
 )
 
-type GTFSStore struct {
-	mu              sync.RWMutex
+// gtfsDataset is one GTFS feed with the indexes derived from it. It is built
+// in full before it is published and never modified afterwards, so readers
+// use it without locking.
+type gtfsDataset struct {
 	routes          map[string]*domain.Route
 	routesByLine    map[string]*domain.Route
 	shapes          map[string]*domain.Shape
@@ -23,12 +29,43 @@ type GTFSStore struct {
 	calendars       map[string]*domain.Calendar
 	calendarDates   map[string][]*domain.CalendarDate
 	shapeDirections map[string]int
+	shapeHeadsigns  map[string]string
+	feedReport      gtfs.FeedReport
+
+	// firstServiceDate and lastServiceDate (YYYYMMDD) bound the dates any
+	// service runs on, from calendar ranges and added calendar dates.
+	firstServiceDate string
+	lastServiceDate  string
+
+	// Full-resolution shapes when only simplified ones are kept in shapes.
+	shapeSource ShapeSource
+	shapeCache  *shapeLRU
 
 	lastUpdate time.Time
 }
 
+// GTFSStore serves the current GTFS dataset. A new dataset is prepared
+// without blocking readers and published by swapping a single pointer.
+type GTFSStore struct {
+	data atomic.Pointer[gtfsDataset]
+
+	// mu guards what the next prepared dataset takes over and onUpdate.
+	mu          sync.Mutex
+	feedReport  gtfs.FeedReport
+	shapeSource ShapeSource
+	shapeCache  *shapeLRU
+	onUpdate    func(stats GTFSStats)
+}
+
+// GTFSDataset is a dataset prepared with PrepareDataset, ready to be
+// published with SwapDataset.
+type GTFSDataset struct {
+	data *gtfsDataset
+}
+
 func NewGTFSStore() *GTFSStore {
-	return &GTFSStore{
+	s := &GTFSStore{}
+	s.data.Store(&gtfsDataset{
 		routes:          make(map[string]*domain.Route),
 		routesByLine:    make(map[string]*domain.Route),
 		shapes:          make(map[string]*domain.Shape),
@@ -42,39 +79,111 @@ func NewGTFSStore() *GTFSStore {
 		calendars:       make(map[string]*domain.Calendar),
 		calendarDates:   make(map[string][]*domain.CalendarDate),
 		shapeDirections: make(map[string]int),
-	}
+		shapeHeadsigns:  make(map[string]string),
+	})
+	return s
 }
 
+// UpdateAll prepares a dataset from the parsed feed and publishes it.
 func (s *GTFSStore) UpdateAll(routes map[string]*domain.Route, shapes map[string]*domain.Shape, stops map[string]*domain.Stop, routeShapes map[string][]string, stopSchedules map[string][]domain.StopTimeCompact, stopLines map[string][]*domain.StopLine, routeStops map[string][]*domain.Stop, routeTripTimes map[string][]*domain.TripTimeEntry, trips []domain.TripMeta, calendars map[string]*domain.Calendar, calendarDates map[string][]*domain.CalendarDate, shapeDirections map[string]int) {
+	s.SwapDataset(s.PrepareDataset(routes, shapes, stops, routeShapes, stopSchedules, stopLines, routeStops, routeTripTimes, trips, calendars, calendarDates, shapeDirections))
+}
+
+// PrepareDataset builds a dataset from the parsed feed, with the shape
+// source and feed report set last, while readers keep using the current
+// one. The maps passed in are owned by the dataset from then on.
+func (s *GTFSStore) PrepareDataset(routes map[string]*domain.Route, shapes map[string]*domain.Shape, stops map[string]*domain.Stop, routeShapes map[string][]string, stopSchedules map[string][]domain.StopTimeCompact, stopLines map[string][]*domain.StopLine, routeStops map[string][]*domain.Stop, routeTripTimes map[string][]*domain.TripTimeEntry, trips []domain.TripMeta, calendars map[string]*domain.Calendar, calendarDates map[string][]*domain.CalendarDate, shapeDirections map[string]int) *GTFSDataset {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	d := &gtfsDataset{
+		routes:          routes,
+		shapes:          shapes,
+		stops:           stops,
+		routeShapes:     routeShapes,
+		stopSchedules:   stopSchedules,
+		stopLines:       stopLines,
+		routeStops:      routeStops,
+		routeTripTimes:  routeTripTimes,
+		trips:           trips,
+		calendars:       calendars,
+		calendarDates:   calendarDates,
+		shapeDirections: shapeDirections,
+		feedReport:      s.feedReport,
+		shapeSource:     s.shapeSource,
+		shapeCache:      s.shapeCache,
+	}
+	s.mu.Unlock()
 
-	s.routes = routes
-	s.shapes = shapes
-	s.stops = stops
-	s.routeShapes = routeShapes
-	s.stopSchedules = stopSchedules
-	s.stopLines = stopLines
-	s.routeStops = routeStops
-	s.routeTripTimes = routeTripTimes
-	s.trips = trips
-	s.calendars = calendars
-	s.calendarDates = calendarDates
-	s.shapeDirections = shapeDirections
-	s.lastUpdate = time.Now()
-
-	s.routesByLine = make(map[string]*domain.Route, len(routes))
+	d.shapeHeadsigns = buildShapeHeadsigns(trips)
+	d.firstServiceDate, d.lastServiceDate = serviceDateRange(calendars, calendarDates)
+	shapeVersions := routeShapeVersions(routeShapes, shapes, shapeDirections, d.shapeSource)
+	d.routesByLine = make(map[string]*domain.Route, len(routes))
 	for _, route := range routes {
-		s.routesByLine[route.ShortName] = route
+		route.ShapesVersion = shapeVersions[route.ID]
+		d.routesByLine[route.ShortName] = route
+	}
+	return &GTFSDataset{data: d}
+}
+
+// SwapDataset publishes a prepared dataset. Readers already working on the
+// previous one finish with it; nothing waits on the swap.
+func (s *GTFSStore) SwapDataset(ds *GTFSDataset) {
+	ds.data.lastUpdate = time.Now()
+	s.data.Store(ds.data)
+
+	s.mu.Lock()
+	onUpdate := s.onUpdate
+	s.mu.Unlock()
+	if onUpdate != nil {
+		onUpdate(s.GetStats())
 	}
 }
 
+// SetFeedReport records what the parser derived for optional files the
+// feed omitted, reported in GetStats. Call it before PrepareDataset.
+func (s *GTFSStore) SetFeedReport(report gtfs.FeedReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feedReport = report
+}
+
+// SetOnUpdate registers a callback invoked after every dataset swap.
+func (s *GTFSStore) SetOnUpdate(fn func(stats GTFSStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onUpdate = fn
+}
+
+// buildShapeHeadsigns maps each shape to the headsign used by most of its trips.
+func buildShapeHeadsigns(trips []domain.TripMeta) map[string]string {
+	counts := make(map[string]map[string]int)
+	for _, trip := range trips {
+		if trip.ShapeID == "" || trip.Headsign == "" {
+			continue
+		}
+		if counts[trip.ShapeID] == nil {
+			counts[trip.ShapeID] = make(map[string]int)
+		}
+		counts[trip.ShapeID][trip.Headsign]++
+	}
+
+	result := make(map[string]string, len(counts))
+	for shapeID, headsigns := range counts {
+		best, bestCount := "", 0
+		for headsign, count := range headsigns {
+			if count > bestCount || (count == bestCount && headsign < best) {
+				best, bestCount = headsign, count
+			}
+		}
+		result[shapeID] = best
+	}
+	return result
+}
+
 func (s *GTFSStore) GetAllRoutes() []*domain.Route {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	result := make([]*domain.Route, 0, len(s.routes))
-	for _, route := range s.routes {
+	result := make([]*domain.Route, 0, len(d.routes))
+	for _, route := range d.routes {
 		copy := *route
 		result = append(result, &copy)
 	}
@@ -82,10 +191,9 @@ func (s *GTFSStore) GetAllRoutes() []*domain.Route {
 }
 
 func (s *GTFSStore) GetRouteByID(id string) (*domain.Route, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	route, ok := s.routes[id]
+	route, ok := d.routes[id]
 	if !ok {
 		return nil, false
 	}
@@ -94,10 +202,9 @@ func (s *GTFSStore) GetRouteByID(id string) (*domain.Route, bool) {
 }
 
 func (s *GTFSStore) GetRouteByLine(line string) (*domain.Route, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	route, ok := s.routesByLine[line]
+	route, ok := d.routesByLine[line]
 	if !ok {
 		return nil, false
 	}
@@ -106,96 +213,406 @@ func (s *GTFSStore) GetRouteByLine(line string) (*domain.Route, bool) {
 }
 
 func (s *GTFSStore) GetRouteShapes(routeID string) []*domain.Shape {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	return s.getRouteShapesLocked(routeID)
+	return d.routeShapeCopies(routeID, true)
 }
 
-func (s *GTFSStore) GetActiveRouteShapes(routeID string, date time.Time, timeMinutes int) []*domain.Shape {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// GetSimplifiedRouteShapes returns the route's shapes as kept in memory,
+// without loading full-resolution points (see SetShapeSource).
+func (s *GTFSStore) GetSimplifiedRouteShapes(routeID string) []*domain.Shape {
+	d := s.data.Load()
 
-	tripTimes, ok := s.routeTripTimes[routeID]
-	if !ok {
-		return s.getRouteShapesLocked(routeID)
-	}
+	return d.routeShapeCopies(routeID, false)
+}
 
-	dateStr := date.Format("20060102")
-	weekday := date.Weekday()
-	activeServices := s.getActiveServices(dateStr, weekday)
+// GetActiveRouteShapesAt returns the shapes of trips running within 30 minutes
+// of at. Both the service day of at and the previous one are considered, so
+// after-midnight trips (GTFS times past 24:00) are found on the right day.
+func (s *GTFSStore) GetActiveRouteShapesAt(routeID string, at time.Time) []*domain.Shape {
+	d := s.data.Load()
 
-	// Also check yesterday for after-midnight GTFS trips (times > 24:00)
-	yesterday := date.AddDate(0, 0, -1)
-	yesterdayStr := yesterday.Format("20060102")
-	yesterdayWeekday := yesterday.Weekday()
-	yesterdayServices := s.getActiveServices(yesterdayStr, yesterdayWeekday)
+	tripTimes, ok := d.routeTripTimes[routeID]
+	if !ok {
+		return d.routeShapeCopies(routeID, true)
+	}
 
 	activeShapeIDs := make(map[string]bool)
 
-	for _, tt := range tripTimes {
-		// Check today's services
-		if activeServices[tt.ServiceID] {
-			if tt.StartMinutes <= timeMinutes+30 && tt.EndMinutes >= timeMinutes-30 {
-				activeShapeIDs[tt.ShapeID] = true
+	for _, day := range candidateServiceDays(at) {
+		activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+		timeMinutes := int(at.Sub(gtfs.ServiceDayStart(day)).Minutes())
+
+		for _, tt := range tripTimes {
+			if !activeServices[tt.ServiceID] {
+				continue
 			}
-		}
-		// Check yesterday's services for after-midnight trips
-		if yesterdayServices[tt.ServiceID] && tt.EndMinutes > 1440 {
-			adjustedTime := timeMinutes + 1440
-			if tt.StartMinutes <= adjustedTime+30 && tt.EndMinutes >= adjustedTime-30 {
+			if tt.StartMinutes <= timeMinutes+30 && tt.EndMinutes >= timeMinutes-30 {
 				activeShapeIDs[tt.ShapeID] = true
 			}
 		}
 	}
 
 	if len(activeShapeIDs) == 0 {
-		return s.getRouteShapesLocked(routeID)
+		return d.routeShapeCopies(routeID, true)
 	}
 
 	var result []*domain.Shape
 	for shapeID := range activeShapeIDs {
-		if shape, ok := s.shapes[shapeID]; ok {
-			dir := s.shapeDirections[shapeID]
-			shapeCopy := &domain.Shape{
-				ID:          shape.ID,
-				Points:      make([]domain.ShapePoint, len(shape.Points)),
-				DirectionID: &dir,
+		if shape, ok := d.shapes[shapeID]; ok {
+			result = append(result, d.copyShape(shape, true))
+		}
+	}
+	return result
+}
+
+// CountActiveTrips returns the number of trips of the route scheduled to be
+// running at the given instant, keyed by direction ID.
+func (s *GTFSStore) CountActiveTrips(routeID string, at time.Time) map[int]int {
+	d := s.data.Load()
+
+	counts := make(map[int]int)
+	for _, day := range candidateServiceDays(at) {
+		activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+		timeMinutes := int(at.Sub(gtfs.ServiceDayStart(day)).Minutes())
+
+		for _, tt := range d.routeTripTimes[routeID] {
+			if activeServices[tt.ServiceID] && tt.StartMinutes <= timeMinutes && tt.EndMinutes >= timeMinutes {
+				counts[tt.DirectionID]++
+			}
+		}
+	}
+	return counts
+}
+
+// ScheduledSpan returns when the route of a line runs on the given service
+// day; ok is false when no trip of it is scheduled that day.
+func (s *GTFSStore) ScheduledSpan(line string, day time.Time) (domain.ServiceSpan, bool) {
+	d := s.data.Load()
+
+	route, ok := d.routesByLine[line]
+	if !ok {
+		return domain.ServiceSpan{}, false
+	}
+	activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+	return d.scheduledSpan(route.ID, day, activeServices)
+}
+
+// ScheduledSpans returns ScheduledSpan for every line running on the given
+// service day, keyed by line.
+func (s *GTFSStore) ScheduledSpans(day time.Time) map[string]domain.ServiceSpan {
+	d := s.data.Load()
+
+	activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+	spans := make(map[string]domain.ServiceSpan)
+	for line, route := range d.routesByLine {
+		if span, ok := d.scheduledSpan(route.ID, day, activeServices); ok {
+			spans[line] = span
+		}
+	}
+	return spans
+}
+
+func (d *gtfsDataset) scheduledSpan(routeID string, day time.Time, activeServices map[string]bool) (domain.ServiceSpan, bool) {
+	var span domain.ServiceSpan
+	first, lastStart, lastEnd := -1, -1, -1
+	for _, tt := range d.routeTripTimes[routeID] {
+		if !activeServices[tt.ServiceID] {
+			continue
+		}
+		if first < 0 || tt.StartMinutes < first {
+			first = tt.StartMinutes
+		}
+		lastStart = max(lastStart, tt.StartMinutes)
+		lastEnd = max(lastEnd, tt.EndMinutes)
+		span.Trips++
+	}
+	if span.Trips == 0 {
+		return span, false
+	}
+	dayStart := gtfs.ServiceDayStart(day)
+	span.FirstDeparture = dayStart.Add(time.Duration(first) * time.Minute)
+	span.LastDeparture = dayStart.Add(time.Duration(lastStart) * time.Minute)
+	span.LastArrival = dayStart.Add(time.Duration(lastEnd) * time.Minute)
+	return span, true
+}
+
+// CountRunningTrips returns the number of trips of all routes scheduled to
+// be en route at the given instant.
+func (s *GTFSStore) CountRunningTrips(at time.Time) int {
+	d := s.data.Load()
+
+	count := 0
+	for _, day := range candidateServiceDays(at) {
+		activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+		timeMinutes := int(at.Sub(gtfs.ServiceDayStart(day)).Minutes())
+
+		for _, trips := range d.routeTripTimes {
+			for _, tt := range trips {
+				if activeServices[tt.ServiceID] && tt.StartMinutes <= timeMinutes && tt.EndMinutes >= timeMinutes {
+					count++
+				}
 			}
-			copy(shapeCopy.Points, shape.Points)
-			result = append(result, shapeCopy)
 		}
 	}
+	return count
+}
+
+// ScheduledHeadways returns the route's scheduled headway per direction ID
+// at the given instant: the mean gap between the departures of the trips
+// scheduled to be en route then. Directions with fewer than two such trips
+// are left out.
+func (s *GTFSStore) ScheduledHeadways(routeID string, at time.Time) map[int]time.Duration {
+	d := s.data.Load()
+
+	starts := make(map[int][]time.Time)
+	for _, day := range candidateServiceDays(at) {
+		activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+		dayStart := gtfs.ServiceDayStart(day)
+		timeMinutes := int(at.Sub(dayStart).Minutes())
+
+		for _, tt := range d.routeTripTimes[routeID] {
+			if activeServices[tt.ServiceID] && tt.StartMinutes <= timeMinutes && tt.EndMinutes >= timeMinutes {
+				starts[tt.DirectionID] = append(starts[tt.DirectionID], dayStart.Add(time.Duration(tt.StartMinutes)*time.Minute))
+			}
+		}
+	}
+
+	headways := make(map[int]time.Duration)
+	for dir, times := range starts {
+		if len(times) < 2 {
+			continue
+		}
+		first, last := times[0], times[0]
+		for _, t := range times[1:] {
+			if t.Before(first) {
+				first = t
+			}
+			if t.After(last) {
+				last = t
+			}
+		}
+		if span := last.Sub(first); span > 0 {
+			headways[dir] = span / time.Duration(len(times)-1)
+		}
+	}
+	return headways
+}
+
+// GetActiveTripsAt returns the route's trip runs scheduled to be en route at
+// at, i.e. between their first departure and last arrival, ordered by start
+// time. This is the schedule GetActiveRouteShapesAt and CountActiveTrips
+// filter by, without their margin.
+func (s *GTFSStore) GetActiveTripsAt(routeID string, at time.Time) []*domain.ActiveTrip {
+	d := s.data.Load()
+
+	var result []*domain.ActiveTrip
+	for _, day := range candidateServiceDays(at) {
+		activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+		dayStart := gtfs.ServiceDayStart(day)
+		timeMinutes := int(at.Sub(dayStart).Minutes())
+
+		for _, tt := range d.routeTripTimes[routeID] {
+			if !activeServices[tt.ServiceID] || tt.StartMinutes > timeMinutes || tt.EndMinutes < timeMinutes {
+				continue
+			}
+			trip := &domain.ActiveTrip{
+				TripID:      tt.TripID,
+				Headsign:    tt.Headsign,
+				ShapeID:     tt.ShapeID,
+				DirectionID: tt.DirectionID,
+				ServiceDate: day.Format("2006-01-02"),
+				StartStopID: tt.StartStopID,
+				EndStopID:   tt.EndStopID,
+				StartTime:   dayStart.Add(time.Duration(tt.StartMinutes) * time.Minute),
+				EndTime:     dayStart.Add(time.Duration(tt.EndMinutes) * time.Minute),
+				Progress:    100,
+			}
+			if stop, ok := d.stops[tt.StartStopID]; ok {
+				trip.StartStopName = stop.Name
+			}
+			if stop, ok := d.stops[tt.EndStopID]; ok {
+				trip.EndStopName = stop.Name
+			}
+			if total := trip.EndTime.Sub(trip.StartTime); total > 0 {
+				progress := float64(at.Sub(trip.StartTime)) / float64(total) * 100
+				trip.Progress = math.Round(min(max(progress, 0), 100)*10) / 10
+			}
+			result = append(result, trip)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].StartTime.Equal(result[j].StartTime) {
+			return result[i].StartTime.Before(result[j].StartTime)
+		}
+		return result[i].TripID < result[j].TripID
+	})
+	return result
+}
+
+// GetScheduledTrips returns every trip run with a scheduled stop between from
+// and to, with all of its stops. Runs of the service days before from are
+// included, so after-midnight trips are found on the right day.
+func (s *GTFSStore) GetScheduledTrips(from, to time.Time) []*domain.ScheduledTrip {
+	d := s.data.Load()
+
+	type serviceDay struct {
+		date           time.Time
+		active         map[string]bool
+		fromSec, toSec float64
+	}
+	var days []serviceDay
+	for day := gtfs.ServiceDay(from).AddDate(0, 0, -1); !day.After(gtfs.ServiceDay(to)); day = day.AddDate(0, 0, 1) {
+		start := gtfs.ServiceDayStart(day)
+		days = append(days, serviceDay{
+			date:    day,
+			active:  d.activeServices(day.Format("20060102"), day.Weekday()),
+			fromSec: from.Sub(start).Seconds(),
+			toSec:   to.Sub(start).Seconds(),
+		})
+	}
+
+	type runKey struct {
+		day  int
+		trip uint32
+	}
+	inWindow := func(st domain.StopTimeCompact, day serviceDay) bool {
+		return float64(st.DepartureSeconds) >= day.fromSec && float64(st.ArrivalSeconds) <= day.toSec
+	}
+
+	// First pass: find the runs stopping anywhere within the window.
+	runs := make(map[runKey]*domain.ScheduledTrip)
+	for _, schedule := range d.stopSchedules {
+		for _, st := range schedule {
+			if int(st.TripIndex) >= len(d.trips) {
+				continue
+			}
+			serviceID := d.trips[st.TripIndex].ServiceID
+			for i, day := range days {
+				if day.active[serviceID] && inWindow(st, day) {
+					runs[runKey{i, st.TripIndex}] = nil
+				}
+			}
+		}
+	}
+
+	// Second pass: collect all stops of those runs.
+	for stopID, schedule := range d.stopSchedules {
+		stop := d.stops[stopID]
+		for _, st := range schedule {
+			for i, day := range days {
+				key := runKey{i, st.TripIndex}
+				run, ok := runs[key]
+				if !ok {
+					continue
+				}
+				if run == nil {
+					trip := d.trips[st.TripIndex]
+					run = &domain.ScheduledTrip{
+						TripID:      trip.ID,
+						RouteID:     trip.RouteID,
+						ShapeID:     trip.ShapeID,
+						Headsign:    trip.Headsign,
+						DirectionID: trip.DirectionID,
+						ServiceDate: day.date,
+					}
+					if route, ok := d.routes[trip.RouteID]; ok {
+						run.Line = route.ShortName
+					}
+					runs[key] = run
+				}
+				scheduled := domain.ScheduledStop{
+					StopID:    stopID,
+					Sequence:  int(st.StopSequence),
+					Arrival:   gtfs.ServiceTime(day.date, st.ArrivalSeconds),
+					Departure: gtfs.ServiceTime(day.date, st.DepartureSeconds),
+				}
+				if stop != nil {
+					scheduled.Lat, scheduled.Lon = stop.Lat, stop.Lon
+				}
+				run.Stops = append(run.Stops, scheduled)
+			}
+		}
+	}
+
+	result := make([]*domain.ScheduledTrip, 0, len(runs))
+	for _, run := range runs {
+		sort.Slice(run.Stops, func(i, j int) bool { return run.Stops[i].Sequence < run.Stops[j].Sequence })
+		first := run.Stops[0].Departure
+		run.StartTime = formatGTFSTime(uint32(first.Sub(gtfs.ServiceDayStart(run.ServiceDate)).Seconds()))
+		result = append(result, run)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].Stops[0].Departure, result[j].Stops[0].Departure
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return result[i].TripID < result[j].TripID
+	})
 	return result
 }
 
-func (s *GTFSStore) getRouteShapesLocked(routeID string) []*domain.Shape {
-	shapeIDs, ok := s.routeShapes[routeID]
+// candidateServiceDays returns the previous and current service day of at,
+// the only ones whose trips can be running at that instant.
+func candidateServiceDays(at time.Time) []time.Time {
+	today := gtfs.ServiceDay(at)
+	return []time.Time{today.AddDate(0, 0, -1), today}
+}
+
+func (d *gtfsDataset) routeShapeCopies(routeID string, full bool) []*domain.Shape {
+	shapeIDs, ok := d.routeShapes[routeID]
 	if !ok {
 		return nil
 	}
 	result := make([]*domain.Shape, 0, len(shapeIDs))
 	for _, shapeID := range shapeIDs {
-		if shape, ok := s.shapes[shapeID]; ok {
-			dir := s.shapeDirections[shapeID]
-			shapeCopy := &domain.Shape{
-				ID:          shape.ID,
-				Points:      make([]domain.ShapePoint, len(shape.Points)),
-				DirectionID: &dir,
-			}
-			copy(shapeCopy.Points, shape.Points)
-			result = append(result, shapeCopy)
+		if shape, ok := d.shapes[shapeID]; ok {
+			result = append(result, d.copyShape(shape, full))
 		}
 	}
 	return result
 }
 
+// GetSimplifiedShape returns a shape as kept in memory (see SetShapeSource).
+func (s *GTFSStore) GetSimplifiedShape(shapeID string) (*domain.Shape, bool) {
+	d := s.data.Load()
+
+	shape, ok := d.shapes[shapeID]
+	if !ok {
+		return nil, false
+	}
+	return d.copyShape(shape, false), true
+}
+
+// copyShape copies a shape with its direction, at full resolution or
+// as kept in memory.
+func (d *gtfsDataset) copyShape(shape *domain.Shape, full bool) *domain.Shape {
+	points := shape.Points
+	if full {
+		points = d.fullPoints(shape)
+	}
+	dir := d.shapeDirections[shape.ID]
+	shapeCopy := &domain.Shape{
+		ID:          shape.ID,
+		Points:      make([]domain.ShapePoint, len(points)),
+		DirectionID: &dir,
+	}
+	copy(shapeCopy.Points, points)
+	return shapeCopy
+}
+
+// GetShapeHeadsign returns the most common trip headsign for a shape.
+func (s *GTFSStore) GetShapeHeadsign(shapeID string) string {
+	d := s.data.Load()
+	return d.shapeHeadsigns[shapeID]
+}
+
 func (s *GTFSStore) GetAllStops() []*domain.Stop {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	result := make([]*domain.Stop, 0, len(s.stops))
-	for _, stop := range s.stops {
+	result := make([]*domain.Stop, 0, len(d.stops))
+	for _, stop := range d.stops {
 		copy := *stop
 		result = append(result, &copy)
 	}
@@ -203,10 +620,9 @@ func (s *GTFSStore) GetAllStops() []*domain.Stop {
 }
 
 func (s *GTFSStore) GetStopByID(id string) (*domain.Stop, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	stop, ok := s.stops[id]
+	stop, ok := d.stops[id]
 	if !ok {
 		return nil, false
 	}
@@ -215,10 +631,9 @@ func (s *GTFSStore) GetStopByID(id string) (*domain.Stop, bool) {
 }
 
 func (s *GTFSStore) GetRouteStops(routeID string) []*domain.Stop {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	stops, ok := s.routeStops[routeID]
+	stops, ok := d.routeStops[routeID]
 	if !ok {
 		return nil
 	}
@@ -232,17 +647,16 @@ func (s *GTFSStore) GetRouteStops(routeID string) []*domain.Stop {
 }
 
 func (s *GTFSStore) GetStopSchedule(stopID string) []*domain.StopTime {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	schedule, ok := s.stopSchedules[stopID]
+	schedule, ok := d.stopSchedules[stopID]
 	if !ok {
 		return nil
 	}
 
 	result := make([]*domain.StopTime, 0, len(schedule))
 	for _, st := range schedule {
-		decoded, ok := s.decodeStopTimeLocked(st)
+		decoded, ok := d.decodeStopTime(st)
 		if ok {
 			result = append(result, decoded)
 		}
@@ -251,30 +665,29 @@ func (s *GTFSStore) GetStopSchedule(stopID string) []*domain.StopTime {
 }
 
 func (s *GTFSStore) GetStopScheduleForDate(stopID string, date time.Time) []*domain.StopTime {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	schedule, ok := s.stopSchedules[stopID]
+	schedule, ok := d.stopSchedules[stopID]
 	if !ok {
 		return nil
 	}
 
 	dateStr := date.Format("20060102")
 	weekday := date.Weekday()
-	activeServices := s.getActiveServices(dateStr, weekday)
+	activeServices := d.activeServices(dateStr, weekday)
 
 	result := make([]*domain.StopTime, 0, len(schedule))
 	for _, st := range schedule {
 		tripIdx := int(st.TripIndex)
-		if tripIdx < 0 || tripIdx >= len(s.trips) {
+		if tripIdx < 0 || tripIdx >= len(d.trips) {
 			continue
 		}
-		trip := s.trips[tripIdx]
+		trip := d.trips[tripIdx]
 		if !activeServices[trip.ServiceID] {
 			continue
 		}
 
-		decoded, ok := s.decodeStopTimeLocked(st)
+		decoded, ok := d.decodeStopTime(st)
 		if ok {
 			result = append(result, decoded)
 		}
@@ -282,15 +695,58 @@ func (s *GTFSStore) GetStopScheduleForDate(stopID string, date time.Time) []*dom
 	return result
 }
 
-func (s *GTFSStore) decodeStopTimeLocked(st domain.StopTimeCompact) (*domain.StopTime, bool) {
+// GetStopScheduleAt returns departures from the stop at or after at, ordered by
+// departure instant. Trips of the previous service day that are still running
+// after midnight are included, and each entry carries its service date.
+func (s *GTFSStore) GetStopScheduleAt(stopID string, at time.Time) []*domain.StopTime {
+	d := s.data.Load()
+
+	schedule, ok := d.stopSchedules[stopID]
+	if !ok {
+		return nil
+	}
+
+	var result []*domain.StopTime
+	for _, day := range candidateServiceDays(at) {
+		activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+		dayStart := gtfs.ServiceDayStart(day)
+		fromSeconds := at.Sub(dayStart).Seconds()
+
+		for _, st := range schedule {
+			if float64(st.DepartureSeconds) < fromSeconds {
+				continue
+			}
+			tripIdx := int(st.TripIndex)
+			if tripIdx < 0 || tripIdx >= len(d.trips) || !activeServices[d.trips[tripIdx].ServiceID] {
+				continue
+			}
+
+			decoded, ok := d.decodeStopTime(st)
+			if !ok {
+				continue
+			}
+			departure := dayStart.Add(time.Duration(st.DepartureSeconds) * time.Second)
+			decoded.ServiceDate = day.Format("2006-01-02")
+			decoded.DepartureAt = &departure
+			result = append(result, decoded)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DepartureAt.Before(*result[j].DepartureAt)
+	})
+	return result
+}
+
+func (d *gtfsDataset) decodeStopTime(st domain.StopTimeCompact) (*domain.StopTime, bool) {
 	tripIdx := int(st.TripIndex)
-	if tripIdx < 0 || tripIdx >= len(s.trips) {
+	if tripIdx < 0 || tripIdx >= len(d.trips) {
 		return nil, false
 	}
-	trip := s.trips[tripIdx]
+	trip := d.trips[tripIdx]
 
 	line := ""
-	if route, ok := s.routes[trip.RouteID]; ok {
+	if route, ok := d.routes[trip.RouteID]; ok {
 		line = route.ShortName
 	}
 
@@ -313,10 +769,10 @@ func formatGTFSTime(totalSeconds uint32) string {
 	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
 }
 
-func (s *GTFSStore) getActiveServices(dateStr string, weekday time.Weekday) map[string]bool {
+func (d *gtfsDataset) activeServices(dateStr string, weekday time.Weekday) map[string]bool {
 	active := make(map[string]bool)
 
-	for serviceID, cal := range s.calendars {
+	for serviceID, cal := range d.calendars {
 		if dateStr < cal.StartDate || dateStr > cal.EndDate {
 			continue
 		}
@@ -344,7 +800,7 @@ func (s *GTFSStore) getActiveServices(dateStr string, weekday time.Weekday) map[
 		}
 	}
 
-	for serviceID, dates := range s.calendarDates {
+	for serviceID, dates := range d.calendarDates {
 		for _, cd := range dates {
 			if cd.Date == dateStr {
 				if cd.ExceptionType == 1 {
@@ -360,10 +816,9 @@ func (s *GTFSStore) getActiveServices(dateStr string, weekday time.Weekday) map[
 }
 
 func (s *GTFSStore) GetStopLines(stopID string) []*domain.StopLine {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	lines, ok := s.stopLines[stopID]
+	lines, ok := d.stopLines[stopID]
 	if !ok {
 		return nil
 	}
@@ -384,39 +839,102 @@ func (s *GTFSStore) GetStopLines(stopID string) []*domain.StopLine {
 	return result
 }
 
+// CountRemainingDepartures counts the departures from the stop at or after
+// at until the end of the current service day, by route ID. Trips of the
+// previous service day still running after midnight count as well.
+func (s *GTFSStore) CountRemainingDepartures(stopID string, at time.Time) map[string]int {
+	d := s.data.Load()
+
+	counts := make(map[string]int)
+	schedule, ok := d.stopSchedules[stopID]
+	if !ok {
+		return counts
+	}
+	for _, day := range candidateServiceDays(at) {
+		activeServices := d.activeServices(day.Format("20060102"), day.Weekday())
+		fromSeconds := at.Sub(gtfs.ServiceDayStart(day)).Seconds()
+
+		for _, st := range schedule {
+			if float64(st.DepartureSeconds) < fromSeconds {
+				continue
+			}
+			tripIdx := int(st.TripIndex)
+			if tripIdx < 0 || tripIdx >= len(d.trips) || !activeServices[d.trips[tripIdx].ServiceID] {
+				continue
+			}
+			counts[d.trips[tripIdx].RouteID]++
+		}
+	}
+	return counts
+}
+
 type GTFSStats struct {
-	RoutesCount int       `json:"routes_count"`
-	ShapesCount int       `json:"shapes_count"`
-	StopsCount  int       `json:"stops_count"`
-	LastUpdate  time.Time `json:"last_update"`
-	IsLoaded    bool      `json:"is_loaded"`
+	RoutesCount int              `json:"routes_count"`
+	ShapesCount int              `json:"shapes_count"`
+	StopsCount  int              `json:"stops_count"`
+	LastUpdate  time.Time        `json:"last_update"`
+	IsLoaded    bool             `json:"is_loaded"`
+	Feed        *gtfs.FeedReport `json:"feed,omitempty"` // set when the parser had findings
 }
 
 func (s *GTFSStore) GetStats() GTFSStats {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
+
+	stats := GTFSStats{
+		RoutesCount: len(d.routes),
+		ShapesCount: len(d.shapes),
+		StopsCount:  len(d.stops),
+		LastUpdate:  d.lastUpdate,
+		IsLoaded:    !d.lastUpdate.IsZero(),
+	}
+	if d.feedReport.HasFindings() {
+		report := d.feedReport
+		stats.Feed = &report
+	}
+	return stats
+}
+
+// InServiceRange reports whether day falls between the first and the last
+// date the dataset schedules any service on.
+func (s *GTFSStore) InServiceRange(day time.Time) bool {
+	d := s.data.Load()
+	date := day.Format("20060102")
+	return d.firstServiceDate != "" && date >= d.firstServiceDate && date <= d.lastServiceDate
+}
 
-	return GTFSStats{
-		RoutesCount: len(s.routes),
-		ShapesCount: len(s.shapes),
-		StopsCount:  len(s.stops),
-		LastUpdate:  s.lastUpdate,
-		IsLoaded:    !s.lastUpdate.IsZero(),
+func serviceDateRange(calendars map[string]*domain.Calendar, calendarDates map[string][]*domain.CalendarDate) (first, last string) {
+	extend := func(from, to string) {
+		if first == "" || from < first {
+			first = from
+		}
+		if to > last {
+			last = to
+		}
+	}
+	for _, cal := range calendars {
+		extend(cal.StartDate, cal.EndDate)
+	}
+	for _, dates := range calendarDates {
+		for _, cd := range dates {
+			if cd.ExceptionType == 1 {
+				extend(cd.Date, cd.Date)
+			}
+		}
 	}
+	return first, last
 }
 
 func (s *GTFSStore) GetCalendarsAndDates() ([]*domain.Calendar, []*domain.CalendarDate) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	d := s.data.Load()
 
-	calendars := make([]*domain.Calendar, 0, len(s.calendars))
-	for _, cal := range s.calendars {
+	calendars := make([]*domain.Calendar, 0, len(d.calendars))
+	for _, cal := range d.calendars {
 		copy := *cal
 		calendars = append(calendars, &copy)
 	}
 
 	var calendarDates []*domain.CalendarDate
-	for _, dates := range s.calendarDates {
+	for _, dates := range d.calendarDates {
 		for _, cd := range dates {
 			copy := *cd
 			calendarDates = append(calendarDates, &copy)
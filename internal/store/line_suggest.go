@@ -0,0 +1,85 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// SuggestLines returns up to limit known lines closest to an unknown one,
+// for "did you mean" hints: case-insensitive matches first, then by edit
+// distance. Only lines within one edit of a query of up to three characters,
+// or two edits of a longer one, are suggested, so numbers far from any line
+// get no suggestions.
+func (s *GTFSStore) SuggestLines(line string, limit int) []string {
+	query := strings.ToUpper(strings.TrimSpace(line))
+	if query == "" || limit <= 0 {
+		return nil
+	}
+
+	type match struct {
+		line     string
+		distance int
+	}
+	var matches []match
+
+	maxDistance := 2
+	switch {
+	case len(query) == 1:
+		maxDistance = 0 // a one-character line is one edit from every other
+	case len(query) <= 3:
+		maxDistance = 1
+	}
+
+	for candidate := range s.data.Load().routesByLine {
+		if candidate == line {
+			continue
+		}
+		if d := editDistance(query, strings.ToUpper(candidate)); d <= maxDistance {
+			matches = append(matches, match{line: candidate, distance: d})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		// Same-length lines ("519" for "509") before longer or shorter ones.
+		li, lj := len(matches[i].line) == len(query), len(matches[j].line) == len(query)
+		if li != lj {
+			return li
+		}
+		return matches[i].line < matches[j].line
+	})
+
+	result := make([]string, 0, min(len(matches), limit))
+	for _, m := range matches[:min(len(matches), limit)] {
+		result = append(result, m.line)
+	}
+	return result
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment)
+// distance of a and b, so swapped digits count as one edit.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"wabus/internal/domain"
+)
+
+// MicromobilityStore holds the stations and free vehicles of the ingested
+// shared mobility systems. Each system is replaced as a whole on refresh.
+type MicromobilityStore struct {
+	mu       sync.RWMutex
+	systems  map[string]*micromobilitySystem
+	onUpdate func()
+}
+
+type micromobilitySystem struct {
+	info     domain.MicromobilitySystem
+	stations []*domain.MicromobilityStation
+	vehicles []*domain.MicromobilityVehicle
+}
+
+func NewMicromobilityStore() *MicromobilityStore {
+	return &MicromobilityStore{systems: make(map[string]*micromobilitySystem)}
+}
+
+// SetOnUpdate registers a callback invoked after every refresh of a system.
+// It runs outside the store lock.
+func (s *MicromobilityStore) SetOnUpdate(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onUpdate = fn
+}
+
+// ReplaceSystem replaces the stations and vehicles of a system.
+func (s *MicromobilityStore) ReplaceSystem(id, name string, stations []*domain.MicromobilityStation, vehicles []*domain.MicromobilityVehicle, at time.Time) {
+	s.mu.Lock()
+	s.systems[id] = &micromobilitySystem{
+		info:     domain.MicromobilitySystem{ID: id, Name: name, Stations: len(stations), Vehicles: len(vehicles), UpdatedAt: at},
+		stations: stations,
+		vehicles: vehicles,
+	}
+	onUpdate := s.onUpdate
+	s.mu.Unlock()
+
+	if onUpdate != nil {
+		onUpdate()
+	}
+}
+
+// Systems returns the ingested systems ordered by ID.
+func (s *MicromobilityStore) Systems() []domain.MicromobilitySystem {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]domain.MicromobilitySystem, 0, len(s.systems))
+	for _, sys := range s.systems {
+		result = append(result, sys.info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// MicromobilityFilter selects stations and vehicles. A nil BBox or Tiles
+// doesn't filter.
+type MicromobilityFilter struct {
+	BBox   *domain.BoundingBox
+	Tiles  map[string]struct{}
+	System string
+}
+
+func (f MicromobilityFilter) matches(system string, lat, lon float64, tileID string) bool {
+	if f.System != "" && system != f.System {
+		return false
+	}
+	if f.BBox != nil && !f.BBox.Contains(lat, lon) {
+		return false
+	}
+	if f.Tiles != nil {
+		if _, ok := f.Tiles[tileID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Stations returns the stations matching f, ordered by system.
+func (s *MicromobilityStore) Stations(f MicromobilityFilter) []*domain.MicromobilityStation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*domain.MicromobilityStation, 0)
+	for _, id := range s.systemIDs() {
+		for _, st := range s.systems[id].stations {
+			if f.matches(st.System, st.Lat, st.Lon, st.TileID) {
+				result = append(result, st)
+			}
+		}
+	}
+	return result
+}
+
+// Vehicles returns the free vehicles matching f, ordered by system.
+func (s *MicromobilityStore) Vehicles(f MicromobilityFilter) []*domain.MicromobilityVehicle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*domain.MicromobilityVehicle, 0)
+	for _, id := range s.systemIDs() {
+		for _, v := range s.systems[id].vehicles {
+			if f.matches(v.System, v.Lat, v.Lon, v.TileID) {
+				result = append(result, v)
+			}
+		}
+	}
+	return result
+}
+
+func (s *MicromobilityStore) systemIDs() []string {
+	ids := make([]string, 0, len(s.systems))
+	for id := range s.systems {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"wabus/internal/domain"
+)
+
+// POIStore holds the static points of interest loaded at startup.
+type POIStore struct {
+	pois    []*domain.POI
+	version string
+}
+
+// NewPOIStore holds pois, ordered by type and ID.
+func NewPOIStore(pois []*domain.POI) *POIStore {
+	sort.Slice(pois, func(i, j int) bool {
+		if pois[i].Type != pois[j].Type {
+			return pois[i].Type < pois[j].Type
+		}
+		return pois[i].ID < pois[j].ID
+	})
+	data, _ := json.Marshal(pois)
+	sum := sha256.Sum256(data)
+	return &POIStore{pois: pois, version: hex.EncodeToString(sum[:4])}
+}
+
+// POIFilter selects points of interest. A nil BBox or Types doesn't filter.
+type POIFilter struct {
+	BBox  *domain.BoundingBox
+	Types map[string]struct{}
+}
+
+// POIs returns the points of interest matching filter.
+func (s *POIStore) POIs(filter POIFilter) []*domain.POI {
+	result := make([]*domain.POI, 0)
+	for _, p := range s.pois {
+		if filter.Types != nil {
+			if _, ok := filter.Types[p.Type]; !ok {
+				continue
+			}
+		}
+		if filter.BBox != nil && !filter.BBox.Contains(p.Lat, p.Lon) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// Version identifies the loaded points of interest, changing with them.
+func (s *POIStore) Version() string {
+	return s.version
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	ID       any `json:"id"`
+	Geometry *struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// LoadPOIFile reads points of interest from a GeoJSON FeatureCollection of
+// Point features. Each feature needs a "type" property; "name" and "id"
+// (or the feature's id) are optional, IDs defaulting to <type>-<n>. An
+// empty path loads none.
+func LoadPOIFile(path string) ([]*domain.POI, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read POI file: %w", err)
+	}
+	var fc geoJSONFeatureCollection
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, fmt.Errorf("parse POI file: %w", err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("parse POI file: expected a FeatureCollection, got %q", fc.Type)
+	}
+
+	pois := make([]*domain.POI, 0, len(fc.Features))
+	seen := make(map[string]struct{}, len(fc.Features))
+	counts := make(map[string]int)
+	for i, f := range fc.Features {
+		if f.Geometry == nil || f.Geometry.Type != "Point" || len(f.Geometry.Coordinates) < 2 {
+			return nil, fmt.Errorf("POI feature %d: geometry must be a Point", i)
+		}
+		// GeoJSON positions are [lon, lat].
+		lon, lat := f.Geometry.Coordinates[0], f.Geometry.Coordinates[1]
+		if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return nil, fmt.Errorf("POI feature %d: coordinates out of range", i)
+		}
+
+		props := f.Properties
+		poiType, _ := props["type"].(string)
+		if poiType == "" {
+			return nil, fmt.Errorf("POI feature %d: type property is required", i)
+		}
+		name, _ := props["name"].(string)
+		counts[poiType]++
+		id := featureID(f.ID, props["id"])
+		if id == "" {
+			id = fmt.Sprintf("%s-%d", poiType, counts[poiType])
+		}
+		if _, ok := seen[id]; ok {
+			return nil, fmt.Errorf("POI feature %d: duplicate id %q", i, id)
+		}
+		seen[id] = struct{}{}
+
+		var extra map[string]any
+		for k, v := range props {
+			if k == "id" || k == "type" || k == "name" {
+				continue
+			}
+			if extra == nil {
+				extra = make(map[string]any)
+			}
+			extra[k] = v
+		}
+		pois = append(pois, &domain.POI{ID: id, Type: poiType, Name: name, Lat: lat, Lon: lon, Properties: extra})
+	}
+	return pois, nil
+}
+
+// featureID returns the first of the candidate IDs set, formatting numeric
+// IDs without a fraction.
+func featureID(candidates ...any) string {
+	for _, c := range candidates {
+		switch v := c.(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return fmt.Sprintf("%.0f", v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+
+	"wabus/internal/domain"
+)
+
+// ShapeSource provides full-resolution shape points kept outside memory.
+type ShapeSource interface {
+	Points(shapeID string) ([]domain.ShapePoint, error)
+}
+
+// shapeLRU caches the most recently used full-resolution shapes.
+type shapeLRU struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recent; values are *shapeEntry
+	entries  map[string]*list.Element
+}
+
+type shapeEntry struct {
+	id     string
+	points []domain.ShapePoint
+}
+
+func newShapeLRU(capacity int) *shapeLRU {
+	return &shapeLRU{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *shapeLRU) get(id string) ([]domain.ShapePoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*shapeEntry).points, true
+}
+
+func (c *shapeLRU) add(id string, points []domain.ShapePoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[id] = c.order.PushFront(&shapeEntry{id: id, points: points})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*shapeEntry).id)
+	}
+}
+
+// SetShapeSource makes the store serve full-resolution shapes from src,
+// caching up to cacheSize of them, while the shapes passed to
+// PrepareDataset are the simplified ones kept in memory. It takes effect
+// with the next dataset prepared; a nil src serves the in-memory shapes
+// only.
+func (s *GTFSStore) SetShapeSource(src ShapeSource, cacheSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shapeSource = src
+	s.shapeCache = nil
+	if src != nil {
+		s.shapeCache = newShapeLRU(max(cacheSize, 1))
+	}
+}
+
+// fullPoints returns the full-resolution points of a shape, falling back to
+// the in-memory points when they can't be loaded.
+func (d *gtfsDataset) fullPoints(shape *domain.Shape) []domain.ShapePoint {
+	if d.shapeSource == nil {
+		return shape.Points
+	}
+	if points, ok := d.shapeCache.get(shape.ID); ok {
+		return points
+	}
+	points, err := d.shapeSource.Points(shape.ID)
+	if err != nil {
+		return shape.Points
+	}
+	d.shapeCache.add(shape.ID, points)
+	return points
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+
+	"wabus/internal/domain"
+)
+
+// routeShapeVersions hashes the shapes of every route, so a route's version
+// only changes when a GTFS update touches its shapes. Full-resolution points
+// come from src when shapes are kept on disk, as they are what's served.
+func routeShapeVersions(routeShapes map[string][]string, shapes map[string]*domain.Shape, shapeDirections map[string]int, src ShapeSource) map[string]string {
+	versions := make(map[string]string, len(routeShapes))
+	buf := make([]byte, 8)
+	for routeID, shapeIDs := range routeShapes {
+		h := sha256.New()
+		for _, shapeID := range shapeIDs {
+			shape, ok := shapes[shapeID]
+			if !ok {
+				continue
+			}
+			points := shape.Points
+			if src != nil {
+				if full, err := src.Points(shapeID); err == nil {
+					points = full
+				}
+			}
+
+			h.Write([]byte(shapeID))
+			h.Write([]byte{0, byte(shapeDirections[shapeID])})
+			for _, p := range points {
+				binary.LittleEndian.PutUint64(buf, math.Float64bits(p.Lat))
+				h.Write(buf)
+				binary.LittleEndian.PutUint64(buf, math.Float64bits(p.Lon))
+				h.Write(buf)
+			}
+		}
+		versions[routeID] = hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	return versions
+}
@@ -1,16 +1,24 @@
 package store
 
 import (
+	"math"
+	"slices"
 	"sync"
 	"time"
 
 	"wabus/internal/domain"
+	"wabus/pkg/geo"
 )
 
 type ListOptions struct {
 	Type *domain.VehicleType
 	Line string
 	BBox *domain.BoundingBox
+	// MinDelay keeps vehicles at least this many seconds late; vehicles
+	// without a delay are left out.
+	MinDelay *int
+	// LeadsOnly leaves out coupled units trailing a lead unit.
+	LeadsOnly bool
 }
 
 type Store struct {
@@ -20,6 +28,9 @@ type Store struct {
 	byLine   map[string]map[string]struct{}
 	byType   map[domain.VehicleType]map[string]struct{}
 
+	// deltaBuf collects the deltas of an update, reused across updates.
+	deltaBuf []domain.VehicleDelta
+
 	staleAfter time.Duration
 }
 
@@ -34,11 +45,22 @@ func New(staleAfter time.Duration) *Store {
 }
 
 func (s *Store) Update(vehicles []*domain.Vehicle) []domain.VehicleDelta {
+	return s.update(vehicles, false)
+}
+
+// UpdateOwned is Update for vehicles the caller hands over: those that
+// aren't stored because they haven't changed are released with
+// domain.ReleaseVehicle.
+func (s *Store) UpdateOwned(vehicles []*domain.Vehicle) []domain.VehicleDelta {
+	return s.update(vehicles, true)
+}
+
+func (s *Store) update(vehicles []*domain.Vehicle, release bool) []domain.VehicleDelta {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	now := time.Now()
-	deltas := make([]domain.VehicleDelta, 0, len(vehicles))
+	deltas := s.deltaBuf[:0]
 
 	for _, v := range vehicles {
 		v.UpdatedAt = now
@@ -46,25 +68,83 @@ func (s *Store) Update(vehicles []*domain.Vehicle) []domain.VehicleDelta {
 		existing, exists := s.vehicles[v.Key]
 		if !exists || hasChanged(existing, v) {
 			if exists {
-				// Remove stale indices before writing updated vehicle.
-				// This prevents index growth when line/type/tile changes.
-				s.removeFromAllIndices(existing)
+				setSpeed(existing, v)
+				s.reindex(existing, v)
+			} else {
+				s.addToIndices(v)
 			}
-
 			s.vehicles[v.Key] = v
-			s.addToIndices(v)
 
 			deltas = append(deltas, domain.VehicleDelta{
-				Type:    domain.DeltaUpdate,
-				Vehicle: v,
-				TileID:  v.TileID,
+				Type:        domain.DeltaUpdate,
+				Vehicle:     v,
+				TileID:      v.TileID,
+				VehicleType: v.Type,
+				Line:        v.Line,
 			})
 		} else {
 			existing.UpdatedAt = now
+			if release {
+				domain.ReleaseVehicle(v)
+			}
 		}
 	}
 
-	return deltas
+	// Deltas outlive the update in the hub and recorders, so they get a
+	// slice of their own, sized to fit; the buffer is kept for the next
+	// update without its vehicle references.
+	result := slices.Clone(deltas)
+	clear(deltas)
+	s.deltaBuf = deltas[:0]
+	return result
+}
+
+// Restore loads vehicles replicated from a peer, keeping their UpdatedAt so
+// they go stale on the usual schedule. Vehicles already in the store with a
+// newer position are left alone. It returns the number of vehicles loaded.
+func (s *Store) Restore(vehicles []*domain.Vehicle) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restored := 0
+	for _, v := range vehicles {
+		existing, exists := s.vehicles[v.Key]
+		if exists && !v.Timestamp.After(existing.Timestamp) {
+			continue
+		}
+		if exists {
+			s.removeFromAllIndices(existing)
+		}
+		s.vehicles[v.Key] = v
+		s.addToIndices(v)
+		restored++
+	}
+	return restored
+}
+
+// Remove deletes a vehicle and returns its remove delta.
+func (s *Store) Remove(key string) (domain.VehicleDelta, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.vehicles[key]
+	if !ok {
+		return domain.VehicleDelta{}, false
+	}
+	s.removeFromAllIndices(v)
+	delete(s.vehicles, key)
+	return domain.VehicleDelta{Type: domain.DeltaRemove, Key: key, TileID: v.TileID, VehicleType: v.Type, Line: v.Line}, true
+}
+
+// Keys returns the keys of all vehicles in the store.
+func (s *Store) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.vehicles))
+	for key := range s.vehicles {
+		keys = append(keys, key)
+	}
+	return keys
 }
 
 func (s *Store) PruneStale() []domain.VehicleDelta {
@@ -77,9 +157,11 @@ func (s *Store) PruneStale() []domain.VehicleDelta {
 	for key, v := range s.vehicles {
 		if v.UpdatedAt.Before(cutoff) {
 			deltas = append(deltas, domain.VehicleDelta{
-				Type:   domain.DeltaRemove,
-				Key:    key,
-				TileID: v.TileID,
+				Type:        domain.DeltaRemove,
+				Key:         key,
+				TileID:      v.TileID,
+				VehicleType: v.Type,
+				Line:        v.Line,
 			})
 			s.removeFromAllIndices(v)
 			delete(s.vehicles, key)
@@ -112,6 +194,12 @@ func (s *Store) List(opts ListOptions) []*domain.Vehicle {
 		if opts.BBox != nil && !opts.BBox.Contains(v.Lat, v.Lon) {
 			continue
 		}
+		if opts.MinDelay != nil && (v.Delay == nil || *v.Delay < *opts.MinDelay) {
+			continue
+		}
+		if opts.LeadsOnly && v.LeadKey != "" {
+			continue
+		}
 		copy := *v
 		result = append(result, &copy)
 	}
@@ -154,6 +242,22 @@ func (s *Store) SnapshotForTiles(tileIDs []string) []*domain.Vehicle {
 	return result
 }
 
+// SnapshotForLines returns copies of the vehicles serving the given lines.
+func (s *Store) SnapshotForLines(lines []string) []*domain.Vehicle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*domain.Vehicle
+	for _, line := range lines {
+		for key := range s.byLine[line] {
+			v := s.vehicles[key]
+			copy := *v
+			result = append(result, &copy)
+		}
+	}
+	return result
+}
+
 func (s *Store) Count() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -233,6 +337,40 @@ func (s *Store) addToIndices(v *domain.Vehicle) {
 	s.byType[v.Type][v.Key] = struct{}{}
 }
 
+// reindex moves a vehicle replacing old between the index entries that
+// differ, leaving the others alone, as most updates only change the
+// position within a tile.
+func (s *Store) reindex(old, v *domain.Vehicle) {
+	if old.TileID != v.TileID {
+		s.removeFromTileIndex(old.Key, old.TileID)
+		addToIndex(s.byTile, v.TileID, v.Key)
+	}
+	if old.Line != v.Line {
+		removeFromIndex(s.byLine, old.Line, old.Key)
+		addToIndex(s.byLine, v.Line, v.Key)
+	}
+	if old.Type != v.Type {
+		removeFromIndex(s.byType, old.Type, old.Key)
+		addToIndex(s.byType, v.Type, v.Key)
+	}
+}
+
+func addToIndex[K comparable](index map[K]map[string]struct{}, k K, key string) {
+	if index[k] == nil {
+		index[k] = make(map[string]struct{})
+	}
+	index[k][key] = struct{}{}
+}
+
+func removeFromIndex[K comparable](index map[K]map[string]struct{}, k K, key string) {
+	if index[k] != nil {
+		delete(index[k], key)
+		if len(index[k]) == 0 {
+			delete(index, k)
+		}
+	}
+}
+
 func (s *Store) removeFromTileIndex(key, tileID string) {
 	if s.byTile[tileID] != nil {
 		delete(s.byTile[tileID], key)
@@ -260,6 +398,42 @@ func (s *Store) removeFromAllIndices(v *domain.Vehicle) {
 	}
 }
 
+const (
+	// maxPlausibleSpeed (km/h) is faster than any bus or tram in the city;
+	// faster moves are GPS jumps and don't update the speed.
+	maxPlausibleSpeed = 100.0
+	// maxSpeedGap is the longest gap between positions the smoothed speed
+	// is carried across; after longer gaps it restarts.
+	maxSpeedGap = 5 * time.Minute
+	// speedSmoothing is the weight of the newest speed in SpeedAvg.
+	speedSmoothing = 0.3
+)
+
+// setSpeed derives v's speed from the previous position of the vehicle.
+func setSpeed(prev, v *domain.Vehicle) {
+	v.Speed, v.SpeedAvg = prev.Speed, prev.SpeedAvg
+
+	elapsed := v.Timestamp.Sub(prev.Timestamp)
+	if elapsed <= 0 {
+		return
+	}
+	speed := geo.Distance(prev.Lat, prev.Lon, v.Lat, v.Lon) / elapsed.Seconds() * 3.6
+	if speed > maxPlausibleSpeed {
+		return
+	}
+
+	avg := speed
+	if prev.SpeedAvg != nil && elapsed <= maxSpeedGap {
+		avg = *prev.SpeedAvg + speedSmoothing*(speed-*prev.SpeedAvg)
+	}
+	speed, avg = roundSpeed(speed), roundSpeed(avg)
+	v.Speed, v.SpeedAvg = &speed, &avg
+}
+
+func roundSpeed(kmh float64) float64 {
+	return math.Round(kmh*10) / 10
+}
+
 func hasChanged(old, new *domain.Vehicle) bool {
 	const epsilon = 0.000001
 
@@ -0,0 +1,48 @@
+package testsupport
+
+import "time"
+
+// corridorStops are three stops northwards along Marszałkowska that most
+// test feeds share, so vehicles placed between them match a trip.
+var corridorStops = []struct {
+	id, name string
+	lat, lon float64
+}{
+	{"S1", "Plac Unii Lubelskiej", 52.2120, 21.0190},
+	{"S2", "Plac Konstytucji", 52.2220, 21.0150},
+	{"S3", "Centrum", 52.2320, 21.0110},
+}
+
+// AddCorridorStops adds the stops S1, S2 and S3 from Plac Unii Lubelskiej
+// to Centrum.
+func (b *GTFSBuilder) AddCorridorStops() *GTFSBuilder {
+	for _, s := range corridorStops {
+		b.AddStop(s.id, s.name, s.lat, s.lon)
+	}
+	return b
+}
+
+// CorridorShape returns the points of the corridor stops in the given order,
+// as lat, lon pairs for AddShape.
+func CorridorShape(stopIDs ...string) []float64 {
+	var latLons []float64
+	for _, id := range stopIDs {
+		for _, s := range corridorStops {
+			if s.id == id {
+				latLons = append(latLons, s.lat, s.lon)
+			}
+		}
+	}
+	return latLons
+}
+
+// CorridorStopTimes returns calls at the stops in order, three minutes apart
+// from 06:00.
+func CorridorStopTimes(stopIDs ...string) []GTFSStopTime {
+	times := make([]GTFSStopTime, 0, len(stopIDs))
+	for i, id := range stopIDs {
+		at := 6*time.Hour + time.Duration(i)*3*time.Minute
+		times = append(times, GTFSStopTime{StopID: id, Arrival: at, Departure: at})
+	}
+	return times
+}
@@ -0,0 +1,128 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wabus/internal/domain"
+	"wabus/pkg/gtfs"
+)
+
+// FakeVehicle is a position served by FakeWarsawAPI.
+type FakeVehicle struct {
+	Number  string
+	Line    string
+	Brigade string
+	Lat     float64
+	Lon     float64
+	// Time defaults to the time of the request.
+	Time time.Time
+}
+
+// FakeWarsawAPI serves the busestrams_get endpoint of the Warsaw open data
+// API from positions set by the test.
+type FakeWarsawAPI struct {
+	server   *httptest.Server
+	requests atomic.Int64
+
+	mu       sync.Mutex
+	vehicles map[domain.VehicleType][]FakeVehicle
+	status   int    // non-200 answers every request with this status
+	apiError string // answered in the error field of the response
+}
+
+// NewFakeWarsawAPI starts a fake API, closed when the test ends.
+func NewFakeWarsawAPI(t testing.TB) *FakeWarsawAPI {
+	t.Helper()
+	f := &FakeWarsawAPI{vehicles: make(map[domain.VehicleType][]FakeVehicle), status: http.StatusOK}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL is the endpoint to set as WARSAW_API_URL.
+func (f *FakeWarsawAPI) URL() string {
+	return f.server.URL + "/api/action/busestrams_get"
+}
+
+// SetVehicles replaces the positions served for one vehicle type.
+func (f *FakeWarsawAPI) SetVehicles(vehicleType domain.VehicleType, vehicles ...FakeVehicle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.vehicles[vehicleType] = vehicles
+}
+
+// SetStatus makes every request fail with the HTTP status, or succeed again
+// with http.StatusOK.
+func (f *FakeWarsawAPI) SetStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// SetAPIError answers requests with status 200 and the message in the error
+// field, as the real API does for bad keys; empty clears it.
+func (f *FakeWarsawAPI) SetAPIError(message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apiError = message
+}
+
+// Requests returns how many requests the fake has answered.
+func (f *FakeWarsawAPI) Requests() int64 {
+	return f.requests.Load()
+}
+
+type fakeAPIVehicle struct {
+	Lines         string  `json:"Lines"`
+	Lon           float64 `json:"Lon"`
+	VehicleNumber string  `json:"VehicleNumber"`
+	Time          string  `json:"Time"`
+	Lat           float64 `json:"Lat"`
+	Brigade       string  `json:"Brigade"`
+}
+
+func (f *FakeWarsawAPI) serve(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+
+	f.mu.Lock()
+	status, apiError := f.status, f.apiError
+	vehicleType, _ := strconv.Atoi(r.URL.Query().Get("type"))
+	vehicles := f.vehicles[domain.VehicleType(vehicleType)]
+	f.mu.Unlock()
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if apiError != "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": "", "error": apiError})
+		return
+	}
+
+	now := time.Now()
+	result := make([]fakeAPIVehicle, 0, len(vehicles))
+	for _, v := range vehicles {
+		ts := v.Time
+		if ts.IsZero() {
+			ts = now
+		}
+		result = append(result, fakeAPIVehicle{
+			Lines:         v.Line,
+			Lon:           v.Lon,
+			VehicleNumber: v.Number,
+			Time:          ts.In(gtfs.Location()).Format("2006-01-02 15:04:05"),
+			Lat:           v.Lat,
+			Brigade:       v.Brigade,
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+}
@@ -0,0 +1,174 @@
+package testsupport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// GTFSBuilder assembles a small synthetic GTFS feed. The zero value is an
+// empty feed; Zip encodes it.
+type GTFSBuilder struct {
+	routes        [][]string
+	stops         [][]string
+	trips         [][]string
+	stopTimes     [][]string
+	shapes        [][]string
+	calendars     [][]string
+	calendarDates [][]string
+}
+
+// GTFSStopTime is one call of a trip at a stop. Times are offsets from the
+// start of the service day and may exceed 24h.
+type GTFSStopTime struct {
+	StopID    string
+	Arrival   time.Duration
+	Departure time.Duration
+}
+
+// AddRoute adds a route; routeType is 0 for trams and 3 for buses.
+func (b *GTFSBuilder) AddRoute(id, line string, routeType int) *GTFSBuilder {
+	b.routes = append(b.routes, []string{id, line, "Line " + line, strconv.Itoa(routeType)})
+	return b
+}
+
+func (b *GTFSBuilder) AddStop(id, name string, lat, lon float64) *GTFSBuilder {
+	b.stops = append(b.stops, []string{id, name, formatCoord(lat), formatCoord(lon), "1"})
+	return b
+}
+
+// AddShape adds a shape through the points, given as lat, lon pairs.
+func (b *GTFSBuilder) AddShape(id string, latLons ...float64) *GTFSBuilder {
+	for i := 0; i+1 < len(latLons); i += 2 {
+		b.shapes = append(b.shapes, []string{id, formatCoord(latLons[i]), formatCoord(latLons[i+1]), strconv.Itoa(i/2 + 1)})
+	}
+	return b
+}
+
+// AddTrip adds a trip calling at the stops in order.
+func (b *GTFSBuilder) AddTrip(routeID, serviceID, tripID, headsign string, directionID int, shapeID string, stopTimes ...GTFSStopTime) *GTFSBuilder {
+	b.trips = append(b.trips, []string{routeID, serviceID, tripID, headsign, strconv.Itoa(directionID), shapeID})
+	for i, st := range stopTimes {
+		b.stopTimes = append(b.stopTimes, []string{tripID, formatGTFSTime(st.Arrival), formatGTFSTime(st.Departure), st.StopID, strconv.Itoa(i + 1)})
+	}
+	return b
+}
+
+// AddDailyService adds a service running every day from start to end.
+func (b *GTFSBuilder) AddDailyService(serviceID string, start, end time.Time) *GTFSBuilder {
+	b.calendars = append(b.calendars, []string{serviceID, "1", "1", "1", "1", "1", "1", "1", start.Format("20060102"), end.Format("20060102")})
+	return b
+}
+
+// AddServiceDate adds (exceptionType 1) or removes (2) a service on a date.
+func (b *GTFSBuilder) AddServiceDate(serviceID string, date time.Time, exceptionType int) *GTFSBuilder {
+	b.calendarDates = append(b.calendarDates, []string{serviceID, date.Format("20060102"), strconv.Itoa(exceptionType)})
+	return b
+}
+
+// Zip encodes the feed as a GTFS zip archive. Files without rows are left
+// out, like the optional files some feeds omit.
+func (b *GTFSBuilder) Zip() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name   string
+		header []string
+		rows   [][]string
+	}{
+		{"routes.txt", []string{"route_id", "route_short_name", "route_long_name", "route_type"}, b.routes},
+		{"stops.txt", []string{"stop_id", "stop_name", "stop_lat", "stop_lon", "zone_id"}, b.stops},
+		{"trips.txt", []string{"route_id", "service_id", "trip_id", "trip_headsign", "direction_id", "shape_id"}, b.trips},
+		{"stop_times.txt", []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence"}, b.stopTimes},
+		{"shapes.txt", []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"}, b.shapes},
+		{"calendar.txt", []string{"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"}, b.calendars},
+		{"calendar_dates.txt", []string{"service_id", "date", "exception_type"}, b.calendarDates},
+	}
+	for _, f := range files {
+		if len(f.rows) == 0 {
+			continue
+		}
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.Write(f.header); err != nil {
+			return nil, err
+		}
+		if err := cw.WriteAll(f.rows); err != nil {
+			return nil, fmt.Errorf("write %s: %w", f.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FakeGTFSServer serves a GTFS zip that the test can replace, as the GTFS
+// ingestor downloads it.
+type FakeGTFSServer struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	data     []byte
+	modified time.Time
+}
+
+// NewFakeGTFSServer starts a server for the feed, closed when the test ends.
+func NewFakeGTFSServer(t testing.TB, feed *GTFSBuilder) *FakeGTFSServer {
+	t.Helper()
+	f := &FakeGTFSServer{}
+	f.SetFeed(t, feed)
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL is the address to set as GTFS_URL.
+func (f *FakeGTFSServer) URL() string {
+	return f.server.URL + "/gtfs.zip"
+}
+
+// SetFeed replaces the served feed.
+func (f *FakeGTFSServer) SetFeed(t testing.TB, feed *GTFSBuilder) {
+	t.Helper()
+	data, err := feed.Zip()
+	if err != nil {
+		t.Fatalf("build GTFS zip: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = data
+	// Last-Modified has second precision; keep it moving so the
+	// downloader's If-Modified-Since never hides a new feed.
+	modified := time.Now().Truncate(time.Second)
+	if !modified.After(f.modified) {
+		modified = f.modified.Add(time.Second)
+	}
+	f.modified = modified
+}
+
+func (f *FakeGTFSServer) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	data, modified := f.data, f.modified
+	f.mu.Unlock()
+	http.ServeContent(w, r, "gtfs.zip", modified, bytes.NewReader(data))
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
+
+func formatGTFSTime(d time.Duration) string {
+	s := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
@@ -0,0 +1,224 @@
+// Package testsupport runs the whole server in-process against fake
+// upstreams, for end-to-end tests of HTTP, WebSocket and GTFS behavior.
+package testsupport
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"wabus/internal/config"
+	"wabus/internal/server"
+)
+
+// Options configure StartServer.
+type Options struct {
+	// GTFS is served as the schedule feed; nil disables GTFS.
+	GTFS *GTFSBuilder
+	// Env overrides configuration variables, e.g. "ADMIN_TOKEN".
+	Env map[string]string
+	// Logger defaults to one discarding everything.
+	Logger *slog.Logger
+}
+
+// TestServer is a running server with its fake upstreams.
+type TestServer struct {
+	*server.Server
+	HTTP      *httptest.Server
+	WarsawAPI *FakeWarsawAPI
+	GTFSFeed  *FakeGTFSServer // nil without Options.GTFS
+
+	t testing.TB
+}
+
+// StartServer configures the server from the environment like production,
+// pointing it at fake upstreams, and serves it on a local port. Everything
+// is stopped when the test ends. Tests using it can't run in parallel, as
+// configuration is read from the environment.
+func StartServer(t testing.TB, opts Options) *TestServer {
+	t.Helper()
+
+	ts := &TestServer{t: t, WarsawAPI: NewFakeWarsawAPI(t)}
+	alerts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		io.WriteString(w, `<?xml version="1.0"?><rss version="2.0"><channel></channel></rss>`)
+	}))
+	t.Cleanup(alerts.Close)
+
+	env := map[string]string{
+		"WARSAW_API_KEY":        "test",
+		"WARSAW_API_URL":        ts.WarsawAPI.URL(),
+		"POLL_INTERVAL":         "100ms",
+		"GTFS_ENABLED":          "false",
+		"GTFS_CACHE_DIR":        t.TempDir(),
+		"SNAPSHOT_DIR":          t.TempDir(),
+		"ALERTS_FEED_URL":       alerts.URL,
+		"PREDICTION_INTERVAL":   "100ms",
+		"RATE_LIMIT_PER_WINDOW": "1000000",
+	}
+	if opts.GTFS != nil {
+		ts.GTFSFeed = NewFakeGTFSServer(t, opts.GTFS)
+		env["GTFS_ENABLED"] = "true"
+		env["GTFS_URL"] = ts.GTFSFeed.URL()
+	}
+	for k, v := range opts.Env {
+		env[k] = v
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	srv, err := server.New(cfg, logger)
+	if err != nil {
+		t.Fatalf("set up server: %v", err)
+	}
+	ts.Server = srv
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.Start(ctx)
+	ts.HTTP = httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		cancel()
+		ts.HTTP.Close()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		srv.Shutdown(shutdownCtx)
+	})
+	return ts
+}
+
+// URL returns the absolute URL of a server path such as "/v1/vehicles".
+func (ts *TestServer) URL(path string) string {
+	return ts.HTTP.URL + path
+}
+
+// Get requests path and returns the response, failing the test on
+// transport errors. The caller closes the body.
+func (ts *TestServer) Get(path string) *http.Response {
+	ts.t.Helper()
+	resp, err := ts.HTTP.Client().Get(ts.URL(path))
+	if err != nil {
+		ts.t.Fatalf("GET %s: %v", path, err)
+	}
+	return resp
+}
+
+// GetJSON requests path, fails the test unless the status is want, and
+// decodes the body into v (unless v is nil).
+func (ts *TestServer) GetJSON(path string, want int, v interface{}) {
+	ts.t.Helper()
+	resp := ts.Get(path)
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		body, _ := io.ReadAll(resp.Body)
+		ts.t.Fatalf("GET %s: status %d, want %d: %s", path, resp.StatusCode, want, body)
+	}
+	if v == nil {
+		return
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		ts.t.Fatalf("GET %s: decode: %v", path, err)
+	}
+}
+
+// WaitFor polls cond until it holds, failing the test after timeout.
+func (ts *TestServer) WaitFor(what string, timeout time.Duration, cond func() bool) {
+	ts.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			ts.t.Fatalf("timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// WaitGTFSLoaded waits for the GTFS feed to be loaded into the store.
+func (ts *TestServer) WaitGTFSLoaded() {
+	ts.t.Helper()
+	ts.WaitFor("GTFS to load", 10*time.Second, func() bool { return ts.GTFSStore().GetStats().IsLoaded })
+}
+
+// WaitVehicles waits for the store to hold n vehicles.
+func (ts *TestServer) WaitVehicles(n int) {
+	ts.t.Helper()
+	ts.WaitFor("vehicles to be ingested", 5*time.Second, func() bool { return ts.VehicleStore().Count() == n })
+}
+
+// WSClient is a WebSocket connection to the test server.
+type WSClient struct {
+	conn *websocket.Conn
+	t    testing.TB
+}
+
+// DialWS opens a WebSocket connection to /v1/ws, closed when the test ends.
+func (ts *TestServer) DialWS() *WSClient {
+	ts.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL("/v1/ws"), "http"), nil)
+	if err != nil {
+		ts.t.Fatalf("dial WebSocket: %v", err)
+	}
+	conn.SetReadLimit(-1)
+	ts.t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+	return &WSClient{conn: conn, t: ts.t}
+}
+
+// Send writes a client message with the given type and payload.
+func (c *WSClient) Send(msgType string, payload interface{}) {
+	c.t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"type": msgType, "payload": payload})
+	if err != nil {
+		c.t.Fatalf("encode %s message: %v", msgType, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.conn.Write(ctx, websocket.MessageText, data); err != nil {
+		c.t.Fatalf("send %s message: %v", msgType, err)
+	}
+}
+
+// WSMessage is a server message with its payload left encoded.
+type WSMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Expect reads messages until one of type msgType arrives, failing the test
+// after timeout. Messages of other types are skipped.
+func (c *WSClient) Expect(msgType string, timeout time.Duration) WSMessage {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		_, data, err := c.conn.Read(ctx)
+		if err != nil {
+			c.t.Fatalf("waiting for %s message: %v", msgType, err)
+		}
+		var msg WSMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.t.Fatalf("decode message %s: %v", data, err)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
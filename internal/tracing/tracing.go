@@ -0,0 +1,73 @@
+// Package tracing wires OpenTelemetry into the server: the OTLP/HTTP
+// exporter, ratio sampling and W3C traceparent propagation. Instrumented
+// code starts spans with Tracer, which records nothing until Setup ran.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config configures span export.
+type Config struct {
+	// Endpoint is the collector's base URL; spans go to Endpoint/v1/traces.
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of new traces recorded, 0-1. Traces started
+	// upstream follow the sampled flag of their traceparent.
+	SampleRatio float64
+	Headers     map[string]string
+}
+
+// Setup installs a tracer provider exporting spans in batches to the
+// collector, and traceparent propagation. Shutting the provider down
+// flushes the spans not exported yet.
+func Setup(cfg Config) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+"/v1/traces"),
+		otlptracehttp.WithHeaders(cfg.Headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider, nil
+}
+
+// Tracer is what the server's packages start spans with.
+func Tracer() trace.Tracer {
+	return otel.Tracer("wabus")
+}
+
+// StartChild starts a span for operations only worth recording as part of
+// a larger one, like cache lookups: outside a trace it records nothing.
+func StartChild(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// SetError marks span failed with err. A nil err is ignored.
+func SetError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestSetupExportsToCollector(t *testing.T) {
+	var posts atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("X-Api-Key"); got != "secret" {
+			t.Errorf("got X-Api-Key %q, want the configured header", got)
+		}
+		posts.Add(1)
+	}))
+	defer collector.Close()
+
+	prev, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	provider, err := Setup(Config{
+		Endpoint:    collector.URL + "/",
+		ServiceName: "wabus-test",
+		SampleRatio: 1,
+		Headers:     map[string]string{"X-Api-Key": "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, span := Tracer().Start(context.Background(), "poll")
+	header := http.Header{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+	if header.Get("traceparent") == "" {
+		t.Error("no traceparent injected for a sampled span")
+	}
+	_, child := StartChild(ctx, "redis get")
+	if !child.SpanContext().IsValid() {
+		t.Error("StartChild recorded nothing within a trace")
+	}
+	child.End()
+	span.End()
+	if _, orphan := StartChild(context.Background(), "redis get"); orphan.SpanContext().IsValid() {
+		t.Error("StartChild recorded a span outside a trace")
+	}
+
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if posts.Load() == 0 {
+		t.Error("no spans posted to the collector")
+	}
+}
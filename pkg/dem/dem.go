@@ -0,0 +1,136 @@
+// Package dem looks up terrain elevation in SRTM-style height tiles: .hgt
+// files of big-endian int16 meters covering one degree each, named after
+// their south-west corner (N52E021.hgt), at 1 or 3 arc-second resolution.
+package dem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// void marks samples without data.
+const void = -32768
+
+// DEM is a set of height tiles loaded into memory.
+type DEM struct {
+	tiles map[[2]int]*tile
+}
+
+type tile struct {
+	size    int // samples per row and column, the edges shared with neighbours
+	samples []int16
+}
+
+// Load reads every .hgt file in dir.
+func Load(dir string) (*DEM, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.hgt"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .hgt files in %s", dir)
+	}
+	d := &DEM{tiles: make(map[[2]int]*tile, len(paths))}
+	for _, path := range paths {
+		lat, lon, err := parseTileName(filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		t, err := readTile(path)
+		if err != nil {
+			return nil, err
+		}
+		d.tiles[[2]int{lat, lon}] = t
+	}
+	return d, nil
+}
+
+// Tiles returns the number of tiles loaded.
+func (d *DEM) Tiles() int {
+	return len(d.tiles)
+}
+
+// Elevation returns the elevation in meters at a point, interpolated
+// between the four surrounding samples. It reports false outside the
+// loaded tiles or next to a void.
+func (d *DEM) Elevation(lat, lon float64) (float64, bool) {
+	south, west := math.Floor(lat), math.Floor(lon)
+	t, ok := d.tiles[[2]int{int(south), int(west)}]
+	if !ok {
+		return 0, false
+	}
+	cells := float64(t.size - 1)
+	// Rows run from north to south.
+	y := (south + 1 - lat) * cells
+	x := (lon - west) * cells
+	row, col := int(y), int(x)
+	if row >= t.size-1 {
+		row = t.size - 2
+	}
+	if col >= t.size-1 {
+		col = t.size - 2
+	}
+	fy, fx := y-float64(row), x-float64(col)
+
+	var h [4]float64
+	for i, rc := range [4][2]int{{row, col}, {row, col + 1}, {row + 1, col}, {row + 1, col + 1}} {
+		v := t.samples[rc[0]*t.size+rc[1]]
+		if v == void {
+			return 0, false
+		}
+		h[i] = float64(v)
+	}
+	top := h[0]*(1-fx) + h[1]*fx
+	bottom := h[2]*(1-fx) + h[3]*fx
+	return top*(1-fy) + bottom*fy, true
+}
+
+// parseTileName returns the south-west corner of a tile named like
+// N52E021.hgt.
+func parseTileName(name string) (lat, lon int, err error) {
+	base := strings.ToUpper(strings.TrimSuffix(name, filepath.Ext(name)))
+	var ns, ew byte
+	if _, err := fmt.Sscanf(base, "%c%2d%c%3d", &ns, &lat, &ew, &lon); err != nil || len(base) != 7 {
+		return 0, 0, fmt.Errorf("invalid tile name %s", name)
+	}
+	switch ns {
+	case 'N':
+	case 'S':
+		lat = -lat
+	default:
+		return 0, 0, fmt.Errorf("invalid tile name %s", name)
+	}
+	switch ew {
+	case 'E':
+	case 'W':
+		lon = -lon
+	default:
+		return 0, 0, fmt.Errorf("invalid tile name %s", name)
+	}
+	return lat, lon, nil
+}
+
+func readTile(path string) (*tile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var size int
+	switch len(data) {
+	case 3601 * 3601 * 2:
+		size = 3601
+	case 1201 * 1201 * 2:
+		size = 1201
+	default:
+		return nil, fmt.Errorf("%s: unexpected size %d bytes", filepath.Base(path), len(data))
+	}
+	samples := make([]int16, size*size)
+	for i := range samples {
+		samples[i] = int16(binary.BigEndian.Uint16(data[2*i:]))
+	}
+	return &tile{size: size, samples: samples}, nil
+}
@@ -0,0 +1,242 @@
+// Package gbfs reads shared mobility systems (bike share, scooters) published
+// in the General Bikeshare Feed Specification, version 2.x, such as
+// Veturilo's.
+package gbfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Station is a docking station with its current availability.
+type Station struct {
+	ID                string
+	Name              string
+	Lat, Lon          float64
+	Capacity          int
+	VehiclesAvailable int
+	DocksAvailable    int
+	IsRenting         bool
+	IsReturning       bool
+	LastReported      time.Time
+}
+
+// Vehicle is a vehicle parked outside a station (a free-floating scooter
+// or a bike left off-dock).
+type Vehicle struct {
+	ID          string
+	Lat, Lon    float64
+	TypeID      string
+	IsReserved  bool
+	IsDisabled  bool
+	RangeMeters float64
+}
+
+// Feed is the state of one system at one fetch.
+type Feed struct {
+	SystemID   string
+	SystemName string
+	Stations   []Station
+	Vehicles   []Vehicle
+}
+
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// New creates a client for the system whose auto-discovery file (gbfs.json)
+// is at url.
+func New(url string) *Client {
+	return &Client{
+		url:        url,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// envelope is the wrapper every GBFS file shares.
+type envelope[T any] struct {
+	Data T `json:"data"`
+}
+
+type discoveryFeed struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type systemInformation struct {
+	SystemID string `json:"system_id"`
+	Name     string `json:"name"`
+}
+
+type stationInformation struct {
+	Stations []struct {
+		ID       string  `json:"station_id"`
+		Name     string  `json:"name"`
+		Lat      float64 `json:"lat"`
+		Lon      float64 `json:"lon"`
+		Capacity int     `json:"capacity"`
+	} `json:"stations"`
+}
+
+type stationStatus struct {
+	Stations []struct {
+		ID string `json:"station_id"`
+		// num_bikes_available predates vehicles other than bikes and
+		// counts every vehicle type in 2.x.
+		VehiclesAvailable int   `json:"num_bikes_available"`
+		DocksAvailable    int   `json:"num_docks_available"`
+		IsRenting         *bool `json:"is_renting"`
+		IsReturning       *bool `json:"is_returning"`
+		LastReported      int64 `json:"last_reported"`
+	} `json:"stations"`
+}
+
+type freeBikeStatus struct {
+	Bikes []struct {
+		ID          string  `json:"bike_id"`
+		Lat         float64 `json:"lat"`
+		Lon         float64 `json:"lon"`
+		TypeID      string  `json:"vehicle_type_id"`
+		IsReserved  bool    `json:"is_reserved"`
+		IsDisabled  bool    `json:"is_disabled"`
+		RangeMeters float64 `json:"current_range_meters"`
+	} `json:"bikes"`
+}
+
+// Fetch reads the discovery file and the system, station and free vehicle
+// files it lists. Systems without stations or without free vehicles are
+// fine; a file listed but failing to load fails the fetch.
+func (c *Client) Fetch(ctx context.Context) (*Feed, error) {
+	var discovery envelope[map[string]struct {
+		Feeds []discoveryFeed `json:"feeds"`
+	}]
+	if err := c.get(ctx, c.url, &discovery); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	feeds := make(map[string]string)
+	for _, lang := range preferredLanguages(discovery.Data) {
+		for _, f := range discovery.Data[lang].Feeds {
+			if _, ok := feeds[f.Name]; !ok {
+				feeds[f.Name] = f.URL
+			}
+		}
+	}
+	if len(feeds) == 0 {
+		return nil, fmt.Errorf("discovery lists no feeds")
+	}
+
+	feed := &Feed{}
+
+	if url, ok := feeds["system_information"]; ok {
+		var info envelope[systemInformation]
+		if err := c.get(ctx, url, &info); err != nil {
+			return nil, fmt.Errorf("system_information: %w", err)
+		}
+		feed.SystemID, feed.SystemName = info.Data.SystemID, info.Data.Name
+	}
+
+	if url, ok := feeds["station_information"]; ok {
+		var info envelope[stationInformation]
+		if err := c.get(ctx, url, &info); err != nil {
+			return nil, fmt.Errorf("station_information: %w", err)
+		}
+		var status envelope[stationStatus]
+		if url, ok := feeds["station_status"]; ok {
+			if err := c.get(ctx, url, &status); err != nil {
+				return nil, fmt.Errorf("station_status: %w", err)
+			}
+		}
+		byID := make(map[string]int, len(status.Data.Stations))
+		for i, s := range status.Data.Stations {
+			byID[s.ID] = i
+		}
+		feed.Stations = make([]Station, 0, len(info.Data.Stations))
+		for _, s := range info.Data.Stations {
+			station := Station{ID: s.ID, Name: s.Name, Lat: s.Lat, Lon: s.Lon, Capacity: s.Capacity}
+			if i, ok := byID[s.ID]; ok {
+				st := status.Data.Stations[i]
+				station.VehiclesAvailable = st.VehiclesAvailable
+				station.DocksAvailable = st.DocksAvailable
+				station.IsRenting = st.IsRenting == nil || *st.IsRenting
+				station.IsReturning = st.IsReturning == nil || *st.IsReturning
+				if st.LastReported > 0 {
+					station.LastReported = time.Unix(st.LastReported, 0)
+				}
+			}
+			feed.Stations = append(feed.Stations, station)
+		}
+	}
+
+	if url, ok := feeds["free_bike_status"]; ok {
+		var status envelope[freeBikeStatus]
+		if err := c.get(ctx, url, &status); err != nil {
+			return nil, fmt.Errorf("free_bike_status: %w", err)
+		}
+		feed.Vehicles = make([]Vehicle, 0, len(status.Data.Bikes))
+		for _, b := range status.Data.Bikes {
+			feed.Vehicles = append(feed.Vehicles, Vehicle{
+				ID:          b.ID,
+				Lat:         b.Lat,
+				Lon:         b.Lon,
+				TypeID:      b.TypeID,
+				IsReserved:  b.IsReserved,
+				IsDisabled:  b.IsDisabled,
+				RangeMeters: b.RangeMeters,
+			})
+		}
+	}
+	return feed, nil
+}
+
+// preferredLanguages orders the languages of a discovery file: Polish,
+// then English, then the rest alphabetically. Feeds are taken from the
+// first language listing them.
+func preferredLanguages[T any](byLang map[string]T) []string {
+	rank := func(lang string) int {
+		switch lang {
+		case "pl":
+			return 0
+		case "en":
+			return 1
+		}
+		return 2
+	}
+	langs := make([]string, 0, len(byLang))
+	for lang := range byLang {
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if ri, rj := rank(langs[i]), rank(langs[j]); ri != rj {
+			return ri < rj
+		}
+		return langs[i] < langs[j]
+	})
+	return langs
+}
+
+func (c *Client) get(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s: %w", url, err)
+	}
+	return nil
+}
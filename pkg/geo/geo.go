@@ -0,0 +1,63 @@
+package geo
+
+import "math"
+
+const earthRadiusMeters = 6371000.0
+
+// Distance returns the great-circle distance in meters between two points.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	lat1Rad := lat1 * math.Pi / 180
+	lat2Rad := lat2 * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1Rad)*math.Cos(lat2Rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Bearing returns the initial bearing in degrees [0, 360) from point 1 to point 2.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	lat1Rad := lat1 * math.Pi / 180
+	lat2Rad := lat2 * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	y := math.Sin(dLon) * math.Cos(lat2Rad)
+	x := math.Cos(lat1Rad)*math.Sin(lat2Rad) - math.Sin(lat1Rad)*math.Cos(lat2Rad)*math.Cos(dLon)
+	deg := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(deg+360, 360)
+}
+
+// AngleDiff returns the absolute difference between two bearings in degrees [0, 180].
+func AngleDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+// ProjectToSegment projects a point onto the segment a-b using a local
+// equirectangular approximation (accurate at city scale). It returns the
+// fraction t in [0, 1] along the segment and the distance in meters from the
+// point to its projection.
+func ProjectToSegment(lat, lon, aLat, aLon, bLat, bLon float64) (t, dist float64) {
+	cosLat := math.Cos(lat * math.Pi / 180)
+	ax, ay := (aLon-lon)*cosLat, aLat-lat
+	bx, by := (bLon-lon)*cosLat, bLat-lat
+
+	dx, dy := bx-ax, by-ay
+	lenSq := dx*dx + dy*dy
+	if lenSq > 0 {
+		t = -(ax*dx + ay*dy) / lenSq
+		t = math.Max(0, math.Min(1, t))
+	}
+
+	px, py := ax+t*dx, ay+t*dy
+	dist = math.Sqrt(px*px+py*py) * math.Pi / 180 * earthRadiusMeters
+	return t, dist
+}
+
+// Interpolate returns the point at fraction t along the segment a-b.
+func Interpolate(aLat, aLon, bLat, bLon, t float64) (lat, lon float64) {
+	return aLat + (bLat-aLat)*t, aLon + (bLon-aLon)*t
+}
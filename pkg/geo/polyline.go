@@ -0,0 +1,33 @@
+package geo
+
+import (
+	"math"
+	"strings"
+)
+
+// EncodePolyline encodes lat/lon pairs in the Google encoded polyline format
+// at 5 decimal digits (about a meter), as understood by most map libraries.
+func EncodePolyline(points [][2]float64) string {
+	var b strings.Builder
+	var prevLat, prevLon int64
+	for _, p := range points {
+		lat := int64(math.Round(p[0] * 1e5))
+		lon := int64(math.Round(p[1] * 1e5))
+		encodePolylineValue(&b, lat-prevLat)
+		encodePolylineValue(&b, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return b.String()
+}
+
+func encodePolylineValue(b *strings.Builder, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|u&0x1f) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
@@ -0,0 +1,149 @@
+package gtfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ArchiveEntry describes one archived dataset. Its parsed result lives in the
+// parse cache under Fingerprint.
+type ArchiveEntry struct {
+	Fingerprint string    `json:"fingerprint"`
+	LoadedAt    time.Time `json:"loaded_at"`
+	ValidFrom   string    `json:"valid_from,omitempty"` // YYYYMMDD, earliest calendar start
+	ValidTo     string    `json:"valid_to,omitempty"`   // YYYYMMDD, latest calendar end
+}
+
+// Archive keeps the last few parsed datasets in the parse cache directory
+// and records when each was loaded, so past timetables can be queried. Parsed
+// results of datasets dropped from the archive are deleted.
+type Archive struct {
+	mu      sync.Mutex
+	dir     string
+	keep    int
+	entries []ArchiveEntry // oldest first
+}
+
+// OpenArchive loads the archive manifest from dir, keeping at most keep datasets.
+func OpenArchive(dir string, keep int) (*Archive, error) {
+	a := &Archive{dir: dir, keep: keep}
+
+	data, err := os.ReadFile(a.manifestPath())
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read archive manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.entries); err != nil {
+		return nil, fmt.Errorf("decode archive manifest: %w", err)
+	}
+	return a, nil
+}
+
+func (a *Archive) manifestPath() string {
+	return filepath.Join(a.dir, "gtfs_archive.json")
+}
+
+// Record adds a dataset to the archive unless it is already the newest entry,
+// then prunes the oldest datasets beyond the retention limit.
+func (a *Archive) Record(fingerprint string, result *ParseResult, loadedAt time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n := len(a.entries); n > 0 && a.entries[n-1].Fingerprint == fingerprint {
+		return nil
+	}
+
+	entry := ArchiveEntry{Fingerprint: fingerprint, LoadedAt: loadedAt}
+	for _, cal := range result.Calendars {
+		if entry.ValidFrom == "" || cal.StartDate < entry.ValidFrom {
+			entry.ValidFrom = cal.StartDate
+		}
+		if cal.EndDate > entry.ValidTo {
+			entry.ValidTo = cal.EndDate
+		}
+	}
+	a.entries = append(a.entries, entry)
+
+	for a.keep > 0 && len(a.entries) > a.keep {
+		dropped := a.entries[0]
+		a.entries = a.entries[1:]
+		if !a.containsLocked(dropped.Fingerprint) {
+			if err := os.Remove(parsedCachePath(a.dir, dropped.Fingerprint)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove archived dataset: %w", err)
+			}
+		}
+	}
+
+	return a.saveLocked()
+}
+
+func (a *Archive) containsLocked(fingerprint string) bool {
+	for _, e := range a.entries {
+		if e.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Archive) saveLocked() error {
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(a.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := a.manifestPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.manifestPath())
+}
+
+// Entries returns the archived datasets, oldest first.
+func (a *Archive) Entries() []ArchiveEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ArchiveEntry(nil), a.entries...)
+}
+
+// Resolve returns the dataset that was in use on the given service day: the
+// last one loaded on or before that day. For days before the first archived
+// load, the oldest dataset is used if its calendar covers the day.
+func (a *Archive) Resolve(day time.Time) (ArchiveEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.entries) == 0 {
+		return ArchiveEntry{}, false
+	}
+
+	dayEnd := ServiceDay(day).AddDate(0, 0, 1)
+	i := sort.Search(len(a.entries), func(i int) bool {
+		return !a.entries[i].LoadedAt.Before(dayEnd)
+	})
+	if i > 0 {
+		return a.entries[i-1], true
+	}
+
+	oldest := a.entries[0]
+	date := day.In(feedLocation).Format("20060102")
+	if oldest.ValidFrom != "" && date >= oldest.ValidFrom && date <= oldest.ValidTo {
+		return oldest, true
+	}
+	return ArchiveEntry{}, false
+}
+
+// Load reads an archived dataset's parsed result.
+func (a *Archive) Load(entry ArchiveEntry) (*ParseResult, error) {
+	result, _, err := LoadParsedResult(a.dir, entry.Fingerprint)
+	return result, err
+}
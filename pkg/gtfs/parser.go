@@ -28,6 +28,7 @@ type ParseResult struct {
 	Calendars       map[string]*domain.Calendar         // service_id -> Calendar
 	CalendarDates   map[string][]*domain.CalendarDate   // service_id -> []CalendarDate
 	ShapeDirections map[string]int                      // shape_id -> direction_id
+	Report          FeedReport                          // optional files missing and what replaced them
 
 	tripIndex map[string]uint32 // trip_id -> index in Trips (parse-only)
 }
@@ -172,6 +173,32 @@ func (p *Parser) Parse(reader *zip.Reader) (*ParseResult, error) {
 		)
 	}
 
+	for _, name := range optionalFiles {
+		if _, ok := fileMap[name]; !ok {
+			result.Report.MissingFiles = append(result.Report.MissingFiles, name)
+		}
+	}
+	if _, ok := fileMap["shapes.txt"]; !ok {
+		result.Report.SynthesizedShapes = p.synthesizeShapes(result)
+		p.logger.Warn("shapes.txt missing, synthesized shapes from stop sequences",
+			"shapes", result.Report.SynthesizedShapes,
+		)
+	} else {
+		result.Report.ReversedShapes, result.Report.BackwardShapes = p.fixShapeDirections(result)
+		if result.Report.ReversedShapes > 0 || len(result.Report.BackwardShapes) > 0 {
+			p.logger.Warn("shapes running against the stop order",
+				"reversed", result.Report.ReversedShapes,
+				"left_alone", result.Report.BackwardShapes,
+			)
+		}
+	}
+	if _, ok := fileMap["calendar.txt"]; !ok {
+		result.Report.CalendarsFromDates = p.calendarsFromDates(result)
+		p.logger.Warn("calendar.txt missing, derived calendars from calendar_dates.txt",
+			"services", result.Report.CalendarsFromDates,
+		)
+	}
+
 	start := time.Now()
 	p.logger.Debug("building stop lines index")
 	p.buildStopLines(result)
@@ -705,29 +732,37 @@ func (p *Parser) buildTripTimeRanges(result *ParseResult) {
 
 	minTime := make([]int, tripCount)
 	maxTime := make([]int, tripCount)
+	firstStop := make([]string, tripCount)
+	lastStop := make([]string, tripCount)
 	seen := make([]bool, tripCount)
 
-	for _, stopTimes := range result.StopSchedules {
+	for stopID, stopTimes := range result.StopSchedules {
 		for _, st := range stopTimes {
 			tripIdx := int(st.TripIndex)
 			if tripIdx < 0 || tripIdx >= tripCount {
 				continue
 			}
 
-			dep := int(st.DepartureSeconds / 60)
-			arr := int(st.ArrivalSeconds / 60)
+			// Compare in seconds so the first and last stops aren't picked
+			// among stops sharing a minute.
+			dep := int(st.DepartureSeconds)
+			arr := int(st.ArrivalSeconds)
 
 			if seen[tripIdx] {
 				if dep < minTime[tripIdx] {
 					minTime[tripIdx] = dep
+					firstStop[tripIdx] = stopID
 				}
 				if arr > maxTime[tripIdx] {
 					maxTime[tripIdx] = arr
+					lastStop[tripIdx] = stopID
 				}
 			} else {
 				seen[tripIdx] = true
 				minTime[tripIdx] = dep
 				maxTime[tripIdx] = arr
+				firstStop[tripIdx] = stopID
+				lastStop[tripIdx] = stopID
 			}
 		}
 	}
@@ -739,11 +774,15 @@ func (p *Parser) buildTripTimeRanges(result *ParseResult) {
 		}
 
 		entry := &domain.TripTimeEntry{
+			TripID:       trip.ID,
+			Headsign:     trip.Headsign,
 			ShapeID:      trip.ShapeID,
 			ServiceID:    trip.ServiceID,
 			DirectionID:  trip.DirectionID,
-			StartMinutes: minTime[idx],
-			EndMinutes:   maxTime[idx],
+			StartMinutes: minTime[idx] / 60,
+			EndMinutes:   maxTime[idx] / 60,
+			StartStopID:  firstStop[idx],
+			EndStopID:    lastStop[idx],
 		}
 
 		result.RouteTripTimes[trip.RouteID] = append(result.RouteTripTimes[trip.RouteID], entry)
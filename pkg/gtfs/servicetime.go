@@ -0,0 +1,45 @@
+package gtfs
+
+import (
+	"time"
+	_ "time/tzdata" // containers may ship without a zoneinfo database
+)
+
+// FeedTimezone is the agency timezone of the Warsaw GTFS feed.
+const FeedTimezone = "Europe/Warsaw"
+
+var feedLocation = loadFeedLocation()
+
+func loadFeedLocation() *time.Location {
+	loc, err := time.LoadLocation(FeedTimezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Location returns the feed timezone all GTFS times are expressed in.
+func Location() *time.Location {
+	return feedLocation
+}
+
+// ServiceDay returns the calendar date of t in the feed timezone, at midnight.
+func ServiceDay(t time.Time) time.Time {
+	t = t.In(feedLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, feedLocation)
+}
+
+// ServiceDayStart returns the reference instant GTFS stop times of a service
+// day are measured from: noon minus 12 hours. On DST transition days this is
+// an hour away from midnight, which keeps 23h/25h days consistent.
+func ServiceDayStart(day time.Time) time.Time {
+	day = day.In(feedLocation)
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, feedLocation)
+	return noon.Add(-12 * time.Hour)
+}
+
+// ServiceTime converts a GTFS time (seconds since ServiceDayStart, may exceed
+// 24h) on the given service day into an absolute instant.
+func ServiceTime(day time.Time, seconds uint32) time.Time {
+	return ServiceDayStart(day).Add(time.Duration(seconds) * time.Second)
+}
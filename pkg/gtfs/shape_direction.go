@@ -0,0 +1,122 @@
+package gtfs
+
+import (
+	"slices"
+	"sort"
+
+	"wabus/internal/domain"
+	"wabus/pkg/geo"
+)
+
+// shapeDirectionMargin is how much closer (meters, summed over both ends)
+// a shape's ends must be to a trip's last and first stops than to its first
+// and last for the shape to count as running backwards, or the other way
+// round for forwards. Loops and shapes of partial trips fall in between.
+const shapeDirectionMargin = 200.0
+
+// Verdicts of shapeDirection.
+const (
+	shapeUnknown = iota
+	shapeForward
+	shapeBackward
+)
+
+// fixShapeDirections reverses the points of shapes running from the last
+// stop of their trips to the first, a recurring defect of the ZTM feed, so
+// matching and snapping see them in travel order. Each shape is judged by
+// the first and last stops of one trip per direction using it; shapes
+// running backwards for some trips and forwards for others are left alone
+// and returned, sorted, with the number reversed.
+func (p *Parser) fixShapeDirections(result *ParseResult) (reversed int, conflicting []string) {
+	type key struct {
+		shapeID     string
+		directionID int
+	}
+	type ends struct {
+		firstSeq, lastSeq uint16
+		first, last       string
+	}
+	sample := make(map[uint32]*ends)
+	samples := make(map[key]uint32)
+	for idx, trip := range result.Trips {
+		if trip.ShapeID == "" {
+			continue
+		}
+		k := key{trip.ShapeID, trip.DirectionID}
+		if _, ok := samples[k]; !ok {
+			samples[k] = uint32(idx)
+			sample[uint32(idx)] = &ends{}
+		}
+	}
+	for stopID, stopTimes := range result.StopSchedules {
+		for _, st := range stopTimes {
+			e, ok := sample[st.TripIndex]
+			if !ok {
+				continue
+			}
+			if e.first == "" || st.StopSequence < e.firstSeq {
+				e.first, e.firstSeq = stopID, st.StopSequence
+			}
+			if e.last == "" || st.StopSequence > e.lastSeq {
+				e.last, e.lastSeq = stopID, st.StopSequence
+			}
+		}
+	}
+
+	verdicts := make(map[string][]int)
+	for k, idx := range samples {
+		shape, ok := result.Shapes[k.shapeID]
+		e := sample[idx]
+		if !ok || e.first == e.last {
+			continue
+		}
+		first, okFirst := result.Stops[e.first]
+		last, okLast := result.Stops[e.last]
+		if !okFirst || !okLast {
+			continue
+		}
+		verdicts[k.shapeID] = append(verdicts[k.shapeID], shapeDirection(shape, first, last))
+	}
+
+	for shapeID, vs := range verdicts {
+		backward := slices.Contains(vs, shapeBackward)
+		switch {
+		case !backward:
+		case slices.Contains(vs, shapeForward):
+			conflicting = append(conflicting, shapeID)
+		default:
+			reverseShape(result.Shapes[shapeID])
+			reversed++
+		}
+	}
+	sort.Strings(conflicting)
+	return reversed, conflicting
+}
+
+// shapeDirection compares the ends of shape with a trip's first and last
+// stops.
+func shapeDirection(shape *domain.Shape, first, last *domain.Stop) int {
+	if len(shape.Points) < 2 {
+		return shapeUnknown
+	}
+	start, end := shape.Points[0], shape.Points[len(shape.Points)-1]
+	forward := geo.Distance(first.Lat, first.Lon, start.Lat, start.Lon) + geo.Distance(last.Lat, last.Lon, end.Lat, end.Lon)
+	backward := geo.Distance(first.Lat, first.Lon, end.Lat, end.Lon) + geo.Distance(last.Lat, last.Lon, start.Lat, start.Lon)
+	switch {
+	case backward+shapeDirectionMargin < forward:
+		return shapeBackward
+	case forward+shapeDirectionMargin < backward:
+		return shapeForward
+	default:
+		return shapeUnknown
+	}
+}
+
+// reverseShape puts the points of shape in reverse order, numbering them
+// from 1 again.
+func reverseShape(shape *domain.Shape) {
+	slices.Reverse(shape.Points)
+	for i := range shape.Points {
+		shape.Points[i].Sequence = i + 1
+	}
+}
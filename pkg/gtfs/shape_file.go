@@ -0,0 +1,196 @@
+package gtfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"wabus/internal/domain"
+	"wabus/pkg/geo"
+)
+
+// Shape files hold full-resolution shape points for random access, so only
+// simplified shapes need to stay in memory. Layout: magic, version, the
+// offset of the index, then every shape's points as fixed-size records
+// (lat, lon float64, sequence int32, little endian), then the gob-encoded index.
+const (
+	shapeFileMagic   = "WSHP"
+	shapeFileVersion = 1
+	shapeHeaderSize  = 4 + 4 + 8
+	shapePointSize   = 8 + 8 + 4
+)
+
+type shapeExtent struct {
+	Offset int64
+	Count  int
+}
+
+// ShapeFilePath returns where the shape file of a dataset is kept.
+func ShapeFilePath(cacheDir, fingerprint string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("gtfs_shapes_v%d_%s.bin", shapeFileVersion, fingerprint))
+}
+
+// WriteShapeFile writes the points of all shapes to path.
+func WriteShapeFile(path string, shapes map[string]*domain.Shape) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = writeShapes(f, shapes)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
+}
+
+func writeShapes(f *os.File, shapes map[string]*domain.Shape) error {
+	w := bufio.NewWriter(f)
+	header := make([]byte, shapeHeaderSize)
+	copy(header, shapeFileMagic)
+	binary.LittleEndian.PutUint32(header[4:], shapeFileVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	index := make(map[string]shapeExtent, len(shapes))
+	offset := int64(shapeHeaderSize)
+	record := make([]byte, shapePointSize)
+	for id, shape := range shapes {
+		index[id] = shapeExtent{Offset: offset, Count: len(shape.Points)}
+		for _, p := range shape.Points {
+			binary.LittleEndian.PutUint64(record[0:], math.Float64bits(p.Lat))
+			binary.LittleEndian.PutUint64(record[8:], math.Float64bits(p.Lon))
+			binary.LittleEndian.PutUint32(record[16:], uint32(int32(p.Sequence)))
+			if _, err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		offset += int64(len(shape.Points)) * shapePointSize
+	}
+
+	if err := gob.NewEncoder(w).Encode(index); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint64(header[8:], uint64(offset))
+	_, err := f.WriteAt(header[8:], 8)
+	return err
+}
+
+// ShapeFile reads shape points from a file written by WriteShapeFile.
+type ShapeFile struct {
+	f     *os.File
+	index map[string]shapeExtent
+}
+
+func OpenShapeFile(path string) (*ShapeFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, shapeHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read shape file header: %w", err)
+	}
+	if string(header[:4]) != shapeFileMagic || binary.LittleEndian.Uint32(header[4:]) != shapeFileVersion {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a version %d shape file", path, shapeFileVersion)
+	}
+
+	indexOffset := int64(binary.LittleEndian.Uint64(header[8:]))
+	var index map[string]shapeExtent
+	if err := gob.NewDecoder(io.NewSectionReader(f, indexOffset, math.MaxInt64-indexOffset)).Decode(&index); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read shape file index: %w", err)
+	}
+	return &ShapeFile{f: f, index: index}, nil
+}
+
+// Points reads the full-resolution points of a shape.
+func (s *ShapeFile) Points(id string) ([]domain.ShapePoint, error) {
+	extent, ok := s.index[id]
+	if !ok {
+		return nil, fmt.Errorf("shape %s not in shape file", id)
+	}
+
+	buf := make([]byte, extent.Count*shapePointSize)
+	if _, err := s.f.ReadAt(buf, extent.Offset); err != nil {
+		return nil, err
+	}
+
+	points := make([]domain.ShapePoint, extent.Count)
+	for i := range points {
+		record := buf[i*shapePointSize:]
+		points[i] = domain.ShapePoint{
+			Lat:      math.Float64frombits(binary.LittleEndian.Uint64(record[0:])),
+			Lon:      math.Float64frombits(binary.LittleEndian.Uint64(record[8:])),
+			Sequence: int(int32(binary.LittleEndian.Uint32(record[16:]))),
+		}
+	}
+	return points, nil
+}
+
+func (s *ShapeFile) Close() error {
+	return s.f.Close()
+}
+
+// SimplifyPoints reduces a polyline with the Douglas-Peucker algorithm,
+// keeping every point further than toleranceMeters from the simplified line.
+// The kept points retain their original sequence numbers.
+func SimplifyPoints(points []domain.ShapePoint, toleranceMeters float64) []domain.ShapePoint {
+	if len(points) < 3 || toleranceMeters <= 0 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	type span struct{ from, to int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		sp := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		a, b := points[sp.from], points[sp.to]
+		farthest, farthestDist := -1, toleranceMeters
+		for i := sp.from + 1; i < sp.to; i++ {
+			_, d := geo.ProjectToSegment(points[i].Lat, points[i].Lon, a.Lat, a.Lon, b.Lat, b.Lon)
+			if d > farthestDist {
+				farthest, farthestDist = i, d
+			}
+		}
+		if farthest >= 0 {
+			keep[farthest] = true
+			stack = append(stack, span{sp.from, farthest}, span{farthest, sp.to})
+		}
+	}
+
+	result := make([]domain.ShapePoint, 0, len(points)/4)
+	for i, p := range points {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,124 @@
+package gtfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"wabus/internal/domain"
+)
+
+// FeedReport records the optional files a feed omitted and what the parser
+// derived in their place, and defects of the feed it found.
+type FeedReport struct {
+	MissingFiles []string `json:"missing_files,omitempty"`
+	// SynthesizedShapes are built from the stop sequences of trips, one per
+	// distinct sequence, when shapes.txt is missing. They run straight from
+	// stop to stop.
+	SynthesizedShapes int `json:"synthesized_shapes,omitempty"`
+	// CalendarsFromDates are services defined only by calendar_dates.txt,
+	// given a calendar spanning their dates when calendar.txt is missing.
+	CalendarsFromDates int `json:"calendars_from_dates,omitempty"`
+	// ReversedShapes ran from the last stop of their trips to the first and
+	// were put in stop order.
+	ReversedShapes int `json:"reversed_shapes,omitempty"`
+	// BackwardShapes run against the stop order for some of their trips but
+	// along it for others, so they were left as they are.
+	BackwardShapes []string `json:"backward_shapes,omitempty"`
+}
+
+// HasFindings reports whether the report has anything to say.
+func (r FeedReport) HasFindings() bool {
+	return len(r.MissingFiles) > 0 || r.ReversedShapes > 0 || len(r.BackwardShapes) > 0
+}
+
+// optionalFiles are the files the parser can do without.
+var optionalFiles = []string{"shapes.txt", "calendar.txt"}
+
+// synthesizeShapes gives every trip a shape through its stops, shared by
+// trips calling at the same stops in the same order. Shape references from
+// trips.txt are dropped, as none of them resolve.
+func (p *Parser) synthesizeShapes(result *ParseResult) int {
+	type call struct {
+		seq    uint16
+		stopID string
+	}
+	calls := make([][]call, len(result.Trips))
+	for stopID, stopTimes := range result.StopSchedules {
+		for _, st := range stopTimes {
+			calls[st.TripIndex] = append(calls[st.TripIndex], call{st.StopSequence, stopID})
+		}
+	}
+
+	result.RouteShapes = make(map[string][]string)
+	result.ShapeDirections = make(map[string]int)
+	seenRouteShapes := make(map[string]map[string]bool)
+
+	for idx := range result.Trips {
+		trip := &result.Trips[idx]
+		trip.ShapeID = ""
+
+		tripCalls := calls[idx]
+		sort.Slice(tripCalls, func(i, j int) bool { return tripCalls[i].seq < tripCalls[j].seq })
+		stopIDs := make([]string, 0, len(tripCalls))
+		for _, c := range tripCalls {
+			if _, ok := result.Stops[c.stopID]; ok {
+				stopIDs = append(stopIDs, c.stopID)
+			}
+		}
+		if len(stopIDs) < 2 {
+			continue
+		}
+
+		sum := sha256.Sum256([]byte(strings.Join(stopIDs, "\x00")))
+		shapeID := "synth-" + hex.EncodeToString(sum[:8])
+		if _, ok := result.Shapes[shapeID]; !ok {
+			points := make([]domain.ShapePoint, len(stopIDs))
+			for i, stopID := range stopIDs {
+				stop := result.Stops[stopID]
+				points[i] = domain.ShapePoint{Lat: stop.Lat, Lon: stop.Lon, Sequence: i + 1}
+			}
+			result.Shapes[shapeID] = &domain.Shape{ID: shapeID, Points: points}
+		}
+
+		trip.ShapeID = shapeID
+		result.ShapeDirections[shapeID] = trip.DirectionID
+		if seenRouteShapes[trip.RouteID] == nil {
+			seenRouteShapes[trip.RouteID] = make(map[string]bool)
+		}
+		if !seenRouteShapes[trip.RouteID][shapeID] {
+			seenRouteShapes[trip.RouteID][shapeID] = true
+			result.RouteShapes[trip.RouteID] = append(result.RouteShapes[trip.RouteID], shapeID)
+		}
+	}
+	return len(result.Shapes)
+}
+
+// calendarsFromDates gives every service of calendar_dates.txt a calendar
+// running on no weekday from its first to its last added date, so the
+// service and the dataset's validity are known without calendar.txt. The
+// dates themselves still decide when it runs.
+func (p *Parser) calendarsFromDates(result *ParseResult) int {
+	for serviceID, dates := range result.CalendarDates {
+		if _, ok := result.Calendars[serviceID]; ok {
+			continue
+		}
+		cal := &domain.Calendar{ServiceID: serviceID}
+		for _, cd := range dates {
+			if cd.ExceptionType != 1 {
+				continue
+			}
+			if cal.StartDate == "" || cd.Date < cal.StartDate {
+				cal.StartDate = cd.Date
+			}
+			if cd.Date > cal.EndDate {
+				cal.EndDate = cd.Date
+			}
+		}
+		if cal.StartDate != "" {
+			result.Calendars[serviceID] = cal
+		}
+	}
+	return len(result.Calendars)
+}
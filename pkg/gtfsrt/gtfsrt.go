@@ -0,0 +1,250 @@
+// Package gtfsrt encodes GTFS Realtime feeds. Only the messages the server
+// publishes are modelled; they are written with the protobuf wire format
+// directly, following gtfs-realtime.proto, so no generated code is needed.
+package gtfsrt
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ContentType is the media type of an encoded FeedMessage.
+const ContentType = "application/x-protobuf"
+
+// specVersion is the gtfs_realtime_version announced in the feed header.
+const specVersion = "2.0"
+
+// FeedMessage is a full-dataset GTFS-RT feed.
+type FeedMessage struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Entities  []FeedEntity `json:"entity"`
+}
+
+type FeedEntity struct {
+	ID         string      `json:"id"`
+	TripUpdate *TripUpdate `json:"trip_update,omitempty"`
+	Alert      *Alert      `json:"alert,omitempty"`
+}
+
+type TripUpdate struct {
+	Trip            TripDescriptor     `json:"trip"`
+	Vehicle         *VehicleDescriptor `json:"vehicle,omitempty"`
+	StopTimeUpdates []StopTimeUpdate   `json:"stop_time_update"`
+	Timestamp       time.Time          `json:"timestamp"`
+	// Delay is the current schedule deviation in seconds, when known.
+	Delay *int32 `json:"delay,omitempty"`
+}
+
+type TripDescriptor struct {
+	TripID      string `json:"trip_id"`
+	RouteID     string `json:"route_id,omitempty"`
+	DirectionID *int   `json:"direction_id,omitempty"`
+	StartTime   string `json:"start_time,omitempty"` // HH:MM:SS
+	StartDate   string `json:"start_date,omitempty"` // YYYYMMDD
+}
+
+type VehicleDescriptor struct {
+	ID    string `json:"id"`
+	Label string `json:"label,omitempty"`
+}
+
+type StopTimeUpdate struct {
+	StopSequence int            `json:"stop_sequence"`
+	StopID       string         `json:"stop_id"`
+	Arrival      *StopTimeEvent `json:"arrival,omitempty"`
+	Departure    *StopTimeEvent `json:"departure,omitempty"`
+}
+
+// StopTimeEvent is a predicted arrival or departure and its deviation from
+// the schedule in seconds.
+type StopTimeEvent struct {
+	Delay int32     `json:"delay"`
+	Time  time.Time `json:"time"`
+}
+
+// Alert is a service alert. Texts are single, untranslated strings.
+type Alert struct {
+	ActivePeriods    []TimeRange      `json:"active_period,omitempty"`
+	InformedEntities []EntitySelector `json:"informed_entity"`
+	URL              string           `json:"url,omitempty"`
+	HeaderText       string           `json:"header_text"`
+	DescriptionText  string           `json:"description_text,omitempty"`
+	SeverityLevel    SeverityLevel    `json:"severity_level"`
+}
+
+// TimeRange is an interval; a nil bound leaves that side open.
+type TimeRange struct {
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// EntitySelector names what an alert affects; at least one field is set.
+type EntitySelector struct {
+	RouteID   string `json:"route_id,omitempty"`
+	RouteType *int   `json:"route_type,omitempty"`
+	StopID    string `json:"stop_id,omitempty"`
+}
+
+// SeverityLevel is the severity of an alert, numbered as in the spec.
+type SeverityLevel int
+
+const (
+	SeverityUnknown SeverityLevel = 1
+	SeverityInfo    SeverityLevel = 2
+	SeverityWarning SeverityLevel = 3
+	SeveritySevere  SeverityLevel = 4
+)
+
+// Marshal encodes the feed as a transit_realtime.FeedMessage.
+func (m *FeedMessage) Marshal() []byte {
+	var header []byte
+	header = appendString(header, 1, specVersion)
+	header = appendVarint(header, 2, 0) // FULL_DATASET
+	header = appendVarint(header, 3, uint64(m.Timestamp.Unix()))
+
+	b := appendMessage(nil, 1, header)
+	for i := range m.Entities {
+		b = appendMessage(b, 2, m.Entities[i].marshal())
+	}
+	return b
+}
+
+func (e *FeedEntity) marshal() []byte {
+	b := appendString(nil, 1, e.ID)
+	if e.TripUpdate != nil {
+		b = appendMessage(b, 3, e.TripUpdate.marshal())
+	}
+	if e.Alert != nil {
+		b = appendMessage(b, 5, e.Alert.marshal())
+	}
+	return b
+}
+
+func (u *TripUpdate) marshal() []byte {
+	b := appendMessage(nil, 1, u.Trip.marshal())
+	for i := range u.StopTimeUpdates {
+		b = appendMessage(b, 2, u.StopTimeUpdates[i].marshal())
+	}
+	if u.Vehicle != nil {
+		b = appendMessage(b, 3, u.Vehicle.marshal())
+	}
+	if !u.Timestamp.IsZero() {
+		b = appendVarint(b, 4, uint64(u.Timestamp.Unix()))
+	}
+	if u.Delay != nil {
+		b = appendVarint(b, 5, uint64(int64(*u.Delay)))
+	}
+	return b
+}
+
+func (t *TripDescriptor) marshal() []byte {
+	b := appendString(nil, 1, t.TripID)
+	if t.StartTime != "" {
+		b = appendString(b, 2, t.StartTime)
+	}
+	if t.StartDate != "" {
+		b = appendString(b, 3, t.StartDate)
+	}
+	b = appendVarint(b, 4, 0) // SCHEDULED
+	if t.RouteID != "" {
+		b = appendString(b, 5, t.RouteID)
+	}
+	if t.DirectionID != nil {
+		b = appendVarint(b, 6, uint64(*t.DirectionID))
+	}
+	return b
+}
+
+func (v *VehicleDescriptor) marshal() []byte {
+	b := appendString(nil, 1, v.ID)
+	if v.Label != "" {
+		b = appendString(b, 2, v.Label)
+	}
+	return b
+}
+
+func (s *StopTimeUpdate) marshal() []byte {
+	b := appendVarint(nil, 1, uint64(s.StopSequence))
+	if s.Arrival != nil {
+		b = appendMessage(b, 2, s.Arrival.marshal())
+	}
+	if s.Departure != nil {
+		b = appendMessage(b, 3, s.Departure.marshal())
+	}
+	b = appendString(b, 4, s.StopID)
+	return b
+}
+
+func (e *StopTimeEvent) marshal() []byte {
+	// int32 fields are sign-extended to 64 bits on the wire.
+	b := appendVarint(nil, 1, uint64(int64(e.Delay)))
+	return appendVarint(b, 2, uint64(e.Time.Unix()))
+}
+
+func (a *Alert) marshal() []byte {
+	var b []byte
+	for i := range a.ActivePeriods {
+		b = appendMessage(b, 1, a.ActivePeriods[i].marshal())
+	}
+	for i := range a.InformedEntities {
+		b = appendMessage(b, 5, a.InformedEntities[i].marshal())
+	}
+	if a.URL != "" {
+		b = appendMessage(b, 8, translatedString(a.URL))
+	}
+	b = appendMessage(b, 10, translatedString(a.HeaderText))
+	if a.DescriptionText != "" {
+		b = appendMessage(b, 11, translatedString(a.DescriptionText))
+	}
+	if a.SeverityLevel != 0 {
+		b = appendVarint(b, 14, uint64(a.SeverityLevel))
+	}
+	return b
+}
+
+func (t *TimeRange) marshal() []byte {
+	var b []byte
+	if t.Start != nil {
+		b = appendVarint(b, 1, uint64(t.Start.Unix()))
+	}
+	if t.End != nil {
+		b = appendVarint(b, 2, uint64(t.End.Unix()))
+	}
+	return b
+}
+
+func (s *EntitySelector) marshal() []byte {
+	var b []byte
+	if s.RouteID != "" {
+		b = appendString(b, 2, s.RouteID)
+	}
+	if s.RouteType != nil {
+		b = appendVarint(b, 3, uint64(*s.RouteType))
+	}
+	if s.StopID != "" {
+		b = appendString(b, 5, s.StopID)
+	}
+	return b
+}
+
+// translatedString encodes text as a TranslatedString with one translation
+// of unspecified language.
+func translatedString(text string) []byte {
+	return appendMessage(nil, 1, appendString(nil, 1, text))
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
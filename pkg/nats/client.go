@@ -0,0 +1,117 @@
+// Package nats adapts the NATS client to the publish/subscribe bus delta
+// distribution uses: one connection, re-established in the background after
+// failures, that publishes without queueing while the server is unreachable.
+package nats
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// subBuffer is how many messages a subscriber may fall behind before
+// further ones are dropped, so a slow subscriber doesn't stall the
+// connection.
+const subBuffer = 256
+
+// Client is a connection to a NATS server.
+type Client struct {
+	conn   *natsgo.Conn
+	logger *slog.Logger
+}
+
+// New connects to the servers of rawURL: nats://host:port, or tls://
+// for TLS, with optional user:password or token credentials; several URLs
+// may be given separated by commas. name identifies the client in the
+// server's monitoring. An unreachable server is retried in the background
+// rather than failing New.
+func New(rawURL, name string, logger *slog.Logger) (*Client, error) {
+	logger = logger.With("component", "nats")
+	conn, err := natsgo.Connect(rawURL,
+		natsgo.Name(name),
+		natsgo.RetryOnFailedConnect(true),
+		natsgo.MaxReconnects(-1),
+		// Publishing while disconnected fails instead of buffering
+		// batches that would be stale by the time they are sent.
+		natsgo.ReconnectBufSize(-1),
+		natsgo.DisconnectErrHandler(func(_ *natsgo.Conn, err error) {
+			if err != nil {
+				logger.Warn("disconnected from NATS", "error", err)
+			}
+		}),
+		natsgo.ReconnectHandler(func(c *natsgo.Conn) {
+			logger.Info("reconnected to NATS", "server", c.ConnectedUrlRedacted())
+		}),
+		natsgo.ErrorHandler(func(_ *natsgo.Conn, sub *natsgo.Subscription, err error) {
+			if sub != nil {
+				logger.Warn("NATS subscription error", "subject", sub.Subject, "error", err)
+				return
+			}
+			logger.Warn("NATS error", "error", err)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("nats: connect: %w", err)
+	}
+	return &Client{conn: conn, logger: logger}, nil
+}
+
+// Publish sends payload to subject and waits for the server to have
+// processed it. It fails rather than queueing while the server is
+// unreachable, and for payloads over MaxPayload.
+func (c *Client) Publish(ctx context.Context, subject string, payload []byte) error {
+	if err := c.conn.Publish(subject, payload); err != nil {
+		return fmt.Errorf("nats: publish: %w", err)
+	}
+	if err := c.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("nats: publish: %w", err)
+	}
+	return nil
+}
+
+// MaxPayload is the largest message the server accepts, in bytes, or 0
+// before the client first connected.
+func (c *Client) MaxPayload() int {
+	return int(c.conn.MaxPayload())
+}
+
+// Subscribe delivers the messages published to subject until ctx is done.
+// The subscription survives outages by reconnecting; messages published in
+// the meantime are lost. The channel is closed at once if subscribing
+// fails.
+func (c *Client) Subscribe(ctx context.Context, subject string) <-chan []byte {
+	msgs := make(chan *natsgo.Msg, subBuffer)
+	out := make(chan []byte)
+	sub, err := c.conn.ChanSubscribe(subject, msgs)
+	if err != nil {
+		c.logger.Error("failed to subscribe", "subject", subject, "error", err)
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-msgs:
+				select {
+				case out <- msg.Data:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.conn.Close()
+	return nil
+}
@@ -0,0 +1,279 @@
+// Package s3 is a minimal client for S3-compatible object storage, covering
+// the few calls the snapshot uploader needs. Requests are signed with AWS
+// Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as endpoint/bucket instead of
+	// bucket.endpoint; most self-hosted S3 implementations need it.
+	PathStyle bool
+}
+
+type Client struct {
+	cfg        Config
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+func New(cfg Config) (*Client, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &Client{
+		cfg:        cfg,
+		endpoint:   u,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// PutObject uploads body under key.
+func (c *Client) PutObject(ctx context.Context, key string, body []byte, contentType string) error {
+	headers := map[string]string{"Content-Type": contentType}
+	return c.do(ctx, http.MethodPut, key, nil, body, headers)
+}
+
+// LifecycleRule expires objects under Prefix after ExpirationDays.
+type LifecycleRule struct {
+	ID             string
+	Prefix         string
+	ExpirationDays int
+}
+
+// lifecycleRule is the XML of a rule the client writes.
+type lifecycleRule struct {
+	ID     string `xml:"ID"`
+	Filter struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"Filter"`
+	Status     string `xml:"Status"`
+	Expiration struct {
+		Days int `xml:"Days"`
+	} `xml:"Expiration"`
+}
+
+// rawLifecycleRule is a rule of the bucket's configuration kept as it is.
+type rawLifecycleRule struct {
+	Inner string `xml:",innerxml"`
+}
+
+// SetLifecycleRule adds rule to the bucket's lifecycle configuration,
+// replacing the rule with the same ID. The bucket's other rules are kept:
+// S3 only lets the whole configuration be replaced, so it is read first.
+func (c *Client) SetLifecycleRule(ctx context.Context, rule LifecycleRule) error {
+	query := url.Values{"lifecycle": {""}}
+	current, err := c.doRead(ctx, http.MethodGet, "", query, nil, nil)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound {
+		current, err = nil, nil // NoSuchLifecycleConfiguration
+	}
+	if err != nil {
+		return fmt.Errorf("get lifecycle configuration: %w", err)
+	}
+
+	var existing struct {
+		Rules []struct {
+			ID    string `xml:"ID"`
+			Inner string `xml:",innerxml"`
+		} `xml:"Rule"`
+	}
+	if len(current) > 0 {
+		if err := xml.Unmarshal(current, &existing); err != nil {
+			return fmt.Errorf("parse lifecycle configuration: %w", err)
+		}
+	}
+
+	doc := struct {
+		XMLName xml.Name `xml:"LifecycleConfiguration"`
+		Rules   []any    `xml:"Rule"`
+	}{}
+	for _, r := range existing.Rules {
+		if strings.TrimSpace(r.ID) != rule.ID {
+			doc.Rules = append(doc.Rules, rawLifecycleRule{Inner: r.Inner})
+		}
+	}
+	wanted := lifecycleRule{ID: rule.ID, Status: "Enabled"}
+	wanted.Filter.Prefix = rule.Prefix
+	wanted.Expiration.Days = rule.ExpirationDays
+	doc.Rules = append(doc.Rules, wanted)
+
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("marshal lifecycle configuration: %w", err)
+	}
+
+	sum := md5.Sum(body)
+	headers := map[string]string{
+		"Content-Type": "application/xml",
+		"Content-MD5":  base64.StdEncoding.EncodeToString(sum[:]),
+	}
+	return c.do(ctx, http.MethodPut, "", query, body, headers)
+}
+
+// statusError is a response outside 2xx.
+type statusError struct {
+	method, path string
+	status       int
+	msg          string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("s3 %s %s: status %d: %s", e.method, e.path, e.status, e.msg)
+}
+
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) error {
+	_, err := c.doRead(ctx, method, key, query, body, headers)
+	return err
+}
+
+// doRead sends a request and returns the response body.
+func (c *Client) doRead(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) ([]byte, error) {
+	u := *c.endpoint
+	path := "/" + strings.TrimPrefix(key, "/")
+	if c.cfg.PathStyle {
+		u.Path = "/" + c.cfg.Bucket + path
+	} else {
+		u.Host = c.cfg.Bucket + "." + u.Host
+		u.Path = path
+	}
+	if key == "" {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, u.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &statusError{method: method, path: u.Path, status: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: read response: %w", method, u.Path, err)
+	}
+	return data, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (c *Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretKey), date)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted by key, with empty values
+// kept as "key=" as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,89 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const existingLifecycle = `<?xml version="1.0" encoding="UTF-8"?>
+<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Rule><ID>logs-expiry</ID><Filter><Prefix>logs/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>7</Days></Expiration></Rule>
+<Rule><ID>wabus-snapshots-expiry</ID><Filter><Prefix>wabus/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>30</Days></Expiration></Rule>
+</LifecycleConfiguration>`
+
+type parsedRule struct {
+	ID     string `xml:"ID"`
+	Prefix string `xml:"Filter>Prefix"`
+	Days   int    `xml:"Expiration>Days"`
+}
+
+func fakeLifecycleBucket(t *testing.T, existing string) (*Client, *[]parsedRule) {
+	t.Helper()
+	var written []parsedRule
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["lifecycle"]; !ok || r.URL.Path != "/bucket" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.Error(w, "unexpected", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if existing == "" {
+				http.Error(w, "<Error><Code>NoSuchLifecycleConfiguration</Code></Error>", http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, existing)
+		case http.MethodPut:
+			var doc struct {
+				Rules []parsedRule `xml:"Rule"`
+			}
+			body, _ := io.ReadAll(r.Body)
+			if err := xml.Unmarshal(body, &doc); err != nil {
+				t.Errorf("invalid lifecycle configuration %s: %v", body, err)
+			}
+			written = doc.Rules
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := New(Config{Endpoint: srv.URL, Bucket: "bucket", PathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &written
+}
+
+func TestSetLifecycleRuleKeepsOtherRules(t *testing.T) {
+	client, written := fakeLifecycleBucket(t, existingLifecycle)
+	err := client.SetLifecycleRule(context.Background(), LifecycleRule{ID: "wabus-snapshots-expiry", Prefix: "wabus/", ExpirationDays: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []parsedRule{
+		{ID: "logs-expiry", Prefix: "logs/", Days: 7},
+		{ID: "wabus-snapshots-expiry", Prefix: "wabus/", Days: 90},
+	}
+	if len(*written) != len(want) {
+		t.Fatalf("got rules %+v, want %+v", *written, want)
+	}
+	for i := range want {
+		if (*written)[i] != want[i] {
+			t.Errorf("rule %d: got %+v, want %+v", i, (*written)[i], want[i])
+		}
+	}
+}
+
+func TestSetLifecycleRuleWithoutConfiguration(t *testing.T) {
+	client, written := fakeLifecycleBucket(t, "")
+	err := client.SetLifecycleRule(context.Background(), LifecycleRule{ID: "wabus-snapshots-expiry", Prefix: "wabus/", ExpirationDays: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*written) != 1 || (*written)[0].Days != 90 {
+		t.Errorf("got rules %+v, want only the snapshot rule", *written)
+	}
+}
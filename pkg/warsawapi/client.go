@@ -8,14 +8,25 @@ import (
 	"net/url"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
 	"wabus/internal/domain"
+	"wabus/internal/tracing"
 )
 
+// warsawLocation is the timezone of the API's timestamps; it is nil, read
+// as UTC, where the zone database is missing.
+var warsawLocation, _ = time.LoadLocation("Europe/Warsaw")
+
 type Client struct {
 	baseURL    string
 	apiKey     string
 	resourceID string
 	httpClient *http.Client
+	breaker    *breaker
 }
 
 func New(baseURL, apiKey, resourceID string) *Client {
@@ -31,9 +42,21 @@ func New(baseURL, apiKey, resourceID string) *Client {
 				IdleConnTimeout:     90 * time.Second,
 			},
 		},
+		breaker: newBreaker(defaultBreakerThreshold, defaultBreakerCooldown),
 	}
 }
 
+// ConfigureBreaker sets how many consecutive failures open the circuit
+// breaker and how long it stays open before a probe request is allowed.
+func (c *Client) ConfigureBreaker(threshold int, cooldown time.Duration) {
+	c.breaker.configure(threshold, cooldown)
+}
+
+// Health reports upstream request outcomes and the circuit breaker state.
+func (c *Client) Health() Health {
+	return c.breaker.snapshot()
+}
+
 type apiResponse struct {
 	Result json.RawMessage `json:"result"`
 	Error  string          `json:"error,omitempty"`
@@ -48,7 +71,38 @@ type apiVehicle struct {
 	Brigade       string  `json:"Brigade"`
 }
 
-func (c *Client) Fetch(ctx context.Context, vehicleType domain.VehicleType) ([]*domain.Vehicle, error) {
+// Fetch retrieves current positions for one vehicle type. While the circuit
+// breaker is open it fails fast with ErrCircuitOpen.
+func (c *Client) Fetch(ctx context.Context, vehicleType domain.VehicleType) (vehicles []*domain.Vehicle, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "warsawapi fetch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("vehicle.type", int(vehicleType))),
+	)
+	defer func() {
+		tracing.SetError(span, err)
+		span.SetAttributes(attribute.Int("vehicles", len(vehicles)))
+		span.End()
+	}()
+
+	if !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	vehicles, err = c.fetch(ctx, vehicleType)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Cancelled by us, not an upstream failure.
+			c.breaker.release()
+		} else {
+			c.breaker.failure(err)
+		}
+		return nil, err
+	}
+	c.breaker.success()
+	return vehicles, nil
+}
+
+func (c *Client) fetch(ctx context.Context, vehicleType domain.VehicleType) ([]*domain.Vehicle, error) {
 	params := url.Values{}
 	params.Set("resource_id", c.resourceID)
 	params.Set("apikey", c.apiKey)
@@ -62,6 +116,7 @@ func (c *Client) Fetch(ctx context.Context, vehicleType domain.VehicleType) ([]*
 	}
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Accept", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -93,29 +148,25 @@ func (c *Client) Fetch(ctx context.Context, vehicleType domain.VehicleType) ([]*
 func (c *Client) toDomain(apiVehicles []apiVehicle, vType domain.VehicleType) []*domain.Vehicle {
 	result := make([]*domain.Vehicle, 0, len(apiVehicles))
 
-	loc, _ := time.LoadLocation("Europe/Warsaw")
-
 	for _, av := range apiVehicles {
 		if av.VehicleNumber == "" {
 			continue
 		}
 
-		ts, err := time.ParseInLocation("2006-01-02 15:04:05", av.Time, loc)
+		ts, err := time.ParseInLocation("2006-01-02 15:04:05", av.Time, warsawLocation)
 		if err != nil {
 			ts = time.Now()
 		}
 
-		key := fmt.Sprintf("%d:%s", vType, av.VehicleNumber)
-		result = append(result, &domain.Vehicle{
-			Key:           key,
-			VehicleNumber: av.VehicleNumber,
-			Type:          vType,
-			Line:          av.Lines,
-			Brigade:       av.Brigade,
-			Lat:           av.Lat,
-			Lon:           av.Lon,
-			Timestamp:     ts,
-		})
+		v := domain.NewVehicle()
+		v.Key = fmt.Sprintf("%d:%s", vType, av.VehicleNumber)
+		v.VehicleNumber = av.VehicleNumber
+		v.Type = vType
+		v.Line = av.Lines
+		v.Brigade = av.Brigade
+		v.Lat, v.Lon = av.Lat, av.Lon
+		v.Timestamp = ts
+		result = append(result, v)
 	}
 
 	return result
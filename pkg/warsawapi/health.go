@@ -0,0 +1,131 @@
+package warsawapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Fetch while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("warsaw api circuit breaker open")
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = time.Minute
+)
+
+// Health summarizes the recent outcome of upstream requests.
+type Health struct {
+	LastSuccess         time.Time `json:"last_success"`
+	LastFailure         time.Time `json:"last_failure,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	TotalRequests       int64     `json:"total_requests"`
+	TotalFailures       int64     `json:"total_failures"`
+	BreakerState        string    `json:"breaker_state"`
+	BreakerOpenUntil    time.Time `json:"breaker_open_until,omitzero"`
+}
+
+// breaker tracks request outcomes and opens after threshold consecutive
+// failures. Once the cooldown has passed a single probe request is let through
+// (half-open); its outcome closes or re-opens the breaker.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	openUntil time.Time
+	probing   bool
+	health    Health
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+func (b *breaker) configure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = threshold
+	b.cooldown = cooldown
+}
+
+// allow reports whether a request may be sent now.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.stateLocked(time.Now()) {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	b.openUntil = time.Time{}
+	b.health.TotalRequests++
+	b.health.ConsecutiveFailures = 0
+	b.health.LastSuccess = time.Now()
+}
+
+func (b *breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.health.TotalRequests++
+	b.health.TotalFailures++
+	b.health.ConsecutiveFailures++
+	b.health.LastFailure = now
+	b.health.LastError = err.Error()
+
+	if b.probing || (b.threshold > 0 && b.health.ConsecutiveFailures >= b.threshold) {
+		b.openUntil = now.Add(b.cooldown)
+	}
+	b.probing = false
+}
+
+// release ends a request without recording an outcome.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) stateLocked(now time.Time) string {
+	if b.openUntil.IsZero() {
+		return BreakerClosed
+	}
+	if now.Before(b.openUntil) {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+func (b *breaker) snapshot() Health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := b.health
+	h.BreakerState = b.stateLocked(time.Now())
+	if h.BreakerState != BreakerClosed {
+		h.BreakerOpenUntil = b.openUntil
+	}
+	return h
+}
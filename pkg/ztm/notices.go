@@ -0,0 +1,104 @@
+// Package ztm reads the Warsaw public transport disruption notices
+// (detours, suspensions, timetable changes) published as an RSS feed.
+package ztm
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Notice is one item of the feed.
+type Notice struct {
+	GUID        string
+	Title       string
+	Link        string
+	Description string // plain text, HTML stripped
+	Published   time.Time
+}
+
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+func New(url string) *Client {
+	return &Client{
+		url:        url,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type rssFeed struct {
+	Items []rssItem `xml:"channel>item"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+}
+
+// FetchNotices downloads and parses the current notices.
+func (c *Client) FetchNotices(ctx context.Context) ([]Notice, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/xml")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("notices feed returned %s", resp.Status)
+	}
+
+	var feed rssFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("decode notices feed: %w", err)
+	}
+
+	notices := make([]Notice, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		n := Notice{
+			GUID:        strings.TrimSpace(item.GUID),
+			Title:       plainText(item.Title),
+			Link:        strings.TrimSpace(item.Link),
+			Description: plainText(item.Description),
+		}
+		if n.GUID == "" {
+			n.GUID = n.Link
+		}
+		if n.GUID == "" || n.Title == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC1123Z, strings.TrimSpace(item.PubDate)); err == nil {
+			n.Published = t
+		}
+		notices = append(notices, n)
+	}
+	return notices, nil
+}
+
+var (
+	tagPattern   = regexp.MustCompile(`<[^>]*>`)
+	spacePattern = regexp.MustCompile(`\s+`)
+)
+
+// plainText strips HTML tags and entities and collapses whitespace.
+func plainText(s string) string {
+	s = tagPattern.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(spacePattern.ReplaceAllString(s, " "))
+}